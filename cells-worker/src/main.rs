@@ -59,9 +59,22 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
 
     println!("Identity: ID={}, X={}, Y={} (Grid: Width={}, Interval={}ms)", id, x, y, width, interval_ms);
 
+    // Spark cells are one-shot Jobs shown as a firework: they flash for a
+    // moment and exit, and the finished Job renders as a fading spark.
+    if env::var("CELL_MODE").map(|m| m == "spark").unwrap_or(false) {
+        let spark_ms: u64 = env::var("SPARK_MS").ok().and_then(|v| v.parse().ok()).unwrap_or(500);
+        time::sleep(Duration::from_millis(spark_ms)).await;
+        println!("Spark: {} burned out", hostname);
+        return Ok(());
+    }
+
+    // Passive cells are driven by the controller's standalone engine: the pod
+    // only exists while the cell is alive, so there is no game loop to run.
+    let passive = env::var("CELL_MODE").map(|m| m == "passive").unwrap_or(false);
+
     // Initial State: Random or based on ID?
     // Let's make even IDs alive for initial entropy
-    let initial_alive = id % 2 == 0;
+    let initial_alive = passive || id % 2 == 0;
     
     let state = Arc::new(Mutex::new(CellState {
         alive: initial_alive,
@@ -69,7 +82,13 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
     }));
 
     // 2. Start gRPC Server
-    let addr = "0.0.0.0:50051".parse()?;
+    // [::] accepts IPv4 too where the node is dual-stack; nodes with IPv6
+    // disabled fall back to 0.0.0.0. BIND_ADDR overrides both.
+    let addr: std::net::SocketAddr = match env::var("BIND_ADDR") {
+        Ok(bind) => bind.parse()?,
+        Err(_) if std::net::TcpListener::bind("[::]:0").is_ok() => "[::]:50051".parse()?,
+        Err(_) => "0.0.0.0:50051".parse()?,
+    };
     let cell_service = MyCell { state: state.clone() };
 
     println!("Starting gRPC server on {}", addr);
@@ -82,6 +101,14 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
             .unwrap();
     });
 
+    if passive {
+        println!("Passive mode: state is managed by the grid controller");
+        // Exit promptly on SIGTERM so dead cells disappear within the tick
+        let mut term = tokio::signal::unix::signal(tokio::signal::unix::SignalKind::terminate())?;
+        term.recv().await;
+        return Ok(());
+    }
+
     // 3. Kubernetes Client
     // In-cluster config is assumed
     let client = Client::try_default().await; 
@@ -1,15 +1,85 @@
-import { useEffect, useState } from 'react';
+import { useEffect, useState, type CSSProperties } from 'react';
 import './App.css';
 
+interface Banner {
+  text: string;
+  severity: 'info' | 'warning' | 'critical';
+  durationSeconds: number;
+}
+
 interface Cell {
   name: string;
-  status: 'alive' | 'dead' | 'initializing' | 'terminating' | 'deleted' | 'unknown';
+  status: 'alive' | 'dead' | 'initializing' | 'terminating' | 'deleted' | 'unknown' | 'spark' | 'fizzled';
   namespace: string;
+  // Set when the pod's lifecycle shows through, e.g. a crash-looping cell.
+  condition?: 'dying' | 'sick' | 'zombie';
+  // Replicas of a super-cell (--cell-objects=deployments), 1-3 while alive.
+  intensity?: number;
 }
 
+interface CellMetrics {
+  cell: number;
+  name: string;
+  cpuMillis: number;
+  memoryBytes: number;
+}
+
+// A birth or death that failed for good, e.g. over quota or a bad image.
+interface CellError {
+  name: string;
+  intent: 'birth' | 'death';
+  reason: string;
+  message?: string;
+}
+
+type Overlay = 'status' | 'cpu' | 'memory';
+
+// Installation theme from GET /api/theme; anything unset keeps the built-in look.
+interface Theme {
+  states?: Partial<Record<Cell['status'] | NonNullable<Cell['condition']> | 'failed', string>>;
+  species?: Record<string, string>;
+  cellShape?: 'square' | 'rounded' | 'circle';
+  background?: string;
+}
+
+const shapeClass = { square: 'rounded-none', rounded: 'rounded', circle: 'rounded-full' };
+
+// After an OIDC login the controller redirects back with the ID token in the
+// URL fragment; keep it for this tab only.
+function idToken(): string | null {
+  const fragment = new URLSearchParams(window.location.hash.slice(1));
+  const token = fragment.get('id_token');
+  if (token) {
+    sessionStorage.setItem('id_token', token);
+    history.replaceState(null, '', window.location.pathname + window.location.search);
+  }
+  return sessionStorage.getItem('id_token');
+}
+
+// A share link (/view/<token>) lands here with ?grid=&share=: a read-only
+// view of one grid.
+const shared = new URLSearchParams(window.location.search);
+const shareToken = shared.get('share');
+
 function App() {
   const [cells, setCells] = useState<Map<string, Cell>>(new Map());
   const [gridSize] = useState(10); // 10x10 hardcoded for now
+  const [viewers, setViewers] = useState(0);
+  const [banner, setBanner] = useState<Banner | null>(null);
+  const [usage, setUsage] = useState<Map<string, CellMetrics>>(new Map());
+  const [failures, setFailures] = useState<Map<string, CellError>>(new Map());
+  const [overlay, setOverlay] = useState<Overlay>('status');
+  const [theme, setTheme] = useState<Theme>({});
+
+  useEffect(() => {
+    const themeUrl = window.location.hostname === 'localhost'
+      ? 'http://localhost:8080/api/theme'
+      : '/api/theme';
+    fetch(themeUrl)
+      .then(res => (res.ok ? res.json() : {}))
+      .then(setTheme)
+      .catch(e => console.error('Failed to load theme', e));
+  }, []);
 
   useEffect(() => {
     // WebSocket Connection
@@ -18,9 +88,15 @@ function App() {
 
     // For local dev with vite proxy, we might need a setup. 
     // Let's assume relative path /ws if served by Go, or localhost:8080 if standalone
-    const wsUrl = window.location.hostname === 'localhost'
+    let wsUrl = window.location.hostname === 'localhost'
       ? 'ws://localhost:8080/ws'
       : `ws://${window.location.host}/ws`;
+    const token = idToken();
+    if (shareToken) {
+      wsUrl += `?grid=${encodeURIComponent(shared.get('grid') ?? '')}&share=${encodeURIComponent(shareToken)}`;
+    } else if (token) {
+      wsUrl += `?access_token=${encodeURIComponent(token)}`;
+    }
 
     const ws = new WebSocket(wsUrl);
 
@@ -31,6 +107,44 @@ function App() {
     ws.onmessage = (event) => {
       try {
         const update = JSON.parse(event.data); // {name, status, namespace}
+        if (update.type === 'viewers') {
+          setViewers(update.viewers);
+          return;
+        }
+        if (update.type === 'banner') {
+          setBanner(update.text ? update : null);
+          if (update.durationSeconds > 0) {
+            setTimeout(() => setBanner(b => (b === update ? null : b)), update.durationSeconds * 1000);
+          }
+          return;
+        }
+        if (update.type === 'theme') {
+          setTheme(update);
+          return;
+        }
+        if (update.type === 'cell_metrics') {
+          setUsage(new Map((update.cells as CellMetrics[]).map(m => [m.name, m])));
+          return;
+        }
+        if (update.type === 'cell_error') {
+          setFailures(prev => new Map(prev).set(update.name, update));
+          return;
+        }
+        if (update.type === 'snapshot') {
+          // Sent on connect: every cell's latest state, replacing ours.
+          setCells(new Map((update.cells as Cell[]).map(c => [c.name, c])));
+          return;
+        }
+        if (update.type) return; // Other typed messages are not cell updates
+        // A cell that settles clears its failure; one still starting keeps it.
+        if (update.status !== 'initializing') {
+          setFailures(prev => {
+            if (!prev.has(update.name)) return prev;
+            const next = new Map(prev);
+            next.delete(update.name);
+            return next;
+          });
+        }
         setCells(prev => {
           const next = new Map(prev);
           next.set(update.name, update);
@@ -68,31 +182,68 @@ function App() {
   // Render Grid
   // We assume names are cell-0, cell-1...
 
+  // Usage overlays shade each cell relative to the busiest one.
+  const usageKey = overlay === 'memory' ? 'memoryBytes' : 'cpuMillis';
+  const peak = Math.max(1, ...Array.from(usage.values(), m => m[usageKey]));
+
   const renderCell = (index: number) => {
     const name = `cell-${index}`;
     const cell = cells.get(name);
+    const failure = failures.get(name);
 
     let color = 'bg-gray-200'; // Default/Unknown
     let statusText = '...';
+    let style: CSSProperties | undefined;
 
-    if (cell) {
+    if (overlay !== 'status') {
+      const m = usage.get(name);
+      color = 'bg-gray-800';
+      statusText = '-';
+      if (m) {
+        const ratio = m[usageKey] / peak;
+        style = { backgroundColor: `rgba(249, 115, 22, ${0.15 + 0.85 * ratio})` };
+        statusText = overlay === 'cpu' ? `${m.cpuMillis}m` : `${Math.round(m.memoryBytes / (1 << 20))}Mi`;
+      }
+    } else if (failure) {
+      color = 'bg-rose-900 border-rose-400 border-2';
+      statusText = 'failed';
+      if (theme.states?.failed) {
+        style = { backgroundColor: theme.states.failed };
+      }
+    } else if (cell) {
       statusText = cell.status;
       switch (cell.status) {
-        case 'alive': color = 'bg-green-500'; break;
+        case 'alive': color = ['bg-green-500', 'bg-green-300', 'bg-green-500', 'bg-green-700'][cell.intensity ?? 0] ?? 'bg-green-700'; break;
         case 'dead': color = 'bg-gray-800'; break;
         case 'initializing': color = 'bg-blue-300 animate-pulse'; break;
         case 'terminating': color = 'bg-red-500 animate-pulse'; break;
         case 'deleted': color = 'bg-red-900 border-red-500 border-2'; break;
+        // Finished firework Jobs (--cell-objects=jobs) fade out
+        case 'spark': color = 'bg-yellow-300 opacity-40 transition-opacity duration-1000'; break;
+        case 'fizzled': color = 'bg-red-400 opacity-30 transition-opacity duration-1000'; break;
         default: color = 'bg-gray-400';
       }
+      switch (cell.condition) {
+        case 'dying': color = 'bg-orange-500 animate-pulse'; break;
+        case 'sick': color = 'bg-yellow-600'; break;
+        case 'zombie': color = 'bg-lime-800'; break;
+      }
+      if (cell.condition) {
+        statusText = cell.condition;
+      }
+      const themed = (cell.condition && theme.states?.[cell.condition]) || theme.states?.[cell.status];
+      if (themed) {
+        style = { backgroundColor: themed };
+      }
     }
 
     return (
       <div
         key={index}
-        className={`w-16 h-16 m-1 rounded flex items-center justify-center text-xs text-white font-mono cursor-pointer transition-colors duration-200 ${color}`}
-        onClick={() => cell && killPod(name)}
-        title={name}
+        className={`w-16 h-16 m-1 ${shapeClass[theme.cellShape ?? 'rounded']} flex items-center justify-center text-xs text-white font-mono cursor-pointer transition-colors duration-200 ${color}`}
+        style={style}
+        onClick={() => cell && !shareToken && killPod(name)}
+        title={failure ? `${name}: ${failure.intent} failed (${failure.reason})` : name}
       >
         {statusText}
       </div>
@@ -100,14 +251,33 @@ function App() {
   };
 
   return (
-    <div className="min-h-screen bg-gray-900 flex flex-col items-center justify-center p-4">
+    <div className="min-h-screen bg-gray-900 flex flex-col items-center justify-center p-4" style={theme.background ? { backgroundColor: theme.background } : undefined}>
+      {banner && (
+        <div className={`w-full max-w-3xl mb-4 p-3 rounded text-white text-center ${banner.severity === 'critical' ? 'bg-red-700' : banner.severity === 'warning' ? 'bg-yellow-600' : 'bg-blue-700'}`}>
+          {banner.text}
+        </div>
+      )}
       <h1 className="text-3xl font-bold text-white mb-8">Cellular Automaton Control</h1>
       <div className="grid grid-cols-10 gap-2 p-4 bg-gray-800 rounded-lg shadow-xl">
         {Array.from({ length: gridSize * gridSize }).map((_, i) => renderCell(i))}
       </div>
       <div className="mt-8 text-gray-400">
-        <p>Click a cell to kill its pod (Chaos Monkey).</p>
-        <p>Green: Alive | Black: Dead | Blue: Init | Red: Terminating</p>
+        {shareToken
+          ? <p>Shared read-only view of grid {shared.get('grid')}.</p>
+          : <p>Click a cell to kill its pod (Chaos Monkey).</p>}
+        <p>Green: Alive | Black: Dead | Blue: Init | Red: Terminating | Rose: Failed</p>
+        <p>
+          Overlay:{' '}
+          <select className="bg-gray-800 text-white" value={overlay} onChange={e => setOverlay(e.target.value as Overlay)}>
+            <option value="status">Status</option>
+            <option value="cpu" disabled={usage.size === 0}>CPU</option>
+            <option value="memory" disabled={usage.size === 0}>Memory</option>
+          </select>
+        </p>
+        <p>Viewers: {viewers}</p>
+        {!sessionStorage.getItem('id_token') && (
+          <p><a className="underline" href="/api/auth/login">Sign in</a></p>
+        )}
       </div>
     </div>
   );
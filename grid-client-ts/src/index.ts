@@ -0,0 +1,471 @@
+// Typed client for the grid controller's REST API and event stream, the
+// TypeScript twin of grid-controller/pkg/client. Types mirror the
+// controller's JSON; keep them in step with the Go package.
+//
+//   const grid = new GridClient('http://localhost:8080', { token });
+//   const page = await grid.cells({ status: ['alive'] });
+//   const sub = grid.subscribe(event => { ... });
+//   sub.close();
+//
+// Mutating calls carry an Idempotency-Key and are retried on network errors
+// and 5xx responses, so a flaky connection never applies an edit twice.
+// subscribe() follows the stream across reconnects, resuming where it left
+// off.
+
+export interface CellUpdate {
+  name: string;
+  status: string;
+  namespace: string;
+  grid?: string;
+  genome?: string;
+  color?: string;
+  condition?: 'dying' | 'sick' | 'zombie';
+  intensity?: number;
+}
+
+export interface CellInfo {
+  index: number;
+  x: number;
+  y: number;
+  name: string;
+  status: string;
+  condition?: string;
+  node?: string;
+  ageSeconds: number;
+  genome?: string;
+}
+
+export interface CellPage {
+  cells: CellInfo[];
+  continue?: string;
+}
+
+export interface CellQuery {
+  status?: string[];
+  node?: string;
+  // Seconds.
+  minAge?: number;
+  // x0, y0, x1, y1 with x1 and y1 exclusive.
+  region?: [number, number, number, number];
+  near?: number;
+  limit?: number;
+  continue?: string;
+}
+
+export interface Pattern {
+  name: string;
+  width: number;
+  height: number;
+  cells: [number, number][];
+}
+
+export interface EditConflict {
+  cell: number;
+  with: number;
+  by: string;
+  outcome: string;
+}
+
+// How a pattern is combined with the grid.
+export type PatternOp = 'union' | 'intersection' | 'difference' | 'xor';
+
+export interface EditResult {
+  seq: number;
+  generation: number;
+  births: number[];
+  deaths?: number[];
+  conflicts?: EditConflict[];
+}
+
+// region is [x0, y0, x1, y1] with x1 and y1 exclusive; rotate is clockwise.
+export interface RegionTransform {
+  region: [number, number, number, number];
+  rotate?: 0 | 90 | 180 | 270;
+  flip?: 'h' | 'v';
+  dx?: number;
+  dy?: number;
+}
+
+// clipped counts the cells moved off the grid.
+export interface TransformResult extends EditResult {
+  moved: number;
+  clipped: number;
+}
+
+export interface StateHash {
+  generation: number;
+  population: number;
+  hash: string;
+  materialized: string;
+  consistent: boolean;
+}
+
+export interface Banner {
+  text: string;
+  severity: 'info' | 'warning' | 'critical';
+  durationSeconds: number;
+}
+
+export interface Generation {
+  generation: number;
+  population: number;
+  births: number;
+  deaths: number;
+  time: string;
+}
+
+// A tournament match with the live cells of each side. The home bot plays
+// the left half of the grid, the away bot the right half.
+export interface MatchBoard {
+  tournament: string;
+  id: number;
+  home: string;
+  away: string;
+  status: 'scheduled' | 'setup' | 'running' | 'finished';
+  generation: number;
+  homeMoves: number;
+  awayMoves: number;
+  homeScore: number;
+  awayScore: number;
+  winner?: string;
+  width: number;
+  height: number;
+  homeCells: number[];
+  awayCells: number[];
+}
+
+// The whole grid, streamed every generation when the controller runs the
+// websocket renderer. bits is base64: row-major, one bit per cell, least
+// significant bit first; see frameAlive.
+export interface Frame {
+  generation: number;
+  width: number;
+  height: number;
+  population: number;
+  bits: string;
+}
+
+// A guided lesson: steps that advance once the grid shows what they expect.
+export interface Scenario {
+  name: string;
+  title: string;
+  steps: { instruction: string }[];
+}
+
+// The running lesson. step counts from 1 and is past the last step once
+// finished; waiting lists what the current step waits for.
+export interface ScenarioProgress {
+  scenario: string;
+  title: string;
+  started: string;
+  step: number;
+  steps: number;
+  instruction?: string;
+  waiting?: string[];
+  completed: { step: number; instruction: string; completed: string; seconds: number }[];
+  finished: boolean;
+  stopped?: boolean;
+}
+
+// Decodes a frame's bitmap into one boolean per cell, in index order.
+export function frameAlive(frame: Frame): boolean[] {
+  const bytes = Uint8Array.from(atob(frame.bits), c => c.charCodeAt(0));
+  const alive = new Array<boolean>(frame.width * frame.height);
+  for (let i = 0; i < alive.length; i++) alive[i] = (bytes[i >> 3] & (1 << (i & 7))) !== 0;
+  return alive;
+}
+
+// What a bot knows of the grid: every cell's latest state by name.
+export interface Grid {
+  cells: Map<string, CellUpdate>;
+  // The last generation reported; 0 until the first one.
+  generation: number;
+}
+
+// Hooks an agent playing against the grid implements. Create the client
+// with the bot's token, so its edits count against the bot's scopes, region
+// and rate limit.
+export interface Bot {
+  // Runs when the bot connects and whenever it had to catch up with a fresh
+  // snapshot after a reconnect.
+  onSnapshot?(client: GridClient, grid: Grid): void | Promise<void>;
+  // Runs after every generation.
+  onGeneration?(client: GridClient, grid: Grid, generation: Generation): void | Promise<void>;
+  // Receives errors thrown by the other hooks; they are logged otherwise.
+  onError?(error: unknown): void;
+}
+
+// One message of the stream. A connection opens with a snapshot, every
+// cell's latest state, which replaces whatever the subscriber knew.
+export type GridEvent =
+  | { type: 'cell'; seq: number; ts: string; data: CellUpdate }
+  | { type: 'snapshot'; seq: number; ts: string; data: { cells: CellUpdate[] } }
+  | { type: 'banner'; seq: number; ts: string; data: Banner }
+  | { type: 'viewers'; seq: number; ts: string; data: { viewers: number } }
+  | { type: 'generation'; seq: number; ts: string; data: Generation }
+  | { type: 'match'; seq: number; ts: string; data: MatchBoard }
+  | { type: 'frame'; seq: number; ts: string; data: Frame }
+  | { type: 'scenario'; seq: number; ts: string; data: ScenarioProgress }
+  | { type: string; seq: number; ts: string; data: unknown };
+
+export interface ClientOptions {
+  // Admin, operator or OIDC ID token.
+  token?: string;
+  // How often a failed call is retried.
+  retries?: number;
+  // First delay between retries and reconnects in milliseconds, doubled up
+  // to maxBackoff.
+  backoff?: number;
+  maxBackoff?: number;
+}
+
+// Picks the grid to subscribe to; the controller's own grid by default.
+// share or code grant access to a grid without a token.
+export interface StreamOptions {
+  grid?: string;
+  share?: string;
+  code?: string;
+}
+
+export interface Subscription {
+  close(): void;
+}
+
+export class GridError extends Error {
+  readonly status: number;
+
+  constructor(status: number, message: string) {
+    super(`grid controller: ${status} ${message}`);
+    this.status = status;
+  }
+}
+
+const sleep = (ms: number) => new Promise(resolve => setTimeout(resolve, ms));
+
+export class GridClient {
+  readonly baseUrl: string;
+  private readonly token?: string;
+  private readonly retries: number;
+  private readonly backoff: number;
+  private readonly maxBackoff: number;
+
+  constructor(baseUrl: string, options: ClientOptions = {}) {
+    this.baseUrl = baseUrl.replace(/\/$/, '');
+    this.token = options.token;
+    this.retries = options.retries ?? 3;
+    this.backoff = options.backoff ?? 250;
+    this.maxBackoff = options.maxBackoff ?? 30_000;
+  }
+
+  // Lists cells matching the query, a page at a time in index order.
+  cells(query: CellQuery = {}): Promise<CellPage> {
+    const params = new URLSearchParams();
+    if (query.status?.length) params.set('status', query.status.join(','));
+    if (query.node) params.set('node', query.node);
+    if (query.minAge) params.set('minAge', String(query.minAge));
+    if (query.region) params.set('region', query.region.join(','));
+    if (query.near !== undefined) params.set('near', String(query.near));
+    if (query.limit) params.set('limit', String(query.limit));
+    if (query.continue) params.set('continue', query.continue);
+    return this.call('GET', `/api/cells?${params}`);
+  }
+
+  spawn(index: number): Promise<EditResult> {
+    return this.call('POST', `/api/cells/${index}`);
+  }
+
+  kill(index: number): Promise<EditResult> {
+    return this.call('DELETE', `/api/cells/${index}`);
+  }
+
+  // Deletes a cell's pod, as chaos does.
+  async deletePod(name: string): Promise<void> {
+    await this.call('DELETE', `/api/pods/${encodeURIComponent(name)}`);
+  }
+
+  patterns(): Promise<Pattern[]> {
+    return this.call('GET', '/api/patterns');
+  }
+
+  // Stamps a built-in pattern with its top-left corner at (x, y), or
+  // centered without coordinates. op combines it with the grid otherwise:
+  // intersection keeps only the live cells under it, difference erases its
+  // cells and xor flips them.
+  applyPattern(name: string, x?: number, y?: number, op: PatternOp = 'union'): Promise<EditResult> {
+    const params = new URLSearchParams();
+    if (x !== undefined && y !== undefined) {
+      params.set('x', String(x));
+      params.set('y', String(y));
+    }
+    if (op !== 'union') params.set('op', op);
+    const query = params.toString();
+    return this.call('POST', `/api/patterns/${encodeURIComponent(name)}${query ? '?' + query : ''}`);
+  }
+
+  // Moves the live cells of a region in one edit: flipped and rotated
+  // within the region's box, then translated.
+  transformRegion(transform: RegionTransform): Promise<TransformResult> {
+    return this.call('POST', '/api/region/transform', transform);
+  }
+
+  stateHash(): Promise<StateHash> {
+    return this.call('GET', '/api/state/hash');
+  }
+
+  // Shows a banner to every viewer; an empty text clears it.
+  async broadcast(banner: Banner): Promise<void> {
+    await this.call('POST', '/api/broadcast', banner);
+  }
+
+  scenarios(): Promise<Scenario[]> {
+    return this.call('GET', '/api/scenarios');
+  }
+
+  // The running lesson's progress; fails with a 404 when none runs.
+  scenarioProgress(): Promise<ScenarioProgress> {
+    return this.call('GET', '/api/scenarios/active');
+  }
+
+  // Starts a lesson, replacing any running one. Needs an operator token.
+  startScenario(name: string): Promise<ScenarioProgress> {
+    return this.call('POST', `/api/scenarios/${encodeURIComponent(name)}/start`);
+  }
+
+  async stopScenario(): Promise<void> {
+    await this.call('POST', '/api/scenarios/stop');
+  }
+
+  match(tournament: string, id: number): Promise<MatchBoard> {
+    return this.call('GET', `/api/tournaments/${encodeURIComponent(tournament)}/matches/${id}`);
+  }
+
+  // Fetches a finished match's signed replay, to be saved and checked with
+  // `grid-controller verify-replay`.
+  replay(tournament: string, id: number): Promise<unknown> {
+    return this.call('GET', `/api/tournaments/${encodeURIComponent(tournament)}/matches/${id}/replay`);
+  }
+
+  // Places live cells on the bot's half of a match; each cell uses one move
+  // of the bot's budget.
+  move(tournament: string, id: number, cells: number[]): Promise<MatchBoard> {
+    return this.call('POST', `/api/tournaments/${encodeURIComponent(tournament)}/matches/${id}/moves`, { cells });
+  }
+
+  // Streams events to onEvent until closed. When the connection drops it
+  // reconnects with backoff and resumes after the last event seen; if the
+  // controller no longer has the missed events, a fresh snapshot follows.
+  subscribe(onEvent: (event: GridEvent) => void, options: StreamOptions = {}): Subscription {
+    let since: number | undefined;
+    let delay = this.backoff;
+    let ws: WebSocket | undefined;
+    let timer: ReturnType<typeof setTimeout> | undefined;
+    let closed = false;
+
+    const connect = () => {
+      const url = new URL(`${this.baseUrl}/ws`);
+      url.protocol = url.protocol.replace('http', 'ws');
+      const params: Record<string, string | undefined> = { ...options, access_token: this.token };
+      for (const [key, value] of Object.entries(params)) {
+        if (value) url.searchParams.set(key, value);
+      }
+      if (since !== undefined) url.searchParams.set('since', String(since));
+
+      ws = new WebSocket(url, 'grid.v2');
+      ws.onopen = () => {
+        delay = this.backoff;
+      };
+      ws.onmessage = message => {
+        const event = JSON.parse(message.data) as GridEvent;
+        // The banner a connection opens with may be older than what came
+        // before; a snapshot starts over.
+        if (event.type === 'snapshot' || since === undefined || event.seq > since) {
+          since = event.seq;
+        }
+        onEvent(event);
+      };
+      ws.onclose = () => {
+        if (closed) return;
+        timer = setTimeout(connect, delay);
+        delay = Math.min(2 * delay, this.maxBackoff);
+      };
+    };
+    connect();
+
+    return {
+      close: () => {
+        closed = true;
+        clearTimeout(timer);
+        ws?.close();
+      },
+    };
+  }
+
+  // Streams the grid to a bot's hooks until closed. Hooks run one at a time,
+  // in stream order.
+  runBot(bot: Bot, options: StreamOptions = {}): Subscription {
+    const grid: Grid = { cells: new Map(), generation: 0 };
+    let queue = Promise.resolve();
+    const run = (hook: () => void | Promise<void>) => {
+      queue = queue.then(hook).catch(e => (bot.onError ? bot.onError(e) : console.error('Bot hook failed', e)));
+    };
+    return this.subscribe(event => {
+      switch (event.type) {
+        case 'snapshot': {
+          const cells = (event.data as { cells: CellUpdate[] }).cells;
+          run(() => {
+            grid.cells = new Map(cells.map(c => [c.name, c]));
+            return bot.onSnapshot?.(this, grid);
+          });
+          break;
+        }
+        case 'cell': {
+          const cell = event.data as CellUpdate;
+          run(() => {
+            grid.cells.set(cell.name, cell);
+          });
+          break;
+        }
+        case 'generation': {
+          const generation = event.data as Generation;
+          run(() => {
+            grid.generation = generation.generation;
+            return bot.onGeneration?.(this, grid, generation);
+          });
+          break;
+        }
+      }
+    }, options);
+  }
+
+  // Makes a request, retrying network errors and 5xx responses.
+  private async call<T>(method: string, path: string, body?: unknown): Promise<T> {
+    const headers: Record<string, string> = {};
+    if (this.token) headers['Authorization'] = `Bearer ${this.token}`;
+    if (body !== undefined) headers['Content-Type'] = 'application/json';
+    // One key for every attempt, so a retried edit is applied once.
+    if (method !== 'GET') headers['Idempotency-Key'] = crypto.randomUUID();
+
+    let delay = this.backoff;
+    for (let attempt = 0; ; attempt++) {
+      let error: unknown;
+      try {
+        const res = await fetch(this.baseUrl + path, {
+          method,
+          headers,
+          body: body === undefined ? undefined : JSON.stringify(body),
+        });
+        if (res.ok) {
+          const text = await res.text();
+          return (text ? JSON.parse(text) : undefined) as T;
+        }
+        error = new GridError(res.status, (await res.text()).trim());
+      } catch (e) {
+        error = e;
+      }
+      const permanent = error instanceof GridError && error.status < 500 && error.status !== 429;
+      if (permanent || attempt >= this.retries) throw error;
+      await sleep(delay);
+      delay = Math.min(2 * delay, this.maxBackoff);
+    }
+  }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// aggregateSource is an independent controller whose stream is republished
+// under its own grid ID, so one dashboard can show several grids side by side.
+type aggregateSource struct {
+	ID  string
+	URL *url.URL
+}
+
+// parseAggregateSources parses the --aggregate flag: id=url[,id=url...].
+func parseAggregateSources(spec string) ([]aggregateSource, error) {
+	var sources []aggregateSource
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry == "" {
+			continue
+		}
+		id, raw, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%q: expected id=url", entry)
+		}
+		if id == gridID || seen[id] {
+			return nil, fmt.Errorf("duplicate grid ID %q", id)
+		}
+		seen[id] = true
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		switch u.Scheme {
+		case "ws", "wss", "grpc":
+		default:
+			return nil, fmt.Errorf("%q: unsupported scheme %q", entry, u.Scheme)
+		}
+		sources = append(sources, aggregateSource{ID: id, URL: u})
+	}
+	return sources, nil
+}
+
+func (s aggregateSource) Run(ctx context.Context, namespace string) {
+	if s.URL.Scheme == "grpc" {
+		aggregateBand(ctx, s.ID, s.URL.Host, namespace)
+		return
+	}
+	for {
+		err := s.follow(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		log.Printf("Aggregate: %s: %v; retrying", s.ID, err)
+	}
+}
+
+// follow relays one WebSocket session of the source until it fails.
+func (s aggregateSource) follow(ctx context.Context) error {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, s.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+	log.Printf("Aggregate: following %s at %s", s.ID, s.URL)
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var update struct {
+			CellUpdate
+			Type  string       `json:"type"`
+			Cells []CellUpdate `json:"cells"`
+		}
+		if err := json.Unmarshal(data, &update); err != nil {
+			log.Printf("Aggregate: %s: bad message: %v", s.ID, err)
+			continue
+		}
+		// Cell updates and the snapshot a session starts with are relayed;
+		// viewer counts, banners and the like belong to the source's own
+		// audience
+		switch update.Type {
+		case "":
+			s.relay(update.CellUpdate)
+		case msgSnapshot:
+			for _, cell := range update.Cells {
+				s.relay(cell)
+			}
+		}
+	}
+}
+
+// relay republishes a cell update of the source under its prefixed grid ID.
+func (s aggregateSource) relay(update CellUpdate) {
+	// Sources that aggregate themselves keep their grid IDs nested
+	if update.Grid != "" {
+		update.Grid = s.ID + "/" + update.Grid
+	} else {
+		update.Grid = s.ID
+	}
+	publish(msgCell, update)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// AlertRule fires when the population crosses a threshold for a number of
+// generations, or grows by a factor within a time window. For example:
+//
+//	alerts:
+//	- name: near-extinction
+//	  populationBelow: 10
+//	  forGenerations: 5
+//	  event: true
+//	- name: population-boom
+//	  growthFactor: 2
+//	  within: 1m
+//	  webhook: http://alertmanager-relay/hook
+type AlertRule struct {
+	Name string `json:"name"`
+
+	PopulationBelow *int `json:"populationBelow,omitempty"`
+	PopulationAbove *int `json:"populationAbove,omitempty"`
+	// ForGenerations is how many consecutive generations a threshold must
+	// hold before the alert fires; defaults to 1.
+	ForGenerations int `json:"forGenerations,omitempty"`
+
+	GrowthFactor float64         `json:"growthFactor,omitempty"`
+	Within       metav1.Duration `json:"within,omitempty"`
+
+	// Webhook receives the Alert as a JSON POST.
+	Webhook string `json:"webhook,omitempty"`
+	// Event records a Kubernetes Warning event on the controller Deployment.
+	Event bool `json:"event,omitempty"`
+}
+
+func (r AlertRule) validate() error {
+	conditions := 0
+	if r.PopulationBelow != nil {
+		conditions++
+	}
+	if r.PopulationAbove != nil {
+		conditions++
+	}
+	if r.GrowthFactor != 0 {
+		conditions++
+		if r.GrowthFactor <= 1 || r.Within.Duration <= 0 {
+			return errors.New("growthFactor must be > 1 with a positive within")
+		}
+	}
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if conditions != 1 {
+		return errors.New("exactly one of populationBelow, populationAbove or growthFactor is required")
+	}
+	return nil
+}
+
+// Alert is what a firing rule reports, on the stream and to webhooks.
+type Alert struct {
+	Name       string    `json:"name"`
+	Message    string    `json:"message"`
+	Generation int64     `json:"generation"`
+	Population int       `json:"population"`
+	Time       time.Time `json:"time"`
+}
+
+type populationSample struct {
+	time       time.Time
+	population int
+}
+
+// alertEngine evaluates the rules against every population sample. A rule
+// fires once per episode and re-arms when its condition clears.
+type alertEngine struct {
+	mu       sync.Mutex
+	rules    []AlertRule
+	streak   []int
+	firing   []bool
+	history  []populationSample
+	recorder record.EventRecorder
+	target   *v1.ObjectReference
+	client   *http.Client
+	osc      *oscOutput
+}
+
+func newAlertEngine(rules []AlertRule, recorder record.EventRecorder, namespace string) *alertEngine {
+	return &alertEngine{
+		rules:    rules,
+		streak:   make([]int, len(rules)),
+		firing:   make([]bool, len(rules)),
+		recorder: recorder,
+		target: &v1.ObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       "grid-controller",
+			Namespace:  namespace,
+		},
+		client: egressClient("alerts", 5*time.Second),
+	}
+}
+
+func (a *alertEngine) Observe(gen int64, population int) {
+	if len(a.rules) == 0 {
+		return
+	}
+	now := time.Now()
+
+	a.mu.Lock()
+	var window time.Duration
+	for _, r := range a.rules {
+		window = max(window, r.Within.Duration)
+	}
+	a.history = append(a.history, populationSample{now, population})
+	for len(a.history) > 1 && now.Sub(a.history[0].time) > window {
+		a.history = a.history[1:]
+	}
+
+	var fired []Alert
+	for i, r := range a.rules {
+		var hit bool
+		var msg string
+		switch {
+		case r.PopulationBelow != nil:
+			hit = population < *r.PopulationBelow
+			msg = fmt.Sprintf("population %d below %d for %d generations", population, *r.PopulationBelow, max(r.ForGenerations, 1))
+		case r.PopulationAbove != nil:
+			hit = population > *r.PopulationAbove
+			msg = fmt.Sprintf("population %d above %d for %d generations", population, *r.PopulationAbove, max(r.ForGenerations, 1))
+		default:
+			low := population
+			for _, s := range a.history {
+				if now.Sub(s.time) <= r.Within.Duration {
+					low = min(low, s.population)
+				}
+			}
+			hit = low > 0 && float64(population) >= r.GrowthFactor*float64(low)
+			msg = fmt.Sprintf("population grew from %d to %d within %s", low, population, r.Within.Duration)
+		}
+
+		if !hit {
+			a.streak[i], a.firing[i] = 0, false
+			continue
+		}
+		a.streak[i]++
+		if !a.firing[i] && a.streak[i] >= max(r.ForGenerations, 1) {
+			a.firing[i] = true
+			fired = append(fired, Alert{Name: r.Name, Message: msg, Generation: gen, Population: population, Time: now})
+		}
+	}
+	a.mu.Unlock()
+
+	for _, alert := range fired {
+		a.notify(alert)
+	}
+}
+
+func (a *alertEngine) notify(alert Alert) {
+	for _, r := range a.rules {
+		if r.Name == alert.Name {
+			a.Emit(alert, r.Event, r.Webhook)
+			return
+		}
+	}
+}
+
+// Emit reports an alert on the stream and, if asked, as a Kubernetes event
+// and to a webhook.
+func (a *alertEngine) Emit(alert Alert, event bool, webhook string) {
+	log.Printf("Alert %s: %s (generation %d)", alert.Name, alert.Message, alert.Generation)
+	go publish(msgAlert, alert)
+	if a == nil {
+		return
+	}
+
+	a.osc.Alert(alert)
+	if event && a.recorder != nil {
+		a.recorder.Event(a.target, v1.EventTypeWarning, "GridAlert", alert.Name+": "+alert.Message)
+	}
+	if webhook != "" {
+		go func() {
+			body, _ := json.Marshal(alert)
+			resp, err := a.client.Post(webhook, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Alert %s: webhook: %v", alert.Name, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Printf("Alert %s: webhook returned %s", alert.Name, resp.Status)
+			}
+		}()
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Verbs an API key can be granted on a grid.
+const (
+	// keyVerbView watches a grid's stream.
+	keyVerbView = "view"
+	// keyVerbSpawn brings cells to life, one at a time or with patterns.
+	keyVerbSpawn = "spawn"
+	// keyVerbKill kills cells, deletes cell pods and runs chaos.
+	keyVerbKill = "kill"
+	// keyVerbControl steers the simulation as an operator does.
+	keyVerbControl = "control"
+)
+
+var keyVerbs = []string{keyVerbView, keyVerbSpawn, keyVerbKill, keyVerbControl}
+
+// keyAllGrids grants a key's verbs on every grid.
+const keyAllGrids = "*"
+
+// apiKeyRefreshInterval is how often the keys are re-read from their Secret,
+// so that keys created or revoked through another replica take effect.
+const apiKeyRefreshInterval = 30 * time.Second
+
+var apiKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_api_key_requests_total",
+	Help: "Requests made with API keys, by key and result: allowed, grid or verb.",
+}, []string{"key", "result"})
+
+// APIKey is a bearer token granting verbs on some grids, e.g. kill on the
+// grid of a partner team, served by a controller run with --grid-id=team-a:
+//
+//	{"name": "team-a-chaos", "grids": ["team-a"], "verbs": ["kill"]}
+//
+// Grids name grids created through /api/grids or this controller's
+// --grid-id; "*" is every grid. Only this controller's own grid is spawned
+// into, killed and controlled here, so other grids can only be granted view;
+// without --grid-id the own grid is named by "*" alone. Keys are managed by
+// administrators through /api/keys and stored, hashed, in the Secret named
+// by --api-keys-secret.
+type APIKey struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Grids   []string   `json:"grids"`
+	Verbs   []string   `json:"verbs"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Rotated *time.Time `json:"rotated,omitempty"`
+}
+
+func (k APIKey) validate() error {
+	if !gridNamePattern.MatchString(k.Name) {
+		return errors.New("name must be a DNS label")
+	}
+	if len(k.Grids) == 0 {
+		return errors.New("grids must name some grids, or * for all of them")
+	}
+	for _, g := range k.Grids {
+		if g != keyAllGrids && !gridNamePattern.MatchString(g) {
+			return fmt.Errorf("grid %q is not a grid name", g)
+		}
+	}
+	if len(k.Verbs) == 0 {
+		return fmt.Errorf("verbs must list some of %v", keyVerbs)
+	}
+	for _, v := range k.Verbs {
+		if !slices.Contains(keyVerbs, v) {
+			return fmt.Errorf("unknown verb %q (want one of %v)", v, keyVerbs)
+		}
+		if v == keyVerbView {
+			continue
+		}
+		for _, g := range k.Grids {
+			if g != keyAllGrids && g != gridID {
+				return fmt.Errorf("grid %q can only be granted %s: %s applies to this controller's own grid, %s", g, keyVerbView, v, ownGridName())
+			}
+		}
+	}
+	if k.Expires != nil && k.Expires.Before(time.Now()) {
+		return errors.New("expires is in the past")
+	}
+	return nil
+}
+
+// ownGridName names this controller's grid in API key errors.
+func ownGridName() string {
+	if gridID == "" {
+		return fmt.Sprintf("granted with %q (--grid-id is not set)", keyAllGrids)
+	}
+	return fmt.Sprintf("%q", gridID)
+}
+
+// storedAPIKey is a key as kept in the Secret. Only the SHA-256 of its token
+// is stored; after a rotation the previous token stays valid until
+// PreviousExpires.
+type storedAPIKey struct {
+	APIKey
+	TokenSHA256     string     `json:"tokenSHA256"`
+	PreviousSHA256  string     `json:"previousSHA256,omitempty"`
+	PreviousExpires *time.Time `json:"previousExpires,omitempty"`
+}
+
+// allows reports whether the key grants the verb on the grid.
+func (k *storedAPIKey) allows(grid, verb string) bool {
+	return slices.Contains(k.Verbs, verb) && (slices.Contains(k.Grids, keyAllGrids) || slices.Contains(k.Grids, grid))
+}
+
+// matches reports whether the token digest is the key's, or its previous
+// one during the rotation grace period.
+func (k *storedAPIKey) matches(digest string, now time.Time) bool {
+	if k.Expires != nil && now.After(*k.Expires) {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(k.TokenSHA256)) == 1 {
+		return true
+	}
+	return k.PreviousSHA256 != "" && k.PreviousExpires != nil && now.Before(*k.PreviousExpires) &&
+		subtle.ConstantTimeCompare([]byte(digest), []byte(k.PreviousSHA256)) == 1
+}
+
+// apiKeys holds the API keys; nil without --api-keys-secret.
+var apiKeys *apiKeyStore
+
+// apiKeyStore keeps the keys of a Secret, one JSON storedAPIKey per data
+// entry named by the key's ID.
+type apiKeyStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+
+	mu   sync.RWMutex
+	keys map[string]*storedAPIKey
+}
+
+func newAPIKeyStore(clientset kubernetes.Interface, namespace, name string) *apiKeyStore {
+	return &apiKeyStore{clientset: clientset, namespace: namespace, name: name, keys: map[string]*storedAPIKey{}}
+}
+
+// Run re-reads the Secret every apiKeyRefreshInterval until ctx is done.
+func (s *apiKeyStore) Run(ctx context.Context) {
+	if err := s.load(ctx); err != nil {
+		log.Printf("API keys: %v", err)
+	}
+	ticker := time.NewTicker(apiKeyRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.load(ctx); err != nil {
+			log.Printf("API keys: %v", err)
+		}
+	}
+}
+
+func (s *apiKeyStore) load(ctx context.Context) error {
+	secret, err := s.clientset.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret, err = &v1.Secret{}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("read Secret %s: %w", s.name, err)
+	}
+	keys := decodeAPIKeys(secret)
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func decodeAPIKeys(secret *v1.Secret) map[string]*storedAPIKey {
+	keys := make(map[string]*storedAPIKey, len(secret.Data))
+	for id, data := range secret.Data {
+		var k storedAPIKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			log.Printf("API keys: skipping %s: %v", id, err)
+			continue
+		}
+		keys[id] = &k
+	}
+	return keys
+}
+
+// update applies change to the keys in the Secret, creating it if needed,
+// and retries on conflicts with other replicas.
+func (s *apiKeyStore) update(ctx context.Context, change func(keys map[string]*storedAPIKey) error) error {
+	secrets := s.clientset.CoreV1().Secrets(s.namespace)
+	var keys map[string]*storedAPIKey
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, s.name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			secret, err = &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace}}, nil
+		}
+		if err != nil {
+			return err
+		}
+		keys = decodeAPIKeys(secret)
+		if err := change(keys); err != nil {
+			return err
+		}
+		secret.Data = make(map[string][]byte, len(keys))
+		for id, k := range keys {
+			if secret.Data[id], err = json.Marshal(k); err != nil {
+				return err
+			}
+		}
+		if create {
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		} else {
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// List returns the keys by name.
+func (s *apiKeyStore) List() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k.APIKey)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Create stores a new key and returns it with its token, which is not
+// kept.
+func (s *apiKeyStore) Create(ctx context.Context, k APIKey) (APIKey, string, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	token, err := newAPIKeyToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	k.ID, k.Created, k.Rotated = id, time.Now().UTC(), nil
+	err = s.update(ctx, func(keys map[string]*storedAPIKey) error {
+		for _, other := range keys {
+			if other.Name == k.Name {
+				return errAPIKeyExists
+			}
+		}
+		keys[k.ID] = &storedAPIKey{APIKey: k, TokenSHA256: tokenDigest(token)}
+		return nil
+	})
+	return k, token, err
+}
+
+// Rotate gives a key a new token. The old one keeps working for grace, so
+// that its users can switch over.
+func (s *apiKeyStore) Rotate(ctx context.Context, id string, grace time.Duration) (APIKey, string, error) {
+	token, err := newAPIKeyToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	var rotated APIKey
+	err = s.update(ctx, func(keys map[string]*storedAPIKey) error {
+		k, ok := keys[id]
+		if !ok {
+			return errAPIKeyNotFound
+		}
+		now := time.Now().UTC()
+		k.PreviousSHA256, k.PreviousExpires = "", nil
+		if grace > 0 {
+			until := now.Add(grace)
+			k.PreviousSHA256, k.PreviousExpires = k.TokenSHA256, &until
+		}
+		k.TokenSHA256, k.Rotated = tokenDigest(token), &now
+		rotated = k.APIKey
+		return nil
+	})
+	return rotated, token, err
+}
+
+// Revoke deletes a key.
+func (s *apiKeyStore) Revoke(ctx context.Context, id string) error {
+	return s.update(ctx, func(keys map[string]*storedAPIKey) error {
+		if _, ok := keys[id]; !ok {
+			return errAPIKeyNotFound
+		}
+		delete(keys, id)
+		return nil
+	})
+}
+
+var (
+	errAPIKeyNotFound = errors.New("API key not found")
+	errAPIKeyExists   = errors.New("an API key of that name exists")
+)
+
+// newAPIKeyToken returns a random token; the prefix tells keys from the
+// other bearer tokens.
+func newAPIKeyToken() (string, error) {
+	secret, err := randomHex(32)
+	return "gk_" + secret, err
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFor returns the key of the request's bearer token, or nil.
+func apiKeyFor(r *http.Request) *storedAPIKey {
+	if apiKeys == nil {
+		return nil
+	}
+	token, ok := bearerToken(r)
+	if !ok || !strings.HasPrefix(token, "gk_") {
+		return nil
+	}
+	digest, now := tokenDigest(token), time.Now()
+	apiKeys.mu.RLock()
+	defer apiKeys.mu.RUnlock()
+	for _, k := range apiKeys.keys {
+		if k.matches(digest, now) {
+			return k
+		}
+	}
+	return nil
+}
+
+// allowKey checks that a request made with an API key is granted the verbs
+// on the grid and writes the error response otherwise. While keys are
+// configured, a request without one needs another identity, e.g. an OIDC ID
+// token or a bot's token, so that dropping a key's header grants nothing;
+// beyond that it is left to the endpoint's own checks.
+func allowKey(w http.ResponseWriter, r *http.Request, grid string, verbs ...string) bool {
+	k := apiKeyFor(r)
+	if k == nil {
+		if apiKeys != nil && requestIdentity(r) == "anonymous" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized: an API key is required", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	if !slices.Contains(k.Grids, keyAllGrids) && !slices.Contains(k.Grids, grid) {
+		apiKeyRequests.WithLabelValues(k.Name, "grid").Inc()
+		http.Error(w, fmt.Sprintf("Forbidden: API key %s is not granted grid %q", k.Name, grid), http.StatusForbidden)
+		return false
+	}
+	for _, v := range verbs {
+		if !k.allows(grid, v) {
+			apiKeyRequests.WithLabelValues(k.Name, "verb").Inc()
+			http.Error(w, "Forbidden: API key "+k.Name+" is not granted "+v, http.StatusForbidden)
+			return false
+		}
+	}
+	apiKeyRequests.WithLabelValues(k.Name, "allowed").Inc()
+	return true
+}
+
+// apiKeyResponse is a key as returned when it is created or rotated, the
+// only time its token is shown.
+type apiKeyResponse struct {
+	APIKey
+	Token string `json:"token"`
+}
+
+// handleAPIKeys serves the administrators' API for keys: GET /api/keys
+// lists them, POST /api/keys creates one from an APIKey body (expires is
+// optional), DELETE /api/keys/{id} revokes one and
+// POST /api/keys/{id}/rotate?grace=1h issues a new token, keeping the old
+// one valid for the grace period (none by default).
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if apiKeys == nil {
+		http.Error(w, "API keys require --api-keys-secret", http.StatusConflict)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/keys"), "/")
+	var resp any
+	status := http.StatusOK
+	switch {
+	case r.Method == "GET" && id == "":
+		resp = apiKeys.List()
+
+	case r.Method == "POST" && id == "":
+		var k APIKey
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16384)).Decode(&k); err != nil {
+			http.Error(w, "Invalid API key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := k.validate(); err != nil {
+			http.Error(w, "Invalid API key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, token, err := apiKeys.Create(ctx, k)
+		if errors.Is(err, errAPIKeyExists) {
+			http.Error(w, "API key already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to create API key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("API keys: %s created %s (%s) granting %s on %s", requestIdentity(r), created.Name, created.ID, strings.Join(created.Verbs, ", "), strings.Join(created.Grids, ", "))
+		resp, status = apiKeyResponse{created, token}, http.StatusCreated
+
+	case r.Method == "POST" && strings.HasSuffix(id, "/rotate"):
+		id = strings.TrimSuffix(id, "/rotate")
+		var grace time.Duration
+		if v := r.URL.Query().Get("grace"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, "Invalid grace", http.StatusBadRequest)
+				return
+			}
+			grace = d
+		}
+		rotated, token, err := apiKeys.Rotate(ctx, id, grace)
+		if errors.Is(err, errAPIKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to rotate API key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("API keys: %s rotated %s (%s), old token valid for %s", requestIdentity(r), rotated.Name, rotated.ID, grace)
+		resp = apiKeyResponse{rotated, token}
+
+	case r.Method == "DELETE" && id != "" && !strings.Contains(id, "/"):
+		err := apiKeys.Revoke(ctx, id)
+		if errors.Is(err, errAPIKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke API key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("API keys: %s revoked %s", requestIdentity(r), id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
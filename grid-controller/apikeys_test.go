@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyMatches(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	current, previous := tokenDigest("gk_current"), tokenDigest("gk_previous")
+
+	tests := []struct {
+		name   string
+		key    storedAPIKey
+		digest string
+		want   bool
+	}{
+		{"current token", storedAPIKey{TokenSHA256: current}, current, true},
+		{"unknown token", storedAPIKey{TokenSHA256: current}, tokenDigest("gk_other"), false},
+		{"empty digest", storedAPIKey{TokenSHA256: current}, "", false},
+		{"current token of an expired key", storedAPIKey{APIKey: APIKey{Expires: &past}, TokenSHA256: current}, current, false},
+		{"current token before expiry", storedAPIKey{APIKey: APIKey{Expires: &future}, TokenSHA256: current}, current, true},
+		{"previous token during grace", storedAPIKey{TokenSHA256: current, PreviousSHA256: previous, PreviousExpires: &future}, previous, true},
+		{"previous token after grace", storedAPIKey{TokenSHA256: current, PreviousSHA256: previous, PreviousExpires: &past}, previous, false},
+		{"previous token without grace", storedAPIKey{TokenSHA256: current, PreviousSHA256: previous}, previous, false},
+		{"current token after rotation", storedAPIKey{TokenSHA256: current, PreviousSHA256: previous, PreviousExpires: &future}, current, true},
+		{"previous token of an expired key", storedAPIKey{APIKey: APIKey{Expires: &past}, TokenSHA256: current, PreviousSHA256: previous, PreviousExpires: &future}, previous, false},
+		{"no previous token", storedAPIKey{TokenSHA256: current, PreviousExpires: &future}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.matches(tt.digest, now); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowKey(t *testing.T) {
+	savedAdmin := adminToken
+	adminToken = "admin-token"
+	defer func() { adminToken = savedAdmin }()
+	future := time.Now().Add(time.Hour)
+	withKeys(t, "main", map[string]*storedAPIKey{
+		"a": {APIKey: APIKey{Name: "team-a-chaos", Grids: []string{"main", "team-a"}, Verbs: []string{keyVerbView, keyVerbKill}}, TokenSHA256: tokenDigest("gk_chaos")},
+		"b": {APIKey: APIKey{Name: "everything", Grids: []string{keyAllGrids}, Verbs: keyVerbs}, TokenSHA256: tokenDigest("gk_all"),
+			PreviousSHA256: tokenDigest("gk_old"), PreviousExpires: &future},
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		grid   string
+		verbs  []string
+		status int
+	}{
+		{"anonymous", "", "main", []string{keyVerbKill}, http.StatusUnauthorized},
+		{"anonymous view", "", "main", []string{keyVerbView}, http.StatusUnauthorized},
+		{"unknown bearer token", "not-a-key", "main", []string{keyVerbKill}, http.StatusUnauthorized},
+		{"unknown key", "gk_unknown", "main", []string{keyVerbKill}, http.StatusUnauthorized},
+		{"admin token", "admin-token", "main", []string{keyVerbKill}, 0},
+		{"granted verb", "gk_chaos", "main", []string{keyVerbKill}, 0},
+		{"view on another granted grid", "gk_chaos", "team-a", []string{keyVerbView}, 0},
+		{"no verbs", "gk_chaos", "main", nil, 0},
+		{"verb not granted", "gk_chaos", "main", []string{keyVerbSpawn}, http.StatusForbidden},
+		{"one of the verbs not granted", "gk_chaos", "main", []string{keyVerbKill, keyVerbControl}, http.StatusForbidden},
+		{"grid not granted", "gk_chaos", "team-b", []string{keyVerbView}, http.StatusForbidden},
+		{"grid not granted without verbs", "gk_chaos", "team-b", nil, http.StatusForbidden},
+		{"every grid", "gk_all", "team-b", []string{keyVerbView, keyVerbControl}, 0},
+		{"previous token during grace", "gk_old", "main", []string{keyVerbSpawn}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/chaos", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			ok := allowKey(w, r, tt.grid, tt.verbs...)
+			if ok != (tt.status == 0) {
+				t.Fatalf("allowKey = %v, want %v", ok, tt.status == 0)
+			}
+			if tt.status != 0 && w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestAllowKeyWithoutKeys(t *testing.T) {
+	withKeys(t, "main", nil)
+	apiKeys = nil
+	r := httptest.NewRequest("POST", "/api/chaos", nil)
+	r.Header.Set("Authorization", "Bearer gk_chaos")
+	if !allowKey(httptest.NewRecorder(), r, "main", keyVerbKill) {
+		t.Error("allowKey refused a request while API keys are disabled")
+	}
+}
+
+func TestAPIKeyValidateGrants(t *testing.T) {
+	tests := []struct {
+		name   string
+		gridID string
+		key    APIKey
+		err    string
+	}{
+		{"own grid", "main", APIKey{Name: "k", Grids: []string{"main"}, Verbs: []string{keyVerbKill}}, ""},
+		{"every grid", "main", APIKey{Name: "k", Grids: []string{keyAllGrids}, Verbs: keyVerbs}, ""},
+		{"view on another grid", "main", APIKey{Name: "k", Grids: []string{"main", "team-a"}, Verbs: []string{keyVerbView}}, ""},
+		{"kill on another grid", "main", APIKey{Name: "k", Grids: []string{"team-a"}, Verbs: []string{keyVerbKill}}, `grid "team-a" can only be granted view`},
+		{"spawn on own and another grid", "main", APIKey{Name: "k", Grids: []string{"main", "team-a"}, Verbs: []string{keyVerbView, keyVerbSpawn}}, `grid "team-a" can only be granted view`},
+		{"kill without --grid-id", "", APIKey{Name: "k", Grids: []string{"main"}, Verbs: []string{keyVerbKill}}, "--grid-id is not set"},
+		{"every grid without --grid-id", "", APIKey{Name: "k", Grids: []string{keyAllGrids}, Verbs: []string{keyVerbKill}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withKeys(t, tt.gridID, nil)
+			err := tt.key.validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("validate: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("validate = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// withKeys sets the controller's grid ID and API keys for a test.
+func withKeys(t *testing.T, id string, keys map[string]*storedAPIKey) {
+	savedID, savedKeys := gridID, apiKeys
+	t.Cleanup(func() { gridID, apiKeys = savedID, savedKeys })
+	gridID, apiKeys = id, &apiKeyStore{keys: keys}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// archiveVersion is bumped whenever Archive changes incompatibly.
+const archiveVersion = 1
+
+// lifeRule is the built-in rule of the engine.
+const lifeRule = "B3/S23"
+
+// Archive is a portable copy of a standalone grid: its live cells, rule,
+// settings and statistics. Export it from one cluster and import it into
+// another to move a long-running world.
+type Archive struct {
+	Version    int          `json:"version"`
+	Exported   time.Time    `json:"exported"`
+	Grid       GridGeometry `json:"grid"`
+	Rule       string       `json:"rule"`
+	Generation int64        `json:"generation"`
+	Alive      []int        `json:"alive"`
+	// Genomes of the live cells, when genetics is enabled.
+	Genomes map[int]Genome `json:"genomes,omitempty"`
+	Paused  bool           `json:"paused"`
+
+	Config ArchiveConfig `json:"config"`
+	Stats  []StatsSample `json:"stats"`
+}
+
+// ArchiveConfig is the simulation configuration carried in an archive. It is
+// informational on import: the importing controller keeps its own settings.
+type ArchiveConfig struct {
+	TickInterval string           `json:"tickInterval"`
+	ViewerBirths float64          `json:"viewerBirths"`
+	Extinction   ExtinctionPolicy `json:"extinction"`
+}
+
+// Export captures the simulation as an archive.
+func (s *simulation) Export() *Archive {
+	gen, alive := s.engine.Snapshot()
+	return &Archive{
+		Version:    archiveVersion,
+		Exported:   time.Now().UTC(),
+		Grid:       s.engine.grid,
+		Rule:       s.engine.Rule(),
+		Generation: gen,
+		Alive:      alive,
+		Genomes:    s.engine.Genomes(),
+		Paused:     s.Paused(),
+		Config: ArchiveConfig{
+			TickInterval: s.interval.String(),
+			ViewerBirths: s.viewerBirths,
+			Extinction:   s.extinction,
+		},
+		Stats: s.stats.Samples(),
+	}
+}
+
+func (a *Archive) validate(grid GridGeometry, rule string) error {
+	if a.Version != archiveVersion {
+		return fmt.Errorf("unsupported archive version %d", a.Version)
+	}
+	if !sameRule(a.Rule, rule) {
+		return fmt.Errorf("archive rule is %s, this grid runs %s", a.Rule, rule)
+	}
+	if a.Grid != grid {
+		return fmt.Errorf("archive grid is %dx%d, this grid is %dx%d", a.Grid.Width, a.Grid.Height, grid.Width, grid.Height)
+	}
+	if a.Generation < 0 {
+		return fmt.Errorf("negative generation")
+	}
+	for _, i := range a.Alive {
+		if i < 0 || i >= grid.Size() {
+			return fmt.Errorf("cell %d outside the grid", i)
+		}
+	}
+	return nil
+}
+
+// Import replaces the simulation state with an archive's.
+func (s *simulation) Import(a *Archive) {
+	births, deaths := s.engine.Restore(a.Generation, a.Alive)
+	s.engine.SetGenomes(a.Genomes)
+	s.stats.Replace(a.Stats)
+	s.mu.Lock()
+	s.paused = a.Paused
+	s.extinct, s.reseeded = time.Time{}, false
+	s.mu.Unlock()
+	s.snapshots.Restart(a.Generation)
+	s.digests.Reset()
+
+	if s.federation != nil {
+		s.federation.Record()
+		s.federation.Publish(births, deaths)
+	}
+	s.cells.Resync()
+	log.Printf("Engine: imported generation %d with %d live cells", a.Generation, len(a.Alive))
+}
+
+// handleArchive serves GET /api/archive, which downloads the grid as an
+// archive, and POST /api/archive for administrators, which imports one.
+func handleArchive(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == "POST" && !requireAdmin(w, r) {
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Archives require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	if r.Method == "GET" {
+		a := s.Export()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="grid-%s-gen%d.json"`, a.Exported.Format("20060102T150405Z"), a.Generation))
+		json.NewEncoder(w).Encode(a)
+		return
+	}
+
+	var a Archive
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Archive too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.validate(s.engine.grid, s.engine.Rule()); err != nil {
+		http.Error(w, "Invalid archive: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	s.Import(&a)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Recover decides the engine's starting state. Unless disabled it resumes from
+// the newest usable snapshot, keeping its generation numbering, pause state
+// and statistics; failing that it adopts the live cell pods left by the
+// previous run at generation 0. Only a grid with neither is freshly seeded.
+// The pod manager then reconciles the pods to whatever was chosen.
+func (s *simulation) Recover(ctx context.Context, enabled bool, pods corelisters.PodLister, namespace string) {
+	if enabled && s.snapshots != nil {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		list, err := s.snapshots.store.List(ctx)
+		if err != nil {
+			log.Printf("Engine: recovery: list snapshots: %v", err)
+		}
+		for _, info := range list {
+			a, err := s.snapshots.store.Load(ctx, info.Name)
+			if err == nil {
+				err = a.validate(s.engine.grid, s.engine.Rule())
+			}
+			if err != nil {
+				log.Printf("Engine: recovery: skipping snapshot %s: %v", info.Name, err)
+				continue
+			}
+			log.Printf("Engine: recovering from snapshot %s", info.Name)
+			s.Import(a)
+			return
+		}
+	}
+
+	if enabled {
+		alive, err := materializedCells(pods, namespace)
+		if err == nil && len(alive) > 0 {
+			log.Printf("Engine: recovering %d live cells from existing pods", len(alive))
+			s.Import(&Archive{Alive: alive, Genomes: podGenomes(pods, namespace)})
+			return
+		}
+	}
+
+	s.engine.Seed()
+	s.mirrorComparison()
+	s.cells.Resync()
+	if s.federation != nil {
+		s.federation.Record()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one line of the access log.
+type AuditRecord struct {
+	Time       time.Time `json:"ts"`
+	Identity   string    `json:"identity"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+// auditLog is an append-only JSONL file of every mutating request, rotated by
+// size: path.1 is the most recent rotation and at most keep rotations are
+// retained.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+func openAuditLog(path string, maxSize int64, keep int) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: maxSize, keep: keep}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, fi.Size()
+	return nil
+}
+
+func (a *auditLog) rotate() error {
+	a.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", a.path, a.keep))
+	for i := a.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	if a.keep > 0 {
+		os.Rename(a.path, a.path+".1")
+	} else {
+		os.Remove(a.path)
+	}
+	return a.open()
+}
+
+func (a *auditLog) Record(rec AuditRecord) {
+	line, _ := json.Marshal(rec)
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxSize > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			log.Printf("Audit: rotate: %v", err)
+			return
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Printf("Audit: write: %v", err)
+	}
+}
+
+// Wrap records every request that is not a read. Reads pass through
+// untouched, which also keeps WebSocket upgrades hijackable.
+func (a *auditLog) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		a.Record(AuditRecord{
+			Time:       start.UTC(),
+			Identity:   requestIdentity(r),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		})
+	})
+}
+
+// statusWriter remembers the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
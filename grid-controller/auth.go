@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminToken guards the administrative endpoints. It is read from the
+// ADMIN_TOKEN environment variable (mounted from a Secret); when unset the
+// admin API is disabled unless OIDC grants the admin role.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// Roles, from least to most privileged. Spectators may watch restricted
+// streams, operators may also steer the simulation, admins may do anything.
+const (
+	roleSpectator = "spectator"
+	roleOperator  = "operator"
+	roleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{"": 0, roleSpectator: 1, roleOperator: 2, roleAdmin: 3}
+
+// bearerToken returns the request's bearer token. Browsers cannot set headers
+// on WebSocket requests, so the access_token query parameter is accepted
+// there too.
+func bearerToken(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token, true
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" && websocketUpgrade(r) {
+		return token, true
+	}
+	return "", false
+}
+
+func websocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func adminTokenValid(r *http.Request) bool {
+	token, ok := bearerToken(r)
+	return ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// requestRole returns the caller's role: admin for the admin token, the
+// mapped role for an OIDC ID token, operator for an API key granted control
+// of this controller's grid, otherwise none.
+func requestRole(r *http.Request) string {
+	if adminTokenValid(r) {
+		return roleAdmin
+	}
+	if id := oidcCaller(r); id != nil {
+		return id.Role
+	}
+	if k := apiKeyFor(r); k != nil && k.allows(gridID, keyVerbControl) {
+		return roleOperator
+	}
+	return ""
+}
+
+func isAdmin(r *http.Request) bool {
+	return requestRole(r) == roleAdmin
+}
+
+// requireRole checks that the caller has at least the given role and writes
+// the error response otherwise.
+func requireRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	if adminToken == "" && oidcAuth == nil && apiKeys == nil {
+		http.Error(w, "Admin API disabled", http.StatusForbidden)
+		return false
+	}
+	have := requestRole(r)
+	if have == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if roleRank[have] < roleRank[role] {
+		http.Error(w, "Forbidden: requires the "+role+" role", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireAdmin checks the request's bearer token and writes the error
+// response when it is missing or wrong.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	return requireRole(w, r, roleAdmin)
+}
+
+// requestIdentity names the caller for logging: "admin" for the admin token,
+// "oidc:<name>" for an ID token, "tenant:<name>" for a tenant, "key:<name>"
+// for an API key, "bot:<name>" for a bot, "chat:<platform>:<user>" for a
+// chat command, otherwise "anonymous".
+func requestIdentity(r *http.Request) string {
+	if adminTokenValid(r) {
+		return "admin"
+	}
+	if id := oidcCaller(r); id != nil {
+		return "oidc:" + id.Name
+	}
+	if t := tenantFor(r); t != nil {
+		return "tenant:" + t.Name
+	}
+	if k := apiKeyFor(r); k != nil {
+		return "key:" + k.Name
+	}
+	if b := botFor(r); b != nil {
+		return "bot:" + b.Name
+	}
+	if u := chatUser(r); u != "" {
+		return "chat:" + u
+	}
+	return "anonymous"
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// AutoChaosConfig lets the chaos monkey kill a live cell pod on a schedule,
+// e.g.
+//
+//	autoChaos:
+//	  interval: 1m
+//	  strategy: random
+//	  seed: 42
+//
+// The strategy is random, oldest (the longest-lived cell), densest (the
+// center of the most crowded region), node:<name> (a cell on that node) or
+// anti-glider (a cell of an isolated glider), and can be changed at runtime
+// with PUT /api/chaos/auto. With a seed the monkey's choices repeat from run to run over the same
+// candidates; without one a seed is drawn at startup and reported in the
+// decision log. Every decision, with the candidates it considered, is kept
+// for GET /api/chaos/auto/log and streamed as a chaos_decision message.
+type AutoChaosConfig struct {
+	Interval metav1.Duration `json:"interval"`
+	Strategy string          `json:"strategy,omitempty"`
+	Seed     int64           `json:"seed,omitempty"`
+}
+
+func (c *AutoChaosConfig) validate() error {
+	if c.Interval.Duration < time.Second {
+		return errors.New("interval must be at least 1s")
+	}
+	if c.Strategy == "" {
+		c.Strategy = chaosRandom
+	}
+	_, err := parseChaosStrategy(c.Strategy)
+	return err
+}
+
+// chaosLogLimit caps the decisions kept for /api/chaos/auto/log.
+const chaosLogLimit = 200
+
+// chaosCandidateLimit caps the candidates listed in one decision.
+const chaosCandidateLimit = 20
+
+var autoChaosKills = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_chaos_auto_kills_total",
+	Help: "Cell pods deleted by the chaos monkey, by strategy.",
+}, []string{"strategy"})
+
+// ChaosDecision records one round of the chaos monkey: what it could have
+// killed, what it picked and why.
+type ChaosDecision struct {
+	Time     time.Time `json:"time"`
+	Strategy string    `json:"strategy"`
+	Seed     int64     `json:"seed"`
+	// Draw counts the monkey's rounds since startup; with Seed it pins the
+	// decision down.
+	Draw       int64    `json:"draw"`
+	Candidates int      `json:"candidates"`
+	Considered []string `json:"considered"`
+	Target     string   `json:"target,omitempty"`
+	Reason     string   `json:"reason"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// chaosMonkey deletes live cell pods on a schedule.
+type chaosMonkey struct {
+	cfg       AutoChaosConfig
+	clientset kubernetes.Interface
+	pods      corelisters.PodLister
+	namespace string
+	grid      GridGeometry
+	sim       *simulation
+	rng       *rand.Rand
+	draws     int64
+
+	mu       sync.Mutex
+	strategy chaosStrategy
+	log      []ChaosDecision
+}
+
+func newChaosMonkey(cfg *AutoChaosConfig, clientset kubernetes.Interface, pods corelisters.PodLister, namespace string, grid GridGeometry, sim *simulation) *chaosMonkey {
+	if cfg == nil {
+		return nil
+	}
+	m := &chaosMonkey{cfg: *cfg, clientset: clientset, pods: pods, namespace: namespace, grid: grid, sim: sim}
+	if m.cfg.Seed == 0 {
+		m.cfg.Seed = time.Now().UnixNano()
+	}
+	m.rng = rand.New(rand.NewSource(m.cfg.Seed))
+	m.strategy, _ = parseChaosStrategy(cfg.Strategy)
+	return m
+}
+
+// Strategy returns the current targeting strategy.
+func (m *chaosMonkey) Strategy() chaosStrategy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.strategy
+}
+
+// SetStrategy switches the targeting strategy from the next round on.
+func (m *chaosMonkey) SetStrategy(s chaosStrategy) {
+	m.mu.Lock()
+	m.strategy = s
+	m.mu.Unlock()
+	log.Printf("Chaos: auto-chaos strategy %s", s.Name())
+}
+
+func (m *chaosMonkey) Run(ctx context.Context) {
+	log.Printf("Chaos: auto-chaos every %s, strategy %s, seed %d", m.cfg.Interval.Duration, m.Strategy().Name(), m.cfg.Seed)
+	ticker := time.NewTicker(m.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if m.sim != nil && (m.sim.Frozen() || m.sim.OffHours()) {
+				continue
+			}
+			m.round(ctx)
+		}
+	}
+}
+
+// candidates lists the live cells whose pods are not already going away,
+// ordered by cell so a seed replays the same choices.
+func (m *chaosMonkey) candidates() ([]chaosTarget, error) {
+	list, err := m.pods.Pods(m.namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell", "game-status": "alive"}))
+	if err != nil {
+		return nil, err
+	}
+	var targets []chaosTarget
+	for _, pod := range list {
+		if i, ok := cellIndex(pod.Name); ok && pod.DeletionTimestamp == nil {
+			targets = append(targets, chaosTarget{pod: pod, cell: i})
+		}
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a].cell < targets[b].cell })
+	return targets, nil
+}
+
+func (m *chaosMonkey) round(ctx context.Context) {
+	m.draws++
+	strategy := m.Strategy()
+	d := ChaosDecision{Time: time.Now(), Strategy: strategy.Name(), Seed: m.cfg.Seed, Draw: m.draws, Considered: []string{}}
+	targets, err := m.candidates()
+	if err != nil {
+		d.Reason, d.Error = "could not list cell pods", err.Error()
+		m.record(d)
+		return
+	}
+	d.Candidates = len(targets)
+	for _, t := range targets[:min(len(targets), chaosCandidateLimit)] {
+		d.Considered = append(d.Considered, t.pod.Name)
+	}
+	if len(targets) == 0 {
+		d.Reason = "no live cells to kill"
+		m.record(d)
+		return
+	}
+
+	pick, reason, ok := strategy.Pick(m.rng, m.grid, targets)
+	d.Reason = reason
+	if !ok {
+		m.record(d)
+		return
+	}
+	d.Target = targets[pick].pod.Name
+	log.Printf("Chaos: auto-chaos deleting pod %s (%s)", d.Target, d.Reason)
+	err = podWrites.Do(ctx, verbDelete, func(ctx context.Context) error {
+		return m.clientset.CoreV1().Pods(m.namespace).Delete(ctx, d.Target, metav1.DeleteOptions{})
+	})
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		autoChaosKills.WithLabelValues(strategy.Name()).Inc()
+	}
+	m.record(d)
+}
+
+func (m *chaosMonkey) record(d ChaosDecision) {
+	m.mu.Lock()
+	m.log = append(m.log, d)
+	if len(m.log) > chaosLogLimit {
+		m.log = m.log[len(m.log)-chaosLogLimit:]
+	}
+	m.mu.Unlock()
+	go publish(msgChaosDecision, d)
+}
+
+// Decisions returns the most recent decisions, newest first.
+func (m *chaosMonkey) Decisions(limit int) []ChaosDecision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]ChaosDecision, 0, min(limit, len(m.log)))
+	for i := len(m.log) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, m.log[i])
+	}
+	return out
+}
+
+// handleChaosLog serves GET /api/chaos/auto/log?limit=, the chaos monkey's
+// recent decisions, newest first.
+func handleChaosLog(w http.ResponseWriter, r *http.Request, m *chaosMonkey) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if m == nil {
+		http.Error(w, "Auto-chaos is not configured", http.StatusNotFound)
+		return
+	}
+
+	limit := chaosLogLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Decisions(limit))
+}
+
+// AutoChaosStatus is served by /api/chaos/auto.
+type AutoChaosStatus struct {
+	Interval   metav1.Duration `json:"interval"`
+	Strategy   string          `json:"strategy"`
+	Seed       int64           `json:"seed"`
+	Strategies []string        `json:"strategies"`
+}
+
+// handleAutoChaos serves GET /api/chaos/auto, the monkey's settings, and
+// PUT /api/chaos/auto with {"strategy": ...}, which switches its targeting
+// strategy.
+func handleAutoChaos(w http.ResponseWriter, r *http.Request, m *chaosMonkey) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if m == nil {
+		http.Error(w, "Auto-chaos is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		if !requireRole(w, r, roleOperator) {
+			return
+		}
+		var req struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		strategy, err := parseChaosStrategy(req.Strategy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.SetStrategy(strategy)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AutoChaosStatus{
+		Interval:   m.cfg.Interval,
+		Strategy:   m.Strategy().Name(),
+		Seed:       m.cfg.Seed,
+		Strategies: chaosStrategyNames,
+	})
+}
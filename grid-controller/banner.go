@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BannerMessage is an operator notice shown on every dashboard, e.g. ahead of
+// cluster maintenance. An empty text clears the banner.
+type BannerMessage struct {
+	Text     string `json:"text"`
+	Severity string `json:"severity"`
+	// DurationSeconds is how long clients show the banner; 0 until cleared.
+	DurationSeconds int `json:"durationSeconds"`
+}
+
+var (
+	bannerMu      sync.Mutex
+	bannerMsg     *Message
+	bannerExpires time.Time
+)
+
+// currentBanner returns the active banner for newly connected clients.
+func currentBanner() *Message {
+	bannerMu.Lock()
+	defer bannerMu.Unlock()
+	if bannerMsg == nil || (!bannerExpires.IsZero() && time.Now().After(bannerExpires)) {
+		return nil
+	}
+	return bannerMsg
+}
+
+func publishBanner(b BannerMessage) {
+	msg := &Message{Type: msgBanner, Data: b, Time: time.Now()}
+
+	bannerMu.Lock()
+	if b.Text == "" {
+		bannerMsg = nil
+	} else {
+		bannerMsg = msg
+	}
+	bannerExpires = time.Time{}
+	if b.DurationSeconds > 0 {
+		bannerExpires = time.Now().Add(time.Duration(b.DurationSeconds) * time.Second)
+	}
+	bannerMu.Unlock()
+
+	broadcast <- msg
+}
+
+// handleBroadcast serves POST /api/broadcast for administrators.
+func handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	// CORS
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var b BannerMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&b); err != nil {
+		http.Error(w, "Invalid banner: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch b.Severity {
+	case "":
+		b.Severity = "info"
+	case "info", "warning", "critical":
+	default:
+		http.Error(w, "Severity must be info, warning or critical", http.StatusBadRequest)
+		return
+	}
+	if len(b.Text) > 500 || b.DurationSeconds < 0 {
+		http.Error(w, "Text too long or negative duration", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Broadcast: %s banner %q for %ds", b.Severity, b.Text, b.DurationSeconds)
+	publishBanner(b)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// BenchReport is the result of `grid-controller bench`.
+type BenchReport struct {
+	Source       string  `json:"source"`
+	Clients      int     `json:"clients"`
+	DurationSec  float64 `json:"durationSec"`
+	Published    int64   `json:"published"`
+	Delivered    int64   `json:"delivered"`
+	Dropped      int64   `json:"dropped"`
+	PublishRate  float64 `json:"publishRate"`
+	DeliveryRate float64 `json:"deliveryRate"`
+	AllocsPerMsg float64 `json:"allocsPerMsg"`
+	BytesPerMsg  float64 `json:"bytesPerMsg"`
+	LatencyP50Ms float64 `json:"latencyP50Ms"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+	LatencyMaxMs float64 `json:"latencyMaxMs"`
+}
+
+// runBench implements `grid-controller bench`: it drives the hub with
+// synthetic cell churn, either published directly or through pod events of a
+// fake clientset and informer, to in-process clients, and reports throughput,
+// allocations and broadcast latency. Latency is measured from the hub
+// dispatching a message to a client receiving it. It returns the exit code.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	clientCount := fs.Int("clients", 100, "number of simulated WebSocket clients")
+	rate := fs.Float64("rate", 1000, "cell updates per second; 0 publishes as fast as possible")
+	duration := fs.Duration("duration", 10*time.Second, "how long to generate churn")
+	cellCount := fs.Int("cells", 1024, "number of distinct cells churned")
+	pods := fs.Bool("fake-clientset", false, "churn pods in a fake clientset and broadcast them through the pod informer, as the controller does; allocations then include the fake clientset")
+	queue := fs.Int("client-queue", 256, "per-client send queue length")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	maxP99 := fs.Duration("max-p99", 0, "exit with status 1 if the p99 broadcast latency exceeds this; 0 disables")
+	fs.Parse(args)
+
+	hubConfig.QueueSize = *queue
+	hubConfig.SlowConsumerTimeout = 0
+	go handleMessages(context.Background())
+
+	latencies := make([][]time.Duration, *clientCount)
+	var delivered atomic.Int64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := range latencies {
+		c := &wsClient{version: protocolV2, send: make(chan outbound, *queue), done: make(chan struct{})}
+		clientsMu.Lock()
+		clients[c] = true
+		clientsMu.Unlock()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var env struct {
+				Time time.Time `json:"ts"`
+			}
+			for {
+				select {
+				case msg := <-c.send:
+					if json.Unmarshal(msg.data, &env) == nil {
+						latencies[i] = append(latencies[i], time.Since(env.Time))
+					}
+					delivered.Add(1)
+				case <-done:
+					return
+				}
+			}
+		}(i)
+	}
+
+	var publish func(n int64)
+	source := "direct"
+	stopCh := make(chan struct{})
+	if *pods {
+		source = "fake-clientset"
+		publish = benchPods(*cellCount, stopCh)
+	} else {
+		publish = func(n int64) {
+			i := int(n % int64(*cellCount))
+			status := "alive"
+			if (n/int64(*cellCount))%2 == 1 {
+				status = "dead"
+			}
+			broadcast <- &Message{Type: msgCell, Data: CellUpdate{Name: cellName(i), Status: status, Namespace: "bench"}}
+		}
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var published int64
+	for time.Since(start) < *duration {
+		due := int64(*rate * time.Since(start).Seconds())
+		if *rate <= 0 {
+			due = published + 1
+		}
+		for ; published < due; published++ {
+			publish(published)
+		}
+		if *rate > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(stopCh)
+	elapsed := time.Since(start)
+
+	// Let the clients drain what the hub already queued.
+	want := published * int64(*clientCount)
+	for deadline := time.Now().Add(5 * time.Second); delivered.Load() < want && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	runtime.ReadMemStats(&after)
+	close(done)
+	wg.Wait()
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	percentile := func(p float64) time.Duration {
+		if len(all) == 0 {
+			return 0
+		}
+		return all[min(len(all)-1, int(p*float64(len(all))))]
+	}
+
+	report := BenchReport{
+		Source:       source,
+		Clients:      *clientCount,
+		DurationSec:  elapsed.Seconds(),
+		Published:    published,
+		Delivered:    delivered.Load(),
+		Dropped:      max(0, want-delivered.Load()),
+		PublishRate:  float64(published) / elapsed.Seconds(),
+		DeliveryRate: float64(delivered.Load()) / elapsed.Seconds(),
+		LatencyP50Ms: ms(percentile(0.50)),
+		LatencyP99Ms: ms(percentile(0.99)),
+	}
+	if len(all) > 0 {
+		report.LatencyMaxMs = ms(all[len(all)-1])
+	}
+	if published > 0 {
+		report.AllocsPerMsg = float64(after.Mallocs-before.Mallocs) / float64(published)
+		report.BytesPerMsg = float64(after.TotalAlloc-before.TotalAlloc) / float64(published)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Printf("source         %s\n", report.Source)
+		fmt.Printf("clients        %d\n", report.Clients)
+		fmt.Printf("duration       %.1fs\n", report.DurationSec)
+		fmt.Printf("published      %d (%.0f/s)\n", report.Published, report.PublishRate)
+		fmt.Printf("delivered      %d (%.0f/s)\n", report.Delivered, report.DeliveryRate)
+		fmt.Printf("dropped        %d\n", report.Dropped)
+		fmt.Printf("allocs/msg     %.1f (%.0f B)\n", report.AllocsPerMsg, report.BytesPerMsg)
+		fmt.Printf("latency p50    %.3fms\n", report.LatencyP50Ms)
+		fmt.Printf("latency p99    %.3fms\n", report.LatencyP99Ms)
+		fmt.Printf("latency max    %.3fms\n", report.LatencyMaxMs)
+	}
+
+	if *maxP99 > 0 && percentile(0.99) > *maxP99 {
+		log.Printf("Bench: p99 latency %s exceeds %s", percentile(0.99), *maxP99)
+		return 1
+	}
+	return 0
+}
+
+// benchPods returns a publisher that flips cell pods between alive and dead
+// in a fake clientset, whose informer feeds the hub through handlePodUpdate.
+func benchPods(cells int, stopCh chan struct{}) func(n int64) {
+	const namespace = "bench"
+	clientset := fake.NewClientset()
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+	informer := factory.Core().V1().Pods().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { handlePodUpdate(obj, nil) },
+		UpdateFunc: func(oldObj, newObj interface{}) { handlePodUpdate(newObj, nil) },
+	})
+	factory.Start(stopCh)
+	cache.WaitForCacheSync(stopCh, informer.HasSynced)
+
+	pods := clientset.CoreV1().Pods(namespace)
+	return func(n int64) {
+		i := int(n % int64(cells))
+		status := "alive"
+		if (n/int64(cells))%2 == 1 {
+			status = "dead"
+		}
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      cellName(i),
+			Namespace: namespace,
+			Labels:    map[string]string{"app": "cell", "game-status": status},
+		}}
+		if n < int64(cells) {
+			pods.Create(context.TODO(), pod, metav1.CreateOptions{})
+		} else {
+			pods.Update(context.TODO(), pod, metav1.UpdateOptions{})
+		}
+	}
+}
+
+// EngineBenchRun is one worker count in the report of
+// `grid-controller bench-engine`.
+type EngineBenchRun struct {
+	Workers         int     `json:"workers"`
+	Generations     int     `json:"generations"`
+	DurationSec     float64 `json:"durationSec"`
+	GenerationsPerS float64 `json:"generationsPerSecond"`
+	CellsPerS       float64 `json:"cellsPerSecond"`
+	Speedup         float64 `json:"speedup"`
+	AllocsPerGen    float64 `json:"allocsPerGeneration"`
+	FinalPopulation int     `json:"finalPopulation"`
+	FinalStateHash  string  `json:"finalStateHash"`
+}
+
+// runEngineBench implements `grid-controller bench-engine`: it steps the
+// standalone engine over the same random soup once per worker count and
+// reports the throughput of each, so that the rule worker pool can be sized
+// for a grid. Every run must end in the same state. It returns the exit code.
+func runEngineBench(args []string) int {
+	fs := flag.NewFlagSet("bench-engine", flag.ExitOnError)
+	width := fs.Int("width", 512, "grid width")
+	height := fs.Int("height", 512, "grid height")
+	density := fs.Float64("density", 0.3, "density of the initial random soup")
+	generations := fs.Int("generations", 100, "generations stepped per run")
+	ruleSpec := fs.String("rule-engine", "life", "built-in rule to step, life or a B/S rule")
+	workerList := fs.String("workers", fmt.Sprintf("1,%d", runtime.GOMAXPROCS(0)), "comma-separated worker counts to compare")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	grid := GridGeometry{Width: *width, Height: *height}
+	rule, err := openRuleEngine(context.Background(), *ruleSpec, grid, 0)
+	if err != nil {
+		log.Printf("Bench: rule engine: %v", err)
+		return 2
+	}
+	var counts []int
+	for _, s := range strings.Split(*workerList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			log.Printf("Bench: invalid worker count %q", s)
+			return 2
+		}
+		counts = append(counts, n)
+	}
+	soup := randomSoup(grid, *density)
+
+	var runs []EngineBenchRun
+	for _, workers := range counts {
+		ruleWorkers = workers
+		engine := NewEngine(grid, rule)
+		engine.Stamp(soup, 0, 0)
+
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		for g := 0; g < *generations; g++ {
+			if _, _, err := engine.Step(context.Background(), nil, nil); err != nil {
+				log.Printf("Bench: step: %v", err)
+				return 1
+			}
+		}
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		gen, alive := engine.Snapshot()
+		run := EngineBenchRun{
+			Workers:         workers,
+			Generations:     *generations,
+			DurationSec:     elapsed.Seconds(),
+			GenerationsPerS: float64(*generations) / elapsed.Seconds(),
+			CellsPerS:       float64(*generations) * float64(grid.Size()) / elapsed.Seconds(),
+			AllocsPerGen:    float64(after.Mallocs-before.Mallocs) / float64(max(1, *generations)),
+			FinalPopulation: len(alive),
+			FinalStateHash:  stateHash(gen, alive),
+		}
+		base := run
+		if len(runs) > 0 {
+			base = runs[0]
+		}
+		run.Speedup = run.GenerationsPerS / base.GenerationsPerS
+		runs = append(runs, run)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(runs)
+	} else {
+		fmt.Printf("grid %dx%d, %s, %d generations\n", grid.Width, grid.Height, rule.Rule(), *generations)
+		for _, r := range runs {
+			fmt.Printf("workers %-4d %8.1f gen/s %12.0f cells/s  x%.2f  %6.0f allocs/gen\n", r.Workers, r.GenerationsPerS, r.CellsPerS, r.Speedup, r.AllocsPerGen)
+		}
+	}
+	for _, r := range runs[1:] {
+		if r.FinalStateHash != runs[0].FinalStateHash {
+			log.Printf("Bench: %d workers ended in state %s, %d workers in %s", r.Workers, r.FinalStateHash, runs[0].Workers, runs[0].FinalStateHash)
+			return 1
+		}
+	}
+	return 0
+}
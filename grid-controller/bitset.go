@@ -0,0 +1,117 @@
+package main
+
+import "math/bits"
+
+// cellBits is a set of grid cells packed into bitset rows, 64 cells to a
+// word. Every row starts on a word boundary, so that rows can be read and
+// counted on their own. Cells outside the grid are never in the set.
+type cellBits struct {
+	width  int
+	stride int
+	words  []uint64
+}
+
+func newCellBits(grid GridGeometry) *cellBits {
+	stride := (grid.Width + 63) / 64
+	return &cellBits{width: grid.Width, stride: stride, words: make([]uint64, stride*grid.Height)}
+}
+
+// locate returns the word and bit of a cell; ok is false outside the grid.
+func (b *cellBits) locate(i int) (word int, bit uint64, ok bool) {
+	if i < 0 || b.width == 0 {
+		return 0, 0, false
+	}
+	x, y := i%b.width, i/b.width
+	word = y*b.stride + x/64
+	return word, 1 << (x % 64), word < len(b.words)
+}
+
+func (b *cellBits) Has(i int) bool {
+	w, bit, ok := b.locate(i)
+	return ok && b.words[w]&bit != 0
+}
+
+// Set adds a cell; cells outside the grid are ignored.
+func (b *cellBits) Set(i int) {
+	if w, bit, ok := b.locate(i); ok {
+		b.words[w] |= bit
+	}
+}
+
+func (b *cellBits) Clear(i int) {
+	if w, bit, ok := b.locate(i); ok {
+		b.words[w] &^= bit
+	}
+}
+
+// Reset empties the set without giving up its memory.
+func (b *cellBits) Reset() {
+	clear(b.words)
+}
+
+// CopyFrom makes b a copy of another set of the same grid.
+func (b *cellBits) CopyFrom(other *cellBits) {
+	copy(b.words, other.words)
+}
+
+// Count returns the number of cells in the set.
+func (b *cellBits) Count() int {
+	n := 0
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Row returns the words of row y, nil outside the grid. They are shared
+// with the set.
+func (b *cellBits) Row(y int) []uint64 {
+	if y < 0 || (y+1)*b.stride > len(b.words) {
+		return nil
+	}
+	return b.words[y*b.stride : (y+1)*b.stride]
+}
+
+// RowCount returns the number of cells of row y in the set.
+func (b *cellBits) RowCount(y int) int {
+	n := 0
+	for _, w := range b.Row(y) {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// ForEach calls fn with every cell of the set in index order.
+func (b *cellBits) ForEach(fn func(i int)) {
+	for w, word := range b.words {
+		y, x0 := w/b.stride, w%b.stride*64
+		for word != 0 {
+			fn(y*b.width + x0 + bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+}
+
+// Indices returns the sorted cells of the set.
+func (b *cellBits) Indices() []int {
+	cells := make([]int, 0, b.Count())
+	b.ForEach(func(i int) { cells = append(cells, i) })
+	return cells
+}
+
+// rowBit reports whether column x of a bitset row is set; x may lie just
+// outside the row, and a nil row is empty.
+func rowBit(row []uint64, x int) bool {
+	return x >= 0 && x/64 < len(row) && row[x/64]&(1<<(x%64)) != 0
+}
+
+// packRow packs a row of cells, e.g. a ghost row, into bitset words.
+func packRow(row []bool, width int) []uint64 {
+	words := make([]uint64, (width+63)/64)
+	for x, alive := range row[:min(len(row), width)] {
+		if alive {
+			words[x/64] |= 1 << (x % 64)
+		}
+	}
+	return words
+}
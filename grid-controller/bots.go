@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+// Actions a bot can be scoped to.
+const (
+	botScopeSpawn    = "spawn"
+	botScopeKill     = "kill"
+	botScopePatterns = "patterns"
+	botScopeChaos    = "chaos"
+	// botScopeTournament lets a bot enter tournaments.
+	botScopeTournament = "tournament"
+)
+
+var botScopes = []string{botScopeSpawn, botScopeKill, botScopePatterns, botScopeChaos, botScopeTournament}
+
+// Bot is an automation account for agents playing against the grid on a
+// shared instance, e.g.
+//
+//	bots:
+//	- name: gardener
+//	  tokenSHA256: 9f86d0...
+//	  scopes: [spawn, patterns]
+//	  region: [0, 0, 20, 20]
+//	  rate: 2
+//	  burst: 5
+//
+// A bot may only perform the actions it is scoped to, only on cells in its
+// region (x0, y0, x1, y1 with x1 and y1 exclusive; the whole grid when
+// unset), and at most rate actions per second with bursts of burst.
+type Bot struct {
+	Name        string   `json:"name"`
+	TokenSHA256 string   `json:"tokenSHA256"`
+	Scopes      []string `json:"scopes"`
+	Region      []int    `json:"region,omitempty"`
+	Rate        float64  `json:"rate"`
+	Burst       int      `json:"burst,omitempty"`
+}
+
+func (b Bot) validate() error {
+	if !gridNamePattern.MatchString(b.Name) {
+		return errors.New("name must be a DNS label")
+	}
+	if d, err := hex.DecodeString(b.TokenSHA256); err != nil || len(d) != sha256.Size {
+		return errors.New("tokenSHA256 must be a hex SHA-256 digest")
+	}
+	if len(b.Scopes) == 0 {
+		return fmt.Errorf("scopes must list some of %v", botScopes)
+	}
+	for _, s := range b.Scopes {
+		known := false
+		for _, k := range botScopes {
+			known = known || k == s
+		}
+		if !known {
+			return fmt.Errorf("unknown scope %q (want one of %v)", s, botScopes)
+		}
+	}
+	if b.Region != nil && (len(b.Region) != 4 || b.Region[0] < 0 || b.Region[1] < 0 || b.Region[2] <= b.Region[0] || b.Region[3] <= b.Region[1]) {
+		return errors.New("region must be x0, y0, x1, y1 with x0 < x1 and y0 < y1")
+	}
+	if b.Rate <= 0 || b.Burst < 0 {
+		return errors.New("rate must be positive and burst not negative")
+	}
+	return nil
+}
+
+// botAccount is a configured bot with its rate limiter.
+type botAccount struct {
+	Bot
+	scopes  map[string]bool
+	limiter *rate.Limiter
+}
+
+// bots is set from the configuration file at startup.
+var bots []*botAccount
+
+func setBots(configured []Bot) {
+	bots = nil
+	for _, b := range configured {
+		account := &botAccount{Bot: b, scopes: map[string]bool{}, limiter: rate.NewLimiter(rate.Limit(b.Rate), max(b.Burst, 1))}
+		for _, s := range b.Scopes {
+			account.scopes[s] = true
+		}
+		bots = append(bots, account)
+	}
+}
+
+var botActions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_bot_actions_total",
+	Help: "Actions attempted by bots, by bot and result: allowed, scope, region or rate.",
+}, []string{"bot", "result"})
+
+// botFor returns the bot owning the request's bearer token, or nil.
+func botFor(r *http.Request) *botAccount {
+	token, ok := bearerToken(r)
+	if !ok || len(bots) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+	for _, b := range bots {
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(b.TokenSHA256)) == 1 {
+			return b
+		}
+	}
+	return nil
+}
+
+// botNamed returns the configured bot of that name, or nil.
+func botNamed(name string) *botAccount {
+	for _, b := range bots {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// botScopeKeyVerbs are the verbs an API key needs on this controller's grid
+// for an action bots are scoped to. Patterns may kill cells as well as
+// bring them to life.
+var botScopeKeyVerbs = map[string][]string{
+	botScopeSpawn:      {keyVerbSpawn},
+	botScopeKill:       {keyVerbKill},
+	botScopePatterns:   {keyVerbSpawn, keyVerbKill},
+	botScopeChaos:      {keyVerbKill},
+	botScopeTournament: {keyVerbControl},
+}
+
+// allowBot checks a bot's request against its scopes, region and rate limit,
+// and a request made with an API key against its grants, and writes the
+// error response when it is refused. Requests from anyone else are left to
+// the endpoint's own checks.
+func allowBot(w http.ResponseWriter, r *http.Request, scope string, grid GridGeometry, cells []int) bool {
+	if !allowKey(w, r, gridID, botScopeKeyVerbs[scope]...) {
+		return false
+	}
+	b := botFor(r)
+	if b == nil {
+		return true
+	}
+	refuse := func(result string, status int, msg string) bool {
+		botActions.WithLabelValues(b.Name, result).Inc()
+		http.Error(w, msg, status)
+		return false
+	}
+	if !b.scopes[scope] {
+		return refuse("scope", http.StatusForbidden, "Forbidden: bot "+b.Name+" is not scoped to "+scope)
+	}
+	if b.Region != nil {
+		for _, i := range cells {
+			if x, y := grid.Coords(i); x < b.Region[0] || y < b.Region[1] || x >= b.Region[2] || y >= b.Region[3] {
+				return refuse("region", http.StatusForbidden, fmt.Sprintf("Forbidden: cell %d is outside bot %s's region", i, b.Name))
+			}
+		}
+	}
+	if res := b.limiter.Reserve(); res.Delay() > 0 {
+		res.Cancel()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.Delay().Seconds()))))
+		return refuse("rate", http.StatusTooManyRequests, fmt.Sprintf("Rate limited: bot %s may act %g times per second", b.Name, b.Rate))
+	}
+	botActions.WithLabelValues(b.Name, "allowed").Inc()
+	return true
+}
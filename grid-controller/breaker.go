@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ChurnBreakerConfig pauses the standalone engine when it churns cell pods
+// faster than the cluster should bear, e.g. a breeder growing without bound:
+//
+//	churnBreaker:
+//	  maxCreations: 500
+//	  maxDeletions: 1000
+//	  per: 1m
+//	  event: true
+//	  webhook: http://alertmanager-relay/hook
+//
+// Births create pods and deaths delete them; when either count within the
+// sliding window exceeds its limit, the simulation is paused and the
+// churn-breaker alert fires. An operator resumes it with POST
+// /api/simulation/resume. A zero limit is not checked.
+type ChurnBreakerConfig struct {
+	MaxCreations int             `json:"maxCreations,omitempty"`
+	MaxDeletions int             `json:"maxDeletions,omitempty"`
+	Per          metav1.Duration `json:"per,omitempty"`
+	Event        bool            `json:"event,omitempty"`
+	Webhook      string          `json:"webhook,omitempty"`
+}
+
+func (c *ChurnBreakerConfig) validate() error {
+	if c.MaxCreations < 0 || c.MaxDeletions < 0 || c.Per.Duration < 0 {
+		return errors.New("maxCreations, maxDeletions and per must not be negative")
+	}
+	if c.MaxCreations == 0 && c.MaxDeletions == 0 {
+		return errors.New("maxCreations or maxDeletions is required")
+	}
+	if c.Per.Duration == 0 {
+		c.Per.Duration = time.Minute
+	}
+	return nil
+}
+
+var churnBreakerTrips = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_churn_breaker_trips_total",
+	Help: "Times the churn breaker paused the simulation.",
+})
+
+type churnSample struct {
+	time                 time.Time
+	creations, deletions int
+}
+
+// churnBreaker keeps the pod churn of the recent generations. Its methods are
+// safe to call on a nil breaker.
+type churnBreaker struct {
+	cfg ChurnBreakerConfig
+
+	mu      sync.Mutex
+	samples []churnSample
+}
+
+func newChurnBreaker(cfg *ChurnBreakerConfig) *churnBreaker {
+	if cfg == nil {
+		return nil
+	}
+	return &churnBreaker{cfg: *cfg}
+}
+
+// Record adds a generation's births and deaths and reports whether the churn
+// within the window exceeds a limit, and why. A trip clears the window, so
+// resuming starts afresh.
+func (b *churnBreaker) Record(now time.Time, creations, deletions int) (tripped bool, reason string) {
+	if b == nil {
+		return false, ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.samples = append(b.samples, churnSample{now, creations, deletions})
+	for len(b.samples) > 0 && now.Sub(b.samples[0].time) > b.cfg.Per.Duration {
+		b.samples = b.samples[1:]
+	}
+	var created, deleted int
+	for _, s := range b.samples {
+		created += s.creations
+		deleted += s.deletions
+	}
+	switch {
+	case b.cfg.MaxCreations > 0 && created > b.cfg.MaxCreations:
+		reason = fmt.Sprintf("%d pod creations within %s exceed the limit of %d", created, b.cfg.Per.Duration, b.cfg.MaxCreations)
+	case b.cfg.MaxDeletions > 0 && deleted > b.cfg.MaxDeletions:
+		reason = fmt.Sprintf("%d pod deletions within %s exceed the limit of %d", deleted, b.cfg.Per.Duration, b.cfg.MaxDeletions)
+	default:
+		return false, ""
+	}
+	b.samples = nil
+	churnBreakerTrips.Inc()
+	return true, reason
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kinds of divergence between the pod informer's cache and the API server.
+const (
+	// divergenceMissing is a pod the cache has not seen.
+	divergenceMissing = "missing"
+	// divergenceStale is a pod the cache still has after it was deleted.
+	divergenceStale = "stale"
+	// divergenceOutdated is a pod whose cached resourceVersion is behind.
+	divergenceOutdated = "outdated"
+)
+
+const (
+	// cacheAuditGrace is how long a suspect pod gets for its watch event to
+	// arrive before it counts as divergent.
+	cacheAuditGrace = 5 * time.Second
+	// cacheAuditMaxSuspects bounds the GETs rechecking one audit's suspects.
+	cacheAuditMaxSuspects = 50
+	// cacheAuditLogLimit is how many divergent pods one audit logs.
+	cacheAuditLogLimit = 10
+)
+
+var (
+	informerDivergentPods = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grid_informer_divergent_pods",
+		Help: "Pods whose cached state differed from the API server at the last cache audit, by kind: missing, stale or outdated.",
+	}, []string{"kind"})
+	informerAudits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grid_informer_audits_total",
+		Help: "Audits of the pod informer's cache against a live LIST, by result: consistent, divergent or error.",
+	}, []string{"result"})
+	informerAuditedPods = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "grid_informer_audited_pods_total",
+		Help: "Pods compared by cache audits.",
+	})
+)
+
+// cacheDivergence is a pod the informer's cache disagrees with the API
+// server about.
+type cacheDivergence struct {
+	Name   string
+	Kind   string
+	Cached string
+	Live   string
+}
+
+// cacheAuditor compares the pod informer's cache with the API server, to
+// catch a watch that silently stopped delivering events and left part of the
+// grid frozen. Each audit LISTs one page of at most sample pods, continuing
+// where the previous audit stopped, so the namespace is covered a window at
+// a time without one large LIST. Pods that disagree get cacheAuditGrace for
+// their watch event before they are rechecked with a GET and counted.
+type cacheAuditor struct {
+	clientset kubernetes.Interface
+	pods      corelisters.PodLister
+	namespace string
+	sample    int
+
+	// cont continues the live LIST after the last window, whose last pod was
+	// after; both are empty when the next window starts the namespace over.
+	cont  string
+	after string
+}
+
+func newCacheAuditor(clientset kubernetes.Interface, pods corelisters.PodLister, namespace string, sample int) *cacheAuditor {
+	return &cacheAuditor{clientset: clientset, pods: pods, namespace: namespace, sample: sample}
+}
+
+// Run audits the cache every interval once it has synced, until ctx is done.
+func (a *cacheAuditor) Run(ctx context.Context, interval time.Duration, synced cache.InformerSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), synced) {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	diverged, failing := false, false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checked, diffs, err := a.audit(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			informerAudits.WithLabelValues("error").Inc()
+			if !failing {
+				log.Printf("Cache audit: %v", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		informerAuditedPods.Add(float64(checked))
+
+		kinds := map[string]int{divergenceMissing: 0, divergenceStale: 0, divergenceOutdated: 0}
+		for _, d := range diffs {
+			kinds[d.Kind]++
+		}
+		for kind, n := range kinds {
+			informerDivergentPods.WithLabelValues(kind).Set(float64(n))
+		}
+		if len(diffs) == 0 {
+			informerAudits.WithLabelValues("consistent").Inc()
+			if diverged {
+				log.Printf("Cache audit: pod cache agrees with the API server again")
+			}
+			diverged = false
+			continue
+		}
+		informerAudits.WithLabelValues("divergent").Inc()
+		diverged = true
+		log.Printf("Cache audit: %d of %d pods diverge from the API server (%d missing, %d stale, %d outdated); the pod watch may be stuck",
+			len(diffs), checked, kinds[divergenceMissing], kinds[divergenceStale], kinds[divergenceOutdated])
+		for i, d := range diffs {
+			if i == cacheAuditLogLimit {
+				log.Printf("Cache audit: ... and %d more", len(diffs)-i)
+				break
+			}
+			log.Printf("Cache audit: pod %s is %s (cached %s, live %s)", d.Name, d.Kind, d.Cached, d.Live)
+		}
+	}
+}
+
+// audit compares the next window of pods and returns how many it checked and
+// those that still disagree after the grace period.
+func (a *cacheAuditor) audit(ctx context.Context) (int, []cacheDivergence, error) {
+	list, err := a.clientset.CoreV1().Pods(a.namespace).List(ctx, metav1.ListOptions{Limit: int64(a.sample), Continue: a.cont})
+	if apierrors.IsResourceExpired(err) {
+		// The continue token outlived the API server's compaction window.
+		a.cont, a.after = "", ""
+		list, err = a.clientset.CoreV1().Pods(a.namespace).List(ctx, metav1.ListOptions{Limit: int64(a.sample)})
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("listing pods: %w", err)
+	}
+
+	// The window spans the names after the previous window's last pod up to
+	// this page's last, or to the end of the namespace on the last page.
+	// Lists are ordered by name, so cached pods in it must have been listed.
+	from, to := a.after, ""
+	if list.Continue != "" && len(list.Items) > 0 {
+		to = list.Items[len(list.Items)-1].Name
+	}
+	a.cont, a.after = list.Continue, to
+	inWindow := func(name string) bool {
+		return name > from && (to == "" || name <= to)
+	}
+
+	live := make(map[string]bool, len(list.Items))
+	var suspects []string
+	for i := range list.Items {
+		pod := &list.Items[i]
+		live[pod.Name] = true
+		if cached, err := a.pods.Pods(a.namespace).Get(pod.Name); err != nil || cached.ResourceVersion != pod.ResourceVersion {
+			suspects = append(suspects, pod.Name)
+		}
+	}
+	cached, err := a.pods.Pods(a.namespace).List(labels.Everything())
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, pod := range cached {
+		if inWindow(pod.Name) && !live[pod.Name] {
+			suspects = append(suspects, pod.Name)
+		}
+	}
+	if len(suspects) == 0 {
+		return len(list.Items), nil, nil
+	}
+
+	// Watch events trail the LIST; give them time before rechecking.
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case <-time.After(cacheAuditGrace):
+	}
+	var diffs []cacheDivergence
+	for i, name := range suspects {
+		if i == cacheAuditMaxSuspects {
+			break
+		}
+		d, err := a.recheck(ctx, name)
+		if err != nil {
+			return 0, nil, err
+		}
+		if d != nil {
+			diffs = append(diffs, *d)
+		}
+	}
+	return len(list.Items), diffs, nil
+}
+
+// recheck compares one pod's cached and live state, or returns nil when they
+// agree.
+func (a *cacheAuditor) recheck(ctx context.Context, name string) (*cacheDivergence, error) {
+	livePod, err := a.clientset.CoreV1().Pods(a.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("getting pod %s: %w", name, err)
+	}
+	if err != nil {
+		livePod = nil
+	}
+	cachedPod, err := a.pods.Pods(a.namespace).Get(name)
+	if err != nil {
+		cachedPod = nil
+	}
+	d := &cacheDivergence{Name: name, Cached: describePod(cachedPod), Live: describePod(livePod)}
+	switch {
+	case livePod == nil && cachedPod == nil:
+		return nil, nil
+	case cachedPod == nil:
+		d.Kind = divergenceMissing
+	case livePod == nil:
+		d.Kind = divergenceStale
+	case cachedPod.ResourceVersion != livePod.ResourceVersion:
+		d.Kind = divergenceOutdated
+	default:
+		return nil, nil
+	}
+	return d, nil
+}
+
+// describePod summarizes a pod for the audit log.
+func describePod(pod *v1.Pod) string {
+	if pod == nil {
+		return "absent"
+	}
+	s := "rv " + pod.ResourceVersion
+	if pod.Status.Phase != "" {
+		s += ", " + string(pod.Status.Phase)
+	}
+	if status := pod.Labels["game-status"]; status != "" {
+		s += ", " + status
+	}
+	return s
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Canary cohorts.
+const (
+	cohortStable = "stable"
+	cohortCanary = "canary"
+)
+
+// CanaryRegion is a rectangle of cells.
+type CanaryRegion struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// CanaryRollout runs a new Life-like rule, a new cell image or both on part
+// of the grid while the rest stays on the current configuration, e.g.
+//
+//	{"rule": "B36/S23", "image": "ghcr.io/example/cell-worker:v2", "percent": 10}
+//
+// The canary covers the given regions, or else percent of the grid's tiles
+// (squares of tile cells, default 4). Tiles are picked in a fixed order, so
+// raising the percentage extends the canary over the cells it already has.
+// Canary cells compute their next state with the canary rule from their
+// full neighborhood, so the two cohorts interact at their borders like the
+// old and new pods of a rolling deployment.
+type CanaryRollout struct {
+	Rule    string         `json:"rule,omitempty"`
+	Image   string         `json:"image,omitempty"`
+	Percent int            `json:"percent,omitempty"`
+	Regions []CanaryRegion `json:"regions,omitempty"`
+	Tile    int            `json:"tile,omitempty"`
+}
+
+func (c *CanaryRollout) validate(grid GridGeometry) error {
+	if c.Rule == "" && c.Image == "" {
+		return errors.New("a canary needs a rule, an image or both")
+	}
+	if c.Rule != "" {
+		if _, err := parseRule(c.Rule); err != nil {
+			return fmt.Errorf("rule: %w", err)
+		}
+	}
+	if c.Image != "" && strings.ContainsAny(c.Image, " \t\n") {
+		return fmt.Errorf("image %q is not a valid image reference", c.Image)
+	}
+	if (c.Percent == 0) == (len(c.Regions) == 0) {
+		return errors.New("set either percent or regions")
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return errors.New("percent must be between 1 and 100")
+	}
+	for i, r := range c.Regions {
+		if r.Width <= 0 || r.Height <= 0 || !grid.Contains(r.X, r.Y) || !grid.Contains(r.X+r.Width-1, r.Y+r.Height-1) {
+			return fmt.Errorf("region %d is not inside the %dx%d grid", i, grid.Width, grid.Height)
+		}
+	}
+	if c.Tile == 0 {
+		c.Tile = 4
+	}
+	if c.Tile < 1 || c.Tile > max(grid.Width, grid.Height) {
+		return errors.New("tile must be between 1 and the grid's size")
+	}
+	return nil
+}
+
+// cells returns the canary's cells.
+func (c *CanaryRollout) cells(grid GridGeometry) map[int]bool {
+	in := make(map[int]bool)
+	for _, r := range c.Regions {
+		for y := r.Y; y < r.Y+r.Height; y++ {
+			for x := r.X; x < r.X+r.Width; x++ {
+				in[grid.Index(x, y)] = true
+			}
+		}
+	}
+	if c.Percent == 0 {
+		return in
+	}
+	cols, rows := (grid.Width+c.Tile-1)/c.Tile, (grid.Height+c.Tile-1)/c.Tile
+	tiles := rand.New(rand.NewSource(1)).Perm(cols * rows)
+	for _, t := range tiles[:(len(tiles)*c.Percent+99)/100] {
+		x0, y0 := (t%cols)*c.Tile, (t/cols)*c.Tile
+		for y := y0; y < min(y0+c.Tile, grid.Height); y++ {
+			for x := x0; x < min(x0+c.Tile, grid.Width); x++ {
+				in[grid.Index(x, y)] = true
+			}
+		}
+	}
+	return in
+}
+
+// CanaryCohort is what one side of a canary did. Density is the population
+// over the cohort's cells, so cohorts of different sizes compare.
+type CanaryCohort struct {
+	Rule        string  `json:"rule"`
+	Image       string  `json:"image,omitempty"`
+	Cells       int     `json:"cells"`
+	Population  int     `json:"population"`
+	Density     float64 `json:"density"`
+	Births      int     `json:"births"`
+	Deaths      int     `json:"deaths"`
+	TotalBirths int64   `json:"totalBirths"`
+	TotalDeaths int64   `json:"totalDeaths"`
+	// Pods, ReadyPods and Restarts describe the cohort's cell pods.
+	Pods      int   `json:"pods"`
+	ReadyPods int   `json:"readyPods"`
+	Restarts  int32 `json:"restarts"`
+}
+
+// CanarySample is the density of both cohorts at one generation.
+type CanarySample struct {
+	Generation int64   `json:"generation"`
+	Stable     float64 `json:"stable"`
+	Canary     float64 `json:"canary"`
+}
+
+// CanaryStatus is served by /api/canary.
+type CanaryStatus struct {
+	Spec        CanaryRollout  `json:"spec"`
+	Started     time.Time      `json:"started"`
+	Generations int64          `json:"generations"`
+	Stable      CanaryCohort   `json:"stable"`
+	Canary      CanaryCohort   `json:"canary"`
+	Recent      []CanarySample `json:"recent"`
+}
+
+var canaryDensity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grid_canary_density",
+	Help: "Share of live cells in each cohort of a canary rollout.",
+}, []string{"cohort"})
+
+// canaryEngine is the rule engine of a canary rollout: the stable rule for
+// the grid, the canary rule for the canary's cells.
+type canaryEngine struct {
+	spec    CanaryRollout
+	grid    GridGeometry
+	stable  RuleEngine
+	canary  RuleEngine
+	in      map[int]bool
+	started time.Time
+
+	mu          sync.Mutex
+	generations int64
+	cohorts     [2]CanaryCohort
+	recent      []CanarySample
+}
+
+func newCanaryEngine(spec CanaryRollout, grid GridGeometry, stable RuleEngine, stableImage string) (*canaryEngine, error) {
+	if err := spec.validate(grid); err != nil {
+		return nil, err
+	}
+	e := &canaryEngine{spec: spec, grid: grid, stable: stable, canary: stable, in: spec.cells(grid), started: time.Now()}
+	if spec.Rule != "" {
+		rule, err := newLifeLikeEngine(spec.Rule)
+		if err != nil {
+			return nil, err
+		}
+		e.canary = rule
+	}
+	e.cohorts[0] = CanaryCohort{Rule: e.stable.Rule(), Image: stableImage, Cells: grid.Size() - len(e.in)}
+	e.cohorts[1] = CanaryCohort{Rule: e.canary.Rule(), Image: stableImage, Cells: len(e.in)}
+	if spec.Image != "" {
+		e.cohorts[1].Image = spec.Image
+	}
+	return e, nil
+}
+
+func (e *canaryEngine) Rule() string {
+	return "canary:" + e.stable.Rule() + "|" + e.canary.Rule()
+}
+
+// Contains reports whether a cell is in the canary.
+func (e *canaryEngine) Contains(index int) bool {
+	return e.in[index]
+}
+
+func (e *canaryEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	next, err := e.stable.Next(ctx, generation, hoods)
+	if err != nil {
+		return nil, err
+	}
+	if e.canary != e.stable {
+		next = append([]bool(nil), next...)
+		idx := make([]int, 0, len(e.in))
+		cohort := make([]Neighborhood, 0, len(e.in))
+		for i, n := range hoods {
+			if e.in[i] {
+				idx = append(idx, i)
+				cohort = append(cohort, n)
+			}
+		}
+		states, err := e.canary.Next(ctx, generation, cohort)
+		if err != nil {
+			return nil, err
+		}
+		if len(states) != len(idx) {
+			return nil, fmt.Errorf("rule %s returned %d states for %d cells", e.canary.Rule(), len(states), len(idx))
+		}
+		for j, i := range idx {
+			next[i] = states[j]
+		}
+	}
+	e.record(generation, [2]ComparisonSide{
+		countChanges(hoods, next, func(i int) bool { return !e.in[i] }),
+		countChanges(hoods, next, func(i int) bool { return e.in[i] }),
+	})
+	return next, nil
+}
+
+func (e *canaryEngine) record(generation int64, delta [2]ComparisonSide) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.generations++
+	for i := range e.cohorts {
+		c := &e.cohorts[i]
+		c.Population, c.Births, c.Deaths = delta[i].Population, delta[i].Births, delta[i].Deaths
+		c.TotalBirths += int64(delta[i].Births)
+		c.TotalDeaths += int64(delta[i].Deaths)
+		c.Density = 0
+		if c.Cells > 0 {
+			c.Density = float64(c.Population) / float64(c.Cells)
+		}
+		canaryDensity.WithLabelValues([]string{cohortStable, cohortCanary}[i]).Set(c.Density)
+	}
+	e.recent = append(e.recent, CanarySample{Generation: generation + 1, Stable: e.cohorts[0].Density, Canary: e.cohorts[1].Density})
+	if len(e.recent) > comparisonHistoryLimit {
+		e.recent = append([]CanarySample(nil), e.recent[len(e.recent)-comparisonHistoryLimit:]...)
+	}
+}
+
+// Status reports both cohorts, with their pods when cells is set.
+func (e *canaryEngine) Status(cells *cellPodManager) *CanaryStatus {
+	e.mu.Lock()
+	status := &CanaryStatus{
+		Spec:        e.spec,
+		Started:     e.started,
+		Generations: e.generations,
+		Stable:      e.cohorts[0],
+		Canary:      e.cohorts[1],
+		Recent:      append([]CanarySample{}, e.recent...),
+	}
+	e.mu.Unlock()
+	if cells == nil {
+		return status
+	}
+	pods, err := cells.pods.Pods(cells.namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell", "managed-by": "grid-controller"}))
+	if err != nil {
+		log.Printf("Canary: list cell pods: %v", err)
+		return status
+	}
+	for _, pod := range pods {
+		i, ok := cellIndex(pod.Name)
+		if !ok || pod.DeletionTimestamp != nil {
+			continue
+		}
+		c := &status.Stable
+		if e.in[i] {
+			c = &status.Canary
+		}
+		c.Pods++
+		for _, cs := range pod.Status.ContainerStatuses {
+			c.Restarts += cs.RestartCount
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
+				c.ReadyPods++
+			}
+		}
+	}
+	return status
+}
+
+// canaryBatch is how many cell pods a canary replaces at a time.
+const canaryBatch = 5
+
+// StartCanary puts a canary in place of the current rule; a running canary
+// is replaced, keeping its stable side.
+func (s *simulation) StartCanary(ctx context.Context, spec CanaryRollout) (*canaryEngine, error) {
+	stable := s.engine.RuleEngine()
+	switch e := stable.(type) {
+	case *canaryEngine:
+		stable = e.stable
+	case *compareEngine:
+		return nil, errors.New("stop the rule comparison first")
+	}
+	var stableImage string
+	if s.cells != nil {
+		template := s.cells.Template()
+		stableImage = cmp.Or(template.Image, s.cells.image)
+	}
+	canary, err := newCanaryEngine(spec, s.engine.grid, stable, stableImage)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Image != "" && s.cells == nil {
+		return nil, errors.New("an image canary needs the controller to own the cell pods")
+	}
+	s.engine.SetRule(canary)
+	if s.cells != nil {
+		s.cells.SetCanary(spec.Image, canary.Contains)
+		if spec.Image != "" {
+			s.rollCells(ctx)
+		}
+	}
+	canaryTransitions.WithLabelValues("start").Inc()
+	return canary, nil
+}
+
+// EndCanary removes the running canary. Promoting it makes its rule and
+// image the grid's; otherwise the canary cells go back to the stable ones.
+func (s *simulation) EndCanary(ctx context.Context, promote bool) (*canaryEngine, bool) {
+	canary, ok := s.engine.RuleEngine().(*canaryEngine)
+	if !ok {
+		return nil, false
+	}
+	rule := canary.stable
+	if promote {
+		rule = canary.canary
+	}
+	if !s.engine.ReplaceRule(canary, rule) {
+		return nil, false
+	}
+	if old, ok := canary.stable.(*wasmEngine); ok && rule != canary.stable {
+		old.Close()
+	}
+	if s.cells != nil && canary.spec.Image != "" {
+		if promote {
+			template := s.cells.Template()
+			template.Image = canary.spec.Image
+			s.cells.SetTemplate(template)
+		}
+		s.cells.SetCanary("", nil)
+		s.rollCells(ctx)
+	}
+	canaryTransitions.WithLabelValues(map[bool]string{true: "promote", false: "abort"}[promote]).Inc()
+	return canary, true
+}
+
+var canaryTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_canary_transitions_total",
+	Help: "Canary rollouts started, promoted and aborted.",
+}, []string{"transition"})
+
+// rollCells replaces the cell pods whose template changed.
+func (s *simulation) rollCells(ctx context.Context) {
+	if _, err := s.cells.Rematerialize(ctx, canaryBatch); err != nil {
+		log.Printf("Canary: %v; re-materialize once it finishes", err)
+	}
+}
+
+// handleCanary serves GET /api/canary, the running canary's comparative
+// metrics, POST /api/canary with a CanaryRollout, which starts a canary or
+// changes the running one, and DELETE /api/canary, which aborts it.
+// POST /api/canary/promote makes the canary's rule and image the grid's.
+func handleCanary(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Canary rollouts require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	promote := r.URL.Path == "/api/canary/promote"
+	switch {
+	case r.Method == "GET" && !promote:
+		canary, ok := s.engine.RuleEngine().(*canaryEngine)
+		if !ok {
+			http.Error(w, "No canary running", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(canary.Status(s.cells))
+		return
+	case r.Method == "POST", r.Method == "DELETE" && !promote:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireRole(w, r, roleOperator) {
+		return
+	}
+	if s.federation != nil {
+		http.Error(w, "Canary rollouts are not supported in a federated grid", http.StatusConflict)
+		return
+	}
+	// Pod replacements outlive the request.
+	ctx := context.WithoutCancel(r.Context())
+
+	if r.Method == "DELETE" || promote {
+		canary, ok := s.EndCanary(ctx, promote)
+		if !ok {
+			http.Error(w, "No canary running", http.StatusNotFound)
+			return
+		}
+		if promote {
+			log.Printf("Canary: %s promoted %s", requestIdentity(r), canary.Rule())
+		} else {
+			log.Printf("Canary: %s aborted %s", requestIdentity(r), canary.Rule())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(canary.Status(s.cells))
+		return
+	}
+
+	var spec CanaryRollout
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16384)).Decode(&spec); err != nil {
+		http.Error(w, "Invalid canary: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if spec.Image != "" && !requireAdmin(w, r) {
+		return
+	}
+	canary, err := s.StartCanary(ctx, spec)
+	if err != nil {
+		http.Error(w, "Invalid canary: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Canary: %s started %s on %d cells", requestIdentity(r), canary.Rule(), len(canary.in))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(canary.Status(s.cells))
+}
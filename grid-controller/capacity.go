@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// growthWindow is how many recent generations the growth rate is averaged
+// over.
+const growthWindow = 20
+
+// CapacityReport is served by /api/capacity.
+type CapacityReport struct {
+	CellPods             int   `json:"cellPods"`
+	RequestedCPUMillis   int64 `json:"requestedCPUMillis"`
+	RequestedMemoryBytes int64 `json:"requestedMemoryBytes"`
+	// Quotas lists every resource limited by a ResourceQuota in the
+	// namespace.
+	Quotas []QuotaHeadroom `json:"quotas"`
+	// HeadroomCells is how many more cell pods fit the tightest quota, and
+	// LimitedBy names it; both are omitted without quotas.
+	HeadroomCells *int64 `json:"headroomCells,omitempty"`
+	LimitedBy     string `json:"limitedBy,omitempty"`
+	// GrowthPerGeneration is the population's average change over the recent
+	// generations of the standalone engine. GenerationsUntilFull projects
+	// when the headroom runs out at that rate, if the population grows.
+	GrowthPerGeneration  *float64 `json:"growthPerGeneration,omitempty"`
+	GenerationsUntilFull *int64   `json:"generationsUntilFull,omitempty"`
+}
+
+// QuotaHeadroom is one resource of a ResourceQuota.
+type QuotaHeadroom struct {
+	Quota     string `json:"quota"`
+	Resource  string `json:"resource"`
+	Hard      string `json:"hard"`
+	Used      string `json:"used"`
+	Remaining string `json:"remaining"`
+	// Cells is how many more cell pods fit, at the requests and limits of a
+	// new cell pod.
+	Cells *int64 `json:"cells,omitempty"`
+}
+
+// cellPodCost is what a new cell pod takes from each quota resource, with the
+// manager's template when the controller owns the cell pods.
+func cellPodCost(cells *cellPodManager) map[v1.ResourceName]resource.Quantity {
+	if cells == nil {
+		cells = &cellPodManager{}
+	}
+	c := cells.podFor(0).Spec.Containers[0].Resources
+	return map[v1.ResourceName]resource.Quantity{
+		v1.ResourcePods:           resource.MustParse("1"),
+		v1.ResourceCPU:            c.Requests[v1.ResourceCPU],
+		v1.ResourceMemory:         c.Requests[v1.ResourceMemory],
+		v1.ResourceRequestsCPU:    c.Requests[v1.ResourceCPU],
+		v1.ResourceRequestsMemory: c.Requests[v1.ResourceMemory],
+		v1.ResourceLimitsCPU:      c.Limits[v1.ResourceCPU],
+		v1.ResourceLimitsMemory:   c.Limits[v1.ResourceMemory],
+	}
+}
+
+func capacityReport(ctx context.Context, clientset kubernetes.Interface, pods corelisters.PodLister, namespace string, cells *cellPodManager, sim *simulation) (*CapacityReport, error) {
+	list, err := pods.Pods(namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell"}))
+	if err != nil {
+		return nil, err
+	}
+	report := &CapacityReport{Quotas: []QuotaHeadroom{}}
+	for _, pod := range list {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		report.CellPods++
+		for _, c := range pod.Spec.Containers {
+			report.RequestedCPUMillis += c.Resources.Requests.Cpu().MilliValue()
+			report.RequestedMemoryBytes += c.Resources.Requests.Memory().Value()
+		}
+	}
+
+	quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	cost := cellPodCost(cells)
+	for _, q := range quotas.Items {
+		names := make([]string, 0, len(q.Status.Hard))
+		for name := range q.Status.Hard {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			hard, used := q.Status.Hard[v1.ResourceName(name)], q.Status.Used[v1.ResourceName(name)]
+			remaining := hard.DeepCopy()
+			remaining.Sub(used)
+			h := QuotaHeadroom{Quota: q.Name, Resource: name, Hard: hard.String(), Used: used.String(), Remaining: remaining.String()}
+			if per, ok := cost[v1.ResourceName(name)]; ok && per.MilliValue() > 0 {
+				cells := max(0, remaining.MilliValue()/per.MilliValue())
+				h.Cells = &cells
+				if report.HeadroomCells == nil || cells < *report.HeadroomCells {
+					report.HeadroomCells, report.LimitedBy = &cells, q.Name+"/"+name
+				}
+			}
+			report.Quotas = append(report.Quotas, h)
+		}
+	}
+
+	if sim == nil {
+		return report, nil
+	}
+	samples := sim.stats.Samples()
+	if len(samples) > growthWindow {
+		samples = samples[len(samples)-growthWindow:]
+	}
+	if len(samples) >= 2 {
+		first, last := samples[0], samples[len(samples)-1]
+		if gens := last.Generation - first.Generation; gens > 0 {
+			growth := float64(last.Population-first.Population) / float64(gens)
+			report.GrowthPerGeneration = &growth
+			if growth > 0 && report.HeadroomCells != nil {
+				until := int64(math.Ceil(float64(*report.HeadroomCells) / growth))
+				report.GenerationsUntilFull = &until
+			}
+		}
+	}
+	return report, nil
+}
+
+// handleCapacity serves GET /api/capacity.
+func handleCapacity(w http.ResponseWriter, r *http.Request, clientset kubernetes.Interface, pods corelisters.PodLister, namespace string, cells *cellPodManager, sim *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	report, err := capacityReport(ctx, clientset, pods, namespace, cells, sim)
+	if err != nil {
+		http.Error(w, "Failed to compute capacity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// statusFailed is how viewers show a cell whose birth or death failed.
+const statusFailed = "failed"
+
+// Intents of a failed cell operation.
+const (
+	intentBirth = "birth"
+	intentDeath = "death"
+)
+
+// CellError is streamed as a cell_error message when an intended birth or
+// death fails permanently, so that viewers mark the square as failed
+// instead of showing a hole. Transient failures, which the reconcile loop
+// retries, are not reported.
+type CellError struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Grid      string `json:"grid,omitempty"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Intent    string `json:"intent"`
+	// Reason is a short cause, e.g. QuotaExceeded or ImagePullBackOff.
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+var cellErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_cell_errors_total",
+	Help: "Births and deaths that failed permanently, by intent and reason.",
+}, []string{"intent", "reason"})
+
+// cellErrors reports the controller's failed cells. Its methods are safe to
+// call while it is nil, e.g. in `grid-controller step`.
+var cellErrors *cellErrorReporter
+
+// cellErrorReporter publishes each failure of a cell once, until the cell
+// recovers.
+type cellErrorReporter struct {
+	grid GridGeometry
+
+	mu sync.Mutex
+	// reported is the reason last reported for each failed cell.
+	reported map[string]string
+}
+
+func newCellErrorReporter(grid GridGeometry) *cellErrorReporter {
+	return &cellErrorReporter{grid: grid, reported: map[string]string{}}
+}
+
+// Report publishes a failure of a cell unless it was already reported for
+// the same reason.
+func (r *cellErrorReporter) Report(namespace, name, intent, reason, message string) {
+	if r == nil {
+		return
+	}
+	i, ok := cellIndex(federatedName(name))
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	seen := r.reported[name] == reason
+	r.reported[name] = reason
+	r.mu.Unlock()
+	if seen {
+		return
+	}
+	cellErrorsTotal.WithLabelValues(intent, reason).Inc()
+	x, y := r.grid.Coords(i)
+	publish(msgCellError, CellError{Name: federatedName(name), Namespace: namespace, Grid: gridID, X: x, Y: y, Intent: intent, Reason: reason, Message: message})
+}
+
+// APIError reports a failed write of a cell's pod if the API server refused
+// it for good, e.g. over quota; other errors are left to the next reconcile.
+func (r *cellErrorReporter) APIError(namespace, name, intent string, err error) {
+	if reason, ok := permanentReason(err); ok {
+		r.Report(namespace, name, intent, reason, err.Error())
+	}
+}
+
+// Clear forgets a cell's failure once it recovered, so that it is reported
+// again should it fail anew.
+func (r *cellErrorReporter) Clear(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.reported, name)
+	r.mu.Unlock()
+}
+
+// Pod reports a cell pod whose containers cannot start, and clears the
+// failure of one that runs.
+func (r *cellErrorReporter) Pod(pod *v1.Pod) {
+	if reason, message, ok := startFailure(pod); ok {
+		r.Report(pod.Namespace, pod.Name, intentBirth, reason, message)
+	} else if pod.Status.Phase == v1.PodRunning {
+		r.Clear(pod.Name)
+	}
+}
+
+func permanentReason(err error) (string, bool) {
+	switch {
+	case err == nil:
+		return "", false
+	case apierrors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota"):
+		return "QuotaExceeded", true
+	case apierrors.IsForbidden(err):
+		return "Forbidden", true
+	case apierrors.IsInvalid(err):
+		return "Invalid", true
+	case apierrors.IsBadRequest(err):
+		return "BadRequest", true
+	}
+	return "", false
+}
+
+// startFailure returns why a pod's containers cannot start without a fix,
+// such as a missing image.
+func startFailure(pod *v1.Pod) (reason, message string, ok bool) {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if w := cs.State.Waiting; w != nil {
+			switch w.Reason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull", "CreateContainerConfigError":
+				return w.Reason, w.Message, true
+			}
+		}
+	}
+	return "", "", false
+}
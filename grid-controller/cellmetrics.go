@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// CellMetrics is the resource usage of one cell pod, as reported by
+// metrics-server.
+type CellMetrics struct {
+	Cell        int    `json:"cell"`
+	Name        string `json:"name"`
+	CPUMillis   int64  `json:"cpuMillis"`
+	MemoryBytes int64  `json:"memoryBytes"`
+}
+
+// CellMetricsMessage is streamed to WebSocket clients every
+// --cell-metrics-interval so they can draw a resource-usage overlay.
+type CellMetricsMessage struct {
+	Cells []CellMetrics `json:"cells"`
+}
+
+// listCellMetrics reads the usage of every cell pod in namespace, summed over
+// its containers and ordered by cell. Pods metrics-server has not sampled yet
+// are missing.
+func listCellMetrics(ctx context.Context, client metricsclient.Interface, namespace string) ([]CellMetrics, error) {
+	list, err := client.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=cell"})
+	if err != nil {
+		return nil, err
+	}
+	cells := make([]CellMetrics, 0, len(list.Items))
+	for _, pm := range list.Items {
+		i, ok := cellIndex(pm.Name)
+		if !ok {
+			continue
+		}
+		m := CellMetrics{Cell: i, Name: pm.Name}
+		for _, c := range pm.Containers {
+			m.CPUMillis += c.Usage.Cpu().MilliValue()
+			m.MemoryBytes += c.Usage.Memory().Value()
+		}
+		cells = append(cells, m)
+	}
+	sort.Slice(cells, func(a, b int) bool { return cells[a].Cell < cells[b].Cell })
+	return cells, nil
+}
+
+// streamCellMetrics publishes the cells' resource usage every interval while
+// anyone is watching.
+func streamCellMetrics(ctx context.Context, client metricsclient.Interface, namespace string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if viewerCount() == 0 {
+			continue
+		}
+		cells, err := listCellMetrics(ctx, client, namespace)
+		if err != nil {
+			if !failing {
+				log.Printf("Cell metrics: %v", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		publish(msgCellMetrics, CellMetricsMessage{Cells: cells})
+	}
+}
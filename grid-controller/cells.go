@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// cellPodManager takes over the role of the cell StatefulSet: it keeps one
+// pod per grid index alive and recreates it after deletion. Owning pod
+// creation lets the controller stamp per-cell scheduling constraints, which
+// a StatefulSet template cannot express.
+//
+// With the standalone engine only live cells are materialized: desired
+// reports which indices should have a pod, and surplus pods are deleted.
+type cellPodManager struct {
+	clientset kubernetes.Interface
+	namespace string
+	grid      GridGeometry
+	image     string
+	pods      corelisters.PodLister
+
+	// affinity returns the scheduling constraints for a cell, or nil.
+	affinity func(index int) *v1.Affinity
+	// desired reports whether a cell should have a pod; nil means every cell.
+	desired func(index int) bool
+	// genome, when set, reports the genome recorded on a cell's pod.
+	genome func(index int) (Genome, bool)
+	// pendingTimeout is how long a pod may stay Pending before it is
+	// replaced; zero waits forever.
+	pendingTimeout time.Duration
+	// digests, when set, counts the pod operations of each generation.
+	digests *digestLog
+	// onDeadline, when set, learns the outcome of each deadline set with
+	// SetDeadline.
+	onDeadline func(gen int64, outcome string)
+
+	trigger chan struct{}
+	// deleting is the time spent deleting pods in the current pass.
+	deleting time.Duration
+
+	mu        sync.Mutex
+	retiring  map[string]bool
+	replacing map[string]bool
+	deadline  *generationDeadline
+	template  CellTemplate
+	// templateVersion counts template and canary changes.
+	templateVersion int
+	canaryImage     string
+	canaryCells     func(index int) bool
+
+	rollout rollout
+}
+
+func newCellPodManager(clientset kubernetes.Interface, namespace string, grid GridGeometry, image string, pods corelisters.PodLister) *cellPodManager {
+	return &cellPodManager{
+		clientset: clientset,
+		namespace: namespace,
+		grid:      grid,
+		image:     image,
+		pods:      pods,
+		trigger:   make(chan struct{}, 1),
+		retiring:  make(map[string]bool),
+		replacing: make(map[string]bool),
+	}
+}
+
+// podFor builds the pod for a grid index. It mirrors the StatefulSet template
+// in k8s/cells.yaml; hostname and subdomain keep the headless-service DNS
+// names (cell-N.cell) the workers use to find their neighbors.
+func (m *cellPodManager) podFor(index int) *v1.Pod {
+	name := cellName(index)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.namespace,
+			Labels: map[string]string{
+				"app":        "cell",
+				"managed-by": "grid-controller",
+			},
+			Annotations: map[string]string{},
+		},
+		Spec: v1.PodSpec{
+			ServiceAccountName: "cell",
+			Hostname:           name,
+			Subdomain:          "cell",
+			Containers: []v1.Container{{
+				Name:            "worker",
+				Image:           m.image,
+				ImagePullPolicy: v1.PullAlways,
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceMemory: resource.MustParse("5Mi"),
+						v1.ResourceCPU:    resource.MustParse("10m"),
+					},
+					Limits: v1.ResourceList{
+						v1.ResourceMemory: resource.MustParse("10Mi"),
+						v1.ResourceCPU:    resource.MustParse("50m"),
+					},
+				},
+				EnvFrom: []v1.EnvFromSource{{
+					ConfigMapRef: &v1.ConfigMapEnvSource{
+						LocalObjectReference: v1.LocalObjectReference{Name: "cell-config"},
+					},
+				}},
+				Env: []v1.EnvVar{
+					{Name: "RUST_LOG", Value: "info"},
+					{Name: "NAMESPACE", ValueFrom: &v1.EnvVarSource{
+						FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+					}},
+					{Name: "HOSTNAME", ValueFrom: &v1.EnvVarSource{
+						FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"},
+					}},
+				},
+			}},
+		},
+	}
+	template := m.templateFor(index)
+	template.apply(pod)
+	pod.Annotations[templateAnnotation] = template.hash(m.image)
+	if m.affinity != nil {
+		pod.Spec.Affinity = m.affinity(index)
+	}
+	if m.desired != nil {
+		// The engine decides the cell's state; the worker only reports it.
+		pod.Labels["game-status"] = "alive"
+		c := &pod.Spec.Containers[0]
+		c.Env = append(c.Env, v1.EnvVar{Name: "CELL_MODE", Value: "passive"})
+	}
+	if m.genome != nil {
+		if g, ok := m.genome(index); ok {
+			pod.Annotations[genomeAnnotation] = g.String()
+		}
+	}
+	return pod
+}
+
+// Resync asks the manager to reconcile as soon as possible.
+func (m *cellPodManager) Resync() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *cellPodManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.reconcile(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.trigger:
+		}
+	}
+}
+
+// Retired reports whether the manager itself deleted the named pod, i.e. the
+// cell died by the rule rather than by chaos. Once the pod is gone the mark
+// is cleared by Forget.
+func (m *cellPodManager) Retired(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.retiring[name]
+}
+
+// Replaced reports whether the manager deleted the named pod only to recreate
+// it, so the cell itself did not die.
+func (m *cellPodManager) Replaced(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.replacing[name]
+}
+
+func (m *cellPodManager) Forget(name string) {
+	m.mu.Lock()
+	delete(m.retiring, name)
+	delete(m.replacing, name)
+	m.mu.Unlock()
+}
+
+// Kinds of drift between the desired grid and the cell pods.
+const (
+	driftMissing    = "missing"
+	driftSurplus    = "surplus"
+	driftFailed     = "failed"
+	driftPending    = "pending"
+	driftDuplicated = "duplicated"
+)
+
+var (
+	driftCells = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grid_drift_cells",
+		Help: "Cells whose pod did not match the desired grid at the last reconcile, by kind of drift.",
+	}, []string{"kind"})
+	driftRepairs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grid_drift_repairs_total",
+		Help: "Pods created or deleted to repair drift, by kind of drift.",
+	}, []string{"kind"})
+)
+
+// reconcile compares the desired grid with the cell pods in the cache and
+// repairs every discrepancy: missing pods are created, surplus ones deleted,
+// and failed or stuck pending pods deleted so the next pass recreates them.
+// Pods that look like cells but do not belong to the grid, such as leftovers
+// of a larger grid, are deleted as duplicates.
+func (m *cellPodManager) reconcile(ctx context.Context) {
+	drift := map[string]int{}
+	var creating time.Duration
+	m.deleting = 0
+	m.mu.Lock()
+	deadline := m.deadline
+	m.deadline = nil
+	m.mu.Unlock()
+
+	for i := 0; i < m.grid.Size(); i++ {
+		want := m.desired == nil || m.desired(i)
+		pod, err := m.pods.Pods(m.namespace).Get(cellName(i))
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Cells: lookup %s: %v", cellName(i), err)
+			continue
+		}
+		exists := err == nil
+
+		switch {
+		case want && !exists:
+			drift[driftMissing]++
+			if deadline.overdue() {
+				continue
+			}
+			start := time.Now()
+			err = podWrites.Do(ctx, verbCreate, func(ctx context.Context) error {
+				_, err := m.clientset.CoreV1().Pods(m.namespace).Create(ctx, m.podFor(i), metav1.CreateOptions{})
+				return err
+			})
+			creating += time.Since(start)
+			if apierrors.IsAlreadyExists(err) {
+				err = nil
+			}
+			m.digests.APICall(true, err)
+			if err != nil {
+				log.Printf("Cells: create %s: %v", cellName(i), err)
+				cellErrors.APIError(m.namespace, cellName(i), intentBirth, err)
+				continue
+			}
+			driftRepairs.WithLabelValues(driftMissing).Inc()
+		case !exists || pod.DeletionTimestamp != nil:
+		case !want:
+			drift[driftSurplus]++
+			if deadline.overdue() {
+				continue
+			}
+			m.retire(ctx, pod.Name, driftSurplus)
+		case pod.Status.Phase == v1.PodFailed:
+			drift[driftFailed]++
+			if deadline.overdue() {
+				continue
+			}
+			m.replace(ctx, pod.Name, driftFailed)
+		case pod.Status.Phase == v1.PodPending && m.pendingTimeout > 0 && time.Since(pod.CreationTimestamp.Time) > m.pendingTimeout:
+			drift[driftPending]++
+			if deadline.overdue() {
+				continue
+			}
+			m.replace(ctx, pod.Name, driftPending)
+		}
+	}
+
+	list, err := m.pods.Pods(m.namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell", "managed-by": "grid-controller"}))
+	if err == nil {
+		for _, pod := range list {
+			if i, ok := cellIndex(pod.Name); ok && cellName(i) == pod.Name && i < m.grid.Size() {
+				continue
+			}
+			if pod.DeletionTimestamp == nil {
+				drift[driftDuplicated]++
+				if deadline.overdue() {
+					continue
+				}
+				m.retire(ctx, pod.Name, driftDuplicated)
+			}
+		}
+	}
+
+	if deadline != nil && m.onDeadline != nil {
+		m.onDeadline(deadline.generation, deadline.outcome())
+	}
+	if creating > 0 {
+		m.digests.Phase(phaseCreates, creating)
+	}
+	if m.deleting > 0 {
+		m.digests.Phase(phaseDeletes, m.deleting)
+	}
+
+	for _, kind := range []string{driftMissing, driftSurplus, driftFailed, driftPending, driftDuplicated} {
+		driftCells.WithLabelValues(kind).Set(float64(drift[kind]))
+	}
+	if len(drift) > 0 {
+		log.Printf("Cells: repaired drift %v", drift)
+	}
+}
+
+// Converged reports whether the cell pods in the cache match the desired
+// grid: every desired cell has a running pod and no other cell has one, not
+// even a terminating one.
+func (m *cellPodManager) Converged() bool {
+	list, err := m.pods.Pods(m.namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell"}))
+	if err != nil {
+		return false
+	}
+	for _, pod := range list {
+		i, ok := cellIndex(pod.Name)
+		if !ok || i >= m.grid.Size() || (m.desired != nil && !m.desired(i)) {
+			return false
+		}
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodRunning {
+			return false
+		}
+	}
+	want := m.grid.Size()
+	if m.desired != nil {
+		want = 0
+		for i := 0; i < m.grid.Size(); i++ {
+			if m.desired(i) {
+				want++
+			}
+		}
+	}
+	return len(list) == want
+}
+
+// retire deletes a pod that should not exist; its deletion is a death by the
+// rule, not a chaos kill.
+func (m *cellPodManager) retire(ctx context.Context, name, kind string) {
+	m.mu.Lock()
+	m.retiring[name] = true
+	m.mu.Unlock()
+	if err := m.delete(ctx, name, kind); err != nil {
+		cellErrors.APIError(m.namespace, name, intentDeath, err)
+		m.Forget(name)
+	}
+}
+
+// replace deletes a broken pod of a desired cell so that it is recreated.
+func (m *cellPodManager) replace(ctx context.Context, name, kind string) {
+	m.mu.Lock()
+	m.replacing[name] = true
+	m.mu.Unlock()
+	if m.delete(ctx, name, kind) != nil {
+		m.Forget(name)
+	}
+}
+
+func (m *cellPodManager) delete(ctx context.Context, name, kind string) error {
+	start := time.Now()
+	err := podWrites.Do(ctx, verbDelete, func(ctx context.Context) error {
+		return m.clientset.CoreV1().Pods(m.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+	m.deleting += time.Since(start)
+	if apierrors.IsNotFound(err) {
+		err = nil
+	}
+	m.digests.APICall(false, err)
+	if err != nil {
+		log.Printf("Cells: delete %s: %v", name, err)
+		return err
+	}
+	driftRepairs.WithLabelValues(kind).Inc()
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Targeting strategies of the chaos monkey.
+const (
+	chaosRandom     = "random"
+	chaosOldest     = "oldest"
+	chaosDensest    = "densest"
+	chaosNode       = "node"
+	chaosAntiGlider = "anti-glider"
+)
+
+var chaosStrategyNames = []string{chaosRandom, chaosOldest, chaosDensest, chaosNode + ":<name>", chaosAntiGlider}
+
+// chaosTarget is a live cell the monkey may kill.
+type chaosTarget struct {
+	pod  *v1.Pod
+	cell int
+}
+
+// chaosStrategy picks the cell to kill among the candidates, ordered by cell
+// index, and explains the choice. ok is false when nothing fits the strategy.
+type chaosStrategy interface {
+	Name() string
+	Pick(rng *rand.Rand, grid GridGeometry, targets []chaosTarget) (pick int, reason string, ok bool)
+}
+
+// parseChaosStrategy parses random, oldest, densest, node:<name> or
+// anti-glider.
+func parseChaosStrategy(spec string) (chaosStrategy, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch {
+	case name == chaosRandom && arg == "":
+		return randomStrategy{}, nil
+	case name == chaosOldest && arg == "":
+		return oldestStrategy{}, nil
+	case name == chaosDensest && arg == "":
+		return densestStrategy{}, nil
+	case name == chaosNode && arg != "":
+		return nodeStrategy{node: arg}, nil
+	case name == chaosAntiGlider && arg == "":
+		return antiGliderStrategy{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q (want one of %v)", spec, chaosStrategyNames)
+}
+
+type randomStrategy struct{}
+
+func (randomStrategy) Name() string { return chaosRandom }
+
+func (randomStrategy) Pick(rng *rand.Rand, _ GridGeometry, targets []chaosTarget) (int, string, bool) {
+	pick := rng.Intn(len(targets))
+	return pick, fmt.Sprintf("picked uniformly at random: candidate %d of %d", pick+1, len(targets)), true
+}
+
+// oldestStrategy kills the longest-lived cell, breaking up still lifes.
+type oldestStrategy struct{}
+
+func (oldestStrategy) Name() string { return chaosOldest }
+
+func (oldestStrategy) Pick(_ *rand.Rand, _ GridGeometry, targets []chaosTarget) (int, string, bool) {
+	pick := 0
+	for i, t := range targets {
+		if t.pod.CreationTimestamp.Before(&targets[pick].pod.CreationTimestamp) {
+			pick = i
+		}
+	}
+	created := targets[pick].pod.CreationTimestamp
+	return pick, fmt.Sprintf("oldest cell, alive since %s", created.UTC().Format("2006-01-02T15:04:05Z")), true
+}
+
+// densestStrategy kills the center of the most crowded 5x5 region.
+type densestStrategy struct{}
+
+func (densestStrategy) Name() string { return chaosDensest }
+
+func (densestStrategy) Pick(rng *rand.Rand, grid GridGeometry, targets []chaosTarget) (int, string, bool) {
+	live := make(map[int]bool, len(targets))
+	for _, t := range targets {
+		live[t.cell] = true
+	}
+	var best []int
+	bestCount := -1
+	for i, t := range targets {
+		x, y := grid.Coords(t.cell)
+		count := 0
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				if grid.Contains(x+dx, y+dy) && live[grid.Index(x+dx, y+dy)] {
+					count++
+				}
+			}
+		}
+		switch {
+		case count > bestCount:
+			best, bestCount = []int{i}, count
+		case count == bestCount:
+			best = append(best, i)
+		}
+	}
+	pick := best[rng.Intn(len(best))]
+	x, y := grid.Coords(targets[pick].cell)
+	return pick, fmt.Sprintf("densest region: %d live cells in the 5x5 around (%d, %d), %d regions tied", bestCount, x, y, len(best)), true
+}
+
+// nodeStrategy kills a random cell scheduled on one node, rehearsing the
+// loss of the cells a node hosts.
+type nodeStrategy struct {
+	node string
+}
+
+func (s nodeStrategy) Name() string { return chaosNode + ":" + s.node }
+
+func (s nodeStrategy) Pick(rng *rand.Rand, _ GridGeometry, targets []chaosTarget) (int, string, bool) {
+	var on []int
+	for i, t := range targets {
+		if t.pod.Spec.NodeName == s.node {
+			on = append(on, i)
+		}
+	}
+	if len(on) == 0 {
+		return 0, fmt.Sprintf("no live cells on node %s", s.node), false
+	}
+	pick := on[rng.Intn(len(on))]
+	return pick, fmt.Sprintf("random cell on node %s: %d of %d there", s.node, len(on), len(targets)), true
+}
+
+// antiGliderStrategy hunts gliders: it kills a cell of an isolated glider,
+// picked at random when there are several.
+type antiGliderStrategy struct{}
+
+func (antiGliderStrategy) Name() string { return chaosAntiGlider }
+
+func (antiGliderStrategy) Pick(rng *rand.Rand, grid GridGeometry, targets []chaosTarget) (int, string, bool) {
+	byCell := make(map[int]int, len(targets))
+	for i, t := range targets {
+		byCell[t.cell] = i
+	}
+	type sighting struct{ x, y, cell int }
+	var found []sighting
+	for y := 0; y+3 <= grid.Height; y++ {
+		for x := 0; x+3 <= grid.Width; x++ {
+			if cells, _, ok := gliderAt(grid, byCell, x, y); ok {
+				found = append(found, sighting{x, y, cells[rng.Intn(len(cells))]})
+			}
+		}
+	}
+	if len(found) == 0 {
+		return 0, "no isolated glider on the grid", false
+	}
+	s := found[rng.Intn(len(found))]
+	return byCell[s.cell], fmt.Sprintf("glider in the 3x3 box at (%d, %d), one of %d found", s.x, s.y, len(found)), true
+}
+
+// gliderHeadings are the glider's four phases in every orientation, as 3x3
+// bitmasks (bit y*3+x), with the diagonal each moves along, one cell every
+// four generations.
+var gliderHeadings = func() map[uint16][2]int {
+	phases := [][]string{
+		{".#.", "..#", "###"},
+		{"#.#", ".##", ".#."},
+		{"..#", "#.#", ".##"},
+		{"#..", ".##", "##."},
+	}
+	masks := map[uint16][2]int{}
+	for _, rows := range phases {
+		for sym := 0; sym < 8; sym++ {
+			// The phases above move down and to the right.
+			var mask uint16
+			dx, dy := 1, 1
+			for y, row := range rows {
+				for x, c := range row {
+					if c != '#' {
+						continue
+					}
+					tx, ty := x, y
+					if sym&1 != 0 {
+						tx = 2 - tx
+					}
+					if sym&2 != 0 {
+						ty = 2 - ty
+					}
+					if sym&4 != 0 {
+						tx, ty = ty, tx
+					}
+					mask |= 1 << (ty*3 + tx)
+				}
+			}
+			if sym&1 != 0 {
+				dx = -dx
+			}
+			if sym&2 != 0 {
+				dy = -dy
+			}
+			if sym&4 != 0 {
+				dx, dy = dy, dx
+			}
+			masks[mask] = [2]int{dx, dy}
+		}
+	}
+	return masks
+}()
+
+// gliderAt reports the cells and heading of a glider filling the 3x3 box at
+// (x, y) with nothing alive in the ring around it.
+func gliderAt(grid GridGeometry, live map[int]int, x, y int) ([]int, [2]int, bool) {
+	var mask uint16
+	var cells []int
+	for dy := -1; dy <= 3; dy++ {
+		for dx := -1; dx <= 3; dx++ {
+			if !grid.Contains(x+dx, y+dy) {
+				continue
+			}
+			i := grid.Index(x+dx, y+dy)
+			if _, alive := live[i]; !alive {
+				continue
+			}
+			if dx < 0 || dy < 0 || dx > 2 || dy > 2 {
+				return nil, [2]int{}, false
+			}
+			mask |= 1 << (dy*3 + dx)
+			cells = append(cells, i)
+		}
+	}
+	sort.Ints(cells)
+	heading, ok := gliderHeadings[mask]
+	return cells, heading, ok
+}
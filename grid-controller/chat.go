@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ChatConfig lets a stream's audience play through chat commands, e.g.
+//
+//	chat:
+//	  twitch:
+//	    channel: mychannel
+//	    nick: gridbot
+//	    tokenFile: /var/run/secrets/twitch/oauth
+//	  webhookSecretFile: /var/run/secrets/chat/webhook
+//
+// Commands are
+//
+//	!kill x,y              kill the cell's pod (chaos)
+//	!spawn x,y             bring the cell to life
+//	!pattern name [x,y]    stamp a built-in pattern, centered without x,y
+//	!stats                 generation, population and rule
+//
+// Coordinates may also be given as "x y". Commands are applied through the
+// control API as requests by chat:<platform>:<user>, so quotas, the audit log
+// and the engine's own checks treat every chat user as a caller of their own;
+// every command is also charged to the chat quota action. Without nick and
+// tokenFile the Twitch bridge joins anonymously and only reads; with them it
+// answers rejected commands. Other platforms, e.g. a YouTube chat relay, POST
+// {"platform", "user", "text"} to /api/chat with the webhook secret as a
+// bearer token.
+type ChatConfig struct {
+	Twitch            *TwitchConfig `json:"twitch,omitempty"`
+	WebhookSecretFile string        `json:"webhookSecretFile,omitempty"`
+}
+
+// TwitchConfig is the Twitch IRC connection of the chat bridge.
+type TwitchConfig struct {
+	Channel   string `json:"channel"`
+	Nick      string `json:"nick,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+	// Server defaults to irc.chat.twitch.tv:6697 (TLS).
+	Server string `json:"server,omitempty"`
+}
+
+func (c *ChatConfig) validate() error {
+	if c.Twitch == nil && c.WebhookSecretFile == "" {
+		return errors.New("twitch or webhookSecretFile is required")
+	}
+	if t := c.Twitch; t != nil {
+		if t.Channel == "" {
+			return errors.New("twitch: channel is required")
+		}
+		if (t.Nick == "") != (t.TokenFile == "") {
+			return errors.New("twitch: nick and tokenFile go together")
+		}
+		if t.Server == "" {
+			t.Server = "irc.chat.twitch.tv:6697"
+		}
+		t.Channel = strings.ToLower(strings.TrimPrefix(t.Channel, "#"))
+	}
+	return nil
+}
+
+var chatCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_chat_commands_total",
+	Help: "Chat commands by platform and outcome (applied, rejected or invalid).",
+}, []string{"platform", "outcome"})
+
+type chatUserKey struct{}
+
+// chatUser returns platform:user for requests made on behalf of a chat user.
+func chatUser(r *http.Request) string {
+	u, _ := r.Context().Value(chatUserKey{}).(string)
+	return u
+}
+
+// chatBridge turns chat commands into control API requests.
+type chatBridge struct {
+	handler http.Handler
+	grid    GridGeometry
+	sim     *simulation
+}
+
+// chatCommandNames are the commands the bridge answers; other !words are
+// left to the chat's own bots.
+var chatCommandNames = map[string]bool{"kill": true, "spawn": true, "pattern": true, "stats": true}
+
+// Command applies one chat message. It returns false for messages that are
+// not commands; otherwise the reply tells the user what happened.
+func (b *chatBridge) Command(ctx context.Context, platform, user, text string) (reply string, ok bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") || !chatCommandNames[strings.ToLower(fields[0][1:])] {
+		return "", false
+	}
+	command, args := strings.ToLower(fields[0][1:]), fields[1:]
+	id := platform + ":" + strings.ToLower(user)
+	ctx = context.WithValue(ctx, chatUserKey{}, id)
+
+	if reply, ok := b.charge(ctx); !ok {
+		chatCommands.WithLabelValues(platform, "rejected").Inc()
+		return reply, true
+	}
+	if command == "stats" {
+		chatCommands.WithLabelValues(platform, "applied").Inc()
+		return b.stats(), true
+	}
+	req, err := b.request(command, args)
+	if err != nil {
+		chatCommands.WithLabelValues(platform, "invalid").Inc()
+		return err.Error(), true
+	}
+	req = req.WithContext(ctx)
+	req.RemoteAddr = "chat"
+	rec := httptest.NewRecorder()
+	b.handler.ServeHTTP(rec, req)
+	if rec.Code >= 300 {
+		chatCommands.WithLabelValues(platform, "rejected").Inc()
+		return strings.TrimSpace(rec.Body.String()), true
+	}
+	chatCommands.WithLabelValues(platform, "applied").Inc()
+	log.Printf("Chat: %s: %s", id, text)
+	return "ok", true
+}
+
+// charge takes one command from the chat user's chat quota.
+func (b *chatBridge) charge(ctx context.Context) (reply string, ok bool) {
+	req, _ := http.NewRequestWithContext(ctx, "POST", "/api/chat", nil)
+	req.RemoteAddr = "chat"
+	rec := httptest.NewRecorder()
+	if !quotas.Allow(rec, req, actionChat) {
+		return strings.TrimSpace(rec.Body.String()), false
+	}
+	return "", true
+}
+
+func (b *chatBridge) stats() string {
+	if b.sim == nil {
+		return "stats require --engine=standalone"
+	}
+	return fmt.Sprintf("generation %d, population %d, rule %s", b.sim.engine.Generation(), b.sim.engine.Population(), b.sim.engine.Rule())
+}
+
+func (b *chatBridge) request(command string, args []string) (*http.Request, error) {
+	switch command {
+	case "kill", "spawn":
+		x, y, err := b.coords(args)
+		if err != nil {
+			return nil, fmt.Errorf("usage: %s x,y (%w)", command, err)
+		}
+		i := b.grid.Index(x, y)
+		if command == "kill" {
+			return http.NewRequest("DELETE", "/api/pods/"+cellName(i), nil)
+		}
+		return http.NewRequest("POST", "/api/cells/"+strconv.Itoa(i), nil)
+	default: // pattern
+		if len(args) < 1 || len(args) > 3 {
+			return nil, errors.New("usage: pattern name [x,y]")
+		}
+		target := "/api/patterns/" + url.PathEscape(strings.ToLower(args[0]))
+		if len(args) > 1 {
+			x, y, err := b.coords(args[1:])
+			if err != nil {
+				return nil, fmt.Errorf("usage: pattern name [x,y] (%w)", err)
+			}
+			target += fmt.Sprintf("?x=%d&y=%d", x, y)
+		}
+		return http.NewRequest("POST", target, nil)
+	}
+}
+
+// coords parses "x,y" or "x y".
+func (b *chatBridge) coords(args []string) (x, y int, err error) {
+	arg := strings.Join(args, ",")
+	xs, ys, ok := strings.Cut(arg, ",")
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if len(args) == 0 || len(args) > 2 || !ok || errX != nil || errY != nil {
+		return 0, 0, fmt.Errorf("coordinates must be x,y, not %q", strings.Join(args, " "))
+	}
+	if !b.grid.Contains(x, y) {
+		return 0, 0, fmt.Errorf("%d,%d is outside the %dx%d grid", x, y, b.grid.Width, b.grid.Height)
+	}
+	return x, y, nil
+}
+
+// RunTwitch keeps the bridge connected to the channel's IRC chat.
+func (b *chatBridge) RunTwitch(ctx context.Context, cfg TwitchConfig) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := b.twitch(ctx, cfg)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Chat: twitch: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 2*time.Minute)
+	}
+}
+
+func (b *chatBridge) twitch(ctx context.Context, cfg TwitchConfig) error {
+	nick := fmt.Sprintf("justinfan%d", 10000+rand.Intn(90000))
+	var pass string
+	if cfg.Nick != "" {
+		token, err := os.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return err
+		}
+		nick, pass = strings.ToLower(cfg.Nick), "oauth:"+strings.TrimPrefix(strings.TrimSpace(string(token)), "oauth:")
+	}
+
+	conn, err := egressDialTLS(ctx, "twitch", cfg.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	send := func(line string) error {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err
+	}
+	if pass != "" {
+		send("PASS " + pass)
+	}
+	send("NICK " + nick)
+	if err := send("JOIN #" + cfg.Channel); err != nil {
+		return err
+	}
+	log.Printf("Chat: joined twitch #%s as %s", cfg.Channel, nick)
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "PING") {
+			send("PONG" + strings.TrimPrefix(line, "PING"))
+			continue
+		}
+		user, text, ok := parsePrivmsg(line, cfg.Channel)
+		if !ok {
+			continue
+		}
+		reply, ok := b.Command(ctx, "twitch", user, text)
+		if ok && pass != "" && reply != "ok" && !strings.Contains(reply, "\n") {
+			send(fmt.Sprintf("PRIVMSG #%s :@%s %s", cfg.Channel, user, reply))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed")
+}
+
+// parsePrivmsg extracts the sender and text of a channel message such as
+// ":alice!alice@alice.tmi.twitch.tv PRIVMSG #channel :!kill 3,7".
+func parsePrivmsg(line, channel string) (user, text string, ok bool) {
+	prefix, rest, ok := strings.Cut(line, " PRIVMSG #"+channel+" :")
+	if !ok || !strings.HasPrefix(prefix, ":") {
+		return "", "", false
+	}
+	user, _, _ = strings.Cut(prefix[1:], "!")
+	return user, rest, user != ""
+}
+
+// ChatMessage is posted to /api/chat by chat relays.
+type ChatMessage struct {
+	Platform string `json:"platform"`
+	User     string `json:"user"`
+	Text     string `json:"text"`
+}
+
+// ChatReply answers a chat relay.
+type ChatReply struct {
+	Command bool   `json:"command"`
+	Reply   string `json:"reply,omitempty"`
+}
+
+// handleChat serves POST /api/chat, the generic chat webhook.
+func handleChat(w http.ResponseWriter, r *http.Request, b *chatBridge, secret []byte) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(secret) == 0 {
+		http.Error(w, "Chat webhook disabled", http.StatusForbidden)
+		return
+	}
+	token, ok := bearerToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), secret) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var msg ChatMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg.Platform == "" || msg.User == "" || strings.Contains(msg.Platform, ":") {
+		http.Error(w, "platform and user are required", http.StatusBadRequest)
+		return
+	}
+
+	var reply ChatReply
+	reply.Reply, reply.Command = b.Command(r.Context(), msg.Platform, msg.User, msg.Text)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
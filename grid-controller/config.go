@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the optional controller configuration file (--config), for
+// settings too structured for flags.
+type Config struct {
+	Alerts            []AlertRule             `json:"alerts,omitempty"`
+	Extinction        ExtinctionPolicy        `json:"extinction,omitempty"`
+	Tenants           []Tenant                `json:"tenants,omitempty"`
+	Bots              []Bot                   `json:"bots,omitempty"`
+	OIDC              *OIDCConfig             `json:"oidc,omitempty"`
+	Quotas            []QuotaRule             `json:"quotas,omitempty"`
+	Snapshots         SnapshotPolicy          `json:"snapshots,omitempty"`
+	Stats             StatsConfig             `json:"stats,omitempty"`
+	Deadline          DeadlinePolicy          `json:"deadline,omitempty"`
+	Edits             EditConfig              `json:"edits,omitempty"`
+	WasmRules         WasmLimits              `json:"wasmRules,omitempty"`
+	Hooks             HookConfig              `json:"hooks,omitempty"`
+	Rules             RulesConfig             `json:"rules,omitempty"`
+	Genetics          *GeneticsConfig         `json:"genetics,omitempty"`
+	Energy            *EnergyConfig           `json:"energy,omitempty"`
+	Sonification      *SonificationConfig     `json:"sonification,omitempty"`
+	OSC               *OSCConfig              `json:"osc,omitempty"`
+	Chat              *ChatConfig             `json:"chat,omitempty"`
+	Slash             *SlashConfig            `json:"slash,omitempty"`
+	AutoChaos         *AutoChaosConfig        `json:"autoChaos,omitempty"`
+	DisruptionBudgets *DisruptionBudgetConfig `json:"disruptionBudgets,omitempty"`
+	ChurnBreaker      *ChurnBreakerConfig     `json:"churnBreaker,omitempty"`
+	PopulationCap     *PopulationCapConfig    `json:"populationCap,omitempty"`
+	CellTemplate      *CellTemplate           `json:"cellTemplate,omitempty"`
+	Lifecycle         *LifecycleConfig        `json:"lifecycle,omitempty"`
+	OperatingHours    *OperatingHours         `json:"operatingHours,omitempty"`
+	Renderers         []RendererConfig        `json:"renderers,omitempty"`
+	Scenarios         []Scenario              `json:"scenarios,omitempty"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, errors.Join(cfg.Snapshots.validate(), cfg.WasmRules.validate(), cfg.Hooks.validate(), cfg.Rules.validate(), cfg.Edits.validate())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Extinction.validate(); err != nil {
+		return nil, fmt.Errorf("%s: extinction: %w", path, err)
+	}
+	for i, rule := range cfg.Alerts {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%s: alert %d: %w", path, i, err)
+		}
+	}
+	if err := cfg.Snapshots.validate(); err != nil {
+		return nil, fmt.Errorf("%s: snapshots: %w", path, err)
+	}
+	if err := cfg.Deadline.validate(); err != nil {
+		return nil, fmt.Errorf("%s: deadline: %w", path, err)
+	}
+	if err := cfg.Edits.validate(); err != nil {
+		return nil, fmt.Errorf("%s: edits: %w", path, err)
+	}
+	if err := cfg.Stats.validate(); err != nil {
+		return nil, fmt.Errorf("%s: stats: %w", path, err)
+	}
+	if err := cfg.WasmRules.validate(); err != nil {
+		return nil, fmt.Errorf("%s: wasmRules: %w", path, err)
+	}
+	if err := cfg.Hooks.validate(); err != nil {
+		return nil, fmt.Errorf("%s: hooks: %w", path, err)
+	}
+	if err := cfg.Rules.validate(); err != nil {
+		return nil, fmt.Errorf("%s: rules: %w", path, err)
+	}
+	if cfg.Genetics != nil {
+		if err := cfg.Genetics.validate(); err != nil {
+			return nil, fmt.Errorf("%s: genetics: %w", path, err)
+		}
+	}
+	if cfg.Energy != nil {
+		if err := cfg.Energy.validate(); err != nil {
+			return nil, fmt.Errorf("%s: energy: %w", path, err)
+		}
+	}
+	if cfg.Sonification != nil {
+		if err := cfg.Sonification.validate(); err != nil {
+			return nil, fmt.Errorf("%s: sonification: %w", path, err)
+		}
+	}
+	if cfg.OSC != nil {
+		if err := cfg.OSC.validate(); err != nil {
+			return nil, fmt.Errorf("%s: osc: %w", path, err)
+		}
+	}
+	for i := range cfg.Renderers {
+		if err := cfg.Renderers[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: renderer %d: %w", path, i, err)
+		}
+	}
+	for i := range cfg.Scenarios {
+		if err := cfg.Scenarios[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: scenario %d: %w", path, i, err)
+		}
+	}
+	if cfg.Chat != nil {
+		if err := cfg.Chat.validate(); err != nil {
+			return nil, fmt.Errorf("%s: chat: %w", path, err)
+		}
+	}
+	if cfg.Slash != nil {
+		if err := cfg.Slash.validate(); err != nil {
+			return nil, fmt.Errorf("%s: slash: %w", path, err)
+		}
+	}
+	if cfg.AutoChaos != nil {
+		if err := cfg.AutoChaos.validate(); err != nil {
+			return nil, fmt.Errorf("%s: autoChaos: %w", path, err)
+		}
+	}
+	if cfg.DisruptionBudgets != nil {
+		if err := cfg.DisruptionBudgets.validate(); err != nil {
+			return nil, fmt.Errorf("%s: disruptionBudgets: %w", path, err)
+		}
+	}
+	if cfg.ChurnBreaker != nil {
+		if err := cfg.ChurnBreaker.validate(); err != nil {
+			return nil, fmt.Errorf("%s: churnBreaker: %w", path, err)
+		}
+	}
+	if cfg.PopulationCap != nil {
+		if err := cfg.PopulationCap.validate(); err != nil {
+			return nil, fmt.Errorf("%s: populationCap: %w", path, err)
+		}
+	}
+	if cfg.Lifecycle != nil {
+		if err := cfg.Lifecycle.validate(); err != nil {
+			return nil, fmt.Errorf("%s: lifecycle: %w", path, err)
+		}
+	}
+	if cfg.OperatingHours != nil {
+		if err := cfg.OperatingHours.validate(); err != nil {
+			return nil, fmt.Errorf("%s: operatingHours: %w", path, err)
+		}
+	}
+	if cfg.CellTemplate != nil {
+		if err := cfg.CellTemplate.validate(); err != nil {
+			return nil, fmt.Errorf("%s: cellTemplate: %w", path, err)
+		}
+	}
+	if cfg.OIDC != nil {
+		if err := cfg.OIDC.validate(); err != nil {
+			return nil, fmt.Errorf("%s: oidc: %w", path, err)
+		}
+	}
+	for i, q := range cfg.Quotas {
+		if err := q.validate(); err != nil {
+			return nil, fmt.Errorf("%s: quota %d: %w", path, i, err)
+		}
+	}
+	names := map[string]bool{}
+	for i, t := range cfg.Tenants {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("%s: tenant %d: %w", path, i, err)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("%s: tenant %d: duplicate name %q", path, i, t.Name)
+		}
+		names[t.Name] = true
+	}
+	names = map[string]bool{}
+	for i, b := range cfg.Bots {
+		if err := b.validate(); err != nil {
+			return nil, fmt.Errorf("%s: bot %d: %w", path, i, err)
+		}
+		if names[b.Name] {
+			return nil, fmt.Errorf("%s: bot %d: duplicate name %q", path, i, b.Name)
+		}
+		names[b.Name] = true
+	}
+	return cfg, nil
+}
@@ -0,0 +1,390 @@
+// Package conformance checks a grid controller's event streams, /ws and
+// /api/events, against the wire protocol: envelope schemas, a snapshot
+// before any delta, strictly increasing sequence numbers, and resuming a
+// stream after a reconnect without gaps or repeats.
+//
+// The suite only reads the streams, so it runs against any controller that
+// produces cell updates while it watches, e.g.
+//
+//	GRID_CONFORMANCE_URL=http://localhost:8080 go test ./conformance
+//
+// Vectors are golden simulations, a seed and a rule with the state hash
+// expected some generations later, for checking rule engines and cell
+// workers against known-correct behavior.
+package conformance
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Suite is one run of the conformance checks.
+type Suite struct {
+	// URL is the controller's base URL, e.g. http://localhost:8080.
+	URL string
+	// Query is added to every stream request, e.g. grid=demo or an
+	// access_token.
+	Query url.Values
+	// Deltas is how many messages after the snapshot each check waits for;
+	// 20 when zero.
+	Deltas int
+	// Timeout bounds each check; 30 seconds when zero.
+	Timeout time.Duration
+}
+
+// Envelope is a v2 message as it appears on the wire.
+type Envelope struct {
+	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
+	Time time.Time       `json:"ts"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Run runs every check as a subtest of t.
+func (s Suite) Run(t *testing.T) {
+	if s.Deltas == 0 {
+		s.Deltas = 20
+	}
+	if s.Timeout == 0 {
+		s.Timeout = 30 * time.Second
+	}
+	t.Run("Envelope", s.testEnvelope)
+	t.Run("SnapshotFirst", s.testSnapshotFirst)
+	t.Run("SequenceContinuity", s.testSequenceContinuity)
+	t.Run("Resume", s.testResume)
+	t.Run("ResumeEvents", s.testResumeEvents)
+	t.Run("LegacyProtocol", s.testLegacyProtocol)
+}
+
+// testEnvelope checks that every v2 message carries a type, a sequence
+// number, a timestamp and an object as data, without extra fields.
+func (s Suite) testEnvelope(t *testing.T) {
+	conn := s.dial(t, nil)
+	for range s.Deltas {
+		raw := readRaw(t, conn)
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			t.Fatalf("message is not a JSON object: %s", raw)
+		}
+		for _, key := range []string{"type", "seq", "ts", "data"} {
+			if _, ok := fields[key]; !ok {
+				t.Fatalf("message has no %q: %s", key, raw)
+			}
+		}
+		if len(fields) != 4 {
+			t.Errorf("message has fields besides type, seq, ts and data: %s", raw)
+		}
+		env := decode(t, raw)
+		if env.Type == "" {
+			t.Errorf("message has an empty type: %s", raw)
+		}
+		if env.Time.IsZero() {
+			t.Errorf("message has no timestamp: %s", raw)
+		}
+		if !strings.HasPrefix(string(env.Data), "{") {
+			t.Errorf("message data is not an object: %s", raw)
+		}
+		if env.Type == "cell" {
+			var cell struct {
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal(env.Data, &cell); err != nil || cell.Name == "" || cell.Status == "" {
+				t.Errorf("cell update has no name or status: %s", raw)
+			}
+		}
+	}
+}
+
+// testSnapshotFirst checks that a new connection receives a snapshot before
+// any other message, except the current banner.
+func (s Suite) testSnapshotFirst(t *testing.T) {
+	conn := s.dial(t, nil)
+	snap := readSnapshot(t, conn)
+	var data struct {
+		Cells []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"cells"`
+	}
+	if err := json.Unmarshal(snap.Data, &data); err != nil || data.Cells == nil {
+		t.Fatalf("snapshot has no cells: %s", snap.Data)
+	}
+	seen := map[string]bool{}
+	for _, c := range data.Cells {
+		if c.Name == "" || c.Status == "" {
+			t.Errorf("snapshot cell has no name or status: %+v", c)
+		}
+		if seen[c.Name] {
+			t.Errorf("snapshot lists %s twice", c.Name)
+		}
+		seen[c.Name] = true
+	}
+}
+
+// testSequenceContinuity checks that sequence numbers strictly increase from
+// the snapshot on.
+func (s Suite) testSequenceContinuity(t *testing.T) {
+	conn := s.dial(t, nil)
+	last := readSnapshot(t, conn).Seq
+	for range s.Deltas {
+		env := read(t, conn)
+		if env.Type == "snapshot" {
+			t.Fatalf("second snapshot at seq %d", env.Seq)
+		}
+		if env.Seq <= last {
+			t.Fatalf("seq %d (%s) after %d", env.Seq, env.Type, last)
+		}
+		last = env.Seq
+	}
+}
+
+// testResume checks that a client reconnecting with ?since= receives exactly
+// the messages a client that stayed connected received after that point.
+func (s Suite) testResume(t *testing.T) {
+	reference := s.dial(t, nil)
+	readSnapshot(t, reference)
+
+	first := s.dial(t, nil)
+	since := readSnapshot(t, first).Seq
+	for range s.Deltas / 2 {
+		since = read(t, first).Seq
+	}
+	first.Close()
+
+	resumed := s.dial(t, url.Values{"since": {strconv.FormatUint(since, 10)}})
+	got := readAfterBanner(t, resumed)
+	if got.Type == "snapshot" {
+		t.Fatalf("resuming from seq %d sent a snapshot, want the missed messages", since)
+	}
+	want := read(t, reference)
+	for want.Seq <= since {
+		want = read(t, reference)
+	}
+	for i := 0; ; i++ {
+		if got.Seq != want.Seq || got.Type != want.Type {
+			t.Fatalf("resumed stream has seq %d (%s), connected client seq %d (%s)", got.Seq, got.Type, want.Seq, want.Type)
+		}
+		if i == s.Deltas {
+			return
+		}
+		got, want = read(t, resumed), read(t, reference)
+	}
+}
+
+// testResumeEvents checks that /api/events numbers its events with their
+// sequence numbers and resumes from Last-Event-ID.
+func (s Suite) testResumeEvents(t *testing.T) {
+	first := s.events(t, "")
+	var since string
+	for range s.Deltas / 2 {
+		id, env := first.next(t)
+		if id == "" {
+			continue // The banner has no id.
+		}
+		if id != strconv.FormatUint(env.Seq, 10) {
+			t.Fatalf("event id %s for seq %d", id, env.Seq)
+		}
+		since = id
+	}
+	first.Close()
+	if since == "" {
+		t.Fatal("no event had an id")
+	}
+
+	resumed := s.events(t, since)
+	defer resumed.Close()
+	last, _ := strconv.ParseUint(since, 10, 64)
+	for range s.Deltas / 2 {
+		id, env := resumed.next(t)
+		if id == "" {
+			continue
+		}
+		if env.Type == "snapshot" {
+			t.Fatalf("resuming from event %s sent a snapshot", since)
+		}
+		if env.Seq <= last {
+			t.Fatalf("resumed event seq %d after %d", env.Seq, last)
+		}
+		last = env.Seq
+	}
+}
+
+// testLegacyProtocol checks that v1 clients get bare cell updates and typed
+// messages, starting with a snapshot.
+func (s Suite) testLegacyProtocol(t *testing.T) {
+	conn := s.dialVersion(t, 1, nil)
+	var typed struct {
+		Type  string            `json:"type"`
+		Cells []json.RawMessage `json:"cells"`
+	}
+	for typed.Type == "" || typed.Type == "banner" {
+		typed.Type = ""
+		raw := readRaw(t, conn)
+		if err := json.Unmarshal(raw, &typed); err != nil {
+			t.Fatalf("v1 message is not a JSON object: %s", raw)
+		}
+		if typed.Type == "" {
+			t.Fatalf("v1 cell update before the snapshot: %s", raw)
+		}
+	}
+	if typed.Type != "snapshot" || typed.Cells == nil {
+		t.Fatalf("first v1 message is %q, want a snapshot", typed.Type)
+	}
+	for range s.Deltas {
+		var fields map[string]json.RawMessage
+		raw := readRaw(t, conn)
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			t.Fatalf("v1 message is not a JSON object: %s", raw)
+		}
+		if _, ok := fields["seq"]; ok {
+			t.Fatalf("v1 message is enveloped: %s", raw)
+		}
+	}
+}
+
+func (s Suite) endpoint(t *testing.T, path string, extra url.Values) *url.URL {
+	u, err := url.Parse(strings.TrimSuffix(s.URL, "/") + path)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", s.URL, err)
+	}
+	q := url.Values{}
+	for k, v := range s.Query {
+		q[k] = v
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u
+}
+
+func (s Suite) dial(t *testing.T, extra url.Values) *websocket.Conn {
+	return s.dialVersion(t, 2, extra)
+}
+
+// dialVersion opens /ws with the grid.vN subprotocol; the connection is
+// closed when the test ends.
+func (s Suite) dialVersion(t *testing.T, version int, extra url.Values) *websocket.Conn {
+	t.Helper()
+	u := s.endpoint(t, "/ws", extra)
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	dialer := websocket.Dialer{
+		Subprotocols:     []string{fmt.Sprintf("grid.v%d", version)},
+		HandshakeTimeout: s.Timeout,
+	}
+	conn, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", u, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(s.Timeout))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readRaw(t *testing.T, conn *websocket.Conn) []byte {
+	t.Helper()
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return raw
+}
+
+func decode(t *testing.T, raw []byte) Envelope {
+	t.Helper()
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		t.Fatalf("message is not an envelope: %s", raw)
+	}
+	return env
+}
+
+func read(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+	return decode(t, readRaw(t, conn))
+}
+
+// readAfterBanner returns the first message that is not the banner, which
+// connections may open with.
+func readAfterBanner(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+	env := read(t, conn)
+	if env.Type == "banner" {
+		env = read(t, conn)
+	}
+	return env
+}
+
+func readSnapshot(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+	env := readAfterBanner(t, conn)
+	if env.Type != "snapshot" {
+		t.Fatalf("connection opened with %q at seq %d, want a snapshot", env.Type, env.Seq)
+	}
+	return env
+}
+
+// eventStream is an open /api/events response.
+type eventStream struct {
+	*http.Response
+	lines *bufio.Scanner
+}
+
+// events opens /api/events, resuming from lastEventID when set.
+func (s Suite) events(t *testing.T, lastEventID string) *eventStream {
+	t.Helper()
+	req, err := http.NewRequest("GET", s.endpoint(t, "/api/events", nil).String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	client := http.Client{Timeout: s.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("GET /api/events: %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("GET /api/events has Content-Type %q", ct)
+	}
+	stream := &eventStream{Response: resp, lines: bufio.NewScanner(resp.Body)}
+	stream.lines.Buffer(nil, 16<<20)
+	t.Cleanup(func() { stream.Close() })
+	return stream
+}
+
+func (e *eventStream) Close() { e.Body.Close() }
+
+// next returns the id and envelope of the next event, skipping comments.
+func (e *eventStream) next(t *testing.T) (string, Envelope) {
+	t.Helper()
+	var id, data string
+	for e.lines.Scan() {
+		line := e.lines.Text()
+		switch {
+		case line == "" && data != "":
+			return id, decode(t, []byte(data))
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("event stream ended: %v", e.lines.Err())
+	return "", Envelope{}
+}
@@ -0,0 +1,16 @@
+package conformance
+
+import (
+	"os"
+	"testing"
+)
+
+// TestController runs the suite against the controller at
+// GRID_CONFORMANCE_URL, e.g. one port-forwarded from a cluster.
+func TestController(t *testing.T) {
+	base := os.Getenv("GRID_CONFORMANCE_URL")
+	if base == "" {
+		t.Skip("GRID_CONFORMANCE_URL is not set")
+	}
+	Suite{URL: base}.Run(t)
+}
@@ -0,0 +1,77 @@
+package conformance
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Vector is a golden simulation: a seed pattern on an empty grid, a rule, and
+// the grid expected after some generations. Grids do not wrap; cells outside
+// them are dead. Engines, the cells materialized as pods and the distributed
+// cell workers must all reach Hash from the same seed.
+type Vector struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Rule is in B/S notation, e.g. B3/S23 for Life.
+	Rule   string `json:"rule"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Seed is the live cells of generation 0, one string per row with O for
+	// a live cell, placed with its top-left corner at (X, Y).
+	Seed        []string `json:"seed"`
+	X           int      `json:"x"`
+	Y           int      `json:"y"`
+	Generations int64    `json:"generations"`
+	// Population and Hash describe the grid after Generations; Hash is the
+	// StateHash at that generation.
+	Population int    `json:"population"`
+	Hash       string `json:"hash"`
+}
+
+// Cells returns the indices, y*Width+x, of the seed's live cells.
+func (v Vector) Cells() []int {
+	var cells []int
+	for dy, row := range v.Seed {
+		for dx, c := range row {
+			if c == 'O' {
+				cells = append(cells, (v.Y+dy)*v.Width+v.X+dx)
+			}
+		}
+	}
+	return cells
+}
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+// Vectors returns the golden vectors.
+func Vectors() ([]Vector, error) {
+	var vectors []Vector
+	if err := json.Unmarshal(vectorsJSON, &vectors); err != nil {
+		return nil, fmt.Errorf("vectors.json: %w", err)
+	}
+	return vectors, nil
+}
+
+// StateHash is the canonical hash of a grid state, as served by
+// /api/state/hash: SHA-256 over the generation followed by the sorted live
+// cell indices, each as a big-endian int64.
+func StateHash(generation int64, live []int) string {
+	sorted := append([]int(nil), live...)
+	sort.Ints(sorted)
+
+	h := sha256.New()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(generation))
+	h.Write(buf[:])
+	for _, i := range sorted {
+		binary.BigEndian.PutUint64(buf[:], uint64(i))
+		h.Write(buf[:])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Deadline policies.
+const (
+	deadlineCarry    = "carry"
+	deadlineRollback = "rollback"
+	deadlineProceed  = "proceed"
+)
+
+// Outcomes of a generation deadline, reported in digests and metrics.
+const (
+	deadlineMet        = "met"
+	deadlineCarried    = "carried"
+	deadlineRolledBack = "rolled_back"
+	deadlineProceeded  = "proceeded"
+)
+
+// DeadlinePolicy bounds how long the pod operations applying a generation may
+// take, counted from the start of its tick, e.g.
+//
+//	deadline:
+//	  timeout: 2s
+//	  policy: rollback
+//
+// When the pod manager overruns it, carry (the default) stops and leaves the
+// remaining operations to the next tick, rollback stops and reverts the
+// engine to the previous generation, and proceed finishes them anyway.
+type DeadlinePolicy struct {
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	Policy  string          `json:"policy,omitempty"`
+}
+
+func (p *DeadlinePolicy) validate() error {
+	if p.Timeout.Duration < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	switch p.Policy {
+	case "":
+		p.Policy = deadlineCarry
+	case deadlineCarry, deadlineRollback, deadlineProceed:
+	default:
+		return fmt.Errorf("unknown policy %q", p.Policy)
+	}
+	return nil
+}
+
+var generationDeadlines = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_generation_deadline_total",
+	Help: "Generations whose pod operations were bounded by the deadline, by outcome.",
+}, []string{"outcome"})
+
+// generationDeadline is the deadline of the pod manager pass applying a
+// generation.
+type generationDeadline struct {
+	generation int64
+	at         time.Time
+	policy     string
+
+	missed bool
+}
+
+// overdue reports whether the pass should skip its remaining operations.
+func (d *generationDeadline) overdue() bool {
+	if d == nil || time.Now().Before(d.at) {
+		return false
+	}
+	d.missed = true
+	return d.policy != deadlineProceed
+}
+
+func (d *generationDeadline) outcome() string {
+	if !d.missed {
+		return deadlineMet
+	}
+	switch d.policy {
+	case deadlineRollback:
+		return deadlineRolledBack
+	case deadlineProceed:
+		return deadlineProceeded
+	}
+	return deadlineCarried
+}
+
+// engineState is a generation the engine can be rolled back to.
+type engineState struct {
+	generation int64
+	alive      []int
+}
+
+// SetDeadline bounds the next pass, which applies generation gen.
+func (m *cellPodManager) SetDeadline(gen int64, at time.Time, policy string) {
+	m.mu.Lock()
+	m.deadline = &generationDeadline{generation: gen, at: at, policy: policy}
+	m.mu.Unlock()
+}
+
+// deadlineOutcome is called by the pod manager once a bounded pass is over.
+func (s *simulation) deadlineOutcome(gen int64, outcome string) {
+	generationDeadlines.WithLabelValues(outcome).Inc()
+	s.digests.Deadline(gen, outcome)
+	if outcome == deadlineMet {
+		return
+	}
+	log.Printf("Engine: generation %d missed its deadline, %s", gen, outcome)
+	if outcome == deadlineRolledBack {
+		select {
+		case s.rollbacks <- gen:
+		default:
+		}
+	}
+}
+
+// rollBack reverts generation gen to the previous one, unless the engine has
+// moved on since.
+func (s *simulation) rollBack(gen int64) {
+	if s.engine.Generation() != gen || s.previous == nil {
+		log.Printf("Engine: not rolling back generation %d, the engine moved on", gen)
+		return
+	}
+	births, deaths := s.engine.Restore(s.previous.generation, s.previous.alive)
+	s.previous = nil
+	if s.federation != nil {
+		s.federation.Record()
+		s.federation.Publish(births, deaths)
+	}
+	s.cells.Resync()
+	log.Printf("Engine: rolled back to generation %d", s.engine.Generation())
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Causes of births and deaths recorded in generation digests.
+const (
+	causeRule      = "rule"
+	causeViewer    = "viewer"
+	causeReseed    = "reseed"
+	causeSpawn     = "spawn"
+	causeKill      = "kill"
+	causePattern   = "pattern"
+	causeTransform = "transform"
+	causeChaos     = "chaos"
+)
+
+// CellEvent is a cell that was born or died, and why.
+type CellEvent struct {
+	Cell  int    `json:"cell"`
+	Cause string `json:"cause"`
+}
+
+// ChaosEvent is a live cell's pod deleted behind the controller's back.
+type ChaosEvent struct {
+	Pod  string    `json:"pod"`
+	Time time.Time `json:"time"`
+}
+
+// APICalls counts the pod operations the controller issued.
+type APICalls struct {
+	Creates int `json:"creates"`
+	Deletes int `json:"deletes"`
+	Failed  int `json:"failed"`
+}
+
+// GenerationDigest summarizes one generation: how it was computed from the
+// previous one, and everything that happened while it was the current
+// generation, such as the pod operations materializing it and chaos kills.
+type GenerationDigest struct {
+	Generation int64        `json:"generation"`
+	Started    time.Time    `json:"started"`
+	DurationMs float64      `json:"durationMs"`
+	Population int          `json:"population"`
+	Births     []CellEvent  `json:"births"`
+	Deaths     []CellEvent  `json:"deaths"`
+	Chaos      []ChaosEvent `json:"chaos"`
+	APICalls   APICalls     `json:"apiCalls"`
+	// PhasesMs is the time spent in each tick phase.
+	PhasesMs map[string]float64 `json:"phasesMs"`
+	// Deadline is the outcome of the generation deadline, if one is set.
+	Deadline string `json:"deadline,omitempty"`
+	// Annotations are set by hook scripts.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Tick phases. Creates and deletes are the pod operations of the pod manager,
+// which runs after the tick, summed over the generation.
+const (
+	phaseReadState = "read_state"
+	phaseCompute   = "compute"
+	phaseCreates   = "creates"
+	phaseDeletes   = "deletes"
+	phaseBroadcast = "broadcast"
+)
+
+var tickPhaseSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "grid_tick_phase_seconds",
+	Help:    "Time spent in each phase of a tick; creates and deletes per pod manager pass.",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+}, []string{"phase"})
+
+// digestHistoryLimit is how many generations' digests are kept.
+var digestHistoryLimit = 1000
+
+// digestLog keeps the digests of recent generations. Events are attributed to
+// the generation that was current when they happened. Its methods are safe to
+// call on a nil log.
+type digestLog struct {
+	mu      sync.Mutex
+	current *GenerationDigest
+	recent  []*GenerationDigest
+}
+
+// Begin opens the digest of a newly computed generation.
+func (l *digestLog) Begin(d *GenerationDigest) {
+	if l == nil {
+		return
+	}
+	if d.Births == nil {
+		d.Births = []CellEvent{}
+	}
+	if d.Deaths == nil {
+		d.Deaths = []CellEvent{}
+	}
+	d.Chaos = []ChaosEvent{}
+	if d.PhasesMs == nil {
+		d.PhasesMs = map[string]float64{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recent = append(l.recent, d)
+	limit := digestHistoryLimit
+	if memoryPressure.Load() {
+		limit = digestHistoryUnderPressure
+	}
+	if len(l.recent) > limit {
+		l.recent = append([]*GenerationDigest(nil), l.recent[len(l.recent)-limit:]...)
+	}
+	l.current = d
+}
+
+// Shrink keeps only the digests of the latest n generations.
+func (l *digestLog) Shrink(n int) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := max(len(l.recent)-n, 0)
+	if dropped > 0 {
+		l.recent = append([]*GenerationDigest(nil), l.recent[dropped:]...)
+	}
+	return dropped
+}
+
+// Reset forgets every digest, e.g. after a restore rewound the generation
+// numbering.
+func (l *digestLog) Reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.current, l.recent = nil, nil
+	l.mu.Unlock()
+}
+
+func (l *digestLog) Birth(cell int, cause string) {
+	l.update(func(d *GenerationDigest) { d.Births = append(d.Births, CellEvent{cell, cause}) })
+}
+
+func (l *digestLog) Death(cell int, cause string) {
+	l.update(func(d *GenerationDigest) { d.Deaths = append(d.Deaths, CellEvent{cell, cause}) })
+}
+
+func (l *digestLog) Chaos(pod string) {
+	l.update(func(d *GenerationDigest) { d.Chaos = append(d.Chaos, ChaosEvent{pod, time.Now()}) })
+}
+
+func (l *digestLog) Annotate(key, value string) {
+	l.update(func(d *GenerationDigest) {
+		if d.Annotations == nil {
+			d.Annotations = map[string]string{}
+		}
+		d.Annotations[key] = value
+	})
+}
+
+// APICall counts a pod create or delete, failed if err is set.
+func (l *digestLog) APICall(create bool, err error) {
+	l.update(func(d *GenerationDigest) {
+		switch {
+		case err != nil:
+			d.APICalls.Failed++
+		case create:
+			d.APICalls.Creates++
+		default:
+			d.APICalls.Deletes++
+		}
+	})
+}
+
+// Phase records time spent in a tick phase, in the metrics and in the current
+// generation's digest.
+func (l *digestLog) Phase(phase string, d time.Duration) {
+	tickPhaseSeconds.WithLabelValues(phase).Observe(d.Seconds())
+	l.update(func(g *GenerationDigest) { g.PhasesMs[phase] += float64(d) / float64(time.Millisecond) })
+}
+
+// Deadline records the outcome of generation gen's deadline.
+func (l *digestLog) Deadline(gen int64, outcome string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.recent) - 1; i >= 0; i-- {
+		if d := l.recent[i]; d.Generation == gen {
+			d.Deadline = outcome
+			return
+		}
+	}
+}
+
+func (l *digestLog) update(fn func(d *GenerationDigest)) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current != nil {
+		fn(l.current)
+	}
+}
+
+// Get returns a copy of a retained generation's digest.
+func (l *digestLog) Get(gen int64) (GenerationDigest, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.recent) - 1; i >= 0; i-- {
+		if d := l.recent[i]; d.Generation == gen {
+			c := *d
+			c.Births = append([]CellEvent{}, d.Births...)
+			c.Deaths = append([]CellEvent{}, d.Deaths...)
+			c.Chaos = append([]ChaosEvent{}, d.Chaos...)
+			c.PhasesMs = make(map[string]float64, len(d.PhasesMs))
+			for k, v := range d.PhasesMs {
+				c.PhasesMs[k] = v
+			}
+			return c, true
+		}
+	}
+	return GenerationDigest{}, false
+}
+
+func cellEvents(cells []int, cause string) []CellEvent {
+	events := make([]CellEvent, 0, len(cells))
+	for _, c := range cells {
+		events = append(events, CellEvent{c, cause})
+	}
+	return events
+}
+
+// handleGenerations serves GET /api/generations/{n}, the digest of a recent
+// generation; n may also be "latest".
+func handleGenerations(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Generation digests require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	arg := strings.TrimPrefix(r.URL.Path, "/api/generations/")
+	gen, err := strconv.ParseInt(arg, 10, 64)
+	if arg == "latest" {
+		gen, err = s.engine.Generation(), nil
+	}
+	if err != nil {
+		http.Error(w, "Invalid generation", http.StatusBadRequest)
+		return
+	}
+
+	d, ok := s.digests.Get(gen)
+	if !ok {
+		http.Error(w, "Generation not retained", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// discoveryAnnotation carries the discovery document on the controller's
+// Service, for clients that find controllers through the Kubernetes API.
+const discoveryAnnotation = "cellular-automaton/discovery"
+
+// Transport is one way to receive the grid's stream or call the controller.
+type Transport struct {
+	// Name is ws, sse or grpc.
+	Name string `json:"name"`
+	// Path is relative to the controller's HTTP listeners; Address is the
+	// listen address of transports served on their own port.
+	Path    string `json:"path,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Versions are the wire protocol versions the transport speaks, and
+	// Subprotocols the WebSocket subprotocols selecting them.
+	Versions     []int    `json:"versions,omitempty"`
+	Subprotocols []string `json:"subprotocols,omitempty"`
+	Services     []string `json:"services,omitempty"`
+}
+
+// Discovery describes what a controller serves, so clients can configure
+// themselves instead of hard-coding paths.
+type Discovery struct {
+	GridID         string        `json:"gridId,omitempty"`
+	Grid           GridGeometry  `json:"grid"`
+	Engine         string        `json:"engine"`
+	LatestProtocol int           `json:"latestProtocol"`
+	Transports     []Transport   `json:"transports"`
+	Features       []FeatureSpec `json:"features"`
+}
+
+// discover returns the controller's discovery document. Only transports the
+// controller actually serves are listed; gRPC needs --grpc-addr.
+func discover(grid GridGeometry, engine, grpcAddr string) Discovery {
+	d := Discovery{
+		GridID:         gridID,
+		Grid:           grid,
+		Engine:         engine,
+		LatestProtocol: latestProtocol,
+		Transports: []Transport{
+			{Name: "ws", Path: "/ws", Versions: []int{protocolV1, protocolV2}, Subprotocols: upgrader.Subprotocols},
+			{Name: "sse", Path: "/api/events", Versions: []int{protocolV2}},
+		},
+		Features: featureSpecs(),
+	}
+	if grpcAddr != "" {
+		d.Transports = append(d.Transports, Transport{Name: "grpc", Address: grpcAddr, Services: []string{"cell.FederationService"}})
+	}
+	return d
+}
+
+// handleDiscovery serves GET /api/discovery.
+func handleDiscovery(w http.ResponseWriter, r *http.Request, d Discovery) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// annotateService records the discovery document on a Service in the
+// controller's namespace.
+func annotateService(ctx context.Context, clientset kubernetes.Interface, namespace, name string, d Discovery) error {
+	doc, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	patch, _ := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{
+		discoveryAnnotation: string(doc),
+	}}})
+	if _, err := clientset.CoreV1().Services(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("annotate service %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// dormancyCheckInterval is how often an idle grid is considered for scaling
+// to zero.
+const dormancyCheckInterval = 10 * time.Second
+
+var gridDormant = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_dormant",
+	Help: "1 while the grid is scaled to zero for lack of viewers and API activity.",
+})
+
+// idle scales the standalone engine's grid to zero; nil when disabled.
+var idle *dormancy
+
+// dormancy deletes every cell pod once the grid has had no viewers and no API
+// requests for a while, so an idle edge box stops running containers nobody
+// watches. The engine keeps its state in memory and is frozen meanwhile; the
+// first viewer or request wakes it and the cell manager re-creates the pods of
+// the live cells. Optionally a snapshot is taken first, in case the
+// controller restarts while the grid sleeps.
+type dormancy struct {
+	after    time.Duration
+	snapshot bool
+	sim      *simulation
+
+	// last is when the last viewer connected or request arrived, in Unix
+	// nanoseconds.
+	last atomic.Int64
+	// dormant is read for every cell by the cell manager; mu serializes
+	// falling asleep and waking.
+	dormant atomic.Bool
+	mu      sync.Mutex
+	since   time.Time
+}
+
+func newDormancy(after time.Duration, snapshot bool, sim *simulation) *dormancy {
+	d := &dormancy{after: after, snapshot: snapshot, sim: sim}
+	d.last.Store(time.Now().UnixNano())
+	return d
+}
+
+// Desired wraps the cell manager's desired cells so that none is wanted while
+// the grid is dormant.
+func (d *dormancy) Desired(desired func(index int) bool) func(index int) bool {
+	return func(index int) bool {
+		return !d.dormant.Load() && desired(index)
+	}
+}
+
+// Dormant reports whether the grid is scaled to zero.
+func (d *dormancy) Dormant() bool {
+	return d != nil && d.dormant.Load()
+}
+
+// Activity records a viewer or request and wakes a dormant grid.
+func (d *dormancy) Activity() {
+	if d == nil {
+		return
+	}
+	d.last.Store(time.Now().UnixNano())
+	if !d.dormant.Load() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.dormant.Load() {
+		return
+	}
+	d.dormant.Store(false)
+	d.sim.SetDormant(false)
+	d.sim.cells.Resync()
+	gridDormant.Set(0)
+	d.sim.digests.Annotate("dormant", "false")
+	log.Printf("Scale to zero: woke after %s, re-creating the pods of %d live cells", time.Since(d.since).Round(time.Second), d.sim.engine.Population())
+}
+
+// idleFor is how long the grid has had neither viewers nor requests.
+func (d *dormancy) idleFor() time.Duration {
+	if viewerCount() > 0 || d.sim.summaries.Listeners() > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, d.last.Load()))
+}
+
+// Run checks for an idle grid every dormancyCheckInterval until ctx is done.
+func (d *dormancy) Run(ctx context.Context) {
+	log.Printf("Scale to zero: cell pods are deleted after %s without viewers or API requests", d.after)
+	ticker := time.NewTicker(dormancyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !d.dormant.Load() && d.idleFor() >= d.after {
+			d.sleep(ctx)
+		}
+	}
+}
+
+// sleep snapshots the grid if asked to and scales it to zero, unless a viewer
+// or request arrived meanwhile.
+func (d *dormancy) sleep(ctx context.Context) {
+	if d.snapshot && d.sim.snapshots != nil {
+		if _, err := d.sim.snapshots.Take(ctx, d.sim.Export()); err != nil {
+			log.Printf("Scale to zero: snapshot: %v", err)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dormant.Load() || d.idleFor() < d.after {
+		return
+	}
+	d.since = time.Now()
+	d.sim.SetDormant(true)
+	d.dormant.Store(true)
+	d.sim.cells.Resync()
+	gridDormant.Set(1)
+	d.sim.digests.Annotate("dormant", "true")
+	log.Printf("Scale to zero: no viewers or API requests for %s; deleting cell pods until the next one, %d live cells kept at generation %d",
+		d.after, d.sim.engine.Population(), d.sim.engine.Generation())
+}
+
+// Wrap counts the API requests a handler serves as activity. Probes and
+// metrics scrapes are not API requests and let the grid sleep.
+func (d *dormancy) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			d.Activity()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Edited propagates the births and deaths of a manual edit.
+func (s *simulation) Edited(result EditResult, cause string) {
+	if len(result.Births) == 0 && len(result.Deaths) == 0 {
+		return
+	}
+	for _, i := range result.Births {
+		s.digests.Birth(i, cause)
+	}
+	for _, i := range result.Deaths {
+		s.digests.Death(i, cause)
+	}
+	if s.federation != nil {
+		s.federation.Publish(result.Births, result.Deaths)
+	}
+	s.scenarios.Observe(cause)
+	s.cells.Resync()
+}
+
+// EditResult acknowledges a manual edit: its place in the sequence of
+// edits, the generation it applies to, what it changed, and how it merged
+// with earlier edits of the same cells in that generation.
+type EditResult struct {
+	Seq        uint64         `json:"seq"`
+	Generation int64          `json:"generation"`
+	Births     []int          `json:"births"`
+	Deaths     []int          `json:"deaths,omitempty"`
+	Conflicts  []EditConflict `json:"conflicts,omitempty"`
+}
+
+// handleCellEdit serves POST /api/cells/{index}, which brings one cell to
+// life, and DELETE /api/cells/{index}, which kills it. Only the standalone
+// engine decides cell state itself, so editing needs it.
+func handleCellEdit(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Idempotency-Key")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Editing cells requires --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	index, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/cells/"))
+	if err != nil || index < 0 || index >= s.engine.grid.Size() {
+		http.Error(w, "Invalid cell index", http.StatusBadRequest)
+		return
+	}
+
+	alive, cause, verb, scope, action := r.Method == "POST", causeSpawn, "spawned", botScopeSpawn, actionSpawn
+	if !alive {
+		// Kills share the chaos quota with pod deletions
+		cause, verb, scope, action = causeKill, "killed", botScopeKill, actionChaos
+	}
+	if !allowBot(w, r, scope, s.engine.grid, []int{index}) {
+		return
+	}
+
+	if !quotas.Allow(w, r, action) {
+		return
+	}
+
+	result := s.Apply(r, []int{index}, alive)
+	s.Edited(result, cause)
+	if len(result.Births) > 0 || len(result.Deaths) > 0 {
+		log.Printf("Edit: %s %s %s (edit %d)", requestIdentity(r), verb, cellName(index), result.Seq)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handlePatterns serves GET /api/patterns, the built-in patterns, and
+// POST /api/patterns/{name}?x=&y=&op=, which combines one with the grid with
+// its top-left corner at (x, y), or centered without coordinates. op is
+// union (stamp it, the default), intersection (keep only the cells under
+// it), difference (erase its cells) or xor (flip them); the result is one
+// edit, applied wholly in one generation. POST /api/patterns/upload uses the
+// RLE, plaintext or Macrocell pattern in the body instead; its format is
+// taken from ?format=rle|cells|mc or guessed. Uploads need the PatternUpload
+// feature gate. ?flip=h|v and ?rotate=90|180|270 transform the pattern
+// before it is placed, e.g. to aim a glider.
+func handlePatterns(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/patterns"), "/")
+	switch {
+	case r.Method == "GET" && name == "":
+		patterns := make([]Pattern, 0, len(builtinPatterns))
+		for _, n := range builtinPatternNames() {
+			patterns = append(patterns, builtinPatterns[n])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(patterns)
+		return
+	case r.Method == "POST" && name != "":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Applying patterns requires --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	q := r.URL.Query()
+	centered := q.Get("x") == "" && q.Get("y") == ""
+	x, err := parseCoordinate(q.Get("x"))
+	y, errY := parseCoordinate(q.Get("y"))
+	if err == nil {
+		err = errY
+	}
+	if !centered && err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	op := q.Get("op")
+	if op == "" {
+		op = opUnion
+	}
+	if !slices.Contains(patternOps, op) {
+		http.Error(w, "Invalid op: want one of "+strings.Join(patternOps, ", "), http.StatusBadRequest)
+		return
+	}
+	transform, err := patternTransform(r)
+	if err != nil {
+		http.Error(w, "Invalid transform: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, ok := builtinPatterns[name]
+	if name == "upload" {
+		if !requireFeature(w, featurePatternUpload) {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatternBytes))
+		if err != nil {
+			http.Error(w, "Pattern file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if p, err = parsePattern(q.Get("format"), data); err != nil {
+			http.Error(w, "Invalid pattern: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	} else if !ok {
+		http.Error(w, "Unknown pattern", http.StatusNotFound)
+		return
+	}
+
+	p = transform.Pattern(p)
+	cells := s.engine.grid.PatternCellsCentered(p)
+	if !centered {
+		cells = s.engine.grid.PatternCells(p, x, y)
+	}
+	// A bot's region must hold every cell the operation may change.
+	births, deaths := combine(op, s.engine, cells)
+	if !allowBot(w, r, botScopePatterns, s.engine.grid, append(births, deaths...)) {
+		return
+	}
+
+	if !quotas.Allow(w, r, actionPatterns) {
+		return
+	}
+	result := s.Combine(r, op, cells)
+	s.Edited(result, causePattern)
+	log.Printf("Edit: %s applied %s (%s), %d births, %d deaths, %d conflicts (edit %d)", requestIdentity(r), p.Name, op, len(result.Births), len(result.Deaths), len(result.Conflicts), result.Seq)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
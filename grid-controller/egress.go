@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// offline disables every call out of the site (--offline), for air-gapped
+// installations: alert and churn breaker webhooks, webhook rule engines,
+// OIDC and the Twitch chat bridge. Configuring any of them is a startup
+// error, and the egress client and dialer refuse calls anyway. Connections
+// within the cluster, to rule engine sidecars and federation members, and
+// the UDP show outputs are not outbound integrations and keep working.
+var offline bool
+
+var errOffline = errors.New("outbound calls are disabled by --offline")
+
+var egressBlocked = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_egress_blocked_total",
+	Help: "Outbound calls refused by --offline, by integration.",
+}, []string{"integration"})
+
+// egressTransport is what outbound HTTP calls go through: the proxy of the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables, as for the
+// Kubernetes API.
+var egressTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	return t
+}()
+
+// egressRoundTripper refuses an integration's requests while offline.
+type egressRoundTripper struct {
+	integration string
+}
+
+func (t egressRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if offline {
+		egressBlocked.WithLabelValues(t.integration).Inc()
+		return nil, errOffline
+	}
+	return egressTransport.RoundTrip(req)
+}
+
+// egressClient returns the HTTP client of an outbound integration.
+func egressClient(integration string, timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: egressRoundTripper{integration}}
+}
+
+// egressDialTLS opens a TLS connection for an outbound integration that does
+// not speak HTTP, tunnelling it through the HTTPS proxy of the environment
+// with CONNECT when there is one.
+func egressDialTLS(ctx context.Context, integration, addr string) (net.Conn, error) {
+	if offline {
+		egressBlocked.WithLabelValues(integration).Inc()
+		return nil, errOffline
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		dialer := &tls.Dialer{}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	conn, err := dialProxy(ctx, proxy, addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dialProxy opens a CONNECT tunnel to addr through an HTTP or HTTPS proxy.
+func dialProxy(ctx context.Context, proxy *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		port := "80"
+		if proxy.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	var conn net.Conn
+	var err error
+	switch proxy.Scheme {
+	case "http":
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", proxyAddr)
+	case "https":
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: proxy.Hostname()}}
+		conn, err = dialer.DialContext(ctx, "tcp", proxyAddr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{Method: "CONNECT", URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if u := proxy.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The proxy sends nothing past its response before the client speaks, so
+	// the reader buffers no bytes of the tunnel.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	return conn, nil
+}
+
+// checkOffline lists the configured integrations --offline rules out.
+func checkOffline(cfg *Config) error {
+	var calls []string
+	for _, r := range cfg.Alerts {
+		if r.Webhook != "" {
+			calls = append(calls, "the webhook of alert "+r.Name)
+		}
+	}
+	if cfg.ChurnBreaker != nil && cfg.ChurnBreaker.Webhook != "" {
+		calls = append(calls, "the churn breaker webhook")
+	}
+	if cfg.OIDC != nil {
+		calls = append(calls, "OIDC")
+	}
+	if cfg.Chat != nil && cfg.Chat.Twitch != nil {
+		calls = append(calls, "the Twitch chat bridge")
+	}
+	if len(calls) > 0 {
+		return fmt.Errorf("%s need outbound calls", strings.Join(calls, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// handleEvents serves GET /api/events, the hub's stream as server-sent
+// events for clients that cannot hold a WebSocket, such as embeds behind
+// proxies. Every event is a v2 Envelope whose id is its sequence number;
+// grid, code and share subscribe to a grid as on /ws.
+func handleEvents(w http.ResponseWriter, r *http.Request, grids *gridBootstrapper) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	grid, ok := streamGrid(w, r, grids)
+	if !ok || !admitClient(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	since, resume := resumeFrom(r)
+	c := newClient(nil, protocolV2, grid)
+	register(c, since, resume)
+
+	// Comments keep proxies from timing the stream out.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		var err error
+		var sent *outbound
+		select {
+		case <-r.Context().Done():
+			c.evict(closeGone)
+			return
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			// The banner has no place in the sequence; without an id the
+			// browser keeps the last one to resume from.
+			if msg.seq > 0 {
+				_, err = fmt.Fprintf(w, "id: %d\n", msg.seq)
+			}
+			if err == nil {
+				_, err = fmt.Fprintf(w, "data: %s\n\n", msg.data)
+			}
+			sent = &msg
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err != nil {
+			c.evict(closeWriteError)
+			return
+		}
+		flusher.Flush()
+		if sent != nil {
+			c.delivered(transportSSE, *sent)
+		}
+	}
+}
+
+// Embed sizes, in pixels.
+const (
+	defaultEmbedSize = 320
+	maxEmbedSize     = 1024
+)
+
+// embedGrid is what the widget draws on load.
+type embedGrid struct {
+	Grid   string
+	Width  int
+	Height int
+	Alive  []int
+	Size   int
+	Events string
+}
+
+// embedSnapshot returns the geometry and live cells of a grid; an empty grid
+// is the controller's own.
+func embedSnapshot(ctx context.Context, grid string, own GridGeometry, sim *simulation, pods corelisters.PodLister, namespace string, grids *gridBootstrapper) (GridGeometry, []int, error) {
+	if grid == "" && sim != nil {
+		return own, sim.engine.LiveCells(), nil
+	}
+	alive := labels.SelectorFromSet(labels.Set{"app": "cell", "game-status": "alive"})
+	var names []string
+	geometry := own
+	if grid == "" {
+		list, err := pods.Pods(namespace).List(alive)
+		if err != nil {
+			return own, nil, err
+		}
+		for _, pod := range list {
+			names = append(names, pod.Name)
+		}
+	} else {
+		info, err := grids.Get(ctx, grid, "")
+		if err != nil {
+			return own, nil, err
+		}
+		geometry = GridGeometry{Width: info.Width, Height: info.Height}
+		list, err := grids.clientset.CoreV1().Pods(info.Namespace).List(ctx, metav1.ListOptions{LabelSelector: alive.String()})
+		if err != nil {
+			return own, nil, err
+		}
+		for _, pod := range list.Items {
+			names = append(names, pod.Name)
+		}
+	}
+	cells := []int{}
+	for _, name := range names {
+		if i, ok := cellIndex(name); ok && i < geometry.Size() {
+			cells = append(cells, i)
+		}
+	}
+	return geometry, cells, nil
+}
+
+// handleEmbed serves GET /api/embed?grid=&share=&size=, a self-contained
+// page drawing a grid live from /api/events, for iframes in wikis and status
+// pages. size is the widget's width in pixels. Grids created through the
+// API need a share link token; the controller's own grid is public.
+func handleEmbed(w http.ResponseWriter, r *http.Request, own GridGeometry, sim *simulation, pods corelisters.PodLister, namespace string, grids *gridBootstrapper) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	size := defaultEmbedSize
+	if v := query.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 32 || n > maxEmbedSize {
+			http.Error(w, fmt.Sprintf("size must be between 32 and %d", maxEmbedSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	grid := query.Get("grid")
+	events := url.Values{}
+	if grid != "" {
+		share := query.Get("share")
+		if g, err := verifyShareToken(share); err != nil || g != grid {
+			http.Error(w, "Embedding a grid needs a valid share link token", http.StatusNotFound)
+			return
+		}
+		events.Set("grid", grid)
+		events.Set("share", share)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	geometry, alive, err := embedSnapshot(ctx, grid, own, sim, pods, namespace, grids)
+	if err != nil {
+		http.Error(w, "Grid not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = embedPage.Execute(w, embedGrid{
+		Grid:   grid,
+		Width:  geometry.Width,
+		Height: geometry.Height,
+		Alive:  alive,
+		Size:   size,
+		Events: "events?" + events.Encode(),
+	})
+	if err != nil {
+		log.Printf("Embed: %v", err)
+	}
+}
+
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Cellular automaton{{if .Grid}} {{.Grid}}{{end}}</title>
+<style>html,body{margin:0;background:#111827}canvas{display:block}</style></head>
+<body><canvas id="grid"></canvas>
+<script>
+(function () {
+  var width = {{.Width}}, height = {{.Height}}, size = {{.Size}};
+  var cell = Math.max(1, Math.floor(size / width));
+  var canvas = document.getElementById('grid');
+  canvas.width = cell * width;
+  canvas.height = cell * height;
+  var ctx = canvas.getContext('2d');
+  var alive = new Set({{.Alive}});
+  var pending = false;
+  function draw() {
+    pending = false;
+    ctx.fillStyle = '#1f2937';
+    ctx.fillRect(0, 0, canvas.width, canvas.height);
+    ctx.fillStyle = '#22c55e';
+    alive.forEach(function (i) {
+      ctx.fillRect((i % width) * cell + 1, Math.floor(i / width) * cell + 1, cell - 2 || 1, cell - 2 || 1);
+    });
+  }
+  function redraw() {
+    if (!pending) { pending = true; requestAnimationFrame(draw); }
+  }
+  draw();
+  var events = new EventSource({{.Events}});
+  events.onmessage = function (e) {
+    var msg = JSON.parse(e.data);
+    if (msg.type !== 'cell') return;
+    var i = parseInt(msg.data.name.replace('cell-', ''), 10);
+    if (isNaN(i)) return;
+    if (msg.data.status === 'alive') alive.add(i); else alive.delete(i);
+    redraw();
+  };
+})();
+</script></body></html>
+`))
+
+// handleEmbedScript serves GET /api/embed.js, which replaces its own script
+// tag with an iframe of /api/embed:
+//
+//	<script src="https://cells.example.com/api/embed.js" data-grid="demo"
+//	        data-share="..." data-size="320"></script>
+func handleEmbedScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	fmt.Fprint(w, embedScript)
+}
+
+const embedScript = `(function () {
+  var script = document.currentScript;
+  if (!script) return;
+  var params = new URLSearchParams();
+  ['grid', 'share', 'size'].forEach(function (key) {
+    var value = script.getAttribute('data-' + key);
+    if (value) params.set(key, value);
+  });
+  var size = parseInt(script.getAttribute('data-size') || '320', 10);
+  var frame = document.createElement('iframe');
+  frame.src = new URL('embed?' + params.toString(), script.src).toString();
+  frame.width = size;
+  frame.height = size;
+  frame.style.border = '0';
+  frame.setAttribute('title', 'Cellular automaton');
+  frame.setAttribute('loading', 'lazy');
+  script.parentNode.replaceChild(frame, script);
+})();
+`
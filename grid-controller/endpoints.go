@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sync"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Sources of the cell states streamed to viewers (--render-source).
+const (
+	// renderLabels: the game-status label of each cell pod.
+	renderLabels = "labels"
+	// renderEndpoints: the readiness of each cell pod's endpoints.
+	renderEndpoints = "endpoints"
+)
+
+// endpointRenderer derives cell states from the EndpointSlices of the
+// Services selecting cell pods, e.g. one Service per cell or the headless
+// cell Service, instead of from labels. A cell is alive while one of its
+// endpoints is ready, terminating while it still serves on the way out, and
+// dead while it cannot be reached, which is how real systems are judged
+// healthy.
+type endpointRenderer struct {
+	selector labels.Selector
+	pods     corelisters.PodLister
+
+	mu sync.Mutex
+	// slices holds the state of each cell pod per EndpointSlice.
+	slices map[string]map[string]string
+}
+
+func newEndpointRenderer(selector labels.Selector, pods corelisters.PodLister) *endpointRenderer {
+	return &endpointRenderer{selector: selector, pods: pods, slices: map[string]map[string]string{}}
+}
+
+// endpointStatus is the cell status an endpoint stands for.
+func endpointStatus(c discoveryv1.EndpointConditions) string {
+	switch {
+	case c.Ready == nil || *c.Ready:
+		// A nil ready condition means ready.
+		return "alive"
+	case c.Terminating != nil && *c.Terminating && c.Serving != nil && *c.Serving:
+		return "terminating"
+	}
+	return "dead"
+}
+
+// statusRank orders statuses by reachability, so a pod behind several
+// Services shows the best of its endpoints.
+var statusRank = map[string]int{"dead": 0, "terminating": 1, "alive": 2}
+
+// Update records an EndpointSlice's endpoints and publishes the cells it
+// covers, including those it no longer does.
+func (r *endpointRenderer) Update(obj interface{}) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok || !r.selector.Matches(labels.Set(slice.Labels)) {
+		return
+	}
+	cells := map[string]string{}
+	for _, ep := range slice.Endpoints {
+		if ep.TargetRef == nil || ep.TargetRef.Kind != "Pod" {
+			continue
+		}
+		if _, ok := cellIndex(ep.TargetRef.Name); !ok {
+			continue
+		}
+		status := endpointStatus(ep.Conditions)
+		if prev, ok := cells[ep.TargetRef.Name]; !ok || statusRank[status] > statusRank[prev] {
+			cells[ep.TargetRef.Name] = status
+		}
+	}
+	r.replace(slice.Namespace+"/"+slice.Name, slice.Namespace, cells)
+}
+
+// Delete forgets an EndpointSlice; its cells become unreachable unless
+// another one still covers them.
+func (r *endpointRenderer) Delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok || !r.selector.Matches(labels.Set(slice.Labels)) {
+		return
+	}
+	r.replace(slice.Namespace+"/"+slice.Name, slice.Namespace, nil)
+}
+
+func (r *endpointRenderer) replace(key, namespace string, cells map[string]string) {
+	r.mu.Lock()
+	touched := map[string]bool{}
+	for name := range r.slices[key] {
+		touched[name] = true
+	}
+	for name := range cells {
+		touched[name] = true
+	}
+	if cells == nil {
+		delete(r.slices, key)
+	} else {
+		r.slices[key] = cells
+	}
+	statuses := make(map[string]string, len(touched))
+	for name := range touched {
+		statuses[name] = r.statusLocked(name)
+	}
+	r.mu.Unlock()
+
+	for name, status := range statuses {
+		r.publish(name, status, namespace)
+	}
+}
+
+// statusLocked merges a pod's state over every slice. r.mu must be held.
+func (r *endpointRenderer) statusLocked(name string) string {
+	best := "dead"
+	for _, cells := range r.slices {
+		if status, ok := cells[name]; ok && statusRank[status] > statusRank[best] {
+			best = status
+		}
+	}
+	return best
+}
+
+func (r *endpointRenderer) publish(name, status, namespace string) {
+	update := CellUpdate{
+		Name:      federatedName(name),
+		Status:    status,
+		Namespace: namespace,
+		Grid:      gridID,
+	}
+	if pod, err := r.pods.Pods(namespace).Get(name); err == nil {
+		if status != "dead" {
+			update.Condition = podCondition(pod, zombieRestarts)
+		}
+		if g, err := parseGenome(pod.Annotations[genomeAnnotation]); err == nil && status != "dead" {
+			update.Genome, update.Color = g.String(), g.Color()
+		}
+	} else if status == "dead" {
+		update.Status = "deleted"
+	}
+	publish(msgCell, update)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// EnergyConfig couples the automaton to the cluster's real resource use, e.g.
+//
+//	energy:
+//	  capacity: 100
+//	  perMilliCPU: 0.1
+//	  share: 0.25
+//	  interval: 30s
+//
+// Every live cell is born with capacity energy and spends perMilliCPU for
+// each millicore its pod uses, as reported by metrics-server and polled every
+// interval. A cell whose energy runs out draws on its live neighbors, each of
+// which gives up to the share fraction of its own energy, richest first; a
+// cell they cannot carry starves.
+type EnergyConfig struct {
+	Capacity    float64         `json:"capacity,omitempty"`
+	PerMilliCPU float64         `json:"perMilliCPU,omitempty"`
+	Share       float64         `json:"share,omitempty"`
+	Interval    metav1.Duration `json:"interval,omitempty"`
+}
+
+func (c *EnergyConfig) validate() error {
+	if c.Capacity < 0 || c.PerMilliCPU < 0 || c.Interval.Duration < 0 {
+		return errors.New("capacity, perMilliCPU and interval must not be negative")
+	}
+	if c.Share < 0 || c.Share > 1 {
+		return errors.New("share must be between 0 and 1")
+	}
+	if c.Capacity == 0 {
+		c.Capacity = 100
+	}
+	if c.PerMilliCPU == 0 {
+		c.PerMilliCPU = 0.1
+	}
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = 30 * time.Second
+	}
+	return nil
+}
+
+const causeStarved = "starved"
+
+var (
+	energyStarved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "grid_energy_starved_total",
+		Help: "Cells that died because they ran out of energy.",
+	})
+	energyShared = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "grid_energy_shared_total",
+		Help: "Energy given by cells to depleted neighbors.",
+	})
+	energyTotal = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "grid_energy_total",
+		Help: "Energy held by all live cells.",
+	})
+)
+
+// energyLedger keeps the energy of every live cell. Spend runs on the
+// engine's goroutine; the CPU usage is refreshed concurrently. Its methods
+// are safe to call on a nil ledger.
+type energyLedger struct {
+	cfg  EnergyConfig
+	grid GridGeometry
+
+	mu     sync.Mutex
+	energy map[int]float64
+	// usage is each cell pod's CPU usage in millicores.
+	usage map[int]int64
+}
+
+func newEnergyLedger(cfg *EnergyConfig, grid GridGeometry) *energyLedger {
+	if cfg == nil {
+		return nil
+	}
+	return &energyLedger{cfg: *cfg, grid: grid, energy: map[int]float64{}, usage: map[int]int64{}}
+}
+
+// Spend charges every live cell for its pod's CPU usage, lets depleted cells
+// draw on their neighbors, and returns the cells that starved. The caller
+// kills them.
+func (l *energyLedger) Spend(alive []int) (starved []int) {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	live := make(map[int]bool, len(alive))
+	for _, i := range alive {
+		live[i] = true
+		if _, ok := l.energy[i]; !ok {
+			// Newborn, or brought to life outside the rule.
+			l.energy[i] = l.cfg.Capacity
+		}
+	}
+	for i := range l.energy {
+		if !live[i] {
+			delete(l.energy, i)
+		}
+	}
+
+	cost := func(i int) float64 { return l.cfg.PerMilliCPU * float64(l.usage[i]) }
+	for _, i := range alive {
+		l.energy[i] -= cost(i)
+	}
+
+	for _, i := range alive {
+		if l.energy[i] > 0 {
+			continue
+		}
+		// Enough to cover the deficit and one more generation.
+		need := cost(i) - l.energy[i]
+		for _, n := range l.donors(i, live) {
+			give := min(need, l.cfg.Share*l.energy[n])
+			l.energy[n] -= give
+			l.energy[i] += give
+			need -= give
+			energyShared.Add(give)
+			if need <= 0 {
+				break
+			}
+		}
+		if l.energy[i] <= 0 {
+			starved = append(starved, i)
+		}
+	}
+
+	total := 0.0
+	for _, i := range starved {
+		delete(l.energy, i)
+	}
+	for _, e := range l.energy {
+		total += e
+	}
+	energyTotal.Set(total)
+	energyStarved.Add(float64(len(starved)))
+	return starved
+}
+
+// donors returns the live neighbors of a cell that have energy to give,
+// richest first.
+func (l *energyLedger) donors(i int, live map[int]bool) []int {
+	var donors []int
+	for _, n := range l.grid.Neighbors(i) {
+		if live[n] && l.energy[n] > 0 {
+			donors = append(donors, n)
+		}
+	}
+	sort.Slice(donors, func(a, b int) bool { return l.energy[donors[a]] > l.energy[donors[b]] })
+	return donors
+}
+
+// RunMetrics polls metrics-server for the CPU usage of the cell pods.
+func (l *energyLedger) RunMetrics(ctx context.Context, client metricsclient.Interface, namespace string) {
+	ticker := time.NewTicker(l.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		l.refresh(ctx, client, namespace)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *energyLedger) refresh(ctx context.Context, client metricsclient.Interface, namespace string) {
+	cells, err := listCellMetrics(ctx, client, namespace)
+	if err != nil {
+		log.Printf("Energy: list pod metrics: %v", err)
+		return
+	}
+	usage := make(map[int]int64, len(cells))
+	for _, m := range cells {
+		usage[m.Cell] = m.CPUMillis
+	}
+	l.mu.Lock()
+	l.usage = usage
+	l.mu.Unlock()
+}
+
+// CellEnergy is one live cell's energy and CPU usage.
+type CellEnergy struct {
+	Cell      int     `json:"cell"`
+	Energy    float64 `json:"energy"`
+	CPUMillis int64   `json:"cpuMillis"`
+}
+
+// EnergyReport is served by /api/energy.
+type EnergyReport struct {
+	Capacity float64      `json:"capacity"`
+	Total    float64      `json:"total"`
+	Cells    []CellEnergy `json:"cells"`
+}
+
+// Fraction returns a live cell's energy as a fraction of its capacity; ok
+// is false without the economy or for cells it has not seen yet.
+func (l *energyLedger) Fraction(i int) (f float64, ok bool) {
+	if l == nil {
+		return 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.energy[i]
+	return max(0, min(1, e/l.cfg.Capacity)), ok
+}
+
+func (l *energyLedger) Report() EnergyReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := EnergyReport{Capacity: l.cfg.Capacity, Cells: make([]CellEnergy, 0, len(l.energy))}
+	for i, e := range l.energy {
+		r.Total += e
+		r.Cells = append(r.Cells, CellEnergy{Cell: i, Energy: e, CPUMillis: l.usage[i]})
+	}
+	sort.Slice(r.Cells, func(a, b int) bool { return r.Cells[a].Cell < r.Cells[b].Cell })
+	return r
+}
+
+// handleEnergy serves GET /api/energy, the energy of every live cell.
+func handleEnergy(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil || s.energy == nil {
+		http.Error(w, "Energy requires --engine=standalone and an energy configuration", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.energy.Report())
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	engineCells      = "cells"
+	engineStandalone = "standalone"
+)
+
+// Engine is the controller-side automaton used by --engine=standalone. The
+// controller computes every generation itself and materializes live cells as
+// pods, instead of letting each worker poll its neighbors.
+//
+// The grid is double-buffered: Step fills the next generation into a spare
+// buffer and swaps it in whole. Readers go through View, which hands out an
+// immutable snapshot of the current generation without taking the lock.
+type Engine struct {
+	mu         sync.RWMutex
+	grid       GridGeometry
+	rule       RuleEngine
+	generation int64
+	live       *cellBits
+	// spare is the buffer Step fills with the next generation.
+	spare *cellBits
+	// stepping serializes Step, which reuses hoods and sterile across
+	// generations.
+	stepping sync.Mutex
+	hoods    []Neighborhood
+	sterile  *cellBits
+	// snapshot is the published state of the current generation, nil once
+	// the state has changed until View builds the next one.
+	snapshot atomic.Pointer[gridSnapshot]
+	// genetics, when set, tracks the genome of every live cell.
+	genetics *genetics
+	// cap, when set, bounds the population the rule may grow.
+	cap *populationCap
+	// conditions, when set, lets the lifecycle of cell pods bend the rule.
+	conditions *cellConditions
+}
+
+func NewEngine(grid GridGeometry, rule RuleEngine) *Engine {
+	return &Engine{grid: grid, rule: rule, live: newCellBits(grid), spare: newCellBits(grid)}
+}
+
+// gridSnapshot is an immutable copy of one generation of the grid, shared
+// by every reader of that generation: the API, render and export paths read
+// whole grids from it without blocking the tick loop, and never see a
+// generation half applied.
+type gridSnapshot struct {
+	grid       GridGeometry
+	generation int64
+	alive      *cellBits
+	// cells are the sorted indices of the live cells.
+	cells []int
+}
+
+// Row returns the live state of one row of the snapshot.
+func (s *gridSnapshot) Row(y int) []bool {
+	words := s.alive.Row(y)
+	row := make([]bool, s.grid.Width)
+	for x := range row {
+		row[x] = rowBit(words, x)
+	}
+	return row
+}
+
+// View returns the snapshot of the current generation. Once published it is
+// read without locking; after a change it is built once, by the first reader.
+func (e *Engine) View() *gridSnapshot {
+	if s := e.snapshot.Load(); s != nil {
+		return s
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s := e.snapshotLocked()
+	// Writers hold the write lock, so the state cannot change before s is
+	// published; a concurrent reader may have published an identical one.
+	e.snapshot.CompareAndSwap(nil, s)
+	return s
+}
+
+// snapshotLocked copies the current generation. e.mu must be held.
+func (e *Engine) snapshotLocked() *gridSnapshot {
+	alive := newCellBits(e.grid)
+	alive.CopyFrom(e.live)
+	return &gridSnapshot{grid: e.grid, generation: e.generation, alive: alive, cells: alive.Indices()}
+}
+
+// changed retracts the published snapshot after a change. e.mu must be held
+// for writing.
+func (e *Engine) changed() {
+	e.snapshot.Store(nil)
+}
+
+// Rule names the rule the engine runs.
+func (e *Engine) Rule() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rule.Rule()
+}
+
+// RuleEngine returns the rule engine itself.
+func (e *Engine) RuleEngine() RuleEngine {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rule
+}
+
+// ReplaceRule swaps in rule only if old is still the engine's rule.
+func (e *Engine) ReplaceRule(old, rule RuleEngine) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rule != old {
+		return false
+	}
+	e.rule = rule
+	return true
+}
+
+// SetRule replaces the rule from the next generation on and returns the
+// previous one.
+func (e *Engine) SetRule(rule RuleEngine) RuleEngine {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old := e.rule
+	e.rule = rule
+	return old
+}
+
+// Seed matches the workers' initial state: even indices start alive.
+func (e *Engine) Seed() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := 0; i < e.grid.Size(); i += 2 {
+		e.live.Set(i)
+		e.genetics.found(i)
+	}
+	e.changed()
+}
+
+// Generation, Alive and Population use the published snapshot if there is
+// one, and otherwise read the state under the lock: building a snapshot
+// copies the whole grid, too much for one cell between two Sets.
+func (e *Engine) Generation() int64 {
+	if s := e.snapshot.Load(); s != nil {
+		return s.generation
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.generation
+}
+
+func (e *Engine) Alive(index int) bool {
+	if s := e.snapshot.Load(); s != nil {
+		return s.alive.Has(index)
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.live.Has(index)
+}
+
+// Set forces a cell alive or dead outside of the rule, e.g. after a chaos kill.
+func (e *Engine) Set(index int, alive bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if alive {
+		if !e.live.Has(index) {
+			e.genetics.found(index)
+		}
+		e.live.Set(index)
+	} else {
+		e.live.Clear(index)
+		e.genetics.forget(index)
+		e.conditions.set(index, "")
+	}
+	e.changed()
+}
+
+// Genome returns a live cell's genome; ok is false without genetics.
+func (e *Engine) Genome(index int) (genome Genome, ok bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.genetics == nil {
+		return 0, false
+	}
+	genome, ok = e.genetics.genomes[index]
+	return genome, ok
+}
+
+// Genomes returns a copy of the live cells' genomes, nil without genetics.
+func (e *Engine) Genomes() map[int]Genome {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.genetics == nil {
+		return nil
+	}
+	genomes := make(map[int]Genome, len(e.genetics.genomes))
+	for i, g := range e.genetics.genomes {
+		genomes[i] = g
+	}
+	return genomes
+}
+
+// SetGenomes restores the genomes of live cells, e.g. from an archive.
+func (e *Engine) SetGenomes(genomes map[int]Genome) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.genetics == nil {
+		return
+	}
+	for i, g := range genomes {
+		if e.live.Has(i) {
+			e.genetics.genomes[i] = g
+		}
+	}
+}
+
+func (e *Engine) Population() int {
+	if s := e.snapshot.Load(); s != nil {
+		return len(s.cells)
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.live.Count()
+}
+
+// LiveCells returns the sorted indices of all live cells.
+func (e *Engine) LiveCells() []int {
+	_, cells := e.Snapshot()
+	return cells
+}
+
+// Snapshot returns the generation and its sorted live cells consistently.
+// The cells are shared with other readers and must not be modified.
+func (e *Engine) Snapshot() (int64, []int) {
+	s := e.View()
+	return s.generation, s.cells
+}
+
+// Restore replaces the state with the given generation and live cells, and
+// returns the resulting births and deaths.
+func (e *Engine) Restore(generation int64, alive []int) (births, deaths []int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	next := e.spare
+	next.Reset()
+	for _, i := range alive {
+		if next.Has(i) {
+			continue
+		}
+		next.Set(i)
+		if next.Has(i) && !e.live.Has(i) {
+			births = append(births, i)
+		}
+	}
+	e.live.ForEach(func(i int) {
+		if !next.Has(i) {
+			deaths = append(deaths, i)
+			e.genetics.forget(i)
+			e.conditions.set(i, "")
+		}
+	})
+	e.genetics.found(births...)
+	e.live, e.spare = next, e.live
+	e.generation = generation
+	e.changed()
+	return births, deaths
+}
+
+// Apply moves to generation with births and deaths computed elsewhere, e.g.
+// by the primary a mirror follows.
+func (e *Engine) Apply(generation int64, births, deaths []int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var born []int
+	for _, i := range births {
+		if !e.live.Has(i) {
+			e.live.Set(i)
+			born = append(born, i)
+		}
+	}
+	for _, i := range deaths {
+		e.live.Clear(i)
+		e.genetics.forget(i)
+		e.conditions.set(i, "")
+	}
+	e.genetics.found(born...)
+	e.generation = generation
+	e.changed()
+}
+
+// Row returns the live state of one row of the grid.
+func (e *Engine) Row(y int) []bool {
+	return e.View().Row(y)
+}
+
+// errStaleStep reports that the state or the rule was replaced, e.g. by an
+// archive import, while the rule computed the next generation.
+var errStaleStep = errors.New("state replaced during step")
+
+// Step advances one generation of the engine's rule without wrapping. above
+// and below are ghost rows just outside the grid, used when the grid is one
+// band of a federated automaton; nil means dead.
+//
+// The rule runs without holding the lock, since a rule server may take a
+// while; cells forced with Set in the meantime keep their forced state.
+func (e *Engine) Step(ctx context.Context, above, below []bool) (births, deaths []int, err error) {
+	e.stepping.Lock()
+	defer e.stepping.Unlock()
+	e.mu.RLock()
+	generation, rule := e.generation, e.rule
+	// Neighborhoods are read straight from the bitset rows; the ghost rows
+	// are packed the same way.
+	ghosts := [2][]uint64{packRow(above, e.grid.Width), packRow(below, e.grid.Width)}
+	row := func(cells *cellBits, y int) []uint64 {
+		switch {
+		case cells == nil:
+			return nil
+		case y == -1 && cells == e.live:
+			return ghosts[0]
+		case y == e.grid.Height && cells == e.live:
+			return ghosts[1]
+		}
+		return cells.Row(y)
+	}
+	// Sterile cells are no neighbors of dead cells.
+	var sterile *cellBits
+	if e.conditions != nil {
+		if e.sterile == nil {
+			e.sterile = newCellBits(e.grid)
+		}
+		sterile = e.sterile
+		sterile.Reset()
+		for i := range e.conditions.of {
+			if e.conditions.effect(i).Sterile {
+				sterile.Set(i)
+			}
+		}
+	}
+	if e.hoods == nil {
+		e.hoods = make([]Neighborhood, e.grid.Size())
+	}
+	hoods := e.hoods
+	partition(e.grid.Height, e.grid.Width, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			live := [3][]uint64{row(e.live, y-1), row(e.live, y), row(e.live, y+1)}
+			barren := [3][]uint64{row(sterile, y-1), row(sterile, y), row(sterile, y+1)}
+			for x := 0; x < e.grid.Width; x++ {
+				dead := !rowBit(live[1], x)
+				var n Neighborhood
+				for bit, dy := 0, 0; dy < 3; dy++ {
+					for dx := -1; dx <= 1; dx, bit = dx+1, bit+1 {
+						if rowBit(live[dy], x+dx) && !(dead && rowBit(barren[dy], x+dx)) {
+							n |= 1 << bit
+						}
+					}
+				}
+				hoods[e.grid.Index(x, y)] = n
+			}
+		}
+	})
+	e.mu.RUnlock()
+
+	next, err := rule.Next(ctx, generation, hoods)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(next) != len(hoods) {
+		return nil, nil, fmt.Errorf("rule %s returned %d states for %d cells", rule.Rule(), len(next), len(hoods))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.generation != generation || e.rule != rule {
+		return nil, nil, errStaleStep
+	}
+	live := e.spare
+	live.Reset()
+	var genomes map[int]Genome
+	if e.genetics != nil {
+		genomes = make(map[int]Genome, len(e.genetics.genomes))
+	}
+	for i, n := range hoods {
+		was := e.live.Has(i)
+		if was != n.Alive() {
+			// Forced by Set while the rule ran.
+			if was {
+				live.Set(i)
+				if genomes != nil {
+					genomes[i] = e.genetics.genomes[i]
+				}
+			}
+			continue
+		}
+		alive := next[i]
+		var parents []int
+		if e.genetics != nil {
+			parents = e.parents(i)
+			alive = e.genetics.bend(i, n, alive, parents)
+		}
+		if was {
+			switch effect := e.conditions.effect(i); {
+			case effect.Doomed:
+				alive = false
+			case effect.Immortal:
+				alive = true
+			}
+		}
+		if alive {
+			live.Set(i)
+			if !was {
+				births = append(births, i)
+			}
+			switch {
+			case genomes == nil:
+			case was:
+				genomes[i] = e.genetics.genomes[i]
+			default:
+				genomes[i] = e.genetics.inherit(parents)
+			}
+		} else if was {
+			deaths = append(deaths, i)
+		}
+	}
+	if e.cap != nil {
+		births, deaths = e.cap.apply(e.grid, generation+1, live, births, deaths)
+		for i := range genomes {
+			if !live.Has(i) {
+				delete(genomes, i)
+			}
+		}
+	}
+	e.live, e.spare = live, e.live
+	if e.genetics != nil {
+		e.genetics.genomes = genomes
+	}
+	for _, i := range deaths {
+		e.conditions.set(i, "")
+	}
+	e.generation++
+	e.snapshot.Store(e.snapshotLocked())
+	return births, deaths, nil
+}
+
+// LiveNeighbors returns the live neighbors of a cell within the grid.
+func (e *Engine) LiveNeighbors(i int) []int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.parents(i)
+}
+
+// parents returns the live neighbors of a cell within the grid.
+func (e *Engine) parents(i int) []int {
+	var parents []int
+	for _, n := range e.grid.Neighbors(i) {
+		if e.live.Has(n) {
+			parents = append(parents, n)
+		}
+	}
+	return parents
+}
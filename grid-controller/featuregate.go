@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Feature stages. Alpha features are off unless enabled and may change or go
+// away; beta features are on by default.
+const (
+	stageAlpha = "alpha"
+	stageBeta  = "beta"
+)
+
+// Features that can be toggled with --feature-gates.
+const (
+	featureFederation    = "Federation"
+	featureAggregation   = "Aggregation"
+	featureGridAPI       = "GridAPI"
+	featurePatternUpload = "PatternUpload"
+	featureWasmRules     = "WasmRules"
+)
+
+// FeatureSpec describes a feature gate.
+type FeatureSpec struct {
+	Name        string `json:"name"`
+	Stage       string `json:"stage"`
+	Default     bool   `json:"default"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+}
+
+var knownFeatures = map[string]FeatureSpec{
+	featureFederation:    {Stage: stageBeta, Default: true, Description: "bands of one grid computed by several controllers (--grpc-addr, --federation-*)"},
+	featureAggregation:   {Stage: stageBeta, Default: true, Description: "republishing other controllers' streams (--aggregate, --federation-members)"},
+	featureGridAPI:       {Stage: stageBeta, Default: true, Description: "grids and workshop sessions created through /api/grids and /api/sessions"},
+	featurePatternUpload: {Stage: stageAlpha, Default: false, Description: "stamping uploaded RLE, plaintext and Macrocell patterns"},
+	featureWasmRules:     {Stage: stageAlpha, Default: false, Description: "replacing the rule with an uploaded WebAssembly module (/api/rules)"},
+}
+
+// featureGate is the --feature-gates flag: comma-separated Name=bool pairs
+// overriding the defaults, e.g. PatternUpload=true,Federation=false.
+type featureGate map[string]bool
+
+var featureGates = featureGate{}
+
+func (g featureGate) String() string {
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", name, g[name]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (g featureGate) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("missing =bool in %q", pair)
+		}
+		if _, known := knownFeatures[name]; !known {
+			return fmt.Errorf("unknown feature %q (known: %s)", name, strings.Join(featureNames(), ", "))
+		}
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %q", name, v)
+		}
+		g[name] = enabled
+	}
+	return nil
+}
+
+// featureEnabled reports whether a feature is on.
+func featureEnabled(name string) bool {
+	if enabled, ok := featureGates[name]; ok {
+		return enabled
+	}
+	return knownFeatures[name].Default
+}
+
+func featureNames() []string {
+	names := make([]string, 0, len(knownFeatures))
+	for name := range knownFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var featureEnabledGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grid_feature_enabled",
+	Help: "Whether a feature gate is enabled (1) or not (0).",
+}, []string{"name", "stage"})
+
+// reportFeatures exports the effective gates as metrics.
+func reportFeatures() {
+	for _, name := range featureNames() {
+		v := 0.0
+		if featureEnabled(name) {
+			v = 1
+		}
+		featureEnabledGauge.WithLabelValues(name, knownFeatures[name].Stage).Set(v)
+	}
+}
+
+// handleFeatures serves GET /api/features, the feature gates and their state,
+// so clients can hide what the controller does not offer.
+func handleFeatures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(featureSpecs())
+}
+
+// featureSpecs returns every feature gate with its effective state.
+func featureSpecs() []FeatureSpec {
+	features := []FeatureSpec{}
+	for _, name := range featureNames() {
+		spec := knownFeatures[name]
+		spec.Name, spec.Enabled = name, featureEnabled(name)
+		features = append(features, spec)
+	}
+	return features
+}
+
+// requireFeature answers 404 for endpoints of a disabled feature.
+func requireFeature(w http.ResponseWriter, name string) bool {
+	if !featureEnabled(name) {
+		http.Error(w, name+" is disabled by --feature-gates", http.StatusNotFound)
+		return false
+	}
+	return true
+}
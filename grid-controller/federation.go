@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/nordiwnd/k3s-cellular-automaton/grid-controller/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// federationOffset is the global index of this controller's first cell when
+// it owns one band of a federated grid.
+var federationOffset int
+
+// federatedName maps a local cell pod name to its name in the federated grid.
+func federatedName(name string) string {
+	i, ok := cellIndex(name)
+	if !ok || federationOffset == 0 {
+		return name
+	}
+	return cellName(i + federationOffset)
+}
+
+// Edge rows are kept for a few generations so a peer that is slightly
+// behind can still fetch the row it needs.
+const federationHistory = 8
+
+// Updates are kept so that a watcher resuming after a backfill can catch up;
+// a few band snapshots are kept for backfills in progress.
+const (
+	federationChangeHistory = 256
+	backfillSnapshots       = 4
+)
+
+// Backfill page sizes, in live cells.
+const (
+	defaultBackfillPage = 4096
+	maxBackfillPage     = 65536
+)
+
+// bandSnapshot is the band's state as paged out by Backfill.
+type bandSnapshot struct {
+	revision   uint64
+	generation int64
+	live       []int
+}
+
+// federationMember is this controller's side of a federated automaton: it owns
+// one horizontal band, serves its edge rows to the bands above and below, and
+// streams its changes to an aggregator.
+type federationMember struct {
+	pb.UnimplementedFederationServiceServer
+
+	engine    *Engine
+	grid      GridGeometry
+	rowOffset int
+
+	// north owns the band above this one, south the band below; nil at the
+	// top and bottom of the federated grid.
+	north, south pb.FederationServiceClient
+
+	mu       sync.Mutex
+	edges    map[int64][2][]bool
+	hashes   map[int64]string
+	latest   int64
+	watchers map[chan *pb.BandUpdate]bool
+
+	// revision is the number of the last published update; changes holds
+	// the latest updates, oldest first.
+	revision  uint64
+	changes   []*pb.BandUpdate
+	snapshots map[uint64]*bandSnapshot
+}
+
+func newFederationMember(engine *Engine, grid GridGeometry, rowOffset int) *federationMember {
+	return &federationMember{
+		engine:    engine,
+		grid:      grid,
+		rowOffset: rowOffset,
+		edges:     make(map[int64][2][]bool),
+		hashes:    make(map[int64]string),
+		watchers:  make(map[chan *pb.BandUpdate]bool),
+		snapshots: make(map[uint64]*bandSnapshot),
+	}
+}
+
+// Record stores the band's edge rows and state hash for the engine's current
+// generation.
+func (f *federationMember) Record() {
+	view := f.engine.View()
+	gen, live := view.generation, view.cells
+	top, bottom := view.Row(0), view.Row(f.grid.Height-1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edges[gen] = [2][]bool{top, bottom}
+	f.hashes[gen] = stateHash(gen, live)
+	f.latest = gen
+	delete(f.edges, gen-federationHistory)
+	delete(f.hashes, gen-federationHistory)
+}
+
+func (f *federationMember) GetBoundary(ctx context.Context, req *pb.BoundaryRequest) (*pb.BoundaryRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows, ok := f.edges[req.Generation]
+	if !ok {
+		if req.Generation > f.latest {
+			return nil, status.Errorf(codes.Unavailable, "generation %d not reached yet (at %d)", req.Generation, f.latest)
+		}
+		return nil, status.Errorf(codes.NotFound, "generation %d no longer retained", req.Generation)
+	}
+	return &pb.BoundaryRow{Generation: req.Generation, Alive: rows[req.Edge]}, nil
+}
+
+func (f *federationMember) GetStateHash(ctx context.Context, req *pb.StateHashRequest) (*pb.StateHash, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hash, ok := f.hashes[req.Generation]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "generation %d not retained (at %d)", req.Generation, f.latest)
+	}
+	return &pb.StateHash{Generation: req.Generation, Hash: hash}, nil
+}
+
+// Ghosts fetches the rows bordering this band at generation gen: the bottom
+// row of the north peer and the top row of the south peer. A peer that cannot
+// answer before the deadline is treated as dead.
+func (f *federationMember) Ghosts(ctx context.Context, gen int64, deadline time.Duration) (above, below []bool) {
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	fetch := func(peer pb.FederationServiceClient, edge pb.Edge, out *[]bool) {
+		defer wg.Done()
+		for {
+			row, err := peer.GetBoundary(ctx, &pb.BoundaryRequest{Generation: gen, Edge: edge})
+			if err == nil {
+				*out = row.Alive
+				return
+			}
+			if status.Code(err) != codes.Unavailable || ctx.Err() != nil {
+				log.Printf("Federation: boundary %s for generation %d: %v", edge, gen, err)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if f.north != nil {
+		wg.Add(1)
+		go fetch(f.north, pb.Edge_EDGE_BOTTOM, &above)
+	}
+	if f.south != nil {
+		wg.Add(1)
+		go fetch(f.south, pb.Edge_EDGE_TOP, &below)
+	}
+	wg.Wait()
+	return above, below
+}
+
+// Publish fans a generation's changes out to every WatchBand stream. Watchers
+// that cannot keep up are dropped and have to reconnect.
+func (f *federationMember) Publish(births, deaths []int) {
+	update := f.update(f.engine.Generation(), births, deaths)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revision++
+	update.Revision = f.revision
+	f.changes = append(f.changes, update)
+	if len(f.changes) > federationChangeHistory {
+		f.changes = f.changes[1:]
+	}
+	for ch := range f.watchers {
+		select {
+		case ch <- update:
+		default:
+			close(ch)
+			delete(f.watchers, ch)
+		}
+	}
+}
+
+func (f *federationMember) WatchBand(_ *pb.Empty, stream grpc.ServerStreamingServer[pb.BandUpdate]) error {
+	f.mu.Lock()
+	ch := f.subscribe()
+	revision := f.revision
+	f.mu.Unlock()
+
+	// Full state first, so the watcher does not have to wait for churn.
+	full := f.update(f.engine.Generation(), f.engine.LiveCells(), nil)
+	full.Revision = revision
+	return f.watch(stream, ch, []*pb.BandUpdate{full})
+}
+
+func (f *federationMember) WatchBandFrom(req *pb.WatchBandRequest, stream grpc.ServerStreamingServer[pb.BandUpdate]) error {
+	f.mu.Lock()
+	if req.FromRevision > f.revision {
+		f.mu.Unlock()
+		return status.Errorf(codes.InvalidArgument, "revision %d not reached yet (at %d)", req.FromRevision, f.revision)
+	}
+	if oldest := f.revision + 1 - uint64(len(f.changes)); req.FromRevision+1 < oldest {
+		f.mu.Unlock()
+		return status.Errorf(codes.FailedPrecondition, "updates after revision %d no longer retained; backfill again", req.FromRevision)
+	}
+	var backlog []*pb.BandUpdate
+	for _, u := range f.changes {
+		if u.Revision > req.FromRevision {
+			backlog = append(backlog, u)
+		}
+	}
+	ch := f.subscribe()
+	f.mu.Unlock()
+
+	return f.watch(stream, ch, backlog)
+}
+
+// subscribe adds a watcher; f.mu must be held.
+func (f *federationMember) subscribe() chan *pb.BandUpdate {
+	ch := make(chan *pb.BandUpdate, 64)
+	f.watchers[ch] = true
+	return ch
+}
+
+// watch sends the initial updates and then everything published to ch.
+func (f *federationMember) watch(stream grpc.ServerStreamingServer[pb.BandUpdate], ch chan *pb.BandUpdate, initial []*pb.BandUpdate) error {
+	defer func() {
+		f.mu.Lock()
+		if f.watchers[ch] {
+			close(ch)
+			delete(f.watchers, ch)
+		}
+		f.mu.Unlock()
+	}()
+
+	for _, update := range initial {
+		if err := stream.Send(update); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case update, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher too slow")
+			}
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Backfill pages through a snapshot of the band taken when the backfill
+// starts. The page token names the snapshot and the next cell, so a caller
+// can resume after reconnecting as long as the snapshot is retained.
+func (f *federationMember) Backfill(ctx context.Context, req *pb.BackfillRequest) (*pb.BackfillPage, error) {
+	size := int(req.PageSize)
+	if size <= 0 {
+		size = defaultBackfillPage
+	}
+	size = min(size, maxBackfillPage)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var snap *bandSnapshot
+	var cursor int
+	if req.PageToken == "" {
+		// The engine may be ahead of the last published update; replaying
+		// those updates over the snapshot is harmless.
+		snap = f.snapshots[f.revision]
+		if snap == nil {
+			gen, live := f.engine.Snapshot()
+			snap = &bandSnapshot{revision: f.revision, generation: gen, live: live}
+			f.snapshots[snap.revision] = snap
+			if len(f.snapshots) > backfillSnapshots {
+				oldest := snap.revision
+				for rev := range f.snapshots {
+					oldest = min(oldest, rev)
+				}
+				delete(f.snapshots, oldest)
+			}
+		}
+	} else {
+		rev, next, err := parseBackfillToken(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if snap = f.snapshots[rev]; snap == nil {
+			return nil, status.Errorf(codes.NotFound, "backfill at revision %d no longer retained; start over", rev)
+		}
+		if next > len(snap.live) {
+			return nil, status.Error(codes.InvalidArgument, "page token out of range")
+		}
+		cursor = next
+	}
+
+	end := min(cursor+size, len(snap.live))
+	page := &pb.BackfillPage{
+		Generation: snap.generation,
+		Revision:   snap.revision,
+		RowOffset:  int32(f.rowOffset),
+		Width:      int32(f.grid.Width),
+		Height:     int32(f.grid.Height),
+		Alive:      make([]int32, 0, end-cursor),
+	}
+	for _, i := range snap.live[cursor:end] {
+		page.Alive = append(page.Alive, int32(i))
+	}
+	if cursor == 0 && req.History > 0 {
+		for _, u := range f.changes {
+			if u.Revision <= snap.revision && u.Generation > snap.generation-int64(req.History) {
+				page.History = append(page.History, u)
+			}
+		}
+	}
+	if end < len(snap.live) {
+		page.NextPageToken = backfillToken(snap.revision, end)
+	}
+	return page, nil
+}
+
+// backfillToken encodes a backfill's snapshot revision and next cell.
+func backfillToken(revision uint64, next int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(revision, 10) + ":" + strconv.Itoa(next)))
+}
+
+func parseBackfillToken(token string) (uint64, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		rev, next, ok := strings.Cut(string(raw), ":")
+		if ok {
+			r, err1 := strconv.ParseUint(rev, 10, 64)
+			n, err2 := strconv.Atoi(next)
+			if err1 == nil && err2 == nil && n >= 0 {
+				return r, n, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid page token")
+}
+
+func (f *federationMember) update(gen int64, births, deaths []int) *pb.BandUpdate {
+	u := &pb.BandUpdate{
+		Generation: gen,
+		RowOffset:  int32(f.rowOffset),
+		Width:      int32(f.grid.Width),
+		Height:     int32(f.grid.Height),
+	}
+	for _, i := range births {
+		u.Births = append(u.Births, int32(i))
+	}
+	for _, i := range deaths {
+		u.Deaths = append(u.Deaths, int32(i))
+	}
+	return u
+}
+
+func dialFederationPeer(addr string) pb.FederationServiceClient {
+	if addr == "" {
+		return nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Federation: invalid peer %q: %s", addr, err.Error())
+	}
+	return pb.NewFederationServiceClient(conn)
+}
+
+// The aggregator verifies its copy of a band against the member's state hash
+// every few generations.
+const federationVerifyEvery = 10
+
+// aggregateBand subscribes to a member's band and republishes its changes on
+// this controller's WebSocket under global cell names, so one dashboard shows
+// the whole federated grid. It backfills the band page by page and follows
+// the updates after it; members that predate Backfill send their full state
+// through WatchBand instead. If the rebuilt band stops matching the member's
+// state hash, it starts over.
+func aggregateBand(ctx context.Context, grid, addr, namespace string) {
+	client := dialFederationPeer(addr)
+	band := make(map[int]bool)
+	apply := func(offset int, births, deaths []int32) {
+		for _, i := range births {
+			band[int(i)] = true
+			publishCell(grid, cellName(offset+int(i)), "alive", namespace)
+		}
+		for _, i := range deaths {
+			delete(band, int(i))
+			publishCell(grid, cellName(offset+int(i)), "dead", namespace)
+		}
+	}
+
+	for ctx.Err() == nil {
+		var stream grpc.ServerStreamingClient[pb.BandUpdate]
+		revision, err := backfillBand(ctx, client, band, apply)
+		switch {
+		case status.Code(err) == codes.Unimplemented:
+			clear(band)
+			stream, err = client.WatchBand(ctx, &pb.Empty{})
+		case err == nil:
+			stream, err = client.WatchBandFrom(ctx, &pb.WatchBandRequest{FromRevision: revision})
+		}
+		if err == nil {
+			log.Printf("Federation: aggregating %s", addr)
+			for {
+				var u *pb.BandUpdate
+				u, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				apply(int(u.RowOffset*u.Width), u.Births, u.Deaths)
+				if u.Generation%federationVerifyEvery == 0 {
+					if err = verifyBand(ctx, client, u.Generation, slices.Collect(maps.Keys(band))); err != nil {
+						break
+					}
+				}
+			}
+		}
+		if ctx.Err() == nil {
+			log.Printf("Federation: member %s: %v; retrying", addr, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// backfill pages through the member's band and returns its live cells and
+// the last page, which carries the generation and revision. Pages that fail
+// transiently are retried with the same token.
+func backfill(ctx context.Context, client pb.FederationServiceClient) ([]int, *pb.BackfillPage, error) {
+	var live []int
+	var page *pb.BackfillPage
+	var token string
+	for retries := 0; ; {
+		p, err := client.Backfill(ctx, &pb.BackfillRequest{PageToken: token})
+		if err != nil {
+			if status.Code(err) != codes.Unavailable || retries == 3 {
+				return nil, nil, err
+			}
+			retries++
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		page, retries = p, 0
+		for _, i := range p.Alive {
+			live = append(live, int(i))
+		}
+		if token = p.NextPageToken; token == "" {
+			return live, page, nil
+		}
+	}
+}
+
+// backfillBand backfills the member's band and reconciles band with it,
+// returning the revision to follow updates from.
+func backfillBand(ctx context.Context, client pb.FederationServiceClient, band map[int]bool, apply func(offset int, births, deaths []int32)) (uint64, error) {
+	cells, page, err := backfill(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	live := make(map[int]bool, len(cells))
+	var births, deaths []int32
+	for _, i := range cells {
+		live[i] = true
+		if !band[i] {
+			births = append(births, int32(i))
+		}
+	}
+	for i := range band {
+		if !live[i] {
+			deaths = append(deaths, int32(i))
+		}
+	}
+	apply(int(page.RowOffset*page.Width), births, deaths)
+	log.Printf("Federation: backfilled %d live cells at generation %d", len(live), page.Generation)
+	return page.Revision, nil
+}
+
+// verifyBand compares the rebuilt band with the member's hash at generation.
+// A member that no longer retains the generation is not an error.
+func verifyBand(ctx context.Context, client pb.FederationServiceClient, gen int64, live []int) error {
+	want, err := client.GetStateHash(ctx, &pb.StateHashRequest{Generation: gen})
+	if err != nil {
+		if status.Code(err) == codes.NotFound || status.Code(err) == codes.Unimplemented {
+			return nil
+		}
+		return err
+	}
+	if got := stateHash(gen, live); got != want.Hash {
+		return fmt.Errorf("band drifted at generation %d: %s, member has %s", gen, got, want.Hash)
+	}
+	return nil
+}
+
+func publishCell(grid, name, status, namespace string) {
+	publish(msgCell, CellUpdate{Name: name, Status: status, Namespace: namespace, Grid: grid})
+}
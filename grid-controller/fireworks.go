@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Statuses of firework cells once their Job has finished.
+const (
+	statusSpark   = "spark"
+	statusFizzled = "fizzled"
+)
+
+// fireworkQueue bounds the births waiting for their Job; births beyond it
+// are not shown.
+const fireworkQueue = 1024
+
+// fireworkTTL is how long finished Jobs are kept, and their sparks shown.
+const fireworkTTL = 30
+
+var fireworksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_firework_jobs_total",
+	Help: "Births shown as firework Jobs, by outcome: created, failed (to create) or dropped (queue full).",
+}, []string{"outcome"})
+
+// firework is one birth waiting for its Job.
+type firework struct {
+	generation int64
+	index      int
+}
+
+// fireworkLauncher materializes the standalone engine's births as one-shot
+// Jobs (--cell-objects=jobs) instead of long-running pods. Each Job runs the
+// worker in spark mode, which exits after a moment; the finished Job renders
+// as a fading spark and is garbage-collected after fireworkTTL seconds. Live
+// cells have no object of their own, which makes high-churn rules such as
+// Seeds cheap to show on small clusters.
+type fireworkLauncher struct {
+	clientset kubernetes.Interface
+	namespace string
+	image     string
+
+	queue chan firework
+
+	mu sync.Mutex
+	// latest is the generation of each cell's newest Job, whose state is
+	// the one shown.
+	latest map[int]int64
+}
+
+func newFireworkLauncher(clientset kubernetes.Interface, namespace, image string) *fireworkLauncher {
+	return &fireworkLauncher{clientset: clientset, namespace: namespace, image: image, queue: make(chan firework, fireworkQueue), latest: map[int]int64{}}
+}
+
+// Launch queues a Job for every birth of a generation. It is safe to call on
+// a nil launcher.
+func (l *fireworkLauncher) Launch(gen int64, births []int) {
+	if l == nil {
+		return
+	}
+	for _, i := range births {
+		select {
+		case l.queue <- firework{generation: gen, index: i}:
+		default:
+			fireworksTotal.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+// Run creates the queued Jobs until ctx is done.
+func (l *fireworkLauncher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-l.queue:
+			_, err := l.clientset.BatchV1().Jobs(l.namespace).Create(ctx, l.jobFor(f), metav1.CreateOptions{})
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				fireworksTotal.WithLabelValues("failed").Inc()
+				log.Printf("Fireworks: create job for %s: %v", cellName(f.index), err)
+				cellErrors.APIError(l.namespace, cellName(f.index), intentBirth, err)
+				continue
+			}
+			fireworksTotal.WithLabelValues("created").Inc()
+		}
+	}
+}
+
+// jobFor builds the Job of one birth, named after the cell and generation.
+func (l *fireworkLauncher) jobFor(f firework) *batchv1.Job {
+	name := cellName(f.index)
+	labels := map[string]string{"app": "firework", "cell": strconv.Itoa(f.index), "managed-by": "grid-controller"}
+	backoff, ttl := int32(0), int32(fireworkTTL)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name + "-g" + strconv.FormatInt(f.generation, 10),
+			Namespace:   l.namespace,
+			Labels:      labels,
+			Annotations: map[string]string{"generation": strconv.FormatInt(f.generation, 10)},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			TTLSecondsAfterFinished: &ttl,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					ServiceAccountName: "cell",
+					RestartPolicy:      v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Name:            "worker",
+						Image:           l.image,
+						ImagePullPolicy: v1.PullIfNotPresent,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceMemory: resource.MustParse("5Mi"),
+								v1.ResourceCPU:    resource.MustParse("10m"),
+							},
+							Limits: v1.ResourceList{
+								v1.ResourceMemory: resource.MustParse("10Mi"),
+								v1.ResourceCPU:    resource.MustParse("50m"),
+							},
+						},
+						Env: []v1.EnvVar{
+							{Name: "RUST_LOG", Value: "info"},
+							{Name: "HOSTNAME", Value: name},
+							{Name: "CELL_MODE", Value: "spark"},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// jobCell returns the cell and generation of a firework Job.
+func jobCell(obj interface{}) (job *batchv1.Job, index int, gen int64, ok bool) {
+	if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+		obj = tombstone.Obj
+	}
+	job, ok = obj.(*batchv1.Job)
+	if !ok || job.Labels["app"] != "firework" {
+		return nil, 0, 0, false
+	}
+	index, err := strconv.Atoi(job.Labels["cell"])
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	gen, err = strconv.ParseInt(job.Annotations["generation"], 10, 64)
+	return job, index, gen, err == nil
+}
+
+// Update streams the cell of a firework Job: alive while it runs, then a
+// spark or, if it failed, a fizzle. Older Jobs of a cell born again are
+// not shown.
+func (l *fireworkLauncher) Update(obj interface{}) {
+	job, i, gen, ok := jobCell(obj)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	stale := gen < l.latest[i]
+	if !stale {
+		l.latest[i] = gen
+	}
+	l.mu.Unlock()
+	if stale {
+		return
+	}
+	status := "initializing"
+	switch {
+	case job.Status.Succeeded > 0:
+		status = statusSpark
+	case job.Status.Failed > 0:
+		status = statusFizzled
+	case job.Status.Active > 0:
+		status = "alive"
+	}
+	publish(msgCell, CellUpdate{Name: federatedName(cellName(i)), Status: status, Namespace: job.Namespace, Grid: gridID})
+}
+
+// Delete clears the spark once the cell's newest Job is collected.
+func (l *fireworkLauncher) Delete(obj interface{}) {
+	job, i, gen, ok := jobCell(obj)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	latest := gen == l.latest[i]
+	if latest {
+		delete(l.latest, i)
+	}
+	l.mu.Unlock()
+	if latest {
+		publish(msgCell, CellUpdate{Name: federatedName(cellName(i)), Status: "dead", Namespace: job.Namespace, Grid: gridID})
+	}
+}
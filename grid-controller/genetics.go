@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// GeneticsConfig gives every live cell a small heritable genome, e.g.
+//
+//	genetics:
+//	  mutationRate: 0.01
+//
+// A newborn cell inherits the genome of one of its live neighbors, picked at
+// random, and each bit then flips with the mutation rate. Cells brought to
+// life outside the rule found a new lineage; a stamped pattern shares one.
+type GeneticsConfig struct {
+	MutationRate float64 `json:"mutationRate"`
+}
+
+func (c *GeneticsConfig) validate() error {
+	if c.MutationRate < 0 || c.MutationRate > 1 {
+		return errors.New("mutationRate must be between 0 and 1")
+	}
+	return nil
+}
+
+// Genome is a cell's heritable traits: a 12-bit RGB444 color and rule
+// modifiers that bend the rule for the cell's lineage.
+type Genome uint16
+
+const (
+	// genomeHardy cells also survive with 4 live neighbors.
+	genomeHardy Genome = 1 << 12
+	// genomeFertile neighbors bring a dead cell with 6 live neighbors to
+	// life when most of those neighbors carry it.
+	genomeFertile Genome = 1 << 13
+
+	genomeBits = 14
+)
+
+// genomeAnnotation carries the genome of a cell pod.
+const genomeAnnotation = "cellular-automaton/genome"
+
+func randomGenome() Genome {
+	return Genome(rand.Intn(1 << genomeBits))
+}
+
+func (g Genome) String() string {
+	return fmt.Sprintf("%04x", uint16(g))
+}
+
+// Color is the lineage's color as #rgb.
+func (g Genome) Color() string {
+	return fmt.Sprintf("#%03x", uint16(g&0xfff))
+}
+
+func (g Genome) Hardy() bool {
+	return g&genomeHardy != 0
+}
+
+func (g Genome) Fertile() bool {
+	return g&genomeFertile != 0
+}
+
+func (g Genome) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.String())
+}
+
+func (g *Genome) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := parseGenome(s)
+	*g = v
+	return err
+}
+
+func parseGenome(s string) (Genome, error) {
+	v, err := strconv.ParseUint(s, 16, 16)
+	if err != nil || v >= 1<<genomeBits {
+		return 0, fmt.Errorf("invalid genome %q", s)
+	}
+	return Genome(v), nil
+}
+
+// podGenomes reads the genomes recorded on cell pods, so a restarted
+// controller keeps the lineages of the cells it adopts.
+func podGenomes(pods corelisters.PodLister, namespace string) map[int]Genome {
+	list, err := pods.Pods(namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell"}))
+	if err != nil {
+		return nil
+	}
+	genomes := map[int]Genome{}
+	for _, pod := range list {
+		i, ok := cellIndex(pod.Name)
+		if !ok {
+			continue
+		}
+		if g, err := parseGenome(pod.Annotations[genomeAnnotation]); err == nil {
+			genomes[i] = g
+		}
+	}
+	return genomes
+}
+
+// genetics tracks the genomes of the engine's live cells. It is guarded by
+// the engine's lock; its methods are safe to call on nil.
+type genetics struct {
+	mutationRate float64
+	genomes      map[int]Genome
+}
+
+func newGenetics(cfg *GeneticsConfig) *genetics {
+	if cfg == nil {
+		return nil
+	}
+	return &genetics{mutationRate: cfg.MutationRate, genomes: make(map[int]Genome)}
+}
+
+// found gives cells brought to life outside the rule a new shared lineage.
+func (g *genetics) found(cells ...int) {
+	if g == nil || len(cells) == 0 {
+		return
+	}
+	genome := randomGenome()
+	for _, i := range cells {
+		g.genomes[i] = genome
+	}
+}
+
+func (g *genetics) forget(i int) {
+	if g != nil {
+		delete(g.genomes, i)
+	}
+}
+
+// inherit returns the genome of a cell born of parents.
+func (g *genetics) inherit(parents []int) Genome {
+	var candidates []Genome
+	for _, p := range parents {
+		if genome, ok := g.genomes[p]; ok {
+			candidates = append(candidates, genome)
+		}
+	}
+	if len(candidates) == 0 {
+		return randomGenome()
+	}
+	genome := candidates[rand.Intn(len(candidates))]
+	for bit := 0; bit < genomeBits; bit++ {
+		if rand.Float64() < g.mutationRate {
+			genome ^= 1 << bit
+		}
+	}
+	return genome
+}
+
+// bend applies the modifiers of cell i's lineage, or of its parents', to the
+// rule's verdict.
+func (g *genetics) bend(i int, n Neighborhood, alive bool, parents []int) bool {
+	if alive {
+		return true
+	}
+	switch {
+	case n.Alive() && n.Neighbors() == 4:
+		return g.genomes[i].Hardy()
+	case !n.Alive() && n.Neighbors() == 6:
+		fertile := 0
+		for _, p := range parents {
+			if g.genomes[p].Fertile() {
+				fertile++
+			}
+		}
+		return fertile > len(parents)/2
+	}
+	return false
+}
+
+// Lineage is a genome and the number of live cells carrying it.
+type Lineage struct {
+	Genome  Genome `json:"genome"`
+	Color   string `json:"color"`
+	Hardy   bool   `json:"hardy"`
+	Fertile bool   `json:"fertile"`
+	Cells   int    `json:"cells"`
+}
+
+var geneticsLineages = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_genetics_lineages",
+	Help: "Distinct genomes among the live cells.",
+})
+
+// lineages counts the live cells per genome, most common first.
+func lineages(genomes map[int]Genome) []Lineage {
+	counts := map[Genome]int{}
+	for _, g := range genomes {
+		counts[g]++
+	}
+	out := make([]Lineage, 0, len(counts))
+	for g, n := range counts {
+		out = append(out, Lineage{Genome: g, Color: g.Color(), Hardy: g.Hardy(), Fertile: g.Fertile(), Cells: n})
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Cells != out[b].Cells {
+			return out[a].Cells > out[b].Cells
+		}
+		return out[a].Genome < out[b].Genome
+	})
+	return out
+}
+
+// lineageLimit caps the lineages /api/genetics lists.
+const lineageLimit = 50
+
+// GeneticsReport is served by /api/genetics.
+type GeneticsReport struct {
+	MutationRate float64   `json:"mutationRate"`
+	Distinct     int       `json:"distinct"`
+	Lineages     []Lineage `json:"lineages"`
+}
+
+// handleGenetics serves GET /api/genetics, the most common lineages.
+func handleGenetics(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil || s.engine.genetics == nil {
+		http.Error(w, "Genetics requires --engine=standalone and a genetics configuration", http.StatusConflict)
+		return
+	}
+
+	all := lineages(s.engine.Genomes())
+	report := GeneticsReport{MutationRate: s.engine.genetics.mutationRate, Distinct: len(all), Lineages: all}
+	if len(all) > lineageLimit {
+		report.Lineages = all[:lineageLimit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GridGeometry mirrors the layout used by the cells-worker: pod cell-N sits
+// at (N % Width, N / Width).
+type GridGeometry struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (g GridGeometry) Size() int {
+	return g.Width * g.Height
+}
+
+func (g GridGeometry) Coords(index int) (x, y int) {
+	return index % g.Width, index / g.Width
+}
+
+func (g GridGeometry) Index(x, y int) int {
+	return y*g.Width + x
+}
+
+func (g GridGeometry) Contains(x, y int) bool {
+	return x >= 0 && x < g.Width && y >= 0 && y < g.Height
+}
+
+// Neighbors returns the indices of the up to eight cells around index that
+// lie within the grid, in row-major order.
+func (g GridGeometry) Neighbors(index int) []int {
+	x, y := g.Coords(index)
+	neighbors := make([]int, 0, 8)
+	for ny := max(y-1, 0); ny <= min(y+1, g.Height-1); ny++ {
+		for nx := max(x-1, 0); nx <= min(x+1, g.Width-1); nx++ {
+			if nx != x || ny != y {
+				neighbors = append(neighbors, g.Index(nx, ny))
+			}
+		}
+	}
+	return neighbors
+}
+
+// cellName returns the pod name of the cell at the given linear index.
+func cellName(index int) string {
+	return "cell-" + strconv.Itoa(index)
+}
+
+// cellIndex parses the linear index out of a cell pod name (cell-N).
+func cellIndex(name string) (int, bool) {
+	s, ok := strings.CutPrefix(name, "cell-")
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// envInt reads an integer environment variable, falling back to def.
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Labels on every namespace created by POST /api/grids.
+const (
+	gridManagedByLabel = "app.kubernetes.io/managed-by"
+	gridNameLabel      = "cellular-automaton/grid"
+	gridTenantLabel    = "cellular-automaton/tenant"
+)
+
+// maxBootstrapCells bounds the size of a grid created through the API.
+const maxBootstrapCells = 1024
+
+var gridNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,40}[a-z0-9])?$`)
+
+// GridSpec is the body of POST /api/grids.
+type GridSpec struct {
+	Name           string `json:"name"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	TickIntervalMs int    `json:"tickIntervalMs,omitempty"`
+	// Template customizes the grid's cell pods; only administrators may
+	// set its image.
+	Template *CellTemplate `json:"template,omitempty"`
+}
+
+// GridInfo describes a grid created through the API.
+type GridInfo struct {
+	Name      string     `json:"name"`
+	Namespace string     `json:"namespace"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Created   time.Time  `json:"created"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	// Preview is the branch of a preview grid.
+	Preview string `json:"preview,omitempty"`
+	Phase   string `json:"phase"`
+}
+
+func (s *GridSpec) validate() error {
+	if !gridNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("name must be a DNS label of at most 42 characters")
+	}
+	if s.Width <= 0 || s.Height <= 0 || s.Width*s.Height > maxBootstrapCells {
+		return fmt.Errorf("width and height must be positive with at most %d cells", maxBootstrapCells)
+	}
+	if s.TickIntervalMs == 0 {
+		s.TickIntervalMs = 1000
+	}
+	if s.TickIntervalMs < 100 {
+		return fmt.Errorf("tickIntervalMs must be at least 100")
+	}
+	if s.Template != nil {
+		if err := s.Template.validate(); err != nil {
+			return fmt.Errorf("template: %w", err)
+		}
+	}
+	return nil
+}
+
+// gridBootstrapper creates self-contained playground grids, each in its own
+// namespace with the same resources as k8s/cells.yaml plus a quota and a
+// network policy. Tearing a grid down deletes its namespace, which takes
+// everything in it along.
+type gridBootstrapper struct {
+	clientset kubernetes.Interface
+	image     string
+	// controllerNamespace may reach the cells of every grid.
+	controllerNamespace string
+	// self, when set, exports the controller's own grid, named --grid-id.
+	self func() ([]any, error)
+}
+
+func gridNamespace(name string) string {
+	return "grid-" + name
+}
+
+// Create provisions a grid owned by tenant, or by the administrators when
+// tenant is empty. opts may add labels and annotations to the namespace. A
+// failure part way removes what was created.
+func (b *gridBootstrapper) Create(ctx context.Context, spec GridSpec, tenant string, opts ...func(*v1.Namespace)) (*GridInfo, error) {
+	ns := gridNamespace(spec.Name)
+	cells := spec.Width * spec.Height
+
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: ns,
+		Labels: map[string]string{
+			gridManagedByLabel: "grid-controller",
+			gridNameLabel:      spec.Name,
+		},
+		Annotations: map[string]string{
+			"cellular-automaton/width":  strconv.Itoa(spec.Width),
+			"cellular-automaton/height": strconv.Itoa(spec.Height),
+		},
+	}}
+	if tenant != "" {
+		namespace.Labels[gridTenantLabel] = tenant
+	}
+	for _, opt := range opts {
+		opt(namespace)
+	}
+	created, err := b.clientset.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.populate(ctx, ns, spec, cells); err != nil {
+		log.Printf("Grids: bootstrap of %s failed, rolling back: %v", spec.Name, err)
+		// Roll back even if the request that created it was cancelled.
+		b.clientset.CoreV1().Namespaces().Delete(context.WithoutCancel(ctx), ns, metav1.DeleteOptions{})
+		return nil, err
+	}
+	log.Printf("Grids: created %s (%dx%d) in namespace %s", spec.Name, spec.Width, spec.Height, ns)
+	return namespaceGridInfo(created), nil
+}
+
+func (b *gridBootstrapper) populate(ctx context.Context, ns string, spec GridSpec, cells int) error {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: ns, Labels: map[string]string{gridNameLabel: spec.Name}}
+	}
+	// Room for every cell plus replacements while old pods terminate.
+	pods := int64(cells + cells/10 + 1)
+	times := func(q string) resource.Quantity {
+		v := resource.MustParse(q)
+		return *resource.NewMilliQuantity(v.MilliValue()*pods, v.Format)
+	}
+
+	core, rbac := b.clientset.CoreV1(), b.clientset.RbacV1()
+	steps := []func() error{
+		func() error {
+			_, err := core.ResourceQuotas(ns).Create(ctx, &v1.ResourceQuota{
+				ObjectMeta: meta("grid"),
+				Spec: v1.ResourceQuotaSpec{Hard: v1.ResourceList{
+					v1.ResourcePods:           *resource.NewQuantity(pods, resource.DecimalSI),
+					v1.ResourceLimitsCPU:      times("50m"),
+					v1.ResourceLimitsMemory:   times("10Mi"),
+					v1.ResourceRequestsCPU:    times("10m"),
+					v1.ResourceRequestsMemory: times("5Mi"),
+				}},
+			}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			// Cells only talk to each other; the controller may reach them.
+			_, err := b.clientset.NetworkingV1().NetworkPolicies(ns).Create(ctx, &networkingv1.NetworkPolicy{
+				ObjectMeta: meta("grid-isolation"),
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{},
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+					Ingress: []networkingv1.NetworkPolicyIngressRule{{
+						From: []networkingv1.NetworkPolicyPeer{
+							{PodSelector: &metav1.LabelSelector{}},
+							{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{
+								"kubernetes.io/metadata.name": b.controllerNamespace,
+							}}},
+						},
+					}},
+				},
+			}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			_, err := core.ServiceAccounts(ns).Create(ctx, &v1.ServiceAccount{ObjectMeta: meta("cell")}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			_, err := rbac.Roles(ns).Create(ctx, &rbacv1.Role{
+				ObjectMeta: meta("cell-worker"),
+				Rules: []rbacv1.PolicyRule{{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get", "patch"},
+				}},
+			}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			_, err := rbac.RoleBindings(ns).Create(ctx, &rbacv1.RoleBinding{
+				ObjectMeta: meta("cell-worker-binding"),
+				Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: "cell", Namespace: ns}},
+				RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "cell-worker", APIGroup: "rbac.authorization.k8s.io"},
+			}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			_, err := core.ConfigMaps(ns).Create(ctx, &v1.ConfigMap{
+				ObjectMeta: meta("cell-config"),
+				Data: map[string]string{
+					"GRID_WIDTH":       strconv.Itoa(spec.Width),
+					"GRID_HEIGHT":      strconv.Itoa(spec.Height),
+					"TICK_INTERVAL_MS": strconv.Itoa(spec.TickIntervalMs),
+				},
+			}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			_, err := core.Services(ns).Create(ctx, &v1.Service{
+				ObjectMeta: meta("cell"),
+				Spec: v1.ServiceSpec{
+					ClusterIP: v1.ClusterIPNone,
+					Selector:  map[string]string{"app": "cell"},
+					Ports:     []v1.ServicePort{{Name: "grpc", Port: 50051}},
+				},
+			}, metav1.CreateOptions{})
+			return err
+		},
+		func() error {
+			m := &cellPodManager{namespace: ns, image: b.image}
+			if spec.Template != nil {
+				m.template = *spec.Template
+			}
+			template := m.podFor(0)
+			podLabels := map[string]string{"app": "cell"}
+			for key, value := range m.template.Labels {
+				podLabels[key] = value
+			}
+			template.ObjectMeta = metav1.ObjectMeta{Labels: podLabels}
+			template.Spec.Hostname, template.Spec.Subdomain = "", ""
+			replicas := int32(cells)
+			_, err := b.clientset.AppsV1().StatefulSets(ns).Create(ctx, &appsv1.StatefulSet{
+				ObjectMeta: meta("cell"),
+				Spec: appsv1.StatefulSetSpec{
+					ServiceName:         "cell",
+					Replicas:            &replicas,
+					PodManagementPolicy: appsv1.ParallelPodManagement,
+					Selector:            &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cell"}},
+					Template:            v1.PodTemplateSpec{ObjectMeta: template.ObjectMeta, Spec: template.Spec},
+				},
+			}, metav1.CreateOptions{})
+			return err
+		},
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a grid visible to tenant; administrators (an empty tenant) see
+// every grid. Other tenants' grids are reported as not found.
+func (b *gridBootstrapper) Get(ctx context.Context, name, tenant string) (*GridInfo, error) {
+	ns, err := b.clientset.CoreV1().Namespaces().Get(ctx, gridNamespace(name), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if ns.Labels[gridManagedByLabel] != "grid-controller" || (tenant != "" && ns.Labels[gridTenantLabel] != tenant) {
+		return nil, apierrors.NewNotFound(v1.Resource("namespaces"), ns.Name)
+	}
+	return namespaceGridInfo(ns), nil
+}
+
+// Delete tears a grid down by deleting its namespace.
+func (b *gridBootstrapper) Delete(ctx context.Context, name, tenant string) error {
+	info, err := b.Get(ctx, name, tenant)
+	if err != nil {
+		return err
+	}
+	log.Printf("Grids: deleting %s", name)
+	if err := b.clientset.CoreV1().Namespaces().Delete(ctx, info.Namespace, metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	forgetGrid(info.Name)
+	return nil
+}
+
+// List returns the grids visible to tenant.
+func (b *gridBootstrapper) List(ctx context.Context, tenant string) ([]GridInfo, error) {
+	selector := labels.Set{gridManagedByLabel: "grid-controller"}
+	if tenant != "" {
+		selector[gridTenantLabel] = tenant
+	}
+	list, err := b.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	grids := []GridInfo{}
+	for i := range list.Items {
+		if _, ok := list.Items[i].Labels[gridNameLabel]; ok {
+			grids = append(grids, *namespaceGridInfo(&list.Items[i]))
+		}
+	}
+	return grids, nil
+}
+
+func namespaceGridInfo(ns *v1.Namespace) *GridInfo {
+	width, _ := strconv.Atoi(ns.Annotations["cellular-automaton/width"])
+	height, _ := strconv.Atoi(ns.Annotations["cellular-automaton/height"])
+	return &GridInfo{
+		Name:      ns.Labels[gridNameLabel],
+		Namespace: ns.Name,
+		Width:     width,
+		Height:    height,
+		Tenant:    ns.Labels[gridTenantLabel],
+		Expires:   sessionExpiry(ns),
+		Preview:   ns.Annotations[previewBranchAnnotation],
+		Created:   ns.CreationTimestamp.Time,
+		Phase:     string(ns.Status.Phase),
+	}
+}
+
+// handleGrids serves GET and POST /api/grids, GET and DELETE
+// /api/grids/{name} and GET /api/grids/{name}/manifest. Administrators manage every grid; tenants only see and
+// delete their own, and create grids within their quota.
+func handleGrids(w http.ResponseWriter, r *http.Request, b *gridBootstrapper) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	tenant, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+	var owner string
+	if tenant != nil {
+		owner = tenant.Name
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/grids"), "/")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch {
+	case r.Method == "GET" && name == "":
+		grids, err := b.List(ctx, owner)
+		if err != nil {
+			http.Error(w, "Failed to list grids: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grids)
+
+	case r.Method == "POST" && name == "":
+		var spec GridSpec
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16384)).Decode(&spec); err != nil {
+			http.Error(w, "Invalid grid: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := spec.validate(); err != nil {
+			http.Error(w, "Invalid grid: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if spec.Template != nil && spec.Template.Image != "" && tenant != nil {
+			http.Error(w, "Only administrators may set the cell image", http.StatusForbidden)
+			return
+		}
+		if !b.admit(w, ctx, tenant, spec) || !quotas.Allow(w, r, actionGrids) {
+			return
+		}
+		info, err := b.Create(ctx, spec, owner)
+		if apierrors.IsAlreadyExists(err) {
+			http.Error(w, "Grid already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to create grid: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "GET" && strings.HasSuffix(name, "/manifest"):
+		name = strings.TrimSuffix(name, "/manifest")
+		var objects []any
+		var err error
+		if b.self != nil && name == gridID && tenant == nil {
+			objects, err = b.self()
+		} else {
+			objects, err = b.Manifest(ctx, name, owner)
+		}
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Grid not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to export grid: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeManifest(w, objects)
+
+	case r.Method == "GET" && name != "":
+		info, err := b.Get(ctx, name, owner)
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Grid not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to get grid: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "DELETE" && name != "":
+		err := b.Delete(ctx, name, owner)
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Grid not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete grid: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// admit checks a new grid against the tenant's quota and writes the error
+// response when it does not fit. Administrators have no quota.
+func (b *gridBootstrapper) admit(w http.ResponseWriter, ctx context.Context, tenant *Tenant, spec GridSpec) bool {
+	if tenant == nil {
+		return true
+	}
+	if spec.Width*spec.Height > tenant.MaxCells {
+		http.Error(w, fmt.Sprintf("Grid exceeds your quota of %d cells", tenant.MaxCells), http.StatusForbidden)
+		return false
+	}
+	owned, err := b.List(ctx, tenant.Name)
+	if err != nil {
+		http.Error(w, "Failed to list grids: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if len(owned) >= tenant.MaxGrids {
+		http.Error(w, fmt.Sprintf("You already own %d of %d grids", len(owned), tenant.MaxGrids), http.StatusForbidden)
+		return false
+	}
+	return true
+}
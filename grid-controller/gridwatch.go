@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchGrids streams the cells of every grid created through /api/grids.
+// Their updates are scoped to the grid, so only WebSocket clients that
+// subscribed with ?grid=<name>, and are allowed to see it, receive them.
+func watchGrids(ctx context.Context, clientset kubernetes.Interface) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = "app=cell"
+		}))
+
+	report := func(obj interface{}, deleted bool) {
+		pod, ok := podFromTombstone(obj)
+		if !ok {
+			return
+		}
+		grid, ok := strings.CutPrefix(pod.Namespace, gridNamespace(""))
+		if !ok {
+			return
+		}
+		status := pod.Labels["game-status"]
+		switch {
+		case deleted:
+			status = "deleted"
+		case pod.DeletionTimestamp != nil:
+			status = "terminating"
+		case status == "":
+			status = "initializing"
+		}
+		publishScoped(grid, msgCell, CellUpdate{Name: pod.Name, Status: status, Namespace: pod.Namespace, Grid: grid})
+	}
+
+	factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { report(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { report(obj, false) },
+		DeleteFunc: func(obj interface{}) { report(obj, true) },
+	})
+	factory.Start(ctx.Done())
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// HookConfig runs a Starlark script on engine events, e.g.
+//
+//	hooks:
+//	  script: /etc/grid-controller/hooks.star
+//	  maxSteps: 100000
+//
+// The script defines any of these functions:
+//
+//	on_generation(generation, population)
+//	on_birth(cell, x, y, cause)
+//	on_death(cell, x, y, cause)
+//	on_chaos(pod, cell)
+//
+// and may call spawn(x, y, pattern=None), kill(x, y), log(message) and
+// annotate(key, value), which annotates the generation digest. For example,
+// to stamp a glider every 100th generation:
+//
+//	def on_generation(generation, population):
+//	    if generation % 100 == 0:
+//	        spawn(0, 0, pattern="glider")
+//
+// Hooks run on the engine's goroutine after each generation is computed;
+// chaos kills are delivered with the next generation. Scripts cannot load
+// modules or reach the filesystem or network.
+type HookConfig struct {
+	Script string `json:"script,omitempty"`
+	// MaxSteps bounds the Starlark steps of one generation's hooks; defaults
+	// to 100000. A run that exceeds it, or fails, is discarded.
+	MaxSteps uint64 `json:"maxSteps,omitempty"`
+}
+
+func (c *HookConfig) validate() error {
+	if c.MaxSteps == 0 {
+		c.MaxSteps = 100000
+	}
+	return nil
+}
+
+const causeScript = "script"
+
+var hookErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_hook_errors_total",
+	Help: "Generations whose hooks failed or exceeded their step budget.",
+})
+
+// hookEdit is a spawn, kill or annotation requested by a hook, applied once
+// every hook of the generation has run.
+type hookEdit struct {
+	pattern    Pattern
+	x, y       int
+	kill       bool
+	key, value string
+}
+
+type chaosKill struct {
+	pod  string
+	cell int
+}
+
+// hookRunner runs the hook script. Its methods are safe to call on a nil
+// runner.
+type hookRunner struct {
+	grid     GridGeometry
+	maxSteps uint64
+	thread   *starlark.Thread
+	hooks    map[string]*starlark.Function
+	edits    []hookEdit
+
+	mu    sync.Mutex
+	chaos []chaosKill
+}
+
+func loadHooks(cfg HookConfig, grid GridGeometry) (*hookRunner, error) {
+	h := &hookRunner{grid: grid, maxSteps: cfg.MaxSteps, hooks: map[string]*starlark.Function{}}
+	h.thread = &starlark.Thread{
+		Name:  "hooks",
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("Hook: %s", msg) },
+	}
+	predeclared := starlark.StringDict{
+		"spawn":    starlark.NewBuiltin("spawn", h.spawn),
+		"kill":     starlark.NewBuiltin("kill", h.kill),
+		"log":      starlark.NewBuiltin("log", h.log),
+		"annotate": starlark.NewBuiltin("annotate", h.annotate),
+	}
+	h.thread.SetMaxExecutionSteps(h.maxSteps)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, h.thread, cfg.Script, nil, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"on_generation", "on_birth", "on_death", "on_chaos"} {
+		if fn, ok := globals[name].(*starlark.Function); ok {
+			h.hooks[name] = fn
+		}
+	}
+	if len(h.hooks) == 0 {
+		return nil, errors.New("script defines no hooks")
+	}
+	// Edits made at load time have no generation to land in.
+	h.edits = nil
+	return h, nil
+}
+
+// Chaos queues a chaos kill for on_chaos.
+func (h *hookRunner) Chaos(pod string, cell int) {
+	if h == nil || h.hooks["on_chaos"] == nil {
+		return
+	}
+	h.mu.Lock()
+	h.chaos = append(h.chaos, chaosKill{pod, cell})
+	h.mu.Unlock()
+}
+
+// Run calls the hooks for a newly computed generation and applies their
+// edits to the simulation, returning the births and deaths they caused.
+func (h *hookRunner) Run(s *simulation, generation int64, population int, births, deaths []CellEvent) (spawned, killed []int) {
+	if h == nil {
+		return nil, nil
+	}
+	h.mu.Lock()
+	chaos := h.chaos
+	h.chaos = nil
+	h.mu.Unlock()
+
+	h.edits = nil
+	h.thread.Uncancel()
+	h.thread.SetMaxExecutionSteps(h.thread.ExecutionSteps() + h.maxSteps)
+	err := func() error {
+		for _, c := range chaos {
+			if err := h.call("on_chaos", starlark.String(c.pod), starlark.MakeInt(c.cell)); err != nil {
+				return err
+			}
+		}
+		for _, ev := range births {
+			if err := h.callCell("on_birth", ev); err != nil {
+				return err
+			}
+		}
+		for _, ev := range deaths {
+			if err := h.callCell("on_death", ev); err != nil {
+				return err
+			}
+		}
+		return h.call("on_generation", starlark.MakeInt64(generation), starlark.MakeInt(population))
+	}()
+	if err != nil {
+		hookErrors.Inc()
+		log.Printf("Hook: generation %d discarded: %s", generation, err.Error())
+		return nil, nil
+	}
+
+	for _, e := range h.edits {
+		switch {
+		case e.key != "":
+			s.digests.Annotate(e.key, e.value)
+		case e.kill:
+			i := h.grid.Index(e.x, e.y)
+			if s.engine.Alive(i) {
+				s.engine.Set(i, false)
+				s.digests.Death(i, causeScript)
+				killed = append(killed, i)
+			}
+		default:
+			born := s.engine.Stamp(e.pattern, e.x, e.y)
+			for _, i := range born {
+				s.digests.Birth(i, causeScript)
+			}
+			spawned = append(spawned, born...)
+		}
+	}
+	return spawned, killed
+}
+
+func (h *hookRunner) call(name string, args ...starlark.Value) error {
+	fn := h.hooks[name]
+	if fn == nil {
+		return nil
+	}
+	_, err := starlark.Call(h.thread, fn, args, nil)
+	return err
+}
+
+func (h *hookRunner) callCell(name string, ev CellEvent) error {
+	if h.hooks[name] == nil {
+		return nil
+	}
+	x, y := h.grid.Coords(ev.Cell)
+	return h.call(name, starlark.MakeInt(ev.Cell), starlark.MakeInt(x), starlark.MakeInt(y), starlark.String(ev.Cause))
+}
+
+// Hook builtins.
+
+func (h *hookRunner) spawn(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y int
+	var pattern starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "y", &y, "pattern?", &pattern); err != nil {
+		return nil, err
+	}
+	p := Pattern{Name: "cell", Width: 1, Height: 1, Cells: [][2]int{{0, 0}}}
+	if name, ok := pattern.(starlark.String); ok {
+		if p, ok = builtinPatterns[string(name)]; !ok {
+			return nil, fmt.Errorf("%s: unknown pattern %q", b.Name(), string(name))
+		}
+	} else if pattern != starlark.None {
+		return nil, fmt.Errorf("%s: pattern must be a string", b.Name())
+	}
+	h.edits = append(h.edits, hookEdit{pattern: p, x: x, y: y})
+	return starlark.None, nil
+}
+
+func (h *hookRunner) kill(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "y", &y); err != nil {
+		return nil, err
+	}
+	if !h.grid.Contains(x, y) {
+		return nil, fmt.Errorf("%s: (%d, %d) outside the grid", b.Name(), x, y)
+	}
+	h.edits = append(h.edits, hookEdit{x: x, y: y, kill: true})
+	return starlark.None, nil
+}
+
+func (h *hookRunner) log(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "message", &msg); err != nil {
+		return nil, err
+	}
+	log.Printf("Hook: %s", msg)
+	return starlark.None, nil
+}
+
+func (h *hookRunner) annotate(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, value string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	if key == "" || len(key) > 63 || len(value) > 1024 {
+		return nil, fmt.Errorf("%s: key must be 1-63 bytes and value at most 1024", b.Name())
+	}
+	h.edits = append(h.edits, hookEdit{key: key, value: value})
+	return starlark.None, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// What the standalone engine does outside operating hours.
+const (
+	offHoursPause       = "pause"
+	offHoursScreensaver = "screensaver"
+)
+
+// OperatingHours runs the standalone engine at full speed only while an
+// installation is open, e.g. a lobby display:
+//
+//	operatingHours:
+//	  timezone: Europe/Berlin
+//	  open: "0 8 * * 1-5"
+//	  close: "30 19 * * 1-5"
+//	  outside: screensaver
+//	  screensaverInterval: 15s
+//
+// open and close are five-field cron expressions (minute hour day-of-month
+// month day-of-week, with *, lists, ranges and steps) in timezone, UTC by
+// default; the grid is open from an open time until the next close time.
+// Outside them the simulation pauses, or with outside: screensaver keeps
+// computing a generation every screensaverInterval (one minute by default).
+// Either way the chaos monkey rests.
+type OperatingHours struct {
+	Timezone            string          `json:"timezone,omitempty"`
+	Open                string          `json:"open"`
+	Close               string          `json:"close"`
+	Outside             string          `json:"outside,omitempty"`
+	ScreensaverInterval metav1.Duration `json:"screensaverInterval,omitempty"`
+
+	location    *time.Location
+	open, close *cronSchedule
+}
+
+func (h *OperatingHours) validate() error {
+	var err error
+	if h.location, err = time.LoadLocation(h.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	if h.open, err = parseCron(h.Open); err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	if h.close, err = parseCron(h.Close); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	switch h.Outside {
+	case "":
+		h.Outside = offHoursPause
+	case offHoursPause, offHoursScreensaver:
+	default:
+		return fmt.Errorf("outside must be %s or %s", offHoursPause, offHoursScreensaver)
+	}
+	if h.ScreensaverInterval.Duration < 0 {
+		return errors.New("screensaverInterval must not be negative")
+	}
+	if h.ScreensaverInterval.Duration == 0 {
+		h.ScreensaverInterval.Duration = time.Minute
+	}
+	return nil
+}
+
+// hoursLookback is how far back IsOpen looks for the last open or close
+// time; schedules that fire less than once a week are not supported.
+const hoursLookback = 8 * 24 * time.Hour
+
+// IsOpen reports whether the grid is open at t: whether the last scheduled
+// open time before t is more recent than the last close time.
+func (h *OperatingHours) IsOpen(t time.Time) bool {
+	t = t.In(h.location).Truncate(time.Minute)
+	for m := t; t.Sub(m) <= hoursLookback; m = m.Add(-time.Minute) {
+		// Closing wins when both fire in the same minute.
+		if h.close.matches(m) {
+			return false
+		}
+		if h.open.matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronSchedule is a parsed five-field cron expression.
+type cronSchedule struct {
+	minute, hour, dom, month, dow [64]bool
+	// domAny and dowAny are set for a *; as in cron, when both days are
+	// restricted either may match.
+	domAny, dowAny bool
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q: want 5 fields, minute hour day-of-month month day-of-week", spec)
+	}
+	c := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, f := range []struct {
+		set      *[64]bool
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}} {
+		if err := parseCronField(fields[i], f.min, f.max, f.set); err != nil {
+			return nil, fmt.Errorf("%q: field %d: %w", spec, i+1, err)
+		}
+	}
+	// Sunday is 0 or 7.
+	c.dow[0] = c.dow[0] || c.dow[7]
+	return c, nil
+}
+
+// parseCronField sets the values a field such as "*/15", "1-5" or "0,30"
+// selects.
+func parseCronField(field string, min, max int, set *[64]bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[t.Month()] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+var operatingHoursOpen = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_operating_hours_open",
+	Help: "1 while the grid is within its operating hours, 0 outside them.",
+})
+
+// hoursWatch switches the simulation in and out of operating hours as the
+// schedule says.
+type hoursWatch struct {
+	hours *OperatingHours
+	sim   *simulation
+	open  bool
+}
+
+func newHoursWatch(hours *OperatingHours, sim *simulation) *hoursWatch {
+	return &hoursWatch{hours: hours, sim: sim, open: true}
+}
+
+// Run checks the schedule every minute until ctx is done.
+func (w *hoursWatch) Run(ctx context.Context) {
+	w.check(time.Now())
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+func (w *hoursWatch) check(now time.Time) {
+	open := w.hours.IsOpen(now)
+	changed := open != w.open
+	w.open = open
+	if open {
+		operatingHoursOpen.Set(1)
+	} else {
+		operatingHoursOpen.Set(0)
+	}
+	if !changed {
+		return
+	}
+	if open {
+		log.Printf("Operating hours: open, running at full speed")
+		w.sim.SetOffHours(false)
+	} else if w.hours.Outside == offHoursScreensaver {
+		log.Printf("Operating hours: closed, a generation every %s and no chaos until the next open time", w.hours.ScreensaverInterval.Duration)
+		w.sim.SetOffHours(true)
+	} else {
+		log.Printf("Operating hours: closed, paused until the next open time")
+		w.sim.SetOffHours(true)
+	}
+	state := "closed"
+	if open {
+		state = "open"
+	}
+	w.sim.digests.Annotate("operating-hours", state)
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	clients   = make(map[*wsClient]bool)
+	broadcast = make(chan *Message)
+	upgrader  = websocket.Upgrader{
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: []string{"grid.v2", "grid.v1"},
+	}
+	seq       uint64
+	clientsMu sync.Mutex
+
+	hubConfig HubConfig
+)
+
+// hubHistory is how many recent messages the hub keeps for clients that
+// resume a stream; --profile=edge keeps fewer.
+var hubHistory = 1024
+
+// hubHistoryUnderPressure is how many it keeps past the memory budget.
+const hubHistoryUnderPressure = 64
+
+// State of the stream as dispatched so far, guarded by clientsMu.
+var (
+	// history holds the latest messages, oldest first.
+	history []*Message
+	// cellStates holds the latest update of every cell by scope, which new
+	// clients receive as a snapshot.
+	cellStates = map[string]map[string]*Message{}
+	// dispatched is the sequence number of the last message dispatched.
+	dispatched uint64
+)
+
+// HubConfig bounds how long the hub keeps unresponsive connections around.
+type HubConfig struct {
+	// IdleTimeout closes connections that sent nothing, not even a pong,
+	// for this long. Zero disables it.
+	IdleTimeout time.Duration
+	// SlowConsumerTimeout evicts clients whose send queue stays full for
+	// this long. Zero disables it.
+	SlowConsumerTimeout time.Duration
+	QueueSize           int
+}
+
+// Close reasons sent to evicted clients and used as metric labels.
+const (
+	closeIdle         = "idle timeout"
+	closeSlowConsumer = "slow consumer"
+	closeWriteError   = "write error"
+	closeGone         = "client gone"
+)
+
+var disconnectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_client_disconnects_total",
+	Help: "WebSocket disconnects by reason.",
+}, []string{"reason"})
+
+var droppedMessagesTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_client_dropped_messages_total",
+	Help: "Messages dropped because a client's send queue was full.",
+})
+
+var resyncsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_client_resyncs_total",
+	Help: "Snapshots sent to clients in place of the messages dropped from their full send queue.",
+})
+
+var deliveredMessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_client_delivered_messages_total",
+	Help: "Messages written to clients, by transport.",
+}, []string{"transport"})
+
+var sendQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grid_client_send_queue_depth",
+	Help: "Messages waiting in client send queues after the last broadcast: the deepest queue (max) and all queues together (total).",
+}, []string{"stat"})
+
+var deliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "grid_event_delivery_seconds",
+	Help:    "Time from the event a message reports, e.g. an informer pod update, until it was written to a client.",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"transport"})
+
+// Transports, used as metric labels.
+const (
+	transportWS  = "ws"
+	transportSSE = "sse"
+)
+
+// outbound is a message queued for one client.
+type outbound struct {
+	data []byte
+	seq  uint64
+	// origin is when the reported event happened.
+	origin time.Time
+}
+
+// wsClient is one WebSocket or server-sent events viewer. Messages are
+// queued on send and written by the client's own goroutine, so one stuck
+// browser cannot stall the hub.
+type wsClient struct {
+	// conn is nil for server-sent events.
+	conn    *websocket.Conn
+	version int
+	// grid is the grid created through /api/grids the client subscribed
+	// to; empty for the controller's own grid.
+	grid string
+	send chan outbound
+	// delivered counts messages written to the client.
+	deliveredCount atomic.Uint64
+	// saturatedSince is when the send queue last filled up, and missed
+	// whether messages were dropped since; guarded by clientsMu.
+	saturatedSince time.Time
+	missed         bool
+	closeOnce      sync.Once
+	closeReason    string
+	done           chan struct{}
+}
+
+// streamGrid checks the request's access to the grid it subscribes to and
+// returns the grid; it writes the error response otherwise. Grids created
+// through the API are only streamed to their owner, workshop participants
+// and holders of a share link.
+func streamGrid(w http.ResponseWriter, r *http.Request, grids *gridBootstrapper) (string, bool) {
+	grid := r.URL.Query().Get("grid")
+	if share := r.URL.Query().Get("share"); share != "" {
+		// Share links carry their own, read-only grant to one grid.
+		shared, err := verifyShareToken(share)
+		if err == nil && shared != grid {
+			err = errInvalidShare
+		}
+		if err == nil {
+			_, err = grids.Get(r.Context(), grid, "")
+		}
+		if err != nil {
+			http.Error(w, "Invalid or expired share link", http.StatusNotFound)
+			return "", false
+		}
+	} else if code := r.URL.Query().Get("code"); grid != "" && code != "" {
+		// Workshop participants join with the session's code instead.
+		if info, err := grids.Join(r.Context(), code); err != nil || info.Name != grid {
+			http.Error(w, "Unknown or expired join code", http.StatusNotFound)
+			return "", false
+		}
+	} else if k := apiKeyFor(r); k != nil && grid != "" {
+		// API keys are granted grids by name.
+		if !allowKey(w, r, grid, keyVerbView) {
+			return "", false
+		}
+		if _, err := grids.Get(r.Context(), grid, ""); err != nil {
+			http.Error(w, "Grid not found", http.StatusNotFound)
+			return "", false
+		}
+	} else if grid != "" {
+		tenant, ok := requireTenant(w, r)
+		if !ok {
+			return "", false
+		}
+		var owner string
+		if tenant != nil {
+			owner = tenant.Name
+		}
+		if _, err := grids.Get(r.Context(), grid, owner); err != nil {
+			http.Error(w, "Grid not found", http.StatusNotFound)
+			return "", false
+		}
+	}
+	return grid, true
+}
+
+func handleConnections(w http.ResponseWriter, r *http.Request, grids *gridBootstrapper) {
+	grid, ok := streamGrid(w, r, grids)
+	if !ok {
+		return
+	}
+
+	if !admitClient(w) {
+		return
+	}
+
+	since, resume := resumeFrom(r)
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has answered the request with an HTTP error.
+		log.Printf("Websocket upgrade failed: %v", err)
+		return
+	}
+	c := newClient(ws, negotiateProtocol(r, ws), grid)
+	register(c, since, resume)
+
+	go c.writePump()
+	go c.readPump()
+}
+
+func newClient(conn *websocket.Conn, version int, grid string) *wsClient {
+	return &wsClient{
+		conn:    conn,
+		version: version,
+		grid:    grid,
+		// Room for the banner and the snapshot at least.
+		send: make(chan outbound, max(hubConfig.QueueSize, 2)),
+		done: make(chan struct{}),
+	}
+}
+
+// resumeFrom returns the sequence number a reconnecting client last
+// received, from ?since= or, for server-sent events, Last-Event-ID.
+func resumeFrom(r *http.Request) (since uint64, ok bool) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		v = r.Header.Get("Last-Event-ID")
+	}
+	since, err := strconv.ParseUint(v, 10, 64)
+	return since, err == nil
+}
+
+// register queues the current banner for a new client, then either the
+// messages it missed since it last received since, when resuming and the
+// hub still has them all, or a snapshot of the cells, and adds it to the
+// hub. Both are queued under clientsMu, so the client's stream continues
+// without gaps or repeats from there.
+func register(c *wsClient, since uint64, resume bool) {
+	viewersByProtocol.WithLabelValues(strconv.Itoa(c.version)).Inc()
+
+	clientsMu.Lock()
+	// A banner the hub has yet to dispatch reaches the client like any
+	// other message.
+	if msg := currentBanner(); msg != nil && msg.Seq > 0 {
+		c.send <- outbound{data: msg.Encode(c.version), origin: time.Now()}
+	}
+	if !resume || !c.replay(since) {
+		c.snapshot()
+	}
+	clients[c] = true
+	viewersChanged()
+	clientsMu.Unlock()
+	idle.Activity()
+
+	log.Println("Client connected")
+}
+
+// wants reports whether a message is for the client's grid. Cell updates are
+// always scoped; other unscoped messages go to every client.
+func (c *wsClient) wants(msg *Message) bool {
+	return msg.Scope == c.grid || (msg.Scope == "" && msg.Type != msgCell)
+}
+
+// replay queues the messages after since for a resuming client. It returns
+// false, queueing nothing, when some are no longer kept or would not fit the
+// client's queue. clientsMu must be held.
+func (c *wsClient) replay(since uint64) bool {
+	if since > dispatched || (len(history) > 0 && history[0].Seq > since+1) || (len(history) == 0 && since != dispatched) {
+		return false
+	}
+	var missed []*Message
+	for _, msg := range history {
+		if msg.Seq > since && c.wants(msg) {
+			missed = append(missed, msg)
+		}
+	}
+	if len(missed) > cap(c.send)-len(c.send) {
+		return false
+	}
+	for _, msg := range missed {
+		c.send <- outbound{data: msg.Encode(c.version), seq: msg.Seq, origin: msg.Origin}
+	}
+	return true
+}
+
+// snapshot queues the latest state of every cell of the client's grid, as
+// of the last dispatched message. clientsMu must be held.
+func (c *wsClient) snapshot() {
+	snap := Snapshot{Cells: []CellUpdate{}}
+	for _, msg := range cellStates[c.grid] {
+		snap.Cells = append(snap.Cells, msg.Data.(CellUpdate))
+	}
+	sort.Slice(snap.Cells, func(a, b int) bool {
+		i, _ := cellIndex(snap.Cells[a].Name)
+		j, _ := cellIndex(snap.Cells[b].Name)
+		return i < j
+	})
+	now := time.Now()
+	msg := &Message{Type: msgSnapshot, Data: snap, Seq: dispatched, Time: now, Origin: now}
+	c.send <- outbound{data: msg.Encode(c.version), seq: msg.Seq, origin: now}
+}
+
+// remember keeps a dispatched message for snapshots and resumes. clientsMu
+// must be held.
+func remember(msg *Message) {
+	dispatched = msg.Seq
+	if n := historyLimit(); len(history) >= n {
+		history = append(history[:0], history[len(history)-n+1:]...)
+	}
+	history = append(history, msg)
+	if update, ok := msg.Data.(CellUpdate); ok && msg.Type == msgCell {
+		// Snapshots replace what clients know, so a deleted cell is left
+		// out, and with it the scope of a grid torn down
+		if update.Status == "deleted" {
+			delete(cellStates[msg.Scope], update.Name)
+			if len(cellStates[msg.Scope]) == 0 {
+				delete(cellStates, msg.Scope)
+			}
+			return
+		}
+		if cellStates[msg.Scope] == nil {
+			cellStates[msg.Scope] = map[string]*Message{}
+		}
+		cellStates[msg.Scope][update.Name] = msg
+	}
+}
+
+// forgetGrid drops the cell states of a grid that was deleted.
+func forgetGrid(scope string) {
+	clientsMu.Lock()
+	delete(cellStates, scope)
+	clientsMu.Unlock()
+}
+
+// historyLimit is how many messages the hub keeps; few while memory is
+// short, so resuming clients mostly fall back to snapshots.
+func historyLimit() int {
+	if memoryPressure.Load() {
+		return hubHistoryUnderPressure
+	}
+	return hubHistory
+}
+
+// shedHistory drops the kept messages but the latest few.
+func shedHistory() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if n := hubHistoryUnderPressure; len(history) > n {
+		history = append([]*Message(nil), history[len(history)-n:]...)
+	}
+}
+
+// readPump only watches for liveness; clients never send commands. Every
+// frame, including pongs, pushes the idle deadline out.
+func (c *wsClient) readPump() {
+	if hubConfig.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(hubConfig.IdleTimeout))
+		c.conn.SetPongHandler(func(string) error {
+			return c.conn.SetReadDeadline(time.Now().Add(hubConfig.IdleTimeout))
+		})
+	}
+	for {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				c.evict(closeIdle)
+			} else {
+				c.evict(closeGone)
+			}
+			return
+		}
+		if hubConfig.IdleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(hubConfig.IdleTimeout))
+		}
+	}
+}
+
+func (c *wsClient) writePump() {
+	var ping <-chan time.Time
+	if hubConfig.IdleTimeout > 0 {
+		t := time.NewTicker(min(hubConfig.IdleTimeout/2, 30*time.Second))
+		defer t.Stop()
+		ping = t.C
+	}
+	for {
+		select {
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, c.closeReason),
+				time.Now().Add(time.Second))
+			c.conn.Close()
+			return
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				log.Printf("Websocket error: %v", err)
+				c.evict(closeWriteError)
+				continue
+			}
+			c.delivered(transportWS, msg)
+		case <-ping:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				c.evict(closeWriteError)
+			}
+		}
+	}
+}
+
+// delivered records a message written to the client.
+func (c *wsClient) delivered(transport string, msg outbound) {
+	c.deliveredCount.Add(1)
+	deliveredMessagesTotal.WithLabelValues(transport).Inc()
+	deliveryLatency.WithLabelValues(transport).Observe(time.Since(msg.origin).Seconds())
+}
+
+// evict unregisters the client and makes the writer close the connection
+// with the given reason.
+func (c *wsClient) evict(reason string) {
+	c.closeOnce.Do(func() {
+		c.closeReason = reason
+		clientsMu.Lock()
+		delete(clients, c)
+		viewersChanged()
+		clientsMu.Unlock()
+		close(c.done)
+		viewersByProtocol.WithLabelValues(strconv.Itoa(c.version)).Dec()
+
+		disconnectsTotal.WithLabelValues(reason).Inc()
+		log.Printf("Client disconnected: %s (%d messages delivered)", reason, c.deliveredCount.Load())
+	})
+}
+
+func handleMessages(ctx context.Context) {
+	for {
+		var msg *Message
+		select {
+		case <-ctx.Done():
+			return
+		case msg = <-broadcast:
+		}
+		var slow []*wsClient
+		var deepest, queued int
+		clientsMu.Lock()
+		seq++
+		msg.Seq = seq
+		msg.Time = time.Now()
+		if msg.Origin.IsZero() {
+			msg.Origin = msg.Time
+		}
+		remember(msg)
+		for client := range clients {
+			if !client.wants(msg) {
+				continue
+			}
+			sent := false
+			if client.missed {
+				// A snapshot, which already reflects msg, makes up for the
+				// messages the client missed once it has room for one.
+				if sent = len(client.send) < cap(client.send); sent {
+					client.snapshot()
+					client.missed = false
+					resyncsTotal.Inc()
+				}
+			} else {
+				select {
+				case client.send <- outbound{data: msg.Encode(client.version), seq: msg.Seq, origin: msg.Origin}:
+					sent = true
+				default:
+				}
+			}
+			if sent {
+				client.saturatedSince = time.Time{}
+			} else {
+				droppedMessagesTotal.Inc()
+				client.missed = true
+				if client.saturatedSince.IsZero() {
+					client.saturatedSince = time.Now()
+				} else if hubConfig.SlowConsumerTimeout > 0 && time.Since(client.saturatedSince) > hubConfig.SlowConsumerTimeout {
+					slow = append(slow, client)
+				}
+			}
+			n := len(client.send)
+			deepest, queued = max(deepest, n), queued+n
+		}
+		clientsMu.Unlock()
+		sendQueueDepth.WithLabelValues("max").Set(float64(deepest))
+		sendQueueDepth.WithLabelValues("total").Set(float64(queued))
+		for _, client := range slow {
+			client.evict(closeSlowConsumer)
+		}
+	}
+}
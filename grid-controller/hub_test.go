@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nordiwnd/k3s-cellular-automaton/grid-controller/conformance"
+)
+
+// TestHubConformance runs the wire protocol conformance suite against the
+// hub, fed with a steady stream of cell updates.
+func TestHubConformance(t *testing.T) {
+	hubConfig = HubConfig{QueueSize: 4096}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleMessages(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		handleConnections(w, r, nil)
+	})
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		handleEvents(w, r, nil)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	publishBanner(BannerMessage{Text: "conformance", Severity: "info"})
+	go func() {
+		statuses := []string{"alive", "dead"}
+		for i := 0; ctx.Err() == nil; i++ {
+			publish(msgCell, CellUpdate{Name: fmt.Sprintf("cell-%d", i%100), Status: statuses[i/100%2], Namespace: "default"})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	conformance.Suite{URL: srv.URL, Timeout: 10 * time.Second}.Run(t)
+}
+
+// TestHubResyncsSaturatedClient fills a client's send queue so that the hub
+// drops messages for it, and checks that once the client drains it gets a
+// snapshot covering what it missed, with the stream continuing after it.
+func TestHubResyncsSaturatedClient(t *testing.T) {
+	saved := hubConfig
+	hubConfig = HubConfig{QueueSize: 4}
+	defer func() { hubConfig = saved }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleMessages(ctx)
+
+	const scope = "saturated-test"
+	c := newClient(nil, protocolV2, scope)
+	register(c, 0, false)
+	defer c.evict(closeGone)
+	// A message for another grid returns once the hub is done with the
+	// messages before it.
+	barrier := func() {
+		publishScoped("barrier-test", msgCell, CellUpdate{Name: "cell-0", Status: "deleted"})
+	}
+	drain := func() []Envelope {
+		barrier()
+		var out []Envelope
+		for {
+			select {
+			case o := <-c.send:
+				var env Envelope
+				if err := json.Unmarshal(o.data, &env); err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, env)
+			default:
+				return out
+			}
+		}
+	}
+	drain()
+
+	for i := range 10 {
+		publishScoped(scope, msgCell, CellUpdate{Name: fmt.Sprintf("cell-%d", i), Status: "alive", Grid: scope})
+	}
+	barrier()
+	clientsMu.Lock()
+	missed := c.missed
+	clientsMu.Unlock()
+	if !missed {
+		t.Fatal("the hub did not mark the client as having missed messages")
+	}
+	flooded := drain()
+	last := flooded[len(flooded)-1].Seq
+
+	publishScoped(scope, msgCell, CellUpdate{Name: "cell-10", Status: "alive", Grid: scope})
+	publishScoped(scope, msgCell, CellUpdate{Name: "cell-11", Status: "alive", Grid: scope})
+	resumed := drain()
+	var snap *Snapshot
+	seq := last
+	for _, env := range resumed {
+		if env.Seq <= seq {
+			t.Fatalf("message %d (%s) after %d", env.Seq, env.Type, seq)
+		}
+		seq = env.Seq
+		if env.Type == msgSnapshot && snap == nil {
+			snap = &Snapshot{}
+			if err := json.Unmarshal(env.Data, snap); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if snap == nil {
+		t.Fatalf("no snapshot after the client drained, got %+v", resumed)
+	}
+	names := map[string]bool{}
+	for _, cell := range snap.Cells {
+		names[cell.Name] = true
+	}
+	for i := range 10 {
+		if !names[fmt.Sprintf("cell-%d", i)] {
+			t.Errorf("snapshot lacks cell-%d", i)
+		}
+	}
+	if resumed[len(resumed)-1].Type != msgCell {
+		t.Errorf("the stream did not continue after the snapshot: %+v", resumed)
+	}
+	clientsMu.Lock()
+	missed = c.missed
+	clientsMu.Unlock()
+	if missed {
+		t.Error("the client is still marked as having missed messages")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyCache lets clients on flaky networks retry mutating requests
+// safely: a request carrying an Idempotency-Key header that the same caller
+// already used within the window gets the original successful response
+// replayed instead of being executed again.
+type idempotencyCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*idempotentResponse
+	lastPrune time.Time
+}
+
+type idempotentResponse struct {
+	// fingerprint catches a key reused for a different request.
+	fingerprint string
+	done        chan struct{}
+	expires     time.Time
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	return &idempotencyCache{window: window, entries: make(map[string]*idempotentResponse)}
+}
+
+// Wrap makes a handler honor Idempotency-Key. Requests without the header,
+// and safe methods, pass straight through.
+func (c *idempotencyCache) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || c.window <= 0 || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+		cacheKey := quotaCaller(r) + "\x00" + key
+
+		now := time.Now()
+		c.mu.Lock()
+		c.prune(now)
+		entry, ok := c.entries[cacheKey]
+		if ok && now.Before(entry.expires) {
+			c.mu.Unlock()
+			if entry.fingerprint != fingerprint {
+				http.Error(w, "Idempotency-Key reused for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			select {
+			case <-entry.done:
+			default:
+				http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+				return
+			}
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+		entry = &idempotentResponse{fingerprint: fingerprint, done: make(chan struct{}), expires: now.Add(c.window)}
+		c.entries[cacheKey] = entry
+		c.mu.Unlock()
+
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			entry.status, entry.header, entry.body = rec.status, w.Header().Clone(), rec.body.Bytes()
+			// Only successes are final. Errors, such as a 429 until
+			// Retry-After or a 503 while the engine restarts, let the
+			// client retry for real.
+			if rec.status < 200 || rec.status >= 300 {
+				c.mu.Lock()
+				delete(c.entries, cacheKey)
+				c.mu.Unlock()
+			}
+			close(entry.done)
+		}()
+		next(rec, r)
+	}
+}
+
+// prune drops expired entries at most once a minute; c.mu must be held.
+func (c *idempotencyCache) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < time.Minute {
+		return
+	}
+	c.lastPrune = now
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIdempotencyRetriesThrottled retries a throttled request with the same
+// Idempotency-Key after Retry-After: it must run again, and only its
+// success is replayed.
+func TestIdempotencyRetriesThrottled(t *testing.T) {
+	c := newIdempotencyCache(time.Hour)
+	calls := 0
+	h := c.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("spawned"))
+	})
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/spawn", strings.NewReader(`{"index":3}`))
+		r.Header.Set("Idempotency-Key", "k1")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("first attempt: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := send(); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry: status %d (replayed %q), want a fresh %d", w.Code, w.Header().Get("Idempotent-Replayed"), http.StatusCreated)
+	}
+	w := send()
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" || w.Body.String() != "spawned" {
+		t.Fatalf("second retry: status %d, body %q, replayed %q; want the success replayed", w.Code, w.Body.String(), w.Header().Get("Idempotent-Replayed"))
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+// TestIdempotencyRetriesErrors checks that each refused status, not
+// only server errors, leaves the key free for a real retry.
+func TestIdempotencyRetriesErrors(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		c := newIdempotencyCache(time.Hour)
+		calls := 0
+		h := c.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(status)
+		})
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest("POST", "/api/chaos", nil)
+			r.Header.Set("Idempotency-Key", "k")
+			h(httptest.NewRecorder(), r)
+		}
+		if calls != 2 {
+			t.Errorf("status %d: handler ran %d times, want 2", status, calls)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Verbs of pod writes.
+const (
+	verbCreate = "create"
+	verbDelete = "delete"
+	verbPatch  = "patch"
+)
+
+// Backoff of retried pod writes.
+const (
+	podWriteBaseDelay = 10 * time.Millisecond
+	podWriteMaxDelay  = 30 * time.Second
+)
+
+var (
+	podWritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grid_pod_writes_total",
+		Help: "Pod writes by verb and result: ok, not_found, exists, conflict, throttled, error or abandoned (the caller gave up before the write ran). Retried attempts count once each.",
+	}, []string{"verb", "result"})
+	podWriteSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grid_pod_write_duration_seconds",
+		Help:    "Time from queueing a pod write to its final result, by verb.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"verb"})
+	podWriteQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "grid_pod_write_queue_depth",
+		Help: "Pod writes waiting for their turn.",
+	})
+)
+
+var errPodWritesStopped = errors.New("pod write queue stopped")
+
+// podWrites queues every pod create, delete and patch of the controller. It
+// is nil outside the controller, e.g. in `grid-controller step`, where writes
+// run inline.
+var podWrites *podWriteQueue
+
+// podWrite is one queued write; its result is sent on done.
+type podWrite struct {
+	ctx    context.Context
+	verb   string
+	write  func(ctx context.Context) error
+	queued time.Time
+	done   chan error
+}
+
+// podWriteQueue runs pod writes at a bounded rate on a rate-limiting work
+// queue, so that a large reconcile or a burst of chaos cannot flood the API
+// server, and retries writes that fail with a conflict or are throttled
+// with exponential backoff.
+type podWriteQueue struct {
+	queue workqueue.TypedRateLimitingInterface[*podWrite]
+	// bucket admits new writes; retries wait for it and for their backoff.
+	bucket  *rate.Limiter
+	retries int
+}
+
+// newPodWriteQueue admits qps writes per second with bursts of burst, and
+// retries a conflicting or throttled write up to retries times.
+func newPodWriteQueue(qps float64, burst, retries int) *podWriteQueue {
+	bucket := rate.NewLimiter(rate.Limit(qps), burst)
+	limiter := workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[*podWrite](podWriteBaseDelay, podWriteMaxDelay),
+		&workqueue.TypedBucketRateLimiter[*podWrite]{Limiter: bucket},
+	)
+	return &podWriteQueue{
+		queue:   workqueue.NewTypedRateLimitingQueueWithConfig(limiter, workqueue.TypedRateLimitingQueueConfig[*podWrite]{Name: "pod-writes"}),
+		bucket:  bucket,
+		retries: retries,
+	}
+}
+
+// Do queues a write and waits for its result, or for ctx to end. It is safe
+// to call on a nil queue, which runs the write inline.
+func (q *podWriteQueue) Do(ctx context.Context, verb string, write func(ctx context.Context) error) error {
+	if q == nil {
+		err := write(ctx)
+		podWritesTotal.WithLabelValues(verb, podWriteResult(err)).Inc()
+		return err
+	}
+	if q.queue.ShuttingDown() {
+		return errPodWritesStopped
+	}
+	w := &podWrite{ctx: ctx, verb: verb, write: write, queued: time.Now(), done: make(chan error, 1)}
+	// A new write only waits for its turn in the bucket; the backoff of
+	// AddRateLimited is for retries, which NumRequeues counts.
+	if delay := q.bucket.Reserve().Delay(); delay > 0 {
+		q.queue.AddAfter(w, delay)
+	} else {
+		q.queue.Add(w)
+	}
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run processes writes on workers goroutines until ctx is done.
+func (q *podWriteQueue) Run(ctx context.Context, workers int) {
+	for range workers {
+		go func() {
+			for q.process() {
+			}
+		}()
+	}
+	<-ctx.Done()
+	q.queue.ShutDown()
+}
+
+func (q *podWriteQueue) process() bool {
+	w, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(w)
+	podWriteQueueDepth.Set(float64(q.queue.Len()))
+
+	if err := w.ctx.Err(); err != nil {
+		q.queue.Forget(w)
+		podWritesTotal.WithLabelValues(w.verb, "abandoned").Inc()
+		w.done <- err
+		return true
+	}
+	err := w.write(w.ctx)
+	result := podWriteResult(err)
+	podWritesTotal.WithLabelValues(w.verb, result).Inc()
+	if (result == "conflict" || result == "throttled") && q.queue.NumRequeues(w) < q.retries {
+		q.queue.AddRateLimited(w)
+		return true
+	}
+	q.queue.Forget(w)
+	podWriteSeconds.WithLabelValues(w.verb).Observe(time.Since(w.queued).Seconds())
+	w.done <- err
+	return true
+}
+
+func podWriteResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case apierrors.IsNotFound(err):
+		return "not_found"
+	case apierrors.IsAlreadyExists(err):
+		return "exists"
+	case apierrors.IsConflict(err):
+		return "conflict"
+	case apierrors.IsTooManyRequests(err):
+		return "throttled"
+	}
+	return "error"
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// primaryLease elects the controller that computes generations and owns
+// cell pods among a primary and its mirrors (--primary-lease). The holder
+// renews the Lease; when it stops, e.g. because its region is down, a mirror
+// takes the Lease over and promotes itself, and a former primary that finds
+// the Lease taken stops until it gets it back. It then carries on from the
+// newest snapshot or the cell pods, not from its own stale state.
+type primaryLease struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+
+	// onLead runs while this controller holds the Lease; ctx is cancelled
+	// when it loses it. onLose is also called when it never held it.
+	onLead func(ctx context.Context)
+	onLose func()
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	sitOut   bool
+	holding  bool
+	observed string
+}
+
+func newPrimaryLease(clientset kubernetes.Interface, namespace, name string, duration time.Duration) *primaryLease {
+	identity := os.Getenv("HOSTNAME")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return &primaryLease{clientset: clientset, namespace: namespace, name: name, identity: identity, duration: duration}
+}
+
+// Run stands for the Lease until ctx is done. After stepping down it sits
+// out one lease duration, so the controller it stepped down for gets the
+// Lease first.
+func (l *primaryLease) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		runCtx, cancel := context.WithCancel(ctx)
+		l.mu.Lock()
+		l.cancel = cancel
+		l.mu.Unlock()
+
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta:  metav1.ObjectMeta{Name: l.name, Namespace: l.namespace},
+				Client:     l.clientset.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{Identity: l.identity},
+			},
+			LeaseDuration:   l.duration,
+			RenewDeadline:   l.duration * 2 / 3,
+			RetryPeriod:     l.duration / 5,
+			ReleaseOnCancel: true,
+			Name:            l.name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					l.setHolding(true)
+					log.Printf("Lease: %s holds %s", l.identity, l.name)
+					l.onLead(ctx)
+				},
+				OnStoppedLeading: func() {
+					if l.setHolding(false) {
+						log.Printf("Lease: %s no longer holds %s", l.identity, l.name)
+					}
+					l.onLose()
+				},
+				OnNewLeader: func(identity string) {
+					l.mu.Lock()
+					l.observed = identity
+					l.mu.Unlock()
+				},
+			},
+		})
+		if err != nil {
+			log.Fatalf("Lease: %s", err.Error())
+		}
+		elector.Run(runCtx)
+		cancel()
+
+		l.mu.Lock()
+		wait := l.duration / 5
+		if l.sitOut {
+			wait, l.sitOut = l.duration, false
+		}
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
+
+// setHolding records whether the Lease is held and reports whether that
+// changed.
+func (l *primaryLease) setHolding(holding bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changed := l.holding != holding
+	l.holding = holding
+	return changed
+}
+
+// Holder returns the last observed holder of the Lease.
+func (l *primaryLease) Holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.observed
+}
+
+// Take makes this controller the holder even though the Lease is still
+// valid, for a promotion on an operator's word. The former holder fails its
+// next renewal and stops.
+func (l *primaryLease) Take(ctx context.Context) error {
+	leases := l.clientset.CoordinationV1().Leases(l.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(l.duration / time.Second)
+	lease, err := leases.Get(ctx, l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: l.name, Namespace: l.namespace}}
+		lease.Spec = coordinationv1.LeaseSpec{HolderIdentity: &l.identity, LeaseDurationSeconds: &seconds, AcquireTime: &now, RenewTime: &now}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if h := lease.Spec.HolderIdentity; h == nil || *h != l.identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &l.identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// StepDown releases the Lease and sits out one lease duration before
+// standing again.
+func (l *primaryLease) StepDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sitOut = true
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Conditions of a live cell derived from its pod's lifecycle, streamed with
+// cell updates alongside the pod status.
+const (
+	// conditionDying: the pod is shutting down, e.g. running its PreStop hook.
+	conditionDying = "dying"
+	// conditionSick: a container is in CrashLoopBackOff.
+	conditionSick = "sick"
+	// conditionZombie: a container died and was restarted in place.
+	conditionZombie = "zombie"
+)
+
+// LifecycleConfig lets pod failure modes play into the standalone engine's
+// rule, e.g.
+//
+//	lifecycle:
+//	  restarts: 3
+//	  sick: {sterile: true}
+//	  zombie: {immortal: true}
+//	  dying: {doomed: true}
+//
+// A running cell becomes a zombie once its containers have restarted
+// restarts times (default 1). Conditions are streamed whether or not this
+// section is set; without it they do not affect the rule.
+type LifecycleConfig struct {
+	Restarts int32           `json:"restarts,omitempty"`
+	Dying    LifecycleEffect `json:"dying,omitempty"`
+	Sick     LifecycleEffect `json:"sick,omitempty"`
+	Zombie   LifecycleEffect `json:"zombie,omitempty"`
+}
+
+// LifecycleEffect is how cells in one condition take part in the rule.
+type LifecycleEffect struct {
+	// Sterile cells do not count as neighbors of dead cells, so they cannot
+	// bring cells to life.
+	Sterile bool `json:"sterile,omitempty"`
+	// Doomed cells die in the next generation whatever the rule says;
+	// Immortal cells survive it.
+	Doomed   bool `json:"doomed,omitempty"`
+	Immortal bool `json:"immortal,omitempty"`
+}
+
+func (c *LifecycleConfig) validate() error {
+	if c.Restarts < 0 {
+		return errors.New("restarts must not be negative")
+	}
+	if c.Restarts == 0 {
+		c.Restarts = 1
+	}
+	for _, e := range []LifecycleEffect{c.Dying, c.Sick, c.Zombie} {
+		if e.Doomed && e.Immortal {
+			return errors.New("a condition cannot be both doomed and immortal")
+		}
+	}
+	return nil
+}
+
+// bendsRule reports whether any condition affects the rule.
+func (c *LifecycleConfig) bendsRule() bool {
+	return c.Dying != (LifecycleEffect{}) || c.Sick != (LifecycleEffect{}) || c.Zombie != (LifecycleEffect{})
+}
+
+// podCondition returns the condition of a cell pod, or "" for a healthy
+// one. Dying takes precedence over sick, and sick over zombie.
+func podCondition(pod *v1.Pod, restarts int32) string {
+	if pod.DeletionTimestamp != nil {
+		return conditionDying
+	}
+	var restarted int32
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+			return conditionSick
+		}
+		restarted += cs.RestartCount
+	}
+	if restarts <= 0 {
+		restarts = 1
+	}
+	if restarted >= restarts && pod.Status.Phase == v1.PodRunning {
+		return conditionZombie
+	}
+	return ""
+}
+
+// cellConditions applies the lifecycle effects while the engine steps. It
+// runs under the engine's lock, and its methods are safe to call on a nil
+// value.
+type cellConditions struct {
+	effects map[string]LifecycleEffect
+	of      map[int]string
+}
+
+func newCellConditions(cfg *LifecycleConfig) *cellConditions {
+	if cfg == nil {
+		return nil
+	}
+	return &cellConditions{
+		effects: map[string]LifecycleEffect{conditionDying: cfg.Dying, conditionSick: cfg.Sick, conditionZombie: cfg.Zombie},
+		of:      map[int]string{},
+	}
+}
+
+func (c *cellConditions) effect(i int) LifecycleEffect {
+	if c == nil {
+		return LifecycleEffect{}
+	}
+	return c.effects[c.of[i]]
+}
+
+func (c *cellConditions) set(i int, condition string) {
+	if c == nil {
+		return
+	}
+	if condition == "" {
+		delete(c.of, i)
+	} else {
+		c.of[i] = condition
+	}
+}
+
+// SetCondition records the condition of a live cell's pod; "" clears it.
+// Conditions of dead cells are ignored.
+func (e *Engine) SetCondition(index int, condition string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conditions == nil || (condition != "" && !e.live.Has(index)) {
+		return
+	}
+	e.conditions.set(index, condition)
+}
+
+// PodCondition passes the condition of a cell pod on to the engine. It is
+// safe to call on a nil simulation.
+func (s *simulation) PodCondition(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if s == nil || !ok || pod.Labels["app"] != "cell" || s.cells.Retired(pod.Name) {
+		return
+	}
+	if i, ok := cellIndex(pod.Name); ok {
+		s.engine.SetCondition(i, podCondition(pod, zombieRestarts))
+	}
+}
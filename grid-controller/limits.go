@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestLimits bounds what a single HTTP request may consume.
+type RequestLimits struct {
+	// MaxBody caps request bodies, including pattern uploads.
+	MaxBody int64
+	// MaxArchive caps archive imports, which carry a whole grid and its
+	// statistics.
+	MaxArchive int64
+	// Timeout is the deadline of each request's context, and so of the
+	// Kubernetes calls made on its behalf. WebSocket and event streams are
+	// exempt.
+	Timeout time.Duration
+}
+
+var requestLimits RequestLimits
+
+// Wrap applies the limits to every request a handler serves.
+func (l RequestLimits) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocketUpgrade(r) || r.URL.Path == "/api/events" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := l.MaxBody
+		if strings.HasPrefix(r.URL.Path, "/api/archive") {
+			limit = l.MaxArchive
+		}
+		if limit > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		if l.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), l.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newHTTPServer returns a server that drops clients too slow to send their
+// request headers or that hold idle keep-alive connections open. Reads and
+// writes are not bounded as a whole, since WebSocket streams last for hours;
+// RequestLimits bounds the handlers instead.
+func newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
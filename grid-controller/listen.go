@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IP families of TCP listeners (--ip-family).
+const (
+	// ipFamilyAuto binds wildcard hosts dual-stack where the host supports
+	// it and falls back to whichever family is available.
+	ipFamilyAuto = "auto"
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+	// ipFamilyDual requires every TCP listener to accept both families.
+	ipFamilyDual = "dual"
+)
+
+// checkIPFamily fails unless the host can serve the families the flag asks
+// for, so an IPv6-only node does not start a controller nobody can reach.
+func checkIPFamily(family string) error {
+	var probes []string
+	switch family {
+	case ipFamilyAuto:
+	case ipFamilyIPv4:
+		probes = []string{"tcp4"}
+	case ipFamilyIPv6:
+		probes = []string{"tcp6"}
+	case ipFamilyDual:
+		probes = []string{"tcp4", "tcp6"}
+	default:
+		return fmt.Errorf("unknown IP family %q (want auto, ipv4, ipv6 or dual)", family)
+	}
+	for _, network := range probes {
+		addr := "127.0.0.1:0"
+		if network == "tcp6" {
+			addr = "[::1]:0"
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return fmt.Errorf("%s is not available on this host: %w", network, err)
+		}
+		l.Close()
+	}
+	return nil
+}
+
+// listenTCP opens a TCP listener restricted to family. A literal host must
+// belong to the family; dual-stack needs a wildcard host such as :8080 or
+// [::]:8080, and is verified on the bound socket.
+func listenTCP(addr, family string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip, literal := netip.ParseAddr(host)
+	isIP := literal == nil
+	network := "tcp"
+	switch family {
+	case ipFamilyIPv4:
+		if isIP && !ip.Unmap().Is4() {
+			return nil, fmt.Errorf("%s is not an IPv4 address", host)
+		}
+		network = "tcp4"
+	case ipFamilyIPv6:
+		if isIP && ip.Is4() {
+			return nil, fmt.Errorf("%s is not an IPv6 address", host)
+		}
+		network = "tcp6"
+	case ipFamilyDual:
+		if host != "" && !(isIP && ip.Is6() && ip.IsUnspecified()) {
+			return nil, fmt.Errorf("dual-stack listeners need a wildcard host such as :port or [::]:port, not %q", host)
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Go silently binds IPv4 only where the kernel has IPv6 disabled.
+	if family == ipFamilyDual {
+		if bound, ok := l.Addr().(*net.TCPAddr); ok && bound.IP.To4() != nil {
+			l.Close()
+			return nil, fmt.Errorf("%s bound IPv4 only; IPv6 is unavailable for dual-stack", addr)
+		}
+	}
+	return l, nil
+}
+
+// listen opens the listener described by addr:
+//
+//	host:port       TCP in the given --ip-family, e.g. :8080, [::]:8080 or
+//	                0.0.0.0:8080
+//	unix:/path      Unix domain socket, e.g. for a local reverse proxy
+//	systemd[:name]  socket passed by systemd socket activation, optionally
+//	                selected by its FileDescriptorName
+func listen(addr, family string, socketMode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(addr, "unix:")
+		// A socket left behind by a previous run would make bind fail
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, socketMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		return activatedListener(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	default:
+		return listenTCP(addr, family)
+	}
+}
+
+// First file descriptor passed by systemd (SD_LISTEN_FDS_START).
+const listenFdsStart = 3
+
+// activatedListener picks a socket handed over by systemd. With an empty name
+// the first socket is used.
+func activatedListener(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < n; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), "systemd-socket-"+strconv.Itoa(i))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("activated socket %d: %w", i, err)
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("no activated socket named %q", name)
+}
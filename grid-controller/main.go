@@ -2,106 +2,1041 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
+	pb "github.com/nordiwnd/k3s-cellular-automaton/grid-controller/proto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/homedir"
-)
-
-var (
-	clients   = make(map[*websocket.Conn]bool)
-	broadcast = make(chan []byte)
-	upgrader  = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	clientsMu sync.Mutex
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
 type CellUpdate struct {
 	Name      string `json:"name"`
 	Status    string `json:"status"`
 	Namespace string `json:"namespace"`
+	// Grid identifies the source grid when several are streamed together.
+	Grid string `json:"grid,omitempty"`
+	// Genome and Color describe the cell's lineage when genetics is enabled.
+	Genome string `json:"genome,omitempty"`
+	Color  string `json:"color,omitempty"`
+	// Condition is dying, sick or zombie when the pod's lifecycle says so.
+	Condition string `json:"condition,omitempty"`
+	// Intensity is a super-cell's replica count, 1 to 3 while alive.
+	Intensity int `json:"intensity,omitempty"`
 }
 
+// gridID tags updates about this controller's own cells.
+var gridID string
+
+// zombieRestarts is how many container restarts make a cell a zombie.
+var zombieRestarts int32 = 1
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench-engine" {
+		os.Exit(runEngineBench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "step" {
+		os.Exit(runStep(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-replay" {
+		os.Exit(runVerifyReplay(os.Args[2:]))
+	}
+
 	var kubeconfig *string
 	if home := homedir.HomeDir(); home != "" {
 		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
 	} else {
 		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
 	}
+	configPath := flag.String("config", "", "path to the controller configuration file (YAML), e.g. alert rules")
+	placement := flag.String("placement", placementNone, "cell placement policy: none (cell StatefulSet schedules pods) or geography (controller creates cell pods pinned to nodes by grid region)")
+	placementNodes := flag.String("placement-node-selector", "", "label selector for nodes eligible to host grid regions in geography mode")
+	cellObjects := flag.String("cell-objects", cellObjectPods, "what materializes the standalone engine's cells: pods (one pod per live cell), deployments (a Deployment per cell scaled to 0-3 replicas by the cell's intensity) or jobs (a short-lived Job per birth, shown as a fading spark)")
+	cellImage := flag.String("cell-image", "ghcr.io/nordiwnd/k3s-cellular-automaton/cells-worker:latest", "cell worker image for controller-managed cell pods")
+	engineMode := flag.String("engine", engineCells, "simulation engine: cells (workers compute their own state) or standalone (controller computes generations and materializes live cells as pods)")
+	tickInterval := flag.Duration("tick-interval", time.Second, "generation interval of the standalone engine")
+	flag.IntVar(&ruleWorkers, "rule-workers", ruleWorkers, "goroutines computing each generation of the standalone engine, GOMAXPROCS by default; grids below 16384 cells always use one")
+	tickSource := flag.String("tick-source", tickInternal, "what advances the standalone engine: internal (every --tick-interval) or external (only POST /api/simulation/tick; --tick-interval then bounds each generation's computation)")
+	grpcAddr := flag.String("grpc-addr", "", "listen address of the controller gRPC API used by federation peers, e.g. :50052")
+	fedRowOffset := flag.Int("federation-row-offset", 0, "global row of this controller's band in a federated grid")
+	fedNorth := flag.String("federation-north", "", "gRPC address of the controller owning the band above this one")
+	fedSouth := flag.String("federation-south", "", "gRPC address of the controller owning the band below this one")
+	fedMembers := flag.String("federation-members", "", "comma-separated gRPC addresses of federation members to aggregate into this controller's WebSocket stream")
+	flag.StringVar(&gridID, "grid-id", "", "grid ID attached to this controller's own updates")
+	flag.BoolVar(&offline, "offline", false, "refuse every outbound call, for air-gapped installations: configuring webhooks, webhook rule engines, OIDC or the Twitch chat bridge is an error. Outbound calls otherwise honor HTTPS_PROXY, HTTP_PROXY and NO_PROXY")
+	apiKeysSecret := flag.String("api-keys-secret", "", "name of a Secret in the controller's namespace storing API keys managed through /api/keys; keys grant view, spawn, kill and control on named grids, this controller's being its --grid-id. Anonymous callers may no longer spawn, kill or delete pods while it is set")
+	viewerBirths := flag.Float64("viewer-births", 0, "expected spontaneous births per tick per connected viewer (standalone engine)")
+	flag.DurationVar(&hubConfig.IdleTimeout, "client-idle-timeout", 5*time.Minute, "close WebSocket connections that have not answered pings for this long")
+	flag.DurationVar(&hubConfig.SlowConsumerTimeout, "client-slow-timeout", 30*time.Second, "evict WebSocket clients whose send queue stays full for this long")
+	flag.IntVar(&hubConfig.QueueSize, "client-queue", 256, "per-connection send queue length")
+	listenAddrs := flag.String("listen", ":8080", "comma-separated HTTP listeners: host:port, unix:/path/to.sock, or systemd[:name] for socket activation")
+	controlAddrs := flag.String("control-listen", "", "comma-separated listeners for control and admin endpoints; when set, --listen only serves the read-only stream and APIs")
+	ipFamily := flag.String("ip-family", ipFamilyAuto, "address family of TCP listeners, including --grpc-addr: auto (dual-stack where the host supports it), ipv4, ipv6, or dual (refuse to start unless both are served)")
+	socketMode := flag.Uint("unix-socket-mode", 0660, "file mode of Unix domain sockets created by --listen")
+	auditPath := flag.String("audit-log", "", "append-only JSONL access log of every mutating request; disabled when empty")
+	auditMaxSize := flag.Int64("audit-log-max-size", 10, "size in MiB at which the audit log is rotated")
+	auditKeep := flag.Int("audit-log-keep", 5, "number of rotated audit logs to retain")
+	reconcileInterval := flag.Duration("reconcile-interval", 30*time.Second, "how often controller-managed cell pods are compared with the desired grid and repaired")
+	writeQPS := flag.Float64("pod-write-qps", 5, "pod creates, deletes and patches per second, across the controller")
+	writeBurst := flag.Int("pod-write-burst", 10, "burst of pod writes allowed above --pod-write-qps")
+	writeRetries := flag.Int("pod-write-retries", 5, "retries of a pod write that conflicts or is throttled by the API server")
+	pendingTimeout := flag.Duration("pending-timeout", 2*time.Minute, "replace controller-managed cell pods stuck in Pending for longer than this; 0 disables")
+	streamGrids := flag.Bool("stream-grids", false, "watch the cells of grids created through /api/grids in every namespace and stream them to WebSocket clients connecting with ?grid=<name>")
+	previewImagePrefix := flag.String("preview-image-prefix", "", "image prefix, e.g. ghcr.io/org/cell:pr-, that preview grids may run instead of --cell-image; empty allows only --cell-image")
+	sessionReap := flag.Duration("session-reap-interval", time.Minute, "how often expired workshop sessions are snapshotted and deleted, and expired preview grids deleted; 0 disables")
+	idempotencyWindow := flag.Duration("idempotency-window", time.Hour, "how long responses to requests with an Idempotency-Key are kept for replay; 0 disables")
+	maintenancePause := flag.Bool("maintenance-pause", false, "freeze the simulation and show a banner while any node is cordoned, e.g. during a k3s upgrade")
+	recoverState := flag.Bool("recover", true, "on startup resume the standalone engine from the newest snapshot, or from the live cell pods, instead of reseeding")
+	flag.Int64Var(&requestLimits.MaxBody, "max-body-size", 1<<20, "maximum request body in bytes, including pattern uploads")
+	flag.Int64Var(&requestLimits.MaxArchive, "max-archive-size", 64<<20, "maximum archive import in bytes")
+	flag.DurationVar(&requestLimits.Timeout, "request-timeout", 30*time.Second, "deadline of each HTTP request, including the Kubernetes calls it makes; WebSocket streams are exempt")
+	flag.Var(featureGates, "feature-gates", "comma-separated Name=bool pairs enabling or disabling experimental features: "+strings.Join(featureNames(), ", "))
+	ruleEngine := flag.String("rule-engine", "life", "transition rule of the standalone engine: life, plugin:/path/to/rule.so (a Go plugin exporting func Next(uint16) bool; needs a cgo build) or grpc://host:port (a rule server, see proto/rule.proto) or an http(s) URL (a webhook that is POSTed each generation and falls back to life when it fails)")
+	ruleTimeout := flag.Duration("rule-timeout", 500*time.Millisecond, "deadline of each call to a rule server or webhook; 0 leaves only the tick's own deadline")
+	cellMetricsInterval := flag.Duration("cell-metrics-interval", 0, "how often cell pod CPU and memory usage is read from metrics-server and streamed as cell_metrics messages; 0 disables")
+	preflightMode := flag.String("preflight", preflightWarn, "startup checks of the namespace, API groups and RBAC permissions the configured features need: warn (log failures and keep /readyz failing), strict (exit on failures) or off")
+	profile := flag.String("profile", profileDefault, "resource profile: default, or edge to tune queues, rule workers, history retention, pod write rates and the memory budget to the detected CPUs and memory and turn off cache audits and cell metrics, for Raspberry Pi-class k3s nodes; flags given explicitly still win")
+	memoryBudgetFlag := flag.Int64("memory-budget", 0, "heap size in bytes past which the controller sheds load: it trims in-memory histories and refuses new viewers until memory is back; 0 uses 80% of the container's memory limit, negative disables")
+	scaleToZeroAfter := flag.Duration("scale-to-zero-after", 0, "delete every cell pod once the standalone engine's grid has had no viewers and no API requests for this long, keeping its state in memory, and re-create them when the next viewer or request arrives; 0 disables")
+	scaleToZeroSnapshot := flag.Bool("scale-to-zero-snapshot", true, "take a snapshot before scaling to zero, in case the controller restarts while the grid sleeps")
+	cacheAuditInterval := flag.Duration("cache-audit-interval", 5*time.Minute, "how often the pod informer's cache is compared with a live LIST of the namespace, exported as grid_informer_divergent_pods; 0 disables")
+	cacheAuditSample := flag.Int("cache-audit-sample", 500, "pods compared by each cache audit; successive audits page through the namespace")
+	discoveryService := flag.String("discovery-service", "", "Service in the controller's namespace to annotate with the /api/discovery document on startup; empty disables")
+	mirrorOf := flag.String("mirror", "", "gRPC address (--grpc-addr) of a primary controller to follow as a read-only mirror serving local viewers; needs --engine=standalone and a grid of the same size. POST /api/mirror/promote takes over from the primary, POST /api/mirror/demote follows it again")
+	primaryLeaseName := flag.String("primary-lease", "", "name of a Lease in the controller's namespace electing the primary among controllers sharing a cluster, e.g. a primary and its --mirror; only the holder computes generations and owns cell pods, and a mirror holding it is promoted. Needs --engine=standalone")
+	primaryLeaseDuration := flag.Duration("primary-lease-duration", 15*time.Second, "how long the primary Lease stays valid without renewal, i.e. how soon a mirror takes over from a primary that went away")
+	renderSource := flag.String("render-source", renderLabels, "where streamed cell states come from: labels (each pod's game-status label) or endpoints (the readiness of each pod's endpoints in the EndpointSlices matching --render-selector, i.e. network reachability)")
+	renderSelector := flag.String("render-selector", "app=cell", "label selector of the EndpointSlices rendered with --render-source=endpoints; slices carry their Service's labels, so this picks per-cell Services or the headless cell Service")
+	aggregate := flag.String("aggregate", "", "comma-separated id=url list of independent controllers to republish under their grid ID; url is ws://host/ws or grpc://host:port")
 	flag.Parse()
+	if err := applyProfile(*profile); err != nil {
+		log.Fatalf("Profile: %s", err.Error())
+	}
+	reportFeatures()
+	if err := checkIPFamily(*ipFamily); err != nil {
+		log.Fatalf("IP family: %s", err.Error())
+	}
+
+	// ctx is cancelled on SIGINT or SIGTERM; everything long-running stops
+	// with it, and in-flight Kubernetes calls are abandoned.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Use in-cluster config if available, otherwise fallback to kubeconfig
-	config, err := rest.InClusterConfig()
+	if *writeQPS <= 0 || *writeBurst < 1 || *writeRetries < 0 {
+		log.Fatalf("Pod writes: --pod-write-qps and --pod-write-burst must be positive and --pod-write-retries not negative")
+	}
+	podWrites = newPodWriteQueue(*writeQPS, *writeBurst, *writeRetries)
+	go podWrites.Run(ctx, max(1, *writeBurst))
+
+	config, err := restConfig(*kubeconfig)
 	if err != nil {
-		config, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
-		if err != nil {
-			log.Fatalf("Error building kubeconfig: %s", err.Error())
-		}
+		log.Fatalf("Error building kubeconfig: %s", err.Error())
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error building clientset: %s", err.Error())
 	}
+	metrics, err := metricsclient.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Error building metrics clientset: %s", err.Error())
+	}
 
 	namespace := os.Getenv("NAMESPACE")
 	if namespace == "" {
 		namespace = "cellular-automaton"
 	}
 
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %s", err.Error())
+	}
+	if offline {
+		if err := checkOffline(cfg); err != nil {
+			log.Fatalf("--offline: %s", err.Error())
+		}
+		log.Printf("Offline: outbound calls are disabled")
+	}
+	if cfg.Lifecycle != nil {
+		zombieRestarts = cfg.Lifecycle.Restarts
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(namespace)})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "grid-controller"})
+	alerts := newAlertEngine(cfg.Alerts, recorder, namespace)
+	var osc *oscOutput
+	if cfg.OSC != nil {
+		if osc, err = newOSCOutput(cfg.OSC.Prefix, cfg.OSC.Targets...); err != nil {
+			log.Fatalf("OSC: %s", err.Error())
+		}
+		alerts.osc = osc
+		log.Printf("OSC: sending %s events to %s", cfg.OSC.Prefix, strings.Join(cfg.OSC.Targets, ", "))
+	}
+	tenants = cfg.Tenants
+	setBots(cfg.Bots)
+	quotas = newQuotaTracker(cfg.Quotas)
+	if *apiKeysSecret != "" {
+		apiKeys = newAPIKeyStore(clientset, namespace, *apiKeysSecret)
+		go apiKeys.Run(ctx)
+	}
+	if cfg.OIDC != nil {
+		oidcCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		oidcAuth, err = newOIDCAuthenticator(oidcCtx, *cfg.OIDC)
+		cancel()
+		if err != nil {
+			log.Fatalf("OIDC: %s", err.Error())
+		}
+		log.Printf("OIDC: accepting ID tokens from %s", cfg.OIDC.Issuer)
+	}
+
+	// Grid geometry is shared with the workers via the cell-config ConfigMap
+	width := envInt("GRID_WIDTH", 10)
+	grid := GridGeometry{Width: width, Height: envInt("GRID_HEIGHT", width)}
+	cellErrors = newCellErrorReporter(grid)
+
 	// Start Informer
 	factory := informers.NewSharedInformerFactoryWithOptions(clientset, time.Minute*10, informers.WithNamespace(namespace))
 	podInformer := factory.Core().V1().Pods().Informer()
+	if err := podInformer.AddIndexers(cellIndexers(grid)); err != nil {
+		log.Fatalf("Pod indexers: %s", err.Error())
+	}
+
+	// background tracks goroutines that must finish cleanly on shutdown.
+	var background sync.WaitGroup
+
+	// Geography placement and the standalone engine both need the controller
+	// to own cell pods. The cell StatefulSet must not be deployed with either.
+	var cells *cellPodManager
+	if *placement != placementNone || *engineMode != engineCells {
+		cells = newCellPodManager(clientset, namespace, grid, *cellImage, factory.Core().V1().Pods().Lister())
+		cells.pendingTimeout = *pendingTimeout
+		if cfg.CellTemplate != nil {
+			cells.SetTemplate(*cfg.CellTemplate)
+		}
+	} else if cfg.CellTemplate != nil {
+		log.Fatalf("cellTemplate requires --engine=%s or --placement=%s", engineStandalone, placementGeography)
+	}
+	syncedFns := []cache.InformerSynced{podInformer.HasSynced}
+
+	var planner *placementPlanner
+	switch *placement {
+	case placementNone:
+	case placementGeography:
+		selector, err := labels.Parse(*placementNodes)
+		if err != nil {
+			log.Fatalf("Invalid --placement-node-selector: %s", err.Error())
+		}
+		planner = newPlacementPlanner(grid, selector)
+		cells.affinity = planner.Affinity
+
+		nodeInformer := factory.Core().V1().Nodes().Informer()
+		nodeLister := factory.Core().V1().Nodes().Lister()
+		nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { planner.Resync(nodeLister) },
+			UpdateFunc: func(oldObj, newObj interface{}) { planner.Resync(nodeLister) },
+			DeleteFunc: func(obj interface{}) { planner.Resync(nodeLister) },
+		})
+		syncedFns = append(syncedFns, nodeInformer.HasSynced)
+	default:
+		log.Fatalf("Unknown --placement %q", *placement)
+	}
+
+	var sim *simulation
+	// mirror is set when the standalone engine follows a primary (--mirror).
+	var mirror *gridMirror
+	// lease is set when the primary is elected (--primary-lease).
+	var lease *primaryLease
+	var snapshots *snapshotter
+	var budgets *budgetManager
+	// runCells materializes the grid until ctx is done.
+	runCells := func(ctx context.Context) {
+		if sim != nil && sim.supercells != nil {
+			go sim.supercells.Run(ctx, *reconcileInterval)
+		}
+		if sim != nil && sim.fireworks != nil {
+			go sim.fireworks.Run(ctx)
+		}
+		cells.Run(ctx, *reconcileInterval)
+	}
+	switch *engineMode {
+	case engineCells:
+		if *cellObjects != cellObjectPods {
+			log.Fatalf("--cell-objects=%s requires --engine=%s", *cellObjects, engineStandalone)
+		}
+		if p := cfg.Extinction.Policy; p != "" && p != extinctionNotify {
+			log.Fatalf("Extinction policy %q requires --engine=%s", p, engineStandalone)
+		}
+		if cfg.Deadline.Timeout.Duration > 0 {
+			log.Fatalf("Generation deadlines require --engine=%s", engineStandalone)
+		}
+		if cfg.Stats.persistent() {
+			log.Fatalf("Stats driver %q requires --engine=%s", cfg.Stats.Driver, engineStandalone)
+		}
+		if *mirrorOf != "" {
+			log.Fatalf("--mirror requires --engine=%s", engineStandalone)
+		}
+		if *primaryLeaseName != "" {
+			log.Fatalf("--primary-lease requires --engine=%s", engineStandalone)
+		}
+		if len(cfg.Alerts) > 0 || osc != nil {
+			go observeCells(ctx, factory.Core().V1().Pods().Lister(), namespace, *tickInterval, func(gen int64, population int) {
+				alerts.Observe(gen, population)
+				osc.Observe(gen, population)
+			})
+		}
+		if *fedNorth != "" || *fedSouth != "" || *fedRowOffset != 0 {
+			log.Fatalf("Federation requires --engine=%s", engineStandalone)
+		}
+		if *ruleEngine != "life" {
+			log.Fatalf("--rule-engine requires --engine=%s", engineStandalone)
+		}
+		if *tickSource != tickInternal {
+			log.Fatalf("--tick-source requires --engine=%s", engineStandalone)
+		}
+		if cfg.Hooks.Script != "" {
+			log.Fatalf("Hooks require --engine=%s", engineStandalone)
+		}
+		if cfg.Genetics != nil {
+			log.Fatalf("Genetics requires --engine=%s", engineStandalone)
+		}
+		if cfg.Energy != nil {
+			log.Fatalf("Energy requires --engine=%s", engineStandalone)
+		}
+		if cfg.Sonification != nil {
+			log.Fatalf("Sonification requires --engine=%s", engineStandalone)
+		}
+		if cfg.DisruptionBudgets != nil {
+			log.Fatalf("Disruption budgets require --engine=%s", engineStandalone)
+		}
+		if cfg.ChurnBreaker != nil {
+			log.Fatalf("The churn breaker requires --engine=%s", engineStandalone)
+		}
+		if cfg.PopulationCap != nil {
+			log.Fatalf("The population cap requires --engine=%s", engineStandalone)
+		}
+		if cfg.OperatingHours != nil {
+			log.Fatalf("Operating hours require --engine=%s", engineStandalone)
+		}
+		if len(cfg.Renderers) > 0 {
+			log.Fatalf("Renderers require --engine=%s", engineStandalone)
+		}
+		if len(cfg.Scenarios) > 0 {
+			log.Fatalf("Scenarios require --engine=%s", engineStandalone)
+		}
+		if *scaleToZeroAfter > 0 {
+			log.Fatalf("--scale-to-zero-after requires --engine=%s", engineStandalone)
+		}
+		if cfg.Lifecycle != nil && cfg.Lifecycle.bendsRule() {
+			log.Fatalf("Lifecycle effects require --engine=%s", engineStandalone)
+		}
+		if len(cfg.Rules.Rotation) > 0 || cfg.Rules.Compare != nil {
+			log.Fatalf("Rule rotation and comparison require --engine=%s", engineStandalone)
+		}
+	case engineStandalone:
+		if *tickSource != tickInternal && *tickSource != tickExternal {
+			log.Fatalf("Unknown --tick-source %q", *tickSource)
+		}
+		// Seeded once the pod cache has synced; see simulation.Recover.
+		rule, err := openRuleEngine(ctx, *ruleEngine, grid, *ruleTimeout)
+		if err != nil {
+			log.Fatalf("Invalid --rule-engine: %s", err.Error())
+		}
+		var rotation *ruleRotation
+		if (len(cfg.Rules.Rotation) > 0 || cfg.Rules.Compare != nil) && *ruleEngine != "life" {
+			log.Fatalf("Rule rotation and comparison require --rule-engine=life")
+		}
+		if len(cfg.Rules.Rotation) > 0 {
+			if rotation, err = newRuleRotation(cfg.Rules.Rotation, cfg.Rules.Every.Duration); err != nil {
+				log.Fatalf("Rule rotation: %s", err.Error())
+			}
+			rule = rotation.current(time.Now())
+		}
+		engine := NewEngine(grid, rule)
+		engine.genetics = newGenetics(cfg.Genetics)
+		engine.cap = newPopulationCap(cfg.PopulationCap)
+		engine.conditions = newCellConditions(cfg.Lifecycle)
+		if engine.genetics != nil {
+			cells.genome = engine.Genome
+			log.Printf("Engine: genetics enabled, mutation rate %g", cfg.Genetics.MutationRate)
+		}
+		if cfg.Rules.Compare != nil {
+			if *grpcAddr != "" {
+				log.Fatalf("Rule comparison is not supported in a federated grid")
+			}
+			compare, err := newCompareEngine(*cfg.Rules.Compare, grid)
+			if err != nil {
+				log.Fatalf("Rule comparison: %s", err.Error())
+			}
+			engine.SetRule(compare)
+		}
+		log.Printf("Engine: running rule %s", engine.Rule())
+		cells.desired = engine.Alive
+		sim = &simulation{
+			engine:       engine,
+			cells:        cells,
+			interval:     *tickInterval,
+			viewerBirths: *viewerBirths,
+			alerts:       alerts,
+			extinction:   cfg.Extinction,
+			deadline:     cfg.Deadline,
+			rollbacks:    make(chan int64, 1),
+			external:     *tickSource == tickExternal,
+			ticks:        make(chan tickRequest),
+			baseRule:     rule,
+			rotation:     rotation,
+			wasmLimits:   cfg.WasmRules,
+			osc:          osc,
+			breaker:      newChurnBreaker(cfg.ChurnBreaker),
+			hours:        cfg.OperatingHours,
+			edits:        editLedger{policy: cfg.Edits.Merge},
+		}
+		cells.digests = &sim.digests
+		if len(cfg.Renderers) > 0 {
+			if sim.renderers, err = newRendererSet(cfg.Renderers, grid); err != nil {
+				log.Fatalf("Renderers: %s", err.Error())
+			}
+			go sim.renderers.Run(ctx)
+		}
+		if sim.sonifier, err = newSonifier(cfg.Sonification, grid); err != nil {
+			log.Fatalf("Sonification: %s", err.Error())
+		}
+		sim.scenarios = newScenarioRunner(sim, cfg.Scenarios)
+		go sim.scenarios.Run(ctx)
+		if cfg.OperatingHours != nil {
+			go newHoursWatch(cfg.OperatingHours, sim).Run(ctx)
+		}
+		if cfg.Energy != nil {
+			sim.energy = newEnergyLedger(cfg.Energy, grid)
+			go sim.energy.RunMetrics(ctx, metrics, namespace)
+			log.Printf("Energy: %g per millicore, polled every %s", cfg.Energy.PerMilliCPU, cfg.Energy.Interval.Duration)
+		}
+		switch *cellObjects {
+		case cellObjectPods:
+		case cellObjectDeployments:
+			if *placement != placementNone {
+				log.Fatalf("--cell-objects=%s cannot be combined with --placement=%s", cellObjectDeployments, *placement)
+			}
+			// Super-cells replace cell pods; the pod manager only deletes
+			// those left over. The same goes for fireworks.
+			cells.desired = func(int) bool { return false }
+			deployments := factory.Apps().V1().Deployments()
+			sim.supercells = newSuperCellManager(clientset, namespace, grid, *cellImage, deployments.Lister())
+			sim.supercells.intensity = engineIntensity(engine, sim.energy)
+			deployments.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc:    handleSuperCellUpdate,
+				UpdateFunc: func(oldObj, newObj interface{}) { handleSuperCellUpdate(newObj) },
+				DeleteFunc: handleSuperCellDelete,
+			})
+			syncedFns = append(syncedFns, deployments.Informer().HasSynced)
+			log.Printf("Engine: cells materialized as Deployments of up to %d replicas", maxIntensity)
+		case cellObjectJobs:
+			if *placement != placementNone {
+				log.Fatalf("--cell-objects=%s cannot be combined with --placement=%s", cellObjectJobs, *placement)
+			}
+			cells.desired = func(int) bool { return false }
+			sim.fireworks = newFireworkLauncher(clientset, namespace, *cellImage)
+			jobs := factory.Batch().V1().Jobs().Informer()
+			jobs.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc:    sim.fireworks.Update,
+				UpdateFunc: func(oldObj, newObj interface{}) { sim.fireworks.Update(newObj) },
+				DeleteFunc: sim.fireworks.Delete,
+			})
+			log.Printf("Engine: births materialized as firework Jobs")
+		default:
+			log.Fatalf("Unknown --cell-objects %q", *cellObjects)
+		}
+		if cfg.DisruptionBudgets != nil {
+			budgets = newBudgetManager(cfg.DisruptionBudgets, clientset, factory.Core().V1().Pods().Lister(), namespace, grid)
+			sim.structures = budgets.detector
+			go budgets.Run(ctx)
+		}
+		// Summaries describe the structures on the grid, so the engine's
+		// generations are recorded for them without disruption budgets too.
+		if sim.structures == nil {
+			sim.structures = newStructureDetector(grid, defaultMaxPeriod)
+		}
+		sim.summaries = newSummaryStream(grid, sim.structures)
+		go sim.summaries.Run(ctx)
+		if cfg.Hooks.Script != "" {
+			if sim.hooks, err = loadHooks(cfg.Hooks, grid); err != nil {
+				log.Fatalf("Hooks: %s", err.Error())
+			}
+			log.Printf("Hooks: loaded %s", cfg.Hooks.Script)
+		}
+		cells.onDeadline = sim.deadlineOutcome
+		if cfg.Deadline.Timeout.Duration >= *tickInterval {
+			log.Fatalf("Generation deadline %s must be shorter than --tick-interval %s", cfg.Deadline.Timeout.Duration, *tickInterval)
+		}
+
+		var store snapshotStore = &configMapSnapshots{clientset: clientset, namespace: namespace}
+		if cfg.Snapshots.Directory != "" {
+			store = &dirSnapshots{dir: cfg.Snapshots.Directory}
+		}
+		snapshots = newSnapshotter(cfg.Snapshots, store)
+		sim.snapshots = snapshots
 
+		if *scaleToZeroAfter > 0 {
+			if *cellObjects != cellObjectPods {
+				log.Fatalf("--scale-to-zero-after requires --cell-objects=%s", cellObjectPods)
+			}
+			idle = newDormancy(*scaleToZeroAfter, *scaleToZeroSnapshot, sim)
+			cells.desired = idle.Desired(cells.desired)
+			go idle.Run(ctx)
+		}
+
+		if cfg.Stats.persistent() {
+			statsGrid := gridID
+			if statsGrid == "" {
+				statsGrid = namespace
+			}
+			openCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			db, err := openStatsStore(openCtx, cfg.Stats, statsGrid)
+			cancel()
+			if err != nil {
+				log.Fatalf("Stats: open %s database: %s", cfg.Stats.Driver, err.Error())
+			}
+			sim.history = newStatsWriter(db)
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				sim.history.Run(ctx)
+			}()
+			log.Printf("Stats: recording grid %q to %s", statsGrid, cfg.Stats.Driver)
+		}
+
+		if *mirrorOf != "" {
+			if *fedNorth != "" || *fedSouth != "" {
+				log.Fatalf("A mirror cannot be a federation member")
+			}
+			mirror = newGridMirror(*mirrorOf, sim, namespace)
+			// The mirrored state replaces recovery; the simulation and the
+			// pod manager only run while promoted. A mirror promoted before
+			// it ever reached its primary recovers from its pods instead.
+			var recovered sync.Once
+			mirror.start = func(ctx context.Context) {
+				recovered.Do(func() {
+					if mirror.Status().Updated.IsZero() {
+						sim.Recover(ctx, *recoverState, factory.Core().V1().Pods().Lister(), namespace)
+					}
+				})
+				go sim.Run(ctx)
+				runCells(ctx)
+			}
+			log.Printf("Mirror: read-only mirror of %s", *mirrorOf)
+		}
+		if *primaryLeaseName != "" {
+			lease = newPrimaryLease(clientset, namespace, *primaryLeaseName, *primaryLeaseDuration)
+			if mirror != nil {
+				mirror.lease = lease
+			}
+			log.Printf("Lease: standing for primary as %s with lease %s", lease.identity, lease.name)
+		}
+
+		if *grpcAddr != "" && !featureEnabled(featureFederation) {
+			log.Fatalf("--grpc-addr requires the Federation feature gate")
+		}
+		if (*fedNorth != "" || *fedSouth != "") && *grpcAddr == "" {
+			log.Fatalf("Federation peers require --grpc-addr")
+		}
+		if *grpcAddr != "" {
+			fed := newFederationMember(engine, grid, *fedRowOffset)
+			fed.north = dialFederationPeer(*fedNorth)
+			fed.south = dialFederationPeer(*fedSouth)
+			sim.federation = fed
+			federationOffset = grid.Index(0, *fedRowOffset)
+
+			lis, err := listenTCP(*grpcAddr, *ipFamily)
+			if err != nil {
+				log.Fatalf("gRPC listen: %s", err.Error())
+			}
+			server := grpc.NewServer()
+			pb.RegisterFederationServiceServer(server, fed)
+			go server.Serve(lis)
+			log.Printf("Federation: band at row %d served on %s", *fedRowOffset, *grpcAddr)
+		}
+	default:
+		log.Fatalf("Unknown --engine %q", *engineMode)
+	}
+
+	if *maintenancePause {
+		maintenance := newMaintenanceWatch(sim)
+		nodeInformer := factory.Core().V1().Nodes().Informer()
+		nodeLister := factory.Core().V1().Nodes().Lister()
+		nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { maintenance.Resync(nodeLister) },
+			UpdateFunc: func(oldObj, newObj interface{}) { maintenance.Resync(nodeLister) },
+			DeleteFunc: func(obj interface{}) { maintenance.Resync(nodeLister) },
+		})
+		syncedFns = append(syncedFns, nodeInformer.HasSynced)
+	}
+
+	// renderPods is false when cell states are streamed from endpoints.
+	renderPods := true
+	switch *renderSource {
+	case renderLabels:
+	case renderEndpoints:
+		selector, err := labels.Parse(*renderSelector)
+		if err != nil {
+			log.Fatalf("Invalid --render-selector: %s", err.Error())
+		}
+		renderer := newEndpointRenderer(selector, factory.Core().V1().Pods().Lister())
+		sliceInformer := factory.Discovery().V1().EndpointSlices().Informer()
+		sliceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    renderer.Update,
+			UpdateFunc: func(oldObj, newObj interface{}) { renderer.Update(newObj) },
+			DeleteFunc: renderer.Delete,
+		})
+		syncedFns = append(syncedFns, sliceInformer.HasSynced)
+		renderPods = false
+		log.Printf("Rendering cell states from the endpoints of %s", selector)
+	default:
+		log.Fatalf("Unknown --render-source %q", *renderSource)
+	}
+
+	selfTests := newSelfTester(clientset, namespace, *cellImage)
 	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			handlePodUpdate(obj)
+			selfTests.Observe(obj, "added")
+			if renderPods {
+				handlePodUpdate(obj, cells)
+			}
+			sim.PodCondition(obj)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			handlePodUpdate(newObj)
+			if renderPods {
+				handlePodUpdate(newObj, cells)
+			}
+			sim.PodCondition(newObj)
 		},
 		DeleteFunc: func(obj interface{}) {
-			handlePodDelete(obj)
+			selfTests.Observe(obj, "deleted")
+			if renderPods {
+				handlePodDelete(obj, cells)
+			}
+			pod, ok := podFromTombstone(obj)
+			if ok && sim != nil {
+				sim.PodDeleted(pod.Name)
+			}
+			if cells != nil {
+				if ok {
+					cells.Forget(pod.Name)
+				}
+				cells.Resync()
+			}
 		},
 	})
 
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-	factory.Start(stopCh)
+	if cells != nil {
+		go func() {
+			if !cache.WaitForCacheSync(ctx.Done(), syncedFns...) {
+				return
+			}
+			if planner != nil {
+				planner.Resync(factory.Core().V1().Nodes().Lister())
+			}
+			if mirror != nil {
+				mirror.Run(ctx)
+				return
+			}
+			if lease != nil {
+				// Only the holder runs; losing the Lease stops both. Another
+				// controller may have run the grid before this one takes the
+				// Lease, at startup or again later, so the state is recovered
+				// each time it does, regardless of --recover after the first.
+				// stopped is closed once the previous lead's simulation has
+				// returned, so that it never overlaps the recovery.
+				led, stopped := false, make(chan struct{})
+				close(stopped)
+				lease.onLead = func(ctx context.Context) {
+					<-stopped
+					sim.Recover(ctx, *recoverState || led, factory.Core().V1().Pods().Lister(), namespace)
+					led, stopped = true, make(chan struct{})
+					go func(done chan struct{}) {
+						defer close(done)
+						sim.Run(ctx)
+					}(stopped)
+					runCells(ctx)
+				}
+				lease.onLose = func() {}
+				lease.Run(ctx)
+				return
+			}
+			if sim != nil {
+				sim.Recover(ctx, *recoverState, factory.Core().V1().Pods().Lister(), namespace)
+				go sim.Run(ctx)
+			}
+			runCells(ctx)
+		}()
+	}
+
+	if (*fedMembers != "" || *aggregate != "") && !featureEnabled(featureAggregation) {
+		log.Fatalf("--federation-members and --aggregate require the Aggregation feature gate")
+	}
+	for _, member := range strings.Split(*fedMembers, ",") {
+		if member != "" {
+			go aggregateBand(ctx, gridID, member, namespace)
+		}
+	}
+
+	sources, err := parseAggregateSources(*aggregate)
+	if err != nil {
+		log.Fatalf("Invalid --aggregate: %s", err.Error())
+	}
+	for _, src := range sources {
+		go src.Run(ctx, namespace)
+	}
+
+	if *streamGrids && !featureEnabled(featureGridAPI) {
+		log.Fatalf("--stream-grids requires the GridAPI feature gate")
+	}
+	if *streamGrids {
+		watchGrids(ctx, clientset)
+	}
+
+	go quotas.RunPruner(ctx, 10*time.Minute)
+	tournaments := newArena()
+	go tournaments.Run(ctx)
+	monkey := newChaosMonkey(cfg.AutoChaos, clientset, factory.Core().V1().Pods().Lister(), namespace, grid, sim)
+	if monkey != nil {
+		go monkey.Run(ctx)
+	}
+	if budget := memoryBudget(*memoryBudgetFlag); budget > 0 {
+		go newMemoryGuard(budget, sim).Run(ctx)
+	}
+	if *cacheAuditInterval > 0 {
+		auditor := newCacheAuditor(clientset, factory.Core().V1().Pods().Lister(), namespace, *cacheAuditSample)
+		go auditor.Run(ctx, *cacheAuditInterval, podInformer.HasSynced)
+	}
+	if *cellMetricsInterval > 0 {
+		go streamCellMetrics(ctx, metrics, namespace, *cellMetricsInterval)
+	}
+
+	var checks *preflight
+	switch *preflightMode {
+	case preflightOff:
+	case preflightWarn, preflightStrict:
+		checks = newPreflight(clientset, namespace)
+		if cells != nil {
+			checks.Permit("", "pods", "create,patch", "the controller creates cell pods")
+		}
+		if *cacheAuditInterval > 0 {
+			checks.Permit("", "pods", "get", "--cache-audit-interval re-reads divergent pods live")
+		}
+		if *placement == placementGeography {
+			checks.Permit("", "nodes", "list,watch", "--placement=geography pins cells to nodes")
+		}
+		switch *cellObjects {
+		case cellObjectDeployments:
+			checks.Permit("apps", "deployments", "list,watch,create,patch,delete", "--cell-objects=deployments materializes cells as Deployments")
+		case cellObjectJobs:
+			checks.Permit("batch", "jobs", "list,watch,create", "--cell-objects=jobs materializes births as Jobs")
+		}
+		if *renderSource == renderEndpoints {
+			checks.API("discovery.k8s.io/v1", "--render-source=endpoints reads EndpointSlices")
+			checks.Permit("discovery.k8s.io", "endpointslices", "list,watch", "--render-source=endpoints reads EndpointSlices")
+		}
+		if *cellMetricsInterval > 0 {
+			checks.API("metrics.k8s.io/v1beta1", "--cell-metrics-interval reads pod usage from metrics-server")
+			checks.Permit("metrics.k8s.io", "pods", "list", "--cell-metrics-interval reads pod usage from metrics-server")
+		}
+		if *primaryLeaseName != "" {
+			checks.Permit("coordination.k8s.io", "leases", "get,create,update", "--primary-lease elects the primary with a Lease")
+		}
+		if apiKeys != nil {
+			checks.Permit("", "secrets", "get,create,update", "--api-keys-secret stores API keys in a Secret")
+		}
+		if snapshots != nil && cfg.Snapshots.enabled() && cfg.Snapshots.Directory == "" {
+			checks.Permit("", "configmaps", "get,list,create,delete", "snapshots are stored as ConfigMaps")
+		}
+		if report := checks.Run(ctx); report.Failed > 0 && *preflightMode == preflightStrict {
+			log.Fatalf("Preflight: %d checks failed (--preflight=%s)", report.Failed, preflightStrict)
+		}
+	default:
+		log.Fatalf("Unknown --preflight %q", *preflightMode)
+	}
+
+	factory.Start(ctx.Done())
 
 	// Broadcaster
-	go handleMessages()
+	go handleMessages(ctx)
 
 	// HTTP Server
-	http.HandleFunc("/ws", handleConnections)
-	http.HandleFunc("/api/pods/", func(w http.ResponseWriter, r *http.Request) {
-		handleChaos(w, r, clientset, namespace)
+	rt := newRoutes()
+	grids := &gridBootstrapper{clientset: clientset, image: *cellImage, controllerNamespace: namespace}
+	if gridID != "" {
+		grids.self = func() ([]any, error) { return selfManifest(cfg, sim, monkey, namespace) }
+	}
+	rt.Public("/ws", func(w http.ResponseWriter, r *http.Request) {
+		handleConnections(w, r, grids)
 	})
-
-	log.Println("Controller started on :8080")
-	err = http.ListenAndServe(":8080", nil)
+	rt.Public("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		handleEvents(w, r, grids)
+	})
+	rt.Public("/api/summaries", func(w http.ResponseWriter, r *http.Request) {
+		handleSummaries(w, r, sim)
+	})
+	rt.Public("/api/embed", func(w http.ResponseWriter, r *http.Request) {
+		handleEmbed(w, r, grid, sim, factory.Core().V1().Pods().Lister(), namespace, grids)
+	})
+	rt.Public("/api/embed.js", handleEmbedScript)
+	rt.Public("/api/placement", func(w http.ResponseWriter, r *http.Request) {
+		handlePlacement(w, r, planner, grid)
+	})
+	rt.Public("/api/generations/", func(w http.ResponseWriter, r *http.Request) {
+		handleGenerations(w, r, sim)
+	})
+	rt.Public("/api/stats/export", func(w http.ResponseWriter, r *http.Request) {
+		handleStatsExport(w, r, sim)
+	})
+	rt.Public("/api/energy", func(w http.ResponseWriter, r *http.Request) {
+		handleEnergy(w, r, sim)
+	})
+	rt.Public("/api/capacity", func(w http.ResponseWriter, r *http.Request) {
+		handleCapacity(w, r, clientset, factory.Core().V1().Pods().Lister(), namespace, cells, sim)
+	})
+	rt.Public("/api/structures", func(w http.ResponseWriter, r *http.Request) {
+		handleStructures(w, r, budgets)
+	})
+	rt.Public("/api/genetics", func(w http.ResponseWriter, r *http.Request) {
+		handleGenetics(w, r, sim)
+	})
+	rt.Public("/api/state/hash", func(w http.ResponseWriter, r *http.Request) {
+		handleStateHash(w, r, sim, factory.Core().V1().Pods().Lister(), namespace)
+	})
+	rt.Public("/api/chaos/auto/log", func(w http.ResponseWriter, r *http.Request) {
+		handleChaosLog(w, r, monkey)
+	})
+	rt.Control("/api/chaos/auto", func(w http.ResponseWriter, r *http.Request) {
+		handleAutoChaos(w, r, monkey)
+	})
+	rt.Control("/metrics", promhttp.Handler().ServeHTTP)
+	idempotency := newIdempotencyCache(*idempotencyWindow)
+	rt.Control("/api/pods/", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		handleChaos(w, r, clientset, namespace, grid)
+	}))
+	rt.Control("/api/cells/template", func(w http.ResponseWriter, r *http.Request) {
+		handleCellTemplate(w, r, cells)
+	})
+	rt.Control("/api/cells/rematerialize", func(w http.ResponseWriter, r *http.Request) {
+		handleRematerialize(w, r, cells, sim)
+	})
+	rt.Control("/api/cells/", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		handleCellEdit(w, r, sim)
+	}))
+	rt.Control("/api/archive", func(w http.ResponseWriter, r *http.Request) {
+		handleArchive(w, r, sim)
+	})
+	rt.Control("/api/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		handleSnapshots(w, r, sim, snapshots)
+	})
+	rt.Control("/api/snapshots/", func(w http.ResponseWriter, r *http.Request) {
+		handleSnapshots(w, r, sim, snapshots)
+	})
+	rt.Public("/api/patterns", func(w http.ResponseWriter, r *http.Request) {
+		handlePatterns(w, r, sim)
+	})
+	rt.Control("/api/patterns/", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		handlePatterns(w, r, sim)
+	}))
+	rt.Control("/api/region/transform", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		handleRegionTransform(w, r, sim)
+	}))
+	rt.Public("/api/scenarios", func(w http.ResponseWriter, r *http.Request) {
+		handleScenarios(w, r, sim)
+	})
+	rt.Public("/api/scenarios/active", func(w http.ResponseWriter, r *http.Request) {
+		handleScenarios(w, r, sim)
+	})
+	rt.Control("/api/scenarios/", func(w http.ResponseWriter, r *http.Request) {
+		handleScenarios(w, r, sim)
+	})
+	rt.Control("/api/broadcast", handleBroadcast)
+	rt.Control("/api/tournaments", func(w http.ResponseWriter, r *http.Request) {
+		handleTournaments(w, r, tournaments)
+	})
+	rt.Control("/api/tournaments/", func(w http.ResponseWriter, r *http.Request) {
+		handleTournaments(w, r, tournaments)
+	})
+	rt.Public("/api/attestation-key", handleAttestationKey)
+	rt.Control("/api/selftest", func(w http.ResponseWriter, r *http.Request) {
+		handleSelfTest(w, r, selfTests)
+	})
+	rt.Public("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(w, r, checks, podInformer.HasSynced)
+	})
+	// Dashboards read the theme from the public listener; changing it
+	// still takes an administrator.
+	themes := newThemeStore(ctx, clientset, namespace)
+	rt.Public("/api/theme", func(w http.ResponseWriter, r *http.Request) {
+		handleTheme(w, r, themes)
+	})
+	rt.Public("/api/auth/", handleAuth)
+	rt.Public("/api/quota", handleQuota)
+	rt.Control("/api/keys", handleAPIKeys)
+	rt.Control("/api/keys/", handleAPIKeys)
+	if featureEnabled(featureGridAPI) {
+		rt.Control("/api/grids", func(w http.ResponseWriter, r *http.Request) {
+			handleGrids(w, r, grids)
+		})
+		rt.Control("/api/grids/", func(w http.ResponseWriter, r *http.Request) {
+			handleGrids(w, r, grids)
+		})
+		rt.Public("/api/sessions/join", func(w http.ResponseWriter, r *http.Request) {
+			handleSessions(w, r, grids)
+		})
+		rt.Control("/api/sessions", func(w http.ResponseWriter, r *http.Request) {
+			handleSessions(w, r, grids)
+		})
+		rt.Control("/api/previews", func(w http.ResponseWriter, r *http.Request) {
+			handlePreviews(w, r, grids, *previewImagePrefix)
+		})
+		rt.Control("/api/share", func(w http.ResponseWriter, r *http.Request) {
+			handleShare(w, r, grids)
+		})
+		rt.Public("/view/", func(w http.ResponseWriter, r *http.Request) {
+			handleView(w, r, grids)
+		})
+		if *sessionReap > 0 {
+			go grids.RunSessionReaper(ctx, *sessionReap)
+		}
+	}
+	rt.Public("/api/features", handleFeatures)
+	rt.Public("/api/cells", func(w http.ResponseWriter, r *http.Request) {
+		handleCells(w, r, podInformer.GetIndexer(), grid)
+	})
+	rt.Public("/api/mirror", func(w http.ResponseWriter, r *http.Request) {
+		handleMirror(w, r, mirror)
+	})
+	rt.Control("/api/mirror/promote", func(w http.ResponseWriter, r *http.Request) {
+		handleMirror(w, r, mirror)
+	})
+	rt.Control("/api/mirror/demote", func(w http.ResponseWriter, r *http.Request) {
+		handleMirror(w, r, mirror)
+	})
+	discovery := discover(grid, *engineMode, *grpcAddr)
+	rt.Public("/api/discovery", func(w http.ResponseWriter, r *http.Request) {
+		handleDiscovery(w, r, discovery)
+	})
+	if *discoveryService != "" {
+		if err := annotateService(ctx, clientset, namespace, *discoveryService, discovery); err != nil {
+			log.Printf("Discovery: %v", err)
+		}
+	}
+	rt.Control("/api/rules", func(w http.ResponseWriter, r *http.Request) {
+		handleRules(w, r, sim)
+	})
+	rt.Control("/api/canary", func(w http.ResponseWriter, r *http.Request) {
+		handleCanary(w, r, sim)
+	})
+	rt.Control("/api/canary/promote", func(w http.ResponseWriter, r *http.Request) {
+		handleCanary(w, r, sim)
+	})
+	rt.Control("/api/rules/compare", func(w http.ResponseWriter, r *http.Request) {
+		handleRuleComparison(w, r, sim)
+	})
+	rt.Control("/api/simulation/", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		handleSimulation(w, r, sim)
+	}))
+	chat := &chatBridge{grid: grid, sim: sim}
+	var chatSecret []byte
+	if cfg.Chat != nil && cfg.Chat.WebhookSecretFile != "" {
+		data, err := os.ReadFile(cfg.Chat.WebhookSecretFile)
+		if err != nil {
+			log.Fatalf("Chat: %s", err.Error())
+		}
+		chatSecret = []byte(strings.TrimSpace(string(data)))
+	}
+	rt.Control("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		handleChat(w, r, chat, chatSecret)
+	})
+	slash, err := newSlashCommands(cfg.Slash, chat)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Fatalf("Slash commands: %s", err.Error())
+	}
+	rt.Control("/api/slash/slack", slash.handleSlack)
+	rt.Control("/api/slash/discord", slash.handleDiscord)
+
+	var public, control http.Handler = rt.public, rt.control
+	if mirror != nil {
+		public, control = mirror.Wrap(public), mirror.Wrap(control)
+	}
+	public, control = requestLimits.Wrap(public), requestLimits.Wrap(control)
+	if idle != nil {
+		public, control = idle.Wrap(public), idle.Wrap(control)
+	}
+	if *auditPath != "" {
+		audit, err := openAuditLog(*auditPath, *auditMaxSize<<20, *auditKeep)
+		if err != nil {
+			log.Fatalf("Audit log: %s", err.Error())
+		}
+		public, control = audit.Wrap(public), audit.Wrap(control)
+	}
+	chat.handler = control
+	if cfg.Chat != nil && cfg.Chat.Twitch != nil {
+		go chat.RunTwitch(ctx, *cfg.Chat.Twitch)
 	}
+
+	errCh := make(chan error)
+	var servers []*http.Server
+	serve := func(addrs string, handler http.Handler, kind string) {
+		for _, addr := range strings.Split(addrs, ",") {
+			l, err := listen(addr, *ipFamily, os.FileMode(*socketMode))
+			if err != nil {
+				log.Fatalf("Listen on %s: %s", addr, err.Error())
+			}
+			log.Printf("Controller started on %s (%s, bound to %s)", addr, kind, l.Addr())
+			server := newHTTPServer(handler)
+			server.BaseContext = func(net.Listener) context.Context { return ctx }
+			servers = append(servers, server)
+			go func() { errCh <- server.Serve(l) }()
+		}
+	}
+	if *controlAddrs == "" {
+		serve(*listenAddrs, control, "public and control")
+	} else {
+		serve(*listenAddrs, public, "public")
+		serve(*controlAddrs, control, "control")
+	}
+	select {
+	case err := <-errCh:
+		log.Fatal("Serve: ", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(shutdownCtx)
+	}
+	background.Wait()
 }
 
-func handlePodUpdate(obj interface{}) {
+func handlePodUpdate(obj interface{}, cells *cellPodManager) {
 	pod, ok := obj.(*v1.Pod)
 	if !ok {
 		return
@@ -112,89 +1047,76 @@ func handlePodUpdate(obj interface{}) {
 		return
 	}
 
-	status := "unknown"
-	if s, ok := pod.Labels["game-status"]; ok {
-		status = s
-	} else {
-		// If no label, it might be initializing
-		status = "initializing"
-	}
-
-	// Also consider DeletionTimestamp as "dying"
-	if pod.DeletionTimestamp != nil {
-		status = "terminating"
+	status := podStatus(pod)
+	// Unless the standalone engine removed it: then the cell simply died
+	if pod.DeletionTimestamp != nil && cells != nil && cells.Retired(pod.Name) {
+		status = "dead"
 	}
 
 	update := CellUpdate{
-		Name:      pod.Name,
+		Name:      federatedName(pod.Name),
 		Status:    status,
 		Namespace: pod.Namespace,
+		Grid:      gridID,
+	}
+	if status != "dead" {
+		update.Condition = podCondition(pod, zombieRestarts)
+	}
+	if g, err := parseGenome(pod.Annotations[genomeAnnotation]); err == nil {
+		update.Genome, update.Color = g.String(), g.Color()
 	}
 
-	msg, _ := json.Marshal(update)
-	broadcast <- msg
+	publish(msgCell, update)
+	cellErrors.Pod(pod)
 }
 
-func handlePodDelete(obj interface{}) {
-	// When a pod is deleted, we might receive a DeletedFinalStateUnknown
-	pod, ok := obj.(*v1.Pod)
+func handlePodDelete(obj interface{}, cells *cellPodManager) {
+	pod, ok := podFromTombstone(obj)
 	if !ok {
-		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-		if !ok {
-			return
-		}
-		pod, ok = tombstone.Obj.(*v1.Pod)
-		if !ok {
-			return
-		}
+		return
 	}
 
 	if app, ok := pod.Labels["app"]; !ok || app != "cell" {
 		return
 	}
 
-	update := CellUpdate{
-		Name:      pod.Name,
-		Status:    "deleted",
-		Namespace: pod.Namespace,
+	status := "deleted"
+	if cells != nil && cells.Retired(pod.Name) {
+		status = "dead"
 	}
-	msg, _ := json.Marshal(update)
-	broadcast <- msg
-}
 
-func handleConnections(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Fatal(err)
+	update := CellUpdate{
+		Name:      federatedName(pod.Name),
+		Status:    status,
+		Namespace: pod.Namespace,
+		Grid:      gridID,
 	}
-	// Register client
-	clientsMu.Lock()
-	clients[ws] = true
-	clientsMu.Unlock()
-
-	log.Println("Client connected")
+	publish(msgCell, update)
+	cellErrors.Clear(pod.Name)
 }
 
-func handleMessages() {
-	for {
-		msg := <-broadcast
-		clientsMu.Lock()
-		for client := range clients {
-			err := client.WriteMessage(websocket.TextMessage, msg)
-			if err != nil {
-				log.Printf("Websocket error: %v", err)
-				client.Close()
-				delete(clients, client)
-			}
+// podFromTombstone unwraps a deleted pod. When a pod is deleted, we might
+// receive a DeletedFinalStateUnknown.
+func podFromTombstone(obj interface{}) (*v1.Pod, bool) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return nil, false
+		}
+		pod, ok = tombstone.Obj.(*v1.Pod)
+		if !ok {
+			return nil, false
 		}
-		clientsMu.Unlock()
 	}
+	return pod, true
 }
 
-func handleChaos(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, namespace string) {
+func handleChaos(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, namespace string, grid GridGeometry) {
 	// CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Idempotency-Key")
 
 	if r.Method == "OPTIONS" {
 		return
@@ -212,9 +1134,26 @@ func handleChaos(w http.ResponseWriter, r *http.Request, clientset *kubernetes.C
 		return
 	}
 
+	var cells []int
+	if i, ok := cellIndex(name); ok {
+		cells = append(cells, i)
+	} else if botFor(r) != nil || apiKeyFor(r) != nil {
+		http.Error(w, "Forbidden: bots and API keys may only delete cell pods", http.StatusForbidden)
+		return
+	}
+	if !allowBot(w, r, botScopeChaos, grid, cells) {
+		return
+	}
+
+	if !quotas.Allow(w, r, actionChaos) {
+		return
+	}
+
 	log.Printf("Chaos: Deleting pod %s", name)
 
-	err := clientset.CoreV1().Pods(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	err := podWrites.Do(r.Context(), verbDelete, func(ctx context.Context) error {
+		return clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
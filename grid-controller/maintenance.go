@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+var maintenancePaused = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_maintenance_paused",
+	Help: "1 while the simulation is frozen because nodes are cordoned for maintenance.",
+})
+
+// maintenanceWatch freezes the simulation while any node is cordoned, as
+// drains before a k3s upgrade do, so cells evicted by the drain are not
+// mistaken for deaths. A banner tells viewers why the grid stands still. Once
+// every node is schedulable again the simulation resumes where it stopped;
+// a pause set by an operator is left alone.
+type maintenanceWatch struct {
+	// sim is nil with --engine=cells, where only the banner is shown.
+	sim *simulation
+
+	mu       sync.Mutex
+	cordoned []string
+	banner   BannerMessage
+}
+
+func newMaintenanceWatch(sim *simulation) *maintenanceWatch {
+	return &maintenanceWatch{sim: sim}
+}
+
+// Resync re-reads the nodes and enters or leaves maintenance.
+func (m *maintenanceWatch) Resync(nodes corelisters.NodeLister) {
+	list, err := nodes.List(labels.Everything())
+	if err != nil {
+		log.Printf("Maintenance: list nodes: %v", err)
+		return
+	}
+	var cordoned []string
+	for _, node := range list {
+		if node.Spec.Unschedulable {
+			cordoned = append(cordoned, node.Name)
+		}
+	}
+	sort.Strings(cordoned)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strings.Join(cordoned, ",") == strings.Join(m.cordoned, ",") {
+		return
+	}
+	entering, leaving := len(m.cordoned) == 0, len(cordoned) == 0
+	m.cordoned = cordoned
+
+	if leaving {
+		log.Printf("Maintenance: all nodes schedulable again, resuming")
+		m.sim.SetMaintenance(false)
+		maintenancePaused.Set(0)
+		// Keep a banner an operator has put up in the meantime.
+		if b := currentBanner(); b != nil && b.Data == m.banner {
+			publishBanner(BannerMessage{})
+		}
+		m.banner = BannerMessage{}
+		return
+	}
+	if entering {
+		log.Printf("Maintenance: nodes %s cordoned, freezing the simulation", strings.Join(cordoned, ", "))
+		m.sim.SetMaintenance(true)
+		maintenancePaused.Set(1)
+	}
+	text := fmt.Sprintf("Cluster maintenance: node %s is cordoned", cordoned[0])
+	if len(cordoned) > 1 {
+		text = fmt.Sprintf("Cluster maintenance: nodes %s are cordoned", strings.Join(cordoned, ", "))
+	}
+	if m.sim != nil {
+		text += "; the simulation is paused until they return"
+	}
+	m.banner = BannerMessage{Text: text, Severity: "warning"}
+	publishBanner(m.banner)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// The manifest of a grid captures what was changed through the API in
+// objects that can be committed to Git and applied again. There are no grid
+// or pattern custom resources: a grid is its namespace and cell-config, or,
+// for the controller's own grid, its configuration file, and its live cells
+// are exported as an RLE pattern in a ConfigMap, which POST
+// /api/patterns/upload?x=&y= stamps back.
+const (
+	patternConfigMapLabel = "cellular-automaton/pattern"
+	rleLineLength         = 70
+)
+
+// encodeRLE writes live cells as an RLE pattern trimmed to their bounding
+// box, and returns where its top-left corner lies on the grid.
+func encodeRLE(name, comment string, grid GridGeometry, alive []int) (rle string, x0, y0 int) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#N %s\n", name)
+	if comment != "" {
+		fmt.Fprintf(&b, "#C %s\n", comment)
+	}
+	if len(alive) == 0 {
+		b.WriteString("x = 0, y = 0\n!\n")
+		return b.String(), 0, 0
+	}
+
+	rows := map[int][]int{}
+	x0, y0 = grid.Width, grid.Height
+	x1, y1 := 0, 0
+	for _, i := range alive {
+		x, y := grid.Coords(i)
+		rows[y] = append(rows[y], x)
+		x0, y0, x1, y1 = min(x0, x), min(y0, y), max(x1, x), max(y1, y)
+	}
+	fmt.Fprintf(&b, "x = %d, y = %d\n", x1-x0+1, y1-y0+1)
+
+	var body strings.Builder
+	run := func(n int, tag byte) {
+		if n > 1 {
+			body.WriteString(strconv.Itoa(n))
+		}
+		if n > 0 {
+			body.WriteByte(tag)
+		}
+	}
+	blank := 0
+	for y := y0; y <= y1; y++ {
+		xs := rows[y]
+		if len(xs) == 0 {
+			blank++
+			continue
+		}
+		if y > y0 {
+			run(blank+1, '$')
+		}
+		blank = 0
+		sort.Ints(xs)
+		x, live := x0, 0
+		for _, cx := range xs {
+			if cx != x+live {
+				run(live, 'o')
+				run(cx-x-live, 'b')
+				x, live = cx, 0
+			}
+			live++
+		}
+		run(live, 'o')
+	}
+	body.WriteByte('!')
+
+	// Wrap without splitting a run count from its tag.
+	line := 0
+	token := ""
+	for _, c := range body.String() {
+		token += string(c)
+		if c >= '0' && c <= '9' {
+			continue
+		}
+		if line+len(token) > rleLineLength {
+			b.WriteByte('\n')
+			line = 0
+		}
+		b.WriteString(token)
+		line += len(token)
+		token = ""
+	}
+	b.WriteByte('\n')
+	return b.String(), x0, y0
+}
+
+// patternConfigMap holds a grid's live cells.
+func patternConfigMap(grid, namespace, comment string, geometry GridGeometry, alive []int) *v1.ConfigMap {
+	rle, x, y := encodeRLE(grid, comment, geometry, alive)
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pattern-" + grid,
+			Namespace: namespace,
+			Labels:    map[string]string{gridNameLabel: grid, patternConfigMapLabel: "true"},
+			Annotations: map[string]string{
+				"cellular-automaton/x": strconv.Itoa(x),
+				"cellular-automaton/y": strconv.Itoa(y),
+			},
+		},
+		Data: map[string]string{grid + ".rle": rle},
+	}
+}
+
+// selfManifest exports the controller's own grid: its configuration with the
+// changes made at runtime, and, with the standalone engine, its live cells.
+func selfManifest(cfg *Config, sim *simulation, monkey *chaosMonkey, namespace string) ([]any, error) {
+	c := *cfg
+	if monkey != nil && c.AutoChaos != nil {
+		autoChaos := *c.AutoChaos
+		autoChaos.Strategy = monkey.Strategy().Name()
+		c.AutoChaos = &autoChaos
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	objects := []any{&v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "grid-controller-config",
+			Namespace: namespace,
+			Labels:    map[string]string{gridNameLabel: gridID},
+		},
+		Data: map[string]string{"config.yaml": string(data)},
+	}}
+	if sim != nil {
+		gen, alive := sim.engine.Snapshot()
+		pattern := patternConfigMap(gridID, namespace, fmt.Sprintf("generation %d of grid %s", gen, gridID), sim.engine.grid, alive)
+		pattern.Annotations["cellular-automaton/generation"] = strconv.FormatInt(gen, 10)
+		pattern.Annotations["cellular-automaton/rule"] = sim.engine.Rule()
+		objects = append(objects, pattern)
+	}
+	return objects, nil
+}
+
+// Manifest exports a grid created through the API: its namespace,
+// cell-config and live cells.
+func (b *gridBootstrapper) Manifest(ctx context.Context, name, tenant string) ([]any, error) {
+	info, err := b.Get(ctx, name, tenant)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := b.clientset.CoreV1().Namespaces().Get(ctx, info.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	config, err := b.clientset.CoreV1().ConfigMaps(info.Namespace).Get(ctx, "cell-config", metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := b.clientset.CoreV1().Pods(info.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=cell,game-status=alive",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var alive []int
+	for _, pod := range pods.Items {
+		if i, ok := cellIndex(pod.Name); ok {
+			alive = append(alive, i)
+		}
+	}
+
+	namespace := &v1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: ns.Name, Labels: ns.Labels, Annotations: ns.Annotations},
+	}
+	cellConfig := &v1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: config.Name, Namespace: config.Namespace, Labels: config.Labels},
+		Data:       config.Data,
+	}
+	geometry := GridGeometry{Width: info.Width, Height: info.Height}
+	return []any{namespace, cellConfig, patternConfigMap(info.Name, info.Namespace, "grid "+info.Name, geometry, alive)}, nil
+}
+
+// writeManifest writes objects as a multi-document YAML stream.
+func writeManifest(w http.ResponseWriter, objects []any) {
+	var out bytes.Buffer
+	for i, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			http.Error(w, "Failed to encode manifest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if i > 0 {
+			out.WriteString("---\n")
+		}
+		out.Write(data)
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	out.WriteTo(w)
+}
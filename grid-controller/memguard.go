@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// memoryCheckInterval is how often the heap is compared with the budget.
+	memoryCheckInterval = 5 * time.Second
+	// memoryBudgetShare is the share of the container's memory limit the
+	// budget defaults to, leaving room for what the heap does not count.
+	memoryBudgetShare = 0.8
+	// memoryRecoveryShare is how far below the budget the heap must fall
+	// before load is taken on again, so the guard does not flap.
+	memoryRecoveryShare = 0.9
+
+	// In-memory histories kept while memory is short.
+	statsHistoryUnderPressure  = 1000
+	digestHistoryUnderPressure = 50
+)
+
+// memoryPressure is set while the heap is past the memory budget.
+var memoryPressure atomic.Bool
+
+var (
+	memoryBudgetBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "grid_memory_budget_bytes",
+		Help: "Heap budget past which the controller sheds load; 0 when disabled.",
+	})
+	memoryPressureGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "grid_memory_pressure",
+		Help: "1 while the heap is past the memory budget and load is shed.",
+	})
+	memoryShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grid_memory_shed_total",
+		Help: "Load shed to stay within the memory budget, by action: history, stats, digests or client.",
+	}, []string{"action"})
+)
+
+// memoryBudget resolves --memory-budget: negative disables the guard, zero
+// takes memoryBudgetShare of the container's cgroup memory limit, if any.
+func memoryBudget(flagValue int64) uint64 {
+	if flagValue > 0 {
+		return uint64(flagValue)
+	}
+	if flagValue < 0 {
+		return 0
+	}
+	return uint64(float64(cgroupMemoryLimit()) * memoryBudgetShare)
+}
+
+// cgroupMemoryLimit reads the container's memory limit from cgroup v2 or v1;
+// it returns 0 when there is none.
+func cgroupMemoryLimit() uint64 {
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		// "max", or v1's near-2^63 for no limit.
+		if err != nil || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
+
+// memoryGuard keeps the controller's heap within a budget. Past it, it sheds
+// load rather than growing until the container is OOMKilled and every viewer
+// is dropped at once: it trims the hub's resume history and the statistics
+// and digest histories (which also shrinks snapshots), keeps them short, and
+// turns new stream clients away until the heap is back under
+// memoryRecoveryShare of the budget. Connected viewers are kept.
+type memoryGuard struct {
+	budget uint64
+	// sim is nil with the cells engine.
+	sim *simulation
+}
+
+func newMemoryGuard(budget uint64, sim *simulation) *memoryGuard {
+	return &memoryGuard{budget: budget, sim: sim}
+}
+
+// Run checks the heap every memoryCheckInterval until ctx is done.
+func (g *memoryGuard) Run(ctx context.Context) {
+	memoryBudgetBytes.Set(float64(g.budget))
+	log.Printf("Memory: shedding load past a heap of %d MiB", g.budget>>20)
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		g.check(stats.HeapAlloc)
+	}
+}
+
+func (g *memoryGuard) check(heap uint64) {
+	switch {
+	case !memoryPressure.Load() && heap >= g.budget:
+		memoryPressure.Store(true)
+		memoryPressureGauge.Set(1)
+		log.Printf("Memory: WARNING heap of %d MiB is past the budget of %d MiB; shedding load and refusing new viewers", heap>>20, g.budget>>20)
+		g.shed()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		log.Printf("Memory: heap is %d MiB after shedding", stats.HeapAlloc>>20)
+	case memoryPressure.Load() && heap < uint64(float64(g.budget)*memoryRecoveryShare):
+		memoryPressure.Store(false)
+		memoryPressureGauge.Set(0)
+		log.Printf("Memory: heap of %d MiB is back within the budget; accepting new viewers", heap>>20)
+	}
+}
+
+// shed drops what can be rebuilt or done without and returns the memory to
+// the OS.
+func (g *memoryGuard) shed() {
+	shedHistory()
+	memoryShed.WithLabelValues("history").Inc()
+	if g.sim != nil {
+		if n := g.sim.stats.Shrink(statsHistoryUnderPressure); n > 0 {
+			memoryShed.WithLabelValues("stats").Inc()
+		}
+		if n := g.sim.digests.Shrink(digestHistoryUnderPressure); n > 0 {
+			memoryShed.WithLabelValues("digests").Inc()
+		}
+	}
+	debug.FreeOSMemory()
+}
+
+// admitClient turns new stream clients away while memory is short and writes
+// the error response; connected clients are unaffected.
+func admitClient(w http.ResponseWriter) bool {
+	if !memoryPressure.Load() {
+		return true
+	}
+	memoryShed.WithLabelValues("client").Inc()
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Service unavailable: the controller is short of memory", http.StatusServiceUnavailable)
+	return false
+}
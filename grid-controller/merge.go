@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Merge policies for manual edits of one cell within a generation.
+const (
+	mergeLastWriter = "lww"
+	mergePrecedence = "precedence"
+)
+
+// Outcomes of an edit that touched a cell already edited this generation.
+const (
+	// mergeApplied: this edit replaced the earlier one's state.
+	mergeApplied = "applied"
+	// mergeKept: the earlier edit stands and this one was not applied.
+	mergeKept = "kept"
+	// mergeAgreed: both edits set the same state.
+	mergeAgreed = "agreed"
+)
+
+// EditConfig decides how manual edits of the same cell within one
+// generation are merged, e.g.
+//
+//	edits:
+//	  merge: precedence
+//
+// Edits are sequenced in the order the controller receives them. With lww,
+// the default, each edit is applied in that order, so the last writer wins.
+// With precedence, an edit does not undo an earlier one by a caller with a
+// higher role; between equal roles the last writer wins.
+type EditConfig struct {
+	Merge string `json:"merge,omitempty"`
+}
+
+func (c *EditConfig) validate() error {
+	switch c.Merge {
+	case "":
+		c.Merge = mergeLastWriter
+	case mergeLastWriter, mergePrecedence:
+	default:
+		return fmt.Errorf("unknown merge policy %q", c.Merge)
+	}
+	return nil
+}
+
+var editConflictsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_edit_conflicts_total",
+	Help: "Manual edits of a cell already edited in the same generation, by outcome.",
+}, []string{"outcome"})
+
+// EditConflict reports a cell the edit shares with an earlier edit in the
+// same generation.
+type EditConflict struct {
+	Cell int `json:"cell"`
+	// With is the sequence number of the earlier edit, By its caller.
+	With    uint64 `json:"with"`
+	By      string `json:"by"`
+	Outcome string `json:"outcome"`
+}
+
+// cellWrite is the last edit of a cell in the current generation.
+type cellWrite struct {
+	seq   uint64
+	by    string
+	rank  int
+	alive bool
+}
+
+// editLedger sequences manual edits and merges those that touch the same
+// cell within a generation. The tick holds mu while it steps the engine, so
+// every edit lands wholly in one generation.
+type editLedger struct {
+	mu         sync.Mutex
+	policy     string
+	seq        uint64
+	generation int64
+	writes     map[int]cellWrite
+}
+
+// Apply sets cells to alive on behalf of the request's caller and returns
+// the acknowledgement. Cells already in that state are left alone.
+func (s *simulation) Apply(r *http.Request, cells []int, alive bool) EditResult {
+	return s.edit(r, func() (births, deaths []int) {
+		if alive {
+			return cells, nil
+		}
+		return nil, cells
+	})
+}
+
+// Combine applies a boolean operation between the grid and the cells of a
+// pattern (see combine) as one edit, so it lands wholly in one generation.
+func (s *simulation) Combine(r *http.Request, op string, cells []int) EditResult {
+	return s.edit(r, func() (births, deaths []int) {
+		return combine(op, s.engine, cells)
+	})
+}
+
+// edit sequences one edit on behalf of the request's caller: cells returns
+// the cells it brings to life and those it kills, and is called with the
+// ledger held, so it sees the generation the edit applies to.
+func (s *simulation) edit(r *http.Request, cells func() (births, deaths []int)) EditResult {
+	by, rank := requestIdentity(r), roleRank[requestRole(r)]
+
+	l := &s.edits
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if gen := s.engine.Generation(); l.writes == nil || gen != l.generation {
+		l.generation, l.writes = gen, make(map[int]cellWrite)
+	}
+	l.seq++
+	result := EditResult{Seq: l.seq, Generation: l.generation, Births: []int{}}
+	write := func(i int, alive bool) {
+		if prev, ok := l.writes[i]; ok && prev.seq != l.seq {
+			outcome := mergeApplied
+			switch {
+			case prev.alive == alive:
+				outcome = mergeAgreed
+			case l.policy == mergePrecedence && prev.rank > rank:
+				outcome = mergeKept
+			}
+			editConflictsTotal.WithLabelValues(outcome).Inc()
+			result.Conflicts = append(result.Conflicts, EditConflict{Cell: i, With: prev.seq, By: prev.by, Outcome: outcome})
+			if outcome == mergeKept {
+				return
+			}
+		}
+		l.writes[i] = cellWrite{seq: l.seq, by: by, rank: rank, alive: alive}
+		if s.engine.Alive(i) == alive {
+			return
+		}
+		s.engine.Set(i, alive)
+		if alive {
+			result.Births = append(result.Births, i)
+		} else {
+			result.Deaths = append(result.Deaths, i)
+		}
+	}
+	births, deaths := cells()
+	for _, i := range births {
+		write(i, true)
+	}
+	for _, i := range deaths {
+		write(i, false)
+	}
+	return result
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/nordiwnd/k3s-cellular-automaton/grid-controller/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var mirrorConnected = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_mirror_connected",
+	Help: "Whether a read-only mirror is following its primary (1) or not (0).",
+})
+
+// gridMirror keeps the standalone engine a read-only copy of a primary
+// controller's grid, followed over the primary's gRPC API, so viewers in
+// another region can stream from a nearby controller. A mirror neither
+// computes generations nor owns cell pods, and refuses mutating requests,
+// until it is promoted; then it carries on from the mirrored state. A
+// promoted mirror can be demoted again, e.g. at the end of a recovery drill,
+// and goes back to following.
+type gridMirror struct {
+	primary   string
+	sim       *simulation
+	namespace string
+	// start runs the simulation and the pod manager while promoted, until
+	// ctx is done.
+	start func(ctx context.Context)
+	// lease, when set, promotes the mirror while it holds the primary Lease
+	// and demotes it when it loses the Lease (--primary-lease).
+	lease *primaryLease
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed when following, or running as the primary, has
+	// stopped, so the two never overlap.
+	done       chan struct{}
+	promoted   bool
+	connected  bool
+	generation int64
+	revision   uint64
+	updated    time.Time
+}
+
+// MirrorStatus is the body of GET /api/mirror.
+type MirrorStatus struct {
+	Primary    string    `json:"primary"`
+	Promoted   bool      `json:"promoted"`
+	Connected  bool      `json:"connected"`
+	Generation int64     `json:"generation"`
+	Revision   uint64    `json:"revision"`
+	Updated    time.Time `json:"updated"`
+	// Lease and Holder name the primary Lease and its last observed holder.
+	Lease  string `json:"lease,omitempty"`
+	Holder string `json:"holder,omitempty"`
+}
+
+func newGridMirror(primary string, sim *simulation, namespace string) *gridMirror {
+	return &gridMirror{primary: primary, sim: sim, namespace: namespace}
+}
+
+func (m *gridMirror) Status() MirrorStatus {
+	m.mu.Lock()
+	status := MirrorStatus{
+		Primary:    m.primary,
+		Promoted:   m.promoted,
+		Connected:  m.connected,
+		Generation: m.generation,
+		Revision:   m.revision,
+		Updated:    m.updated,
+	}
+	m.mu.Unlock()
+	if m.lease != nil {
+		status.Lease, status.Holder = m.lease.name, m.lease.Holder()
+	}
+	return status
+}
+
+func (m *gridMirror) Promoted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.promoted
+}
+
+// Run follows the primary, and with a lease stands for primary, until ctx
+// is done.
+func (m *gridMirror) Run(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	if !m.promoted {
+		m.followLocked()
+	}
+	m.mu.Unlock()
+
+	if m.lease != nil {
+		m.lease.onLead = func(context.Context) {
+			if promoted, err := m.Promote(); promoted {
+				log.Printf("Mirror: promoted by lease %s at generation %d; no longer following %s", m.lease.name, m.sim.engine.Generation(), m.primary)
+			} else if err != nil {
+				log.Printf("Mirror: promote: %v", err)
+			}
+		}
+		m.lease.onLose = func() {
+			if m.Demote() {
+				log.Printf("Mirror: lost lease %s; following %s again", m.lease.name, m.primary)
+			}
+		}
+		m.lease.Run(ctx)
+		return
+	}
+	<-ctx.Done()
+}
+
+// followLocked starts following the primary once whatever ran before has
+// stopped. m.mu must be held.
+func (m *gridMirror) followLocked() {
+	ctx, cancel := context.WithCancel(m.ctx)
+	prev, done := m.done, make(chan struct{})
+	m.cancel, m.done = cancel, done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		client := dialFederationPeer(m.primary)
+		for ctx.Err() == nil {
+			err := m.follow(ctx, client)
+			m.setConnected(false)
+			if ctx.Err() == nil {
+				log.Printf("Mirror: primary %s: %v; retrying", m.primary, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}
+	}()
+}
+
+// follow backfills the primary's grid and applies its updates until the
+// stream fails or the mirrored grid drifts from the primary's state hash.
+func (m *gridMirror) follow(ctx context.Context, client pb.FederationServiceClient) error {
+	live, page, err := backfill(ctx, client)
+	if err != nil {
+		return err
+	}
+	grid := m.sim.engine.grid
+	if int(page.Width) != grid.Width || int(page.Height) != grid.Height {
+		return fmt.Errorf("primary grid is %dx%d, this one %dx%d", page.Width, page.Height, grid.Width, grid.Height)
+	}
+	births, deaths := m.sim.engine.Restore(page.Generation, live)
+	m.applied(page.Generation, page.Revision, births, deaths)
+
+	stream, err := client.WatchBandFrom(ctx, &pb.WatchBandRequest{FromRevision: page.Revision})
+	if err != nil {
+		return err
+	}
+	m.setConnected(true)
+	log.Printf("Mirror: following %s from generation %d", m.primary, page.Generation)
+	for {
+		u, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		births, deaths := make([]int, len(u.Births)), make([]int, len(u.Deaths))
+		for j, i := range u.Births {
+			births[j] = int(i)
+		}
+		for j, i := range u.Deaths {
+			deaths[j] = int(i)
+		}
+		m.sim.engine.Apply(u.Generation, births, deaths)
+		m.applied(u.Generation, u.Revision, births, deaths)
+		if u.Generation%federationVerifyEvery == 0 {
+			if err := verifyBand(ctx, client, u.Generation, m.sim.engine.LiveCells()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// applied streams mirrored changes to local viewers and to this
+// controller's own federation watchers, e.g. a mirror of the mirror.
+func (m *gridMirror) applied(gen int64, revision uint64, births, deaths []int) {
+	for _, i := range births {
+		publishCell(gridID, cellName(i), "alive", m.namespace)
+	}
+	for _, i := range deaths {
+		publishCell(gridID, cellName(i), "dead", m.namespace)
+	}
+	if f := m.sim.federation; f != nil {
+		f.Record()
+		f.Publish(births, deaths)
+	}
+
+	m.mu.Lock()
+	m.generation, m.revision, m.updated = gen, revision, time.Now()
+	m.mu.Unlock()
+}
+
+func (m *gridMirror) setConnected(connected bool) {
+	m.mu.Lock()
+	m.connected = connected
+	m.mu.Unlock()
+	v := 0.0
+	if connected {
+		v = 1
+	}
+	mirrorConnected.Set(v)
+}
+
+var errMirrorStarting = errors.New("the mirror has not started following its primary yet")
+
+// Promote stops following the primary and starts computing generations and
+// materializing cells from the mirrored state. It reports false if the
+// mirror was already promoted.
+func (m *gridMirror) Promote() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.promoted {
+		return false, nil
+	}
+	if m.ctx == nil {
+		return false, errMirrorStarting
+	}
+	m.promoted = true
+	m.cancel()
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	prev, done := m.done, make(chan struct{})
+	m.cancel, m.done = cancel, done
+	go func() {
+		defer close(done)
+		<-prev
+		m.setConnected(false)
+		m.sim.cells.Resync()
+		m.start(ctx)
+	}()
+	return true, nil
+}
+
+// Demote stops computing generations and managing cell pods and follows the
+// primary again, whose state then replaces this one. It reports false if
+// the mirror was not promoted.
+func (m *gridMirror) Demote() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.promoted {
+		return false
+	}
+	m.promoted = false
+	m.cancel()
+	m.followLocked()
+	return true
+}
+
+// Wrap refuses mutating requests while the mirror is read-only; only
+// promotion, demotion and sign-in go through.
+func (m *gridMirror) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS":
+		case strings.HasPrefix(r.URL.Path, "/api/mirror/") || strings.HasPrefix(r.URL.Path, "/api/auth/"):
+		case !m.Promoted():
+			http.Error(w, "Read-only mirror of "+m.primary+"; promote it with POST /api/mirror/promote first", http.StatusConflict)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMirror serves GET /api/mirror, the mirror's state,
+// POST /api/mirror/promote, which makes it take over from its primary, and
+// POST /api/mirror/demote, which makes it follow the primary again. With a
+// lease, promotion takes the Lease over, fencing the former primary, and
+// demotion hands it back.
+func handleMirror(w http.ResponseWriter, r *http.Request, m *gridMirror) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if m == nil {
+		http.Error(w, "Mirror mode is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/mirror":
+	case r.Method == "POST" && r.URL.Path == "/api/mirror/promote":
+		if !requireAdmin(w, r) {
+			return
+		}
+		if m.lease != nil {
+			if err := m.lease.Take(r.Context()); err != nil {
+				http.Error(w, "Take lease "+m.lease.name+": "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		promoted, err := m.Promote()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if promoted {
+			log.Printf("Mirror: promoted by %s at generation %d; no longer following %s", requestIdentity(r), m.sim.engine.Generation(), m.primary)
+		}
+	case r.Method == "POST" && r.URL.Path == "/api/mirror/demote":
+		if !requireAdmin(w, r) {
+			return
+		}
+		if m.lease != nil {
+			m.lease.StepDown()
+		}
+		if m.Demote() {
+			log.Printf("Mirror: demoted by %s at generation %d; following %s again", requestIdentity(r), m.sim.engine.Generation(), m.primary)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Status())
+}
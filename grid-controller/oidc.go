@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig plugs the controller into an OpenID Connect provider. The
+// dashboard signs in with the authorization-code flow through
+// /api/auth/login; CLIs run the device flow against the provider themselves
+// (see /api/auth/config). Either way the ID token is then sent as the bearer
+// token, and the caller's groups decide the role:
+//
+//	oidc:
+//	  issuer: https://sso.example.com/realms/lab
+//	  clientID: grid-dashboard
+//	  cliClientID: grid-cli
+//	  redirectURL: https://cells.example.com/api/auth/callback
+//	  dashboardURL: https://cells.example.com/
+//	  roles:
+//	    admin: [grid-admins]
+//	    operator: [grid-operators]
+//	    spectator: [staff]
+//
+// The dashboard client's secret is read from OIDC_CLIENT_SECRET.
+type OIDCConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientID"`
+	CLIClientID  string `json:"cliClientID,omitempty"`
+	RedirectURL  string `json:"redirectURL"`
+	DashboardURL string `json:"dashboardURL"`
+	// GroupsClaim names the ID token claim listing the caller's groups;
+	// defaults to "groups".
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+	// Roles maps each role to the groups granted it.
+	Roles map[string][]string `json:"roles"`
+}
+
+func (c *OIDCConfig) validate() error {
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" || c.DashboardURL == "" {
+		return errors.New("issuer, clientID, redirectURL and dashboardURL are required")
+	}
+	for role := range c.Roles {
+		if _, ok := roleRank[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	return nil
+}
+
+// oidcAuth verifies ID tokens; nil when OIDC is not configured.
+var oidcAuth *oidcAuthenticator
+
+type oidcAuthenticator struct {
+	cfg      OIDCConfig
+	client   *http.Client
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    oauth2.Config
+}
+
+// oidcIdentity is a caller authenticated by ID token.
+type oidcIdentity struct {
+	Subject string `json:"subject"`
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+}
+
+func newOIDCAuthenticator(ctx context.Context, cfg OIDCConfig) (*oidcAuthenticator, error) {
+	// The provider fetches its signing keys with this client later on too.
+	client := egressClient("oidc", 10*time.Second)
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcAuthenticator{
+		cfg:      cfg,
+		client:   client,
+		provider: provider,
+		// Tokens from either client are accepted; the audience is
+		// checked in Authenticate.
+		verifier: provider.Verifier(&oidc.Config{SkipClientIDCheck: true}),
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		},
+	}, nil
+}
+
+// Authenticate verifies an ID token and maps the caller's groups to the
+// highest role granted. Callers without any mapped group get no role.
+func (a *oidcAuthenticator) Authenticate(ctx context.Context, rawToken string) (*oidcIdentity, error) {
+	token, err := a.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(token.Audience, a.cfg.ClientID) && (a.cfg.CLIClientID == "" || !slices.Contains(token.Audience, a.cfg.CLIClientID)) {
+		return nil, errors.New("token issued for another client")
+	}
+
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+	id := &oidcIdentity{Subject: token.Subject, Name: token.Subject}
+	if email, ok := claims["email"].(string); ok {
+		id.Name = email
+	}
+	groups, _ := claims[a.cfg.GroupsClaim].([]any)
+	for role, granted := range a.cfg.Roles {
+		for _, g := range groups {
+			if s, ok := g.(string); ok && slices.Contains(granted, s) && roleRank[role] > roleRank[id.Role] {
+				id.Role = role
+			}
+		}
+	}
+	return id, nil
+}
+
+// oidcCaller authenticates the request's bearer token as an ID token.
+func oidcCaller(r *http.Request) *oidcIdentity {
+	if oidcAuth == nil {
+		return nil
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil
+	}
+	id, err := oidcAuth.Authenticate(r.Context(), token)
+	if err != nil {
+		return nil
+	}
+	return id
+}
+
+const oidcStateCookie = "grid_oidc_state"
+
+// handleAuth serves the OIDC endpoints:
+//
+//	GET /api/auth/config    provider details for CLIs running the device flow
+//	GET /api/auth/login     starts the dashboard's authorization-code flow
+//	GET /api/auth/callback  finishes it and hands the ID token to the dashboard
+//	GET /api/auth/whoami    the caller's identity and role
+func handleAuth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path == "/api/auth/whoami" {
+		resp := struct {
+			Identity string `json:"identity"`
+			Role     string `json:"role,omitempty"`
+		}{Identity: requestIdentity(r), Role: requestRole(r)}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if oidcAuth == nil {
+		http.Error(w, "OIDC not configured", http.StatusNotFound)
+		return
+	}
+	a := oidcAuth
+
+	switch r.URL.Path {
+	case "/api/auth/config":
+		endpoint := a.provider.Endpoint()
+		resp := struct {
+			Issuer                      string `json:"issuer"`
+			ClientID                    string `json:"clientID"`
+			DeviceAuthorizationEndpoint string `json:"deviceAuthorizationEndpoint,omitempty"`
+			TokenEndpoint               string `json:"tokenEndpoint"`
+		}{a.cfg.Issuer, a.cfg.CLIClientID, endpoint.DeviceAuthURL, endpoint.TokenURL}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case "/api/auth/login":
+		b := make([]byte, 16)
+		rand.Read(b)
+		state := hex.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     "/api/auth/",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, a.oauth.AuthCodeURL(state), http.StatusFound)
+
+	case "/api/auth/callback":
+		cookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || cookie.Value != r.URL.Query().Get("state") {
+			http.Error(w, "Invalid login state", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/", MaxAge: -1})
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		token, err := a.oauth.Exchange(context.WithValue(ctx, oauth2.HTTPClient, a.client), r.URL.Query().Get("code"))
+		if err != nil {
+			log.Printf("OIDC: code exchange: %v", err)
+			http.Error(w, "Login failed", http.StatusBadGateway)
+			return
+		}
+		idToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			http.Error(w, "Login failed: no ID token", http.StatusBadGateway)
+			return
+		}
+		if _, err := a.Authenticate(ctx, idToken); err != nil {
+			log.Printf("OIDC: login: %v", err)
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+			return
+		}
+		// The fragment never reaches a server, so the token stays in the
+		// browser.
+		http.Redirect(w, r, a.cfg.DashboardURL+"#"+url.Values{"id_token": {idToken}}.Encode(), http.StatusFound)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
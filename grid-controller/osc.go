@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+)
+
+// OSCConfig sends generation and population events as Open Sound Control
+// messages over UDP, for TouchDesigner, Max/MSP, lighting consoles and the
+// like, e.g.
+//
+//	osc:
+//	  targets: ["192.168.1.20:9000", "lighting.local:8000"]
+//	  prefix: /grid
+//
+// Every generation (every population sample with --engine=cells) sends
+//
+//	/grid/generation  generation population  (int32s)
+//
+// and, when they apply,
+//
+//	/grid/population  population             (int32, when it changed)
+//	/grid/births      count                  (int32, standalone engine)
+//	/grid/deaths      count                  (int32, standalone engine)
+//	/grid/extinct     generation             (int32, when the last cell died)
+//	/grid/alert       name message           (strings)
+type OSCConfig struct {
+	Targets []string `json:"targets"`
+	Prefix  string   `json:"prefix,omitempty"`
+}
+
+func (c *OSCConfig) validate() error {
+	if len(c.Targets) == 0 {
+		return errors.New("at least one target is required")
+	}
+	for _, t := range c.Targets {
+		if _, _, err := net.SplitHostPort(t); err != nil {
+			return fmt.Errorf("target %q: %w", t, err)
+		}
+	}
+	if c.Prefix == "" {
+		c.Prefix = "/grid"
+	}
+	if !strings.HasPrefix(c.Prefix, "/") || strings.HasSuffix(c.Prefix, "/") {
+		return fmt.Errorf("prefix %q must start and not end with /", c.Prefix)
+	}
+	return nil
+}
+
+// oscOutput sends OSC messages to a fixed set of UDP targets. Its methods are
+// safe to call on a nil output and from any goroutine.
+type oscOutput struct {
+	prefix string
+	conns  []net.Conn
+
+	mu             sync.Mutex
+	lastPopulation int
+	failing        bool
+}
+
+func newOSCOutput(prefix string, targets ...string) (*oscOutput, error) {
+	o := &oscOutput{prefix: prefix, lastPopulation: -1}
+	for _, t := range targets {
+		conn, err := net.Dial("udp", t)
+		if err != nil {
+			return nil, err
+		}
+		o.conns = append(o.conns, conn)
+	}
+	return o, nil
+}
+
+// Observe reports a population sample.
+func (o *oscOutput) Observe(gen int64, population int) {
+	o.Generation(gen, population, nil, nil)
+}
+
+// Generation reports a computed generation; births and deaths are nil where
+// the controller does not compute generations itself.
+func (o *oscOutput) Generation(gen int64, population int, births, deaths []int) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.send("/generation", int32(gen), int32(population))
+	if population != o.lastPopulation {
+		o.send("/population", int32(population))
+		if population == 0 && o.lastPopulation > 0 {
+			o.send("/extinct", int32(gen))
+		}
+		o.lastPopulation = population
+	}
+	if births != nil || deaths != nil {
+		o.send("/births", int32(len(births)))
+		o.send("/deaths", int32(len(deaths)))
+	}
+}
+
+// Alert reports a firing alert.
+func (o *oscOutput) Alert(alert Alert) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.send("/alert", alert.Name, alert.Message)
+}
+
+// send writes one message to every target. UDP writes only fail locally, so
+// failures are logged once until a write succeeds again.
+func (o *oscOutput) send(address string, args ...any) {
+	msg := oscMessage(o.prefix+address, args...)
+	for _, conn := range o.conns {
+		if _, err := conn.Write(msg); err != nil {
+			if !o.failing {
+				log.Printf("OSC: %s: %v", conn.RemoteAddr(), err)
+			}
+			o.failing = true
+			return
+		}
+	}
+	o.failing = false
+}
+
+// oscMessage encodes an OSC 1.0 message. Arguments are int32, float32 or
+// string.
+func oscMessage(address string, args ...any) []byte {
+	tags := []byte{','}
+	var data []byte
+	for _, a := range args {
+		switch v := a.(type) {
+		case int32:
+			tags = append(tags, 'i')
+			data = binary.BigEndian.AppendUint32(data, uint32(v))
+		case float32:
+			tags = append(tags, 'f')
+			data = binary.BigEndian.AppendUint32(data, math.Float32bits(v))
+		case string:
+			tags = append(tags, 's')
+			data = oscString(data, v)
+		default:
+			panic(fmt.Sprintf("osc: unsupported argument %T", a))
+		}
+	}
+	b := oscString(nil, address)
+	b = oscString(b, string(tags))
+	return append(b, data...)
+}
+
+// oscString appends a NUL-terminated string padded to a multiple of 4 bytes.
+func oscString(b []byte, s string) []byte {
+	b = append(b, s...)
+	return append(b, make([]byte, 4-len(s)%4)...)
+}
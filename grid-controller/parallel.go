@@ -0,0 +1,37 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ruleWorkers is how many goroutines compute a generation of the standalone
+// engine (--rule-workers).
+var ruleWorkers = runtime.GOMAXPROCS(0)
+
+// parallelMinCells is the grid size below which a generation is computed on
+// one goroutine; smaller grids lose more to scheduling than they gain.
+const parallelMinCells = 1 << 14
+
+// partition splits [0, n) into one contiguous range per rule worker and
+// runs fn on the ranges concurrently, returning once all are done. Each item
+// stands for cells cells, e.g. a grid row, so that small grids stay on one
+// goroutine. Grids are partitioned by rows, so every worker reads and writes
+// its own region of memory.
+func partition(n, cells int, fn func(lo, hi int)) {
+	workers := min(ruleWorkers, n)
+	if workers <= 1 || n*cells < parallelMinCells {
+		fn(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
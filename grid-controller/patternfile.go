@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits on uploaded patterns. Every parser enforces them while reading, so
+// a hostile file is rejected before it costs more than its own size.
+const (
+	maxPatternBytes = 1 << 20
+	maxPatternSide  = 4096
+	maxPatternCells = 1 << 16
+	// maxMacrocellLevel is the level of a macrocell node maxPatternSide wide.
+	maxMacrocellLevel = 12
+)
+
+// Pattern file formats.
+const (
+	formatRLE       = "rle"
+	formatPlaintext = "cells"
+	formatMacrocell = "mc"
+)
+
+var (
+	errPatternTooLarge = fmt.Errorf("pattern exceeds %dx%d cells or %d live cells", maxPatternSide, maxPatternSide, maxPatternCells)
+	errPatternEmpty    = errors.New("pattern has no live cells")
+)
+
+// parsePattern reads a pattern in the given format, or guesses the format
+// when it is empty.
+func parsePattern(format string, data []byte) (Pattern, error) {
+	if len(data) > maxPatternBytes {
+		return Pattern{}, fmt.Errorf("pattern file larger than %d bytes", maxPatternBytes)
+	}
+	if format == "" {
+		format = detectPatternFormat(data)
+	}
+	switch format {
+	case formatRLE:
+		return parseRLE(data)
+	case formatPlaintext:
+		return parsePlaintext(data)
+	case formatMacrocell:
+		return parseMacrocell(data)
+	}
+	return Pattern{}, fmt.Errorf("unknown pattern format %q", format)
+}
+
+func detectPatternFormat(data []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "[M2]"):
+			return formatMacrocell
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "!"):
+			return formatPlaintext
+		case strings.HasPrefix(line, "x"):
+			return formatRLE
+		default:
+			return formatPlaintext
+		}
+	}
+	return formatPlaintext
+}
+
+// patternBuilder collects live cells within the limits.
+type patternBuilder struct {
+	p    Pattern
+	seen map[[2]int]bool
+}
+
+func (b *patternBuilder) set(x, y int) error {
+	if x < 0 || y < 0 || x >= maxPatternSide || y >= maxPatternSide {
+		return errPatternTooLarge
+	}
+	if b.seen == nil {
+		b.seen = make(map[[2]int]bool)
+	}
+	if b.seen[[2]int{x, y}] {
+		return nil
+	}
+	if len(b.p.Cells) >= maxPatternCells {
+		return errPatternTooLarge
+	}
+	b.seen[[2]int{x, y}] = true
+	b.p.Cells = append(b.p.Cells, [2]int{x, y})
+	b.p.Width = max(b.p.Width, x+1)
+	b.p.Height = max(b.p.Height, y+1)
+	return nil
+}
+
+func (b *patternBuilder) pattern(name string) (Pattern, error) {
+	if len(b.p.Cells) == 0 {
+		return Pattern{}, errPatternEmpty
+	}
+	b.p.Name = name
+	sort.Slice(b.p.Cells, func(i, j int) bool {
+		if b.p.Cells[i][1] != b.p.Cells[j][1] {
+			return b.p.Cells[i][1] < b.p.Cells[j][1]
+		}
+		return b.p.Cells[i][0] < b.p.Cells[j][0]
+	})
+	return b.p, nil
+}
+
+// parseRLE reads the run length encoded format:
+//
+//	#N Glider
+//	x = 3, y = 3, rule = B3/S23
+//	bob$2bo$3o!
+func parseRLE(data []byte) (Pattern, error) {
+	var b patternBuilder
+	name := "uploaded"
+	lines := strings.Split(string(data), "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#N") {
+			name = patternName(line[2:], name)
+		}
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+	}
+	if i == len(lines) {
+		return Pattern{}, errors.New("rle: missing header line")
+	}
+	width, height, err := parseRLEHeader(strings.TrimSpace(lines[i]))
+	if err != nil {
+		return Pattern{}, err
+	}
+
+	x, y, run := 0, 0, 0
+	for _, line := range lines[i+1:] {
+		for _, c := range strings.TrimSpace(line) {
+			switch {
+			case c >= '0' && c <= '9':
+				run = run*10 + int(c-'0')
+				if run > maxPatternSide {
+					return Pattern{}, errPatternTooLarge
+				}
+				continue
+			case c == 'b' || c == '.':
+				x += max(run, 1)
+			case c == 'o' || c == 'A':
+				for n := max(run, 1); n > 0; n-- {
+					if err := b.set(x, y); err != nil {
+						return Pattern{}, err
+					}
+					x++
+				}
+			case c == '$':
+				y += max(run, 1)
+				x = 0
+			case c == '!':
+				return b.bounded(name, width, height)
+			case c == ' ' || c == '\t' || c == '\r':
+			default:
+				return Pattern{}, fmt.Errorf("rle: unexpected %q", c)
+			}
+			run = 0
+			if x > maxPatternSide || y > maxPatternSide {
+				return Pattern{}, errPatternTooLarge
+			}
+		}
+	}
+	return b.bounded(name, width, height)
+}
+
+// bounded checks the cells against the size an RLE header declared.
+func (b *patternBuilder) bounded(name string, width, height int) (Pattern, error) {
+	if b.p.Width > width || b.p.Height > height {
+		return Pattern{}, fmt.Errorf("rle: cells outside the declared %dx%d", width, height)
+	}
+	p, err := b.pattern(name)
+	if err == nil {
+		p.Width, p.Height = width, height
+	}
+	return p, err
+}
+
+func parseRLEHeader(line string) (width, height int, err error) {
+	width, height = -1, -1
+	for _, field := range strings.Split(line, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return 0, 0, fmt.Errorf("rle: malformed header %q", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "x":
+			width, err = parseExtent(value)
+		case "y":
+			height, err = parseExtent(value)
+		case "rule":
+			err = requireLifeRule(value)
+		default:
+			err = fmt.Errorf("rle: unknown header field %q", key)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if width < 0 || height < 0 {
+		return 0, 0, errors.New("rle: header needs x and y")
+	}
+	return width, height, nil
+}
+
+func parseExtent(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > maxPatternSide {
+		return 0, errPatternTooLarge
+	}
+	return n, nil
+}
+
+// parsePlaintext reads the plaintext .cells format: ! comments, then rows of
+// . for dead and O (or *) for live cells.
+func parsePlaintext(data []byte) (Pattern, error) {
+	var b patternBuilder
+	name := "uploaded"
+	y := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "!") {
+			if n, ok := strings.CutPrefix(line, "!Name:"); ok {
+				name = patternName(n, name)
+			}
+			continue
+		}
+		for x, c := range []byte(line) {
+			switch c {
+			case 'O', '*':
+				if err := b.set(x, y); err != nil {
+					return Pattern{}, err
+				}
+			case '.', ' ':
+			default:
+				return Pattern{}, fmt.Errorf("cells: unexpected %q in row %d", c, y+1)
+			}
+		}
+		y++
+	}
+	return b.pattern(name)
+}
+
+// macrocellNode is a node of a macrocell quadtree: a leaf 8x8 block at level
+// 3, or four children one level down.
+type macrocellNode struct {
+	level    int
+	leaf     [8][8]bool
+	children [4]int // nw, ne, sw, se; 0 is the empty node
+	// population saturates at maxPatternCells+1.
+	population int
+}
+
+// parseMacrocell reads Golly's Macrocell format, a hashed quadtree. The tree
+// can describe astronomically large patterns in a few lines, so populations
+// are summed, and bounded, before anything is expanded.
+func parseMacrocell(data []byte) (Pattern, error) {
+	lines := strings.Split(string(data), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(strings.TrimSpace(lines[0]), "[M2]") {
+		return Pattern{}, errors.New("mc: missing [M2] header")
+	}
+	name := "uploaded"
+	nodes := []macrocellNode{{}} // node 0 is empty
+	for n, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#R"):
+			if err := requireLifeRule(strings.TrimSpace(line[2:])); err != nil {
+				return Pattern{}, err
+			}
+			continue
+		case strings.HasPrefix(line, "#N"):
+			name = patternName(line[2:], name)
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		}
+		node, err := parseMacrocellLine(line, nodes)
+		if err != nil {
+			return Pattern{}, fmt.Errorf("mc: line %d: %w", n+2, err)
+		}
+		nodes = append(nodes, node)
+		if len(nodes) > maxPatternCells {
+			return Pattern{}, errPatternTooLarge
+		}
+	}
+	if len(nodes) == 1 {
+		return Pattern{}, errPatternEmpty
+	}
+	root := len(nodes) - 1
+	if nodes[root].population > maxPatternCells {
+		return Pattern{}, errPatternTooLarge
+	}
+
+	var b patternBuilder
+	var expand func(i, x, y int) error
+	expand = func(i, x, y int) error {
+		node := &nodes[i]
+		if i == 0 || node.population == 0 {
+			return nil
+		}
+		if node.level == 3 {
+			for r := range node.leaf {
+				for c, alive := range node.leaf[r] {
+					if alive {
+						if err := b.set(x+c, y+r); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		}
+		half := 1 << (node.level - 1)
+		for q, child := range node.children {
+			if err := expand(child, x+half*(q%2), y+half*(q/2)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expand(root, 0, 0); err != nil {
+		return Pattern{}, err
+	}
+	return b.trimmed(name)
+}
+
+func parseMacrocellLine(line string, nodes []macrocellNode) (macrocellNode, error) {
+	if c := line[0]; c == '.' || c == '*' || c == '$' {
+		node := macrocellNode{level: 3}
+		x, y := 0, 0
+		for _, c := range line {
+			switch c {
+			case '.', '*':
+				if x >= 8 || y >= 8 {
+					return node, errors.New("leaf larger than 8x8")
+				}
+				if c == '*' {
+					node.leaf[y][x] = true
+					node.population++
+				}
+				x++
+			case '$':
+				x, y = 0, y+1
+			default:
+				return node, fmt.Errorf("unexpected %q", c)
+			}
+		}
+		return node, nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		return macrocellNode{}, errors.New("want level and four children")
+	}
+	level, err := strconv.Atoi(fields[0])
+	if err != nil || level < 4 || level > maxMacrocellLevel {
+		return macrocellNode{}, fmt.Errorf("level must be 4 to %d", maxMacrocellLevel)
+	}
+	node := macrocellNode{level: level}
+	for q, f := range fields[1:] {
+		child, err := strconv.Atoi(f)
+		// Children are defined before their parents.
+		if err != nil || child < 0 || child >= len(nodes) {
+			return node, fmt.Errorf("invalid child %q", f)
+		}
+		if child != 0 && nodes[child].level != level-1 {
+			return node, fmt.Errorf("child %d is not at level %d", child, level-1)
+		}
+		node.children[q] = child
+		node.population = min(node.population+nodes[child].population, maxPatternCells+1)
+	}
+	return node, nil
+}
+
+// trimmed moves the pattern to the top-left corner of its bounding box.
+func (b *patternBuilder) trimmed(name string) (Pattern, error) {
+	p, err := b.pattern(name)
+	if err != nil {
+		return p, err
+	}
+	minX, minY := maxPatternSide, maxPatternSide
+	for _, c := range p.Cells {
+		minX, minY = min(minX, c[0]), min(minY, c[1])
+	}
+	p.Width, p.Height = 0, 0
+	for i, c := range p.Cells {
+		p.Cells[i] = [2]int{c[0] - minX, c[1] - minY}
+		p.Width, p.Height = max(p.Width, c[0]-minX+1), max(p.Height, c[1]-minY+1)
+	}
+	return p, nil
+}
+
+// patternName cleans a name taken from a pattern file.
+func patternName(s, fallback string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return fallback
+	}
+	if len(s) > 64 {
+		s = s[:64]
+	}
+	return strings.ToValidUTF8(s, "")
+}
+
+// parseRule normalizes a Life-like rule in B/S ("B3/S23") or S/B ("23/3")
+// notation to B/S.
+func parseRule(s string) (string, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) > 32 {
+		return "", errors.New("rule too long")
+	}
+	first, second, ok := strings.Cut(s, "/")
+	if !ok {
+		return "", fmt.Errorf("invalid rule %q", s)
+	}
+	var birth, survival string
+	switch {
+	case strings.HasPrefix(first, "B") && strings.HasPrefix(second, "S"):
+		birth, survival = first[1:], second[1:]
+	case strings.HasPrefix(first, "S") && strings.HasPrefix(second, "B"):
+		birth, survival = second[1:], first[1:]
+	default:
+		birth, survival = second, first
+	}
+	b, err := neighborCounts(birth)
+	if err != nil {
+		return "", err
+	}
+	sv, err := neighborCounts(survival)
+	if err != nil {
+		return "", err
+	}
+	return "B" + b + "/S" + sv, nil
+}
+
+// neighborCounts validates and sorts the digits of one half of a rule.
+func neighborCounts(s string) (string, error) {
+	var seen [9]bool
+	for _, c := range s {
+		if c < '0' || c > '8' {
+			return "", fmt.Errorf("invalid neighbor count %q", c)
+		}
+		seen[c-'0'] = true
+	}
+	var out []byte
+	for n, ok := range seen {
+		if ok {
+			out = append(out, byte('0'+n))
+		}
+	}
+	return string(out), nil
+}
+
+// requireLifeRule accepts only Conway's Life, in any notation: patterns are
+// stamped as-is whatever rule the engine runs.
+func requireLifeRule(s string) error {
+	rule, err := parseRule(s)
+	if err != nil {
+		return err
+	}
+	if rule != lifeRule {
+		return fmt.Errorf("unsupported rule %s (only %s)", rule, lifeRule)
+	}
+	return nil
+}
+
+// parseCoordinate reads a coordinate, which may lie outside the grid by up to
+// a pattern's size so that patterns can be clipped at the edges.
+func parseCoordinate(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if len(s) > 8 || err != nil || n < -maxPatternSide || n > maxPatternSide {
+		return 0, fmt.Errorf("coordinates must be integers between %d and %d", -maxPatternSide, maxPatternSide)
+	}
+	return n, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+)
+
+// checkPattern asserts the invariants every parsed pattern must satisfy.
+func checkPattern(t *testing.T, p Pattern) {
+	t.Helper()
+	if len(p.Cells) == 0 || len(p.Cells) > maxPatternCells {
+		t.Fatalf("%d cells", len(p.Cells))
+	}
+	if p.Width > maxPatternSide || p.Height > maxPatternSide {
+		t.Fatalf("pattern is %dx%d", p.Width, p.Height)
+	}
+	for _, c := range p.Cells {
+		if c[0] < 0 || c[1] < 0 || c[0] >= p.Width || c[1] >= p.Height {
+			t.Fatalf("cell %v outside %dx%d", c, p.Width, p.Height)
+		}
+	}
+}
+
+func FuzzParseRLE(f *testing.F) {
+	f.Add([]byte("#N Glider\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n"))
+	f.Add([]byte("x = 0, y = 0\n!"))
+	f.Add([]byte("x = 4096, y = 4096\n4096o$4096o!"))
+	f.Add([]byte("x=2,y=1\n99999999999o!"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if p, err := parseRLE(data); err == nil {
+			checkPattern(t, p)
+		}
+	})
+}
+
+func FuzzParsePlaintext(f *testing.F) {
+	f.Add([]byte("!Name: Glider\n.O.\n..O\nOOO\n"))
+	f.Add([]byte("!\n\r\n*"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if p, err := parsePlaintext(data); err == nil {
+			checkPattern(t, p)
+		}
+	})
+}
+
+func FuzzParseMacrocell(f *testing.F) {
+	f.Add([]byte("[M2] (golly 4.2)\n#R B3/S23\n$$$$$.*$..*$***$\n4 0 0 0 1\n5 2 2 2 2\n"))
+	f.Add([]byte("[M2]\n********$********$\n4 1 1 1 1\n5 2 2 2 2\n6 3 3 3 3\n7 4 4 4 4\n8 5 5 5 5\n9 6 6 6 6\n10 7 7 7 7\n"))
+	f.Add([]byte("[M2]\n4 0 0 0 1\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if p, err := parseMacrocell(data); err == nil {
+			checkPattern(t, p)
+		}
+	})
+}
+
+func FuzzParseRule(f *testing.F) {
+	for _, s := range []string{"B3/S23", "b3/s23", "23/3", "S23/B3", "B36/S23", "/", "B9/S", ""} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		rule, err := parseRule(s)
+		if err != nil {
+			return
+		}
+		again, err := parseRule(rule)
+		if err != nil || again != rule {
+			t.Fatalf("%q normalized to %q, which normalizes to %q, %v", s, rule, again, err)
+		}
+	})
+}
+
+func FuzzParseCoordinate(f *testing.F) {
+	for _, s := range []string{"0", "-3", "4096", "4097", "+12", "1e3", "99999999999999999999"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		n, err := parseCoordinate(s)
+		if err == nil && (n < -maxPatternSide || n > maxPatternSide) {
+			t.Fatalf("%q parsed to %d", s, n)
+		}
+	})
+}
+
+func TestParsePatternFormats(t *testing.T) {
+	glider := builtinPatterns["glider"]
+	for format, data := range map[string]string{
+		formatRLE:       "#N Glider\nx = 3, y = 3, rule = 23/3\nbob$2bo$3o!\n",
+		formatPlaintext: "!Name: Glider\n.O.\n..O\nOOO\n",
+		formatMacrocell: "[M2] (golly 4.2)\n#R B3/S23\n$$$$$.*$..*$***$\n4 0 0 0 1\n",
+	} {
+		p, err := parsePattern("", []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got, want := len(p.Cells), len(glider.Cells); got != want || p.Width != 3 || p.Height != 3 {
+			t.Errorf("%s: got %dx%d with %d cells, want a glider", format, p.Width, p.Height, got)
+		}
+	}
+}
+
+func TestParsePatternLimits(t *testing.T) {
+	for name, data := range map[string]string{
+		"rle wider than declared": "x = 1, y = 1\n2o!",
+		"rle other rule":          "x = 1, y = 1, rule = B36/S23\no!",
+		"rle long run":            "x = 4096, y = 1\n5000o!",
+		"mc deep tree":            "[M2]\n*$\n4 1 0 0 0\n5 2 0 0 0\n6 3 0 0 0\n7 4 0 0 0\n8 5 0 0 0\n9 6 0 0 0\n10 7 0 0 0\n11 8 0 0 0\n12 9 0 0 0\n13 10 0 0 0\n",
+		"mc dense":                "[M2]\n********$********$********$********$********$********$********$********$\n4 1 1 1 1\n5 2 2 2 2\n6 3 3 3 3\n7 4 4 4 4\n8 5 5 5 5\n9 6 6 6 6\n",
+		"mc forward reference":    "[M2]\n4 2 0 0 0\n*$\n",
+		"cells stray character":   "O?O",
+	} {
+		if _, err := parsePattern("", []byte(data)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Pattern is a set of live cells relative to its top-left corner.
+type Pattern struct {
+	Name   string   `json:"name"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Cells  [][2]int `json:"cells"`
+}
+
+func newPattern(name string, rows ...string) Pattern {
+	p := Pattern{Name: name, Height: len(rows)}
+	for y, row := range rows {
+		p.Width = max(p.Width, len(row))
+		for x, c := range row {
+			if c == 'O' {
+				p.Cells = append(p.Cells, [2]int{x, y})
+			}
+		}
+	}
+	return p
+}
+
+var builtinPatterns = map[string]Pattern{
+	"blinker":     newPattern("blinker", "OOO"),
+	"glider":      newPattern("glider", ".O.", "..O", "OOO"),
+	"lwss":        newPattern("lwss", ".O..O", "O....", "O...O", "OOOO."),
+	"r-pentomino": newPattern("r-pentomino", ".OO", "OO.", ".O."),
+	"acorn":       newPattern("acorn", ".O.....", "...O...", "OO..OOO"),
+	"pulsar": newPattern("pulsar",
+		"..OOO...OOO..",
+		".............",
+		"O....O.O....O",
+		"O....O.O....O",
+		"O....O.O....O",
+		"..OOO...OOO..",
+		".............",
+		"..OOO...OOO..",
+		"O....O.O....O",
+		"O....O.O....O",
+		"O....O.O....O",
+		".............",
+		"..OOO...OOO.."),
+}
+
+func builtinPatternNames() []string {
+	names := make([]string, 0, len(builtinPatterns))
+	for name := range builtinPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// randomSoup fills the whole grid with the given density of live cells.
+func randomSoup(grid GridGeometry, density float64) Pattern {
+	p := Pattern{Name: "random", Width: grid.Width, Height: grid.Height}
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			if rand.Float64() < density {
+				p.Cells = append(p.Cells, [2]int{x, y})
+			}
+		}
+	}
+	return p
+}
+
+// Stamp brings the pattern's cells to life with its top-left corner at
+// (x0, y0), clipping at the grid edges, and returns the births.
+func (e *Engine) Stamp(p Pattern, x0, y0 int) []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var births []int
+	for _, i := range e.grid.PatternCells(p, x0, y0) {
+		if !e.live.Has(i) {
+			e.live.Set(i)
+			births = append(births, i)
+		}
+	}
+	e.genetics.found(births...)
+	e.changed()
+	return births
+}
+
+// PatternCells returns the indices the pattern covers with its top-left
+// corner at (x0, y0), clipped at the grid edges.
+func (g GridGeometry) PatternCells(p Pattern, x0, y0 int) []int {
+	var cells []int
+	for _, c := range p.Cells {
+		x, y := x0+c[0], y0+c[1]
+		if g.Contains(x, y) {
+			cells = append(cells, g.Index(x, y))
+		}
+	}
+	return cells
+}
+
+// PatternCellsCentered returns the indices the pattern covers in the middle
+// of the grid.
+func (g GridGeometry) PatternCellsCentered(p Pattern) []int {
+	return g.PatternCells(p, (g.Width-p.Width)/2, (g.Height-p.Height)/2)
+}
+
+// StampCentered stamps the pattern in the middle of the grid.
+func (e *Engine) StampCentered(p Pattern) []int {
+	return e.Stamp(p, (e.grid.Width-p.Width)/2, (e.grid.Height-p.Height)/2)
+}
+
+// Boolean operations between the grid and a pattern placed on it.
+const (
+	// opUnion brings the pattern's cells to life and leaves the rest:
+	// stamping without overwriting.
+	opUnion = "union"
+	// opIntersection kills every cell outside the pattern's live cells, so
+	// the pattern acts as a mask.
+	opIntersection = "intersection"
+	// opDifference kills the pattern's cells, erasing with it.
+	opDifference = "difference"
+	// opXor flips the pattern's cells.
+	opXor = "xor"
+)
+
+var patternOps = []string{opUnion, opIntersection, opDifference, opXor}
+
+// combine returns the cells an operation between the grid and the cells a
+// pattern covers sets alive and dead.
+func combine(op string, e *Engine, cells []int) (births, deaths []int) {
+	switch op {
+	case opUnion:
+		return cells, nil
+	case opDifference:
+		return nil, cells
+	case opXor:
+		for _, i := range cells {
+			if e.Alive(i) {
+				deaths = append(deaths, i)
+			} else {
+				births = append(births, i)
+			}
+		}
+		return births, deaths
+	case opIntersection:
+		mask := make(map[int]bool, len(cells))
+		for _, i := range cells {
+			mask[i] = true
+		}
+		for _, i := range e.LiveCells() {
+			if !mask[i] {
+				deaths = append(deaths, i)
+			}
+		}
+		return nil, deaths
+	}
+	return nil, nil
+}
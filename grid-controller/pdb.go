@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// DisruptionBudgetConfig protects the grid's most interesting structures from
+// voluntary disruptions such as node drains during a cluster upgrade, e.g.
+//
+//	disruptionBudgets:
+//	  interval: 30s
+//	  maxPeriod: 15
+//	  minCells: 3
+//	  stillLifes: false
+//	  maxStructures: 20
+//
+// Every interval the controller looks for oscillators of period up to
+// maxPeriod in the standalone engine's recent generations, labels the pods of
+// their cells with cellular-automaton/structure=<id> and keeps one
+// PodDisruptionBudget with maxUnavailable: 0 per structure, so evictions of
+// those pods are refused until the structure has died. Structures of fewer
+// than minCells cells are left alone; still lifes are protected only with
+// stillLifes. At most maxStructures, the largest, are protected at a time.
+type DisruptionBudgetConfig struct {
+	Interval      metav1.Duration `json:"interval,omitempty"`
+	MaxPeriod     int             `json:"maxPeriod,omitempty"`
+	MinCells      int             `json:"minCells,omitempty"`
+	StillLifes    bool            `json:"stillLifes,omitempty"`
+	MaxStructures int             `json:"maxStructures,omitempty"`
+}
+
+func (c *DisruptionBudgetConfig) validate() error {
+	if c.Interval.Duration < 0 || c.MaxPeriod < 0 || c.MinCells < 0 || c.MaxStructures < 0 {
+		return errors.New("interval, maxPeriod, minCells and maxStructures must not be negative")
+	}
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = 30 * time.Second
+	}
+	if c.MaxPeriod == 0 {
+		c.MaxPeriod = defaultMaxPeriod
+	}
+	if c.MinCells == 0 {
+		c.MinCells = 3
+	}
+	if c.MaxStructures == 0 {
+		c.MaxStructures = 20
+	}
+	return nil
+}
+
+const (
+	// structureLabel marks the pods of a protected structure's cells.
+	structureLabel = "cellular-automaton/structure"
+	// structureBudgetPrefix names the budget of a structure.
+	structureBudgetPrefix = "structure-"
+)
+
+var protectedStructures = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_protected_structures",
+	Help: "Structures whose cell pods are covered by a PodDisruptionBudget.",
+})
+
+// budgetManager keeps a PodDisruptionBudget for every structure worth
+// protecting.
+type budgetManager struct {
+	cfg       DisruptionBudgetConfig
+	clientset kubernetes.Interface
+	pods      corelisters.PodLister
+	namespace string
+	detector  *structureDetector
+
+	mu        sync.Mutex
+	protected []Structure
+}
+
+func newBudgetManager(cfg *DisruptionBudgetConfig, clientset kubernetes.Interface, pods corelisters.PodLister, namespace string, grid GridGeometry) *budgetManager {
+	if cfg == nil {
+		return nil
+	}
+	return &budgetManager{
+		cfg:       *cfg,
+		clientset: clientset,
+		pods:      pods,
+		namespace: namespace,
+		detector:  newStructureDetector(grid, cfg.MaxPeriod),
+	}
+}
+
+func (m *budgetManager) Run(ctx context.Context) {
+	log.Printf("Budgets: protecting structures every %s", m.cfg.Interval.Duration)
+	ticker := time.NewTicker(m.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.reconcile(ctx); err != nil {
+				log.Printf("Budgets: %s", err.Error())
+			}
+		}
+	}
+}
+
+// selectStructures picks the structures to protect, largest first.
+func (m *budgetManager) selectStructures() []Structure {
+	var out []Structure
+	for _, s := range m.detector.Detect() {
+		if len(s.Cells) < m.cfg.MinCells || (s.Kind == structureStillLife && !m.cfg.StillLifes) {
+			continue
+		}
+		out = append(out, s)
+		if len(out) == m.cfg.MaxStructures {
+			break
+		}
+	}
+	return out
+}
+
+// reconcile labels the cell pods of the structures to protect and brings the
+// budgets in line with them.
+func (m *budgetManager) reconcile(ctx context.Context) error {
+	structures := m.selectStructures()
+	owner := make(map[int]string)
+	wanted := make(map[string]bool, len(structures))
+	for _, s := range structures {
+		wanted[structureBudgetPrefix+s.ID] = true
+		for _, i := range s.Cells {
+			owner[i] = s.ID
+		}
+	}
+
+	// Label first, so a new budget never selects fewer pods than it should
+	// for longer than necessary.
+	pods, err := m.pods.Pods(m.namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell"}))
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		i, ok := cellIndex(pod.Name)
+		if !ok || pod.Labels[structureLabel] == owner[i] {
+			continue
+		}
+		value := any(nil)
+		if owner[i] != "" {
+			value = owner[i]
+		}
+		patch, _ := json.Marshal(map[string]any{"metadata": map[string]any{"labels": map[string]any{structureLabel: value}}})
+		err := podWrites.Do(ctx, verbPatch, func(ctx context.Context) error {
+			_, err := m.clientset.CoreV1().Pods(m.namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+			return err
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("label pod %s: %w", pod.Name, err)
+		}
+	}
+
+	budgets := m.clientset.PolicyV1().PodDisruptionBudgets(m.namespace)
+	existing, err := budgets.List(ctx, metav1.ListOptions{LabelSelector: "app=grid-structure"})
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(existing.Items))
+	for _, pdb := range existing.Items {
+		have[pdb.Name] = true
+		if wanted[pdb.Name] {
+			continue
+		}
+		if err := budgets.Delete(ctx, pdb.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("delete budget %s: %w", pdb.Name, err)
+		}
+		log.Printf("Budgets: released %s", pdb.Name)
+	}
+	for _, s := range structures {
+		if have[structureBudgetPrefix+s.ID] {
+			continue
+		}
+		if _, err := budgets.Create(ctx, budgetFor(s, m.namespace), metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("create budget for structure %s: %w", s.ID, err)
+		}
+		log.Printf("Budgets: protecting %s of period %d at (%d, %d), %d cells", s.Kind, s.Period, s.X, s.Y, len(s.Cells))
+	}
+
+	m.mu.Lock()
+	m.protected = structures
+	m.mu.Unlock()
+	protectedStructures.Set(float64(len(structures)))
+	return nil
+}
+
+// budgetFor builds the budget that refuses every eviction of a structure's
+// cell pods.
+func budgetFor(s Structure, namespace string) *policyv1.PodDisruptionBudget {
+	zero := intstr.FromInt32(0)
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      structureBudgetPrefix + s.ID,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":        "grid-structure",
+				"managed-by": "grid-controller",
+			},
+			Annotations: map[string]string{
+				"cellular-automaton/kind":   s.Kind,
+				"cellular-automaton/period": fmt.Sprint(s.Period),
+				"cellular-automaton/box":    fmt.Sprintf("%d,%d %dx%d", s.X, s.Y, s.Width, s.Height),
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &zero,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "cell", structureLabel: s.ID},
+			},
+		},
+	}
+}
+
+// Protected returns the structures protected in the last round.
+func (m *budgetManager) Protected() []Structure {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.protected
+}
+
+// StructuresReport is served by /api/structures.
+type StructuresReport struct {
+	// Detected are the structures of the current generation; Protected are
+	// those covered by a budget as of the last reconcile.
+	Detected  []Structure `json:"detected"`
+	Protected []Structure `json:"protected"`
+}
+
+// handleStructures serves GET /api/structures.
+func handleStructures(w http.ResponseWriter, r *http.Request, m *budgetManager) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if m == nil {
+		http.Error(w, "Disruption budgets are not configured", http.StatusNotFound)
+		return
+	}
+
+	report := StructuresReport{Detected: m.detector.Detect(), Protected: m.Protected()}
+	if report.Detected == nil {
+		report.Detected = []Structure{}
+	}
+	if report.Protected == nil {
+		report.Protected = []Structure{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
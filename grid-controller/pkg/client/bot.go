@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Generation is one computed generation.
+type Generation struct {
+	Generation int64     `json:"generation"`
+	Population int       `json:"population"`
+	Births     int       `json:"births"`
+	Deaths     int       `json:"deaths"`
+	Time       time.Time `json:"time"`
+}
+
+// Grid is what a bot knows of the grid: every cell's latest state by name.
+type Grid struct {
+	Cells map[string]CellUpdate
+	// Generation is the last generation reported; 0 until the first one.
+	Generation int64
+}
+
+// Alive reports whether the named cell is alive.
+func (g *Grid) Alive(name string) bool {
+	return g.Cells[name].Status == "alive"
+}
+
+// Bot is a set of hooks an agent playing against the grid implements; nil
+// hooks are skipped. Hooks run one at a time on the stream's goroutine, and
+// an error from one stops RunBot. Create the client with the bot's token,
+// so its edits count against the bot's scopes, region and rate limit.
+type Bot struct {
+	// OnSnapshot runs when the bot connects and whenever it had to catch up
+	// with a fresh snapshot after a reconnect.
+	OnSnapshot func(ctx context.Context, c *Client, g *Grid) error
+	// OnGeneration runs after every generation.
+	OnGeneration func(ctx context.Context, c *Client, g *Grid, gen Generation) error
+}
+
+// RunBot streams the grid to the bot's hooks until ctx is done or a hook
+// fails.
+func (c *Client) RunBot(ctx context.Context, opts StreamOptions, bot Bot) error {
+	g := &Grid{Cells: map[string]CellUpdate{}}
+	return c.Subscribe(ctx, opts, func(e Event) error {
+		switch e.Type {
+		case EventSnapshot:
+			cells, err := e.Snapshot()
+			if err != nil {
+				return err
+			}
+			clear(g.Cells)
+			for _, cell := range cells {
+				g.Cells[cell.Name] = cell
+			}
+			if bot.OnSnapshot != nil {
+				return bot.OnSnapshot(ctx, c, g)
+			}
+		case EventCell:
+			cell, err := e.Cell()
+			if err != nil {
+				return err
+			}
+			g.Cells[cell.Name] = cell
+		case EventGeneration:
+			var gen Generation
+			if err := json.Unmarshal(e.Data, &gen); err != nil {
+				return err
+			}
+			g.Generation = gen.Generation
+			if bot.OnGeneration != nil {
+				return bot.OnGeneration(ctx, c, g, gen)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,333 @@
+// Package client is a typed Go client for the grid controller's REST API and
+// event stream, for bots and tools that drive or watch a grid.
+//
+//	c := client.New("http://localhost:8080", os.Getenv("GRID_TOKEN"))
+//	page, err := c.Cells(ctx, client.CellQuery{Statuses: []string{"alive"}})
+//
+// Mutating calls carry an Idempotency-Key and are retried on network errors
+// and 5xx responses, so a flaky connection never applies an edit twice.
+// Subscribe follows the stream across reconnects, resuming where it left off.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one controller. The zero value is not usable; use New.
+type Client struct {
+	// BaseURL is the controller's address, e.g. http://localhost:8080.
+	BaseURL string
+	// Token is sent as a bearer token; admin, operator or OIDC ID token.
+	Token string
+	// HTTPClient makes the REST calls; http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Retries is how often a failed call is retried; 3 by New.
+	Retries int
+	// Backoff is the first delay between retries and reconnects, doubled
+	// each time up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// New returns a client for the controller at baseURL.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		Retries:    3,
+		Backoff:    250 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// APIError is a response the controller refused.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("grid controller: %d %s", e.StatusCode, e.Message)
+}
+
+// CellUpdate is one cell's state as streamed and in snapshots.
+type CellUpdate struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Namespace string `json:"namespace"`
+	Grid      string `json:"grid,omitempty"`
+	Genome    string `json:"genome,omitempty"`
+	Color     string `json:"color,omitempty"`
+	Condition string `json:"condition,omitempty"`
+	Intensity int    `json:"intensity,omitempty"`
+}
+
+// CellInfo is one cell in a Cells result.
+type CellInfo struct {
+	Index      int     `json:"index"`
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Condition  string  `json:"condition,omitempty"`
+	Node       string  `json:"node,omitempty"`
+	AgeSeconds float64 `json:"ageSeconds"`
+	Genome     string  `json:"genome,omitempty"`
+}
+
+// CellPage is a page of cells; Continue fetches the next one.
+type CellPage struct {
+	Cells    []CellInfo `json:"cells"`
+	Continue string     `json:"continue,omitempty"`
+}
+
+// CellQuery filters Cells. Zero fields do not filter.
+type CellQuery struct {
+	Statuses []string
+	Node     string
+	MinAge   time.Duration
+	// Region is x0, y0, x1, y1 with x1 and y1 exclusive.
+	Region []int
+	// Near keeps the neighbors of that cell index when not nil.
+	Near     *int
+	Limit    int
+	Continue string
+}
+
+// Pattern is a built-in pattern, with its live cells as x, y offsets.
+type Pattern struct {
+	Name   string   `json:"name"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Cells  [][2]int `json:"cells"`
+}
+
+// EditResult is the outcome of an edit; Conflicts lists cells other callers
+// edited in the same generation.
+type EditResult struct {
+	Seq        uint64         `json:"seq"`
+	Generation int64          `json:"generation"`
+	Births     []int          `json:"births"`
+	Deaths     []int          `json:"deaths,omitempty"`
+	Conflicts  []EditConflict `json:"conflicts,omitempty"`
+}
+
+// EditConflict is a cell an earlier edit in the same generation also wrote.
+type EditConflict struct {
+	Cell    int    `json:"cell"`
+	With    uint64 `json:"with"`
+	By      string `json:"by"`
+	Outcome string `json:"outcome"`
+}
+
+// StateHash compares the engine's state with the cells in the cluster.
+type StateHash struct {
+	Generation   int64  `json:"generation"`
+	Population   int    `json:"population"`
+	Hash         string `json:"hash"`
+	Materialized string `json:"materialized"`
+	Consistent   bool   `json:"consistent"`
+}
+
+// Banner is an announcement shown to every viewer.
+type Banner struct {
+	Text     string `json:"text"`
+	Severity string `json:"severity"`
+	// DurationSeconds is how long viewers show it; 0 until cleared.
+	DurationSeconds int `json:"durationSeconds"`
+}
+
+// Cells lists cells matching the query, a page at a time in index order.
+func (c *Client) Cells(ctx context.Context, q CellQuery) (*CellPage, error) {
+	v := url.Values{}
+	if len(q.Statuses) > 0 {
+		v.Set("status", strings.Join(q.Statuses, ","))
+	}
+	if q.Node != "" {
+		v.Set("node", q.Node)
+	}
+	if q.MinAge > 0 {
+		v.Set("minAge", q.MinAge.String())
+	}
+	if len(q.Region) == 4 {
+		v.Set("region", fmt.Sprintf("%d,%d,%d,%d", q.Region[0], q.Region[1], q.Region[2], q.Region[3]))
+	}
+	if q.Near != nil {
+		v.Set("near", strconv.Itoa(*q.Near))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Continue != "" {
+		v.Set("continue", q.Continue)
+	}
+	var page CellPage
+	return &page, c.call(ctx, "GET", "/api/cells?"+v.Encode(), nil, &page)
+}
+
+// Spawn brings a cell to life.
+func (c *Client) Spawn(ctx context.Context, index int) (*EditResult, error) {
+	var result EditResult
+	return &result, c.call(ctx, "POST", "/api/cells/"+strconv.Itoa(index), nil, &result)
+}
+
+// Kill kills a cell through the engine.
+func (c *Client) Kill(ctx context.Context, index int) (*EditResult, error) {
+	var result EditResult
+	return &result, c.call(ctx, "DELETE", "/api/cells/"+strconv.Itoa(index), nil, &result)
+}
+
+// DeletePod deletes a cell's pod, as chaos does.
+func (c *Client) DeletePod(ctx context.Context, name string) error {
+	return c.call(ctx, "DELETE", "/api/pods/"+url.PathEscape(name), nil, nil)
+}
+
+// Patterns lists the built-in patterns.
+func (c *Client) Patterns(ctx context.Context) ([]Pattern, error) {
+	var patterns []Pattern
+	return patterns, c.call(ctx, "GET", "/api/patterns", nil, &patterns)
+}
+
+// ApplyPattern stamps a built-in pattern with its top-left corner at (x, y).
+func (c *Client) ApplyPattern(ctx context.Context, name string, x, y int) (*EditResult, error) {
+	var result EditResult
+	path := fmt.Sprintf("/api/patterns/%s?x=%d&y=%d", url.PathEscape(name), x, y)
+	return &result, c.call(ctx, "POST", path, nil, &result)
+}
+
+// Boolean operations between the grid and a pattern, for CombinePattern.
+const (
+	OpUnion        = "union"
+	OpIntersection = "intersection"
+	OpDifference   = "difference"
+	OpXor          = "xor"
+)
+
+// CombinePattern combines a built-in pattern placed with its top-left corner
+// at (x, y) with the grid: OpUnion stamps it, OpIntersection keeps only the
+// live cells under it, OpDifference erases its cells and OpXor flips them.
+func (c *Client) CombinePattern(ctx context.Context, name, op string, x, y int) (*EditResult, error) {
+	var result EditResult
+	path := fmt.Sprintf("/api/patterns/%s?x=%d&y=%d&op=%s", url.PathEscape(name), x, y, url.QueryEscape(op))
+	return &result, c.call(ctx, "POST", path, nil, &result)
+}
+
+// RegionTransform moves the live cells of Region, x0, y0, x1, y1 with x1
+// and y1 exclusive: Flip ("h" or "v") and a clockwise Rotate of 90, 180 or
+// 270 degrees apply within the region's box, then (DX, DY) translates them.
+type RegionTransform struct {
+	Region []int  `json:"region"`
+	Rotate int    `json:"rotate,omitempty"`
+	Flip   string `json:"flip,omitempty"`
+	DX     int    `json:"dx,omitempty"`
+	DY     int    `json:"dy,omitempty"`
+}
+
+// TransformResult is the outcome of a region transform; Clipped counts the
+// cells moved off the grid.
+type TransformResult struct {
+	EditResult
+	Moved   int `json:"moved"`
+	Clipped int `json:"clipped"`
+}
+
+// TransformRegion moves the live cells of a region in one edit.
+func (c *Client) TransformRegion(ctx context.Context, t RegionTransform) (*TransformResult, error) {
+	var result TransformResult
+	return &result, c.call(ctx, "POST", "/api/region/transform", t, &result)
+}
+
+// StateHash fetches the state hashes of the grid.
+func (c *Client) StateHash(ctx context.Context) (*StateHash, error) {
+	var hash StateHash
+	return &hash, c.call(ctx, "GET", "/api/state/hash", nil, &hash)
+}
+
+// Broadcast shows a banner to every viewer; an empty text clears it.
+func (c *Client) Broadcast(ctx context.Context, b Banner) error {
+	return c.call(ctx, "POST", "/api/broadcast", b, nil)
+}
+
+// call makes a request, retrying network errors and 5xx responses, and
+// decodes the JSON response into out when it is not nil.
+func (c *Client) call(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	// One key for every attempt, so a retried edit is applied once.
+	var key string
+	if method != "GET" {
+		key = idempotencyKey()
+	}
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, method, path, payload, key, out)
+		var apiErr *APIError
+		retry := err != nil && ctx.Err() == nil &&
+			(!errors.As(err, &apiErr) || apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests)
+		if !retry || attempt >= c.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, c.MaxBackoff)
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, payload []byte, key string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func idempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// EventScenario carries the running lesson's ScenarioProgress whenever it
+// starts, advances a step, finishes or is stopped.
+const EventScenario = "scenario"
+
+// Scenario is a guided lesson: steps that advance once the grid shows what
+// they expect.
+type Scenario struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	Steps []struct {
+		Instruction string `json:"instruction"`
+	} `json:"steps"`
+}
+
+// ScenarioProgress is the running lesson. Step counts from 1 and is past the
+// last step once Finished; Waiting lists what the current step waits for.
+type ScenarioProgress struct {
+	Scenario    string    `json:"scenario"`
+	Title       string    `json:"title"`
+	Started     time.Time `json:"started"`
+	Step        int       `json:"step"`
+	Steps       int       `json:"steps"`
+	Instruction string    `json:"instruction,omitempty"`
+	Waiting     []string  `json:"waiting,omitempty"`
+	Completed   []struct {
+		Step        int       `json:"step"`
+		Instruction string    `json:"instruction"`
+		Completed   time.Time `json:"completed"`
+		Seconds     float64   `json:"seconds"`
+	} `json:"completed"`
+	Finished bool `json:"finished"`
+	Stopped  bool `json:"stopped,omitempty"`
+}
+
+// Scenarios lists the lessons.
+func (c *Client) Scenarios(ctx context.Context) ([]Scenario, error) {
+	var scenarios []Scenario
+	return scenarios, c.call(ctx, "GET", "/api/scenarios", nil, &scenarios)
+}
+
+// ScenarioProgress fetches the running lesson's progress; it fails with a
+// 404 when none runs.
+func (c *Client) ScenarioProgress(ctx context.Context) (*ScenarioProgress, error) {
+	var progress ScenarioProgress
+	return &progress, c.call(ctx, "GET", "/api/scenarios/active", nil, &progress)
+}
+
+// StartScenario starts a lesson, replacing any running one. It needs an
+// operator token.
+func (c *Client) StartScenario(ctx context.Context, name string) (*ScenarioProgress, error) {
+	var progress ScenarioProgress
+	return &progress, c.call(ctx, "POST", fmt.Sprintf("/api/scenarios/%s/start", url.PathEscape(name)), nil, &progress)
+}
+
+// StopScenario stops the running lesson.
+func (c *Client) StopScenario(ctx context.Context) error {
+	return c.call(ctx, "POST", "/api/scenarios/stop", nil, nil)
+}
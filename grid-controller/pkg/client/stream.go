@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event types on the stream.
+const (
+	EventCell     = "cell"
+	EventSnapshot = "snapshot"
+	EventBanner   = "banner"
+	EventViewers  = "viewers"
+	// EventGeneration reports each generation the engine computed.
+	EventGeneration = "generation"
+)
+
+// Event is one message of the stream. Data holds the message's payload;
+// Cell and Snapshot decode the common ones.
+type Event struct {
+	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
+	Time time.Time       `json:"ts"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Cell decodes a cell update.
+func (e Event) Cell() (CellUpdate, error) {
+	var u CellUpdate
+	if e.Type != EventCell {
+		return u, errors.New("client: not a cell event: " + e.Type)
+	}
+	return u, json.Unmarshal(e.Data, &u)
+}
+
+// Snapshot decodes a snapshot: every cell's latest state, which replaces
+// whatever the subscriber knew before.
+func (e Event) Snapshot() ([]CellUpdate, error) {
+	var s struct {
+		Cells []CellUpdate `json:"cells"`
+	}
+	if e.Type != EventSnapshot {
+		return nil, errors.New("client: not a snapshot event: " + e.Type)
+	}
+	return s.Cells, json.Unmarshal(e.Data, &s)
+}
+
+// StreamOptions pick the grid to subscribe to; the controller's own grid
+// when Grid is empty. Share or Code grant access to a grid without a token.
+type StreamOptions struct {
+	Grid  string
+	Share string
+	Code  string
+}
+
+// Subscribe streams events to fn until ctx is done or fn returns an error,
+// which Subscribe then returns. The first event is a snapshot. When the
+// connection drops, Subscribe reconnects with backoff and resumes after the
+// last event fn saw; if the controller no longer has the missed events, fn
+// gets a fresh snapshot instead.
+func (c *Client) Subscribe(ctx context.Context, opts StreamOptions, fn func(Event) error) error {
+	var since uint64
+	resume := false
+	backoff := c.Backoff
+	for {
+		conn, err := c.dial(ctx, opts, since, resume)
+		if err == nil {
+			backoff = c.Backoff
+			err = c.read(ctx, conn, func(e Event) error {
+				// The banner a connection opens with may be older than
+				// what came before; a snapshot starts over.
+				if e.Type == EventSnapshot || e.Seq > since {
+					since, resume = e.Seq, true
+				}
+				return fn(e)
+			})
+			var stop handlerError
+			if errors.As(err, &stop) {
+				return stop.err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, c.MaxBackoff)
+	}
+}
+
+// handlerError carries an error returned by the subscriber, which ends the
+// subscription instead of reconnecting.
+type handlerError struct{ err error }
+
+func (e handlerError) Error() string { return e.err.Error() }
+
+func (c *Client) dial(ctx context.Context, opts StreamOptions, since uint64, resume bool) (*websocket.Conn, error) {
+	u, err := url.Parse(c.BaseURL + "/ws")
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	q := url.Values{}
+	for key, v := range map[string]string{"grid": opts.Grid, "share": opts.Share, "code": opts.Code, "access_token": c.Token} {
+		if v != "" {
+			q.Set(key, v)
+		}
+	}
+	if resume {
+		q.Set("since", strconv.FormatUint(since, 10))
+	}
+	u.RawQuery = q.Encode()
+	dialer := websocket.Dialer{Subprotocols: []string{"grid.v2"}, HandshakeTimeout: 30 * time.Second}
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	return conn, err
+}
+
+// read delivers the connection's events to fn until it fails.
+func (c *Client) read(ctx context.Context, conn *websocket.Conn, fn func(Event) error) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	for {
+		var e Event
+		if err := conn.ReadJSON(&e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return handlerError{err}
+		}
+	}
+}
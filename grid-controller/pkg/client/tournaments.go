@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Stream events of tournaments.
+const (
+	// EventTournament carries a tournament's schedule and standings when a
+	// match starts or ends.
+	EventTournament = "tournament"
+	// EventMatch carries a MatchBoard every generation of a match.
+	EventMatch = "match"
+)
+
+// MatchBoard is a tournament match with the live cells of each side. The
+// home bot plays the left half of the grid, the away bot the right half.
+type MatchBoard struct {
+	Tournament string `json:"tournament"`
+	ID         int    `json:"id"`
+	Home       string `json:"home"`
+	Away       string `json:"away"`
+	// Status is scheduled, setup, running or finished.
+	Status     string `json:"status"`
+	Generation int64  `json:"generation"`
+	HomeMoves  int    `json:"homeMoves"`
+	AwayMoves  int    `json:"awayMoves"`
+	HomeScore  int    `json:"homeScore"`
+	AwayScore  int    `json:"awayScore"`
+	Winner     string `json:"winner,omitempty"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	HomeCells  []int  `json:"homeCells"`
+	AwayCells  []int  `json:"awayCells"`
+}
+
+// Match fetches a tournament match and its board.
+func (c *Client) Match(ctx context.Context, tournament string, id int) (*MatchBoard, error) {
+	var board MatchBoard
+	return &board, c.call(ctx, "GET", fmt.Sprintf("/api/tournaments/%s/matches/%d", url.PathEscape(tournament), id), nil, &board)
+}
+
+// Move places live cells on the bot's half of a match; each cell uses one
+// move of the bot's budget.
+func (c *Client) Move(ctx context.Context, tournament string, id int, cells []int) (*MatchBoard, error) {
+	var board MatchBoard
+	body := struct {
+		Cells []int `json:"cells"`
+	}{cells}
+	return &board, c.call(ctx, "POST", fmt.Sprintf("/api/tournaments/%s/matches/%d/moves", url.PathEscape(tournament), id), body, &board)
+}
+
+// Replay fetches a finished match's signed replay. It is returned as sent so
+// it can be saved and checked with `grid-controller verify-replay`.
+func (c *Client) Replay(ctx context.Context, tournament string, id int) (json.RawMessage, error) {
+	var replay json.RawMessage
+	if err := c.call(ctx, "GET", fmt.Sprintf("/api/tournaments/%s/matches/%d/replay", url.PathEscape(tournament), id), nil, &replay); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
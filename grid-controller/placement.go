@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	placementNone      = "none"
+	placementGeography = "geography"
+)
+
+// PlacementRegion is a contiguous band of grid rows pinned to one node.
+type PlacementRegion struct {
+	Region   int    `json:"region"`
+	Node     string `json:"node"`
+	Ready    bool   `json:"ready"`
+	FirstRow int    `json:"firstRow"`
+	LastRow  int    `json:"lastRow"`
+}
+
+// placementPlanner splits the grid into horizontal bands, one per eligible
+// node, so losing a node leaves a geographically coherent hole instead of
+// scattered dead cells. The plan only changes when nodes join or leave;
+// a NotReady node keeps its band.
+type placementPlanner struct {
+	mu       sync.RWMutex
+	grid     GridGeometry
+	selector labels.Selector
+	regions  []PlacementRegion
+}
+
+func newPlacementPlanner(grid GridGeometry, selector labels.Selector) *placementPlanner {
+	return &placementPlanner{grid: grid, selector: selector}
+}
+
+// Resync recomputes the plan from the node cache.
+func (p *placementPlanner) Resync(nodes corelisters.NodeLister) {
+	list, err := nodes.List(p.selector)
+	if err != nil {
+		log.Printf("Placement: list nodes: %v", err)
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	var regions []PlacementRegion
+	if len(list) > 0 {
+		rowsPer := (p.grid.Height + len(list) - 1) / len(list)
+		for i, node := range list {
+			first := i * rowsPer
+			if first >= p.grid.Height {
+				break
+			}
+			regions = append(regions, PlacementRegion{
+				Region:   i,
+				Node:     node.Name,
+				Ready:    nodeReady(node),
+				FirstRow: first,
+				LastRow:  min(first+rowsPer, p.grid.Height) - 1,
+			})
+		}
+	}
+
+	p.mu.Lock()
+	p.regions = regions
+	p.mu.Unlock()
+}
+
+// NodeFor returns the node owning the band that contains the cell.
+func (p *placementPlanner) NodeFor(index int) (string, bool) {
+	_, y := p.grid.Coords(index)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.regions {
+		if y >= r.FirstRow && y <= r.LastRow {
+			return r.Node, true
+		}
+	}
+	return "", false
+}
+
+// Affinity pins a cell to its region's node by name.
+func (p *placementPlanner) Affinity(index int) *v1.Affinity {
+	node, ok := p.NodeFor(index)
+	if !ok {
+		return nil
+	}
+	return &v1.Affinity{
+		NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchFields: []v1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: v1.NodeSelectorOpIn,
+						Values:   []string{node},
+					}},
+				}},
+			},
+		},
+	}
+}
+
+func (p *placementPlanner) Regions() []PlacementRegion {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]PlacementRegion(nil), p.regions...)
+}
+
+func nodeReady(node *v1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// handlePlacement serves GET /api/placement with the region→node mapping.
+// planner is nil when placement is disabled.
+func handlePlacement(w http.ResponseWriter, r *http.Request, planner *placementPlanner, grid GridGeometry) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := struct {
+		Mode    string            `json:"mode"`
+		Grid    GridGeometry      `json:"grid"`
+		Regions []PlacementRegion `json:"regions"`
+	}{Mode: placementNone, Grid: grid, Regions: []PlacementRegion{}}
+	if planner != nil {
+		resp.Mode = placementGeography
+		resp.Regions = planner.Regions()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
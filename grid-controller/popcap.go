@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Overflow policies of the population cap.
+const (
+	overflowSuppress   = "suppress"
+	overflowCullOldest = "cull-oldest"
+	overflowPause      = "pause"
+)
+
+// PopulationCapConfig bounds the live cells of the standalone engine, e.g.
+//
+//	populationCap:
+//	  max: 2000
+//	  policy: suppress
+//
+// The policy decides what happens to a generation that would exceed max:
+// suppress cancels births, those nearest the grid's edge first; cull-oldest
+// kills the longest-lived cells; pause lets the generation through and
+// pauses the simulation until an operator resumes it. Cells brought to life
+// by operators, viewers or the extinction policy are not capped.
+type PopulationCapConfig struct {
+	Max    int    `json:"max"`
+	Policy string `json:"policy,omitempty"`
+}
+
+func (c *PopulationCapConfig) validate() error {
+	if c.Max <= 0 {
+		return errors.New("max must be positive")
+	}
+	switch c.Policy {
+	case "":
+		c.Policy = overflowSuppress
+	case overflowSuppress, overflowCullOldest, overflowPause:
+	default:
+		return fmt.Errorf("unknown policy %q (want %s, %s or %s)", c.Policy, overflowSuppress, overflowCullOldest, overflowPause)
+	}
+	return nil
+}
+
+var populationCapped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_population_capped_total",
+	Help: "Births suppressed or cells culled to keep the population under its cap, by policy.",
+}, []string{"policy"})
+
+// populationCap enforces the cap while the engine applies the rule. It runs
+// under the engine's lock.
+type populationCap struct {
+	cfg PopulationCapConfig
+	// born is the generation each live cell was born in; cells of unknown
+	// age count as born when first seen.
+	born map[int]int64
+}
+
+func newPopulationCap(cfg *PopulationCapConfig) *populationCap {
+	if cfg == nil {
+		return nil
+	}
+	return &populationCap{cfg: *cfg, born: map[int]int64{}}
+}
+
+// apply trims the next generation, live, to the cap, and returns the births
+// and deaths adjusted accordingly. generation is the one being computed.
+func (c *populationCap) apply(grid GridGeometry, generation int64, live *cellBits, births, deaths []int) ([]int, []int) {
+	for i := range c.born {
+		if !live.Has(i) {
+			delete(c.born, i)
+		}
+	}
+	for _, i := range births {
+		c.born[i] = generation
+	}
+	live.ForEach(func(i int) {
+		if _, ok := c.born[i]; !ok {
+			c.born[i] = generation - 1
+		}
+	})
+
+	excess := live.Count() - c.cfg.Max
+	if excess <= 0 {
+		return births, deaths
+	}
+	switch c.cfg.Policy {
+	case overflowSuppress:
+		edge := func(i int) int {
+			x, y := grid.Coords(i)
+			return min(x, y, grid.Width-1-x, grid.Height-1-y)
+		}
+		sort.SliceStable(births, func(a, b int) bool { return edge(births[a]) < edge(births[b]) })
+		n := min(excess, len(births))
+		for _, i := range births[:n] {
+			live.Clear(i)
+			delete(c.born, i)
+		}
+		populationCapped.WithLabelValues(overflowSuppress).Add(float64(n))
+		births = births[n:]
+		sort.Ints(births)
+	case overflowCullOldest:
+		cells := live.Indices()
+		sort.Slice(cells, func(a, b int) bool {
+			if c.born[cells[a]] != c.born[cells[b]] {
+				return c.born[cells[a]] < c.born[cells[b]]
+			}
+			return cells[a] < cells[b]
+		})
+		newborn := make(map[int]bool, len(births))
+		for _, i := range births {
+			newborn[i] = true
+		}
+		kept := births[:0]
+		for _, i := range cells[:excess] {
+			live.Clear(i)
+			delete(c.born, i)
+			if newborn[i] {
+				// Born and culled at once: neither a birth nor a death.
+				delete(newborn, i)
+				continue
+			}
+			deaths = append(deaths, i)
+		}
+		for _, i := range births {
+			if newborn[i] {
+				kept = append(kept, i)
+			}
+		}
+		births = kept
+		sort.Ints(deaths)
+		populationCapped.WithLabelValues(overflowCullOldest).Add(float64(excess))
+	}
+	return births, deaths
+}
+
+// Over reports whether the pause policy should pause at this population.
+func (c *populationCap) Over(population int) bool {
+	return c != nil && c.cfg.Policy == overflowPause && population > c.cfg.Max
+}
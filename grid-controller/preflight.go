@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Preflight modes (--preflight).
+const (
+	// preflightWarn logs failed checks and keeps /readyz failing.
+	preflightWarn = "warn"
+	// preflightStrict exits on failed checks.
+	preflightStrict = "strict"
+	preflightOff    = "off"
+)
+
+// preflightTimeout bounds all startup checks together.
+const preflightTimeout = 15 * time.Second
+
+// Results of a preflight check.
+const (
+	checkPassed  = "passed"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+// PreflightCheck is the outcome of one startup check. Fix says what to do
+// about a failure.
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// PreflightReport is every startup check, as served by /readyz.
+type PreflightReport struct {
+	Checked time.Time        `json:"checked"`
+	Failed  int              `json:"failed"`
+	Checks  []PreflightCheck `json:"checks"`
+}
+
+// permission is an RBAC permission the controller needs and why.
+type permission struct {
+	group, resource string
+	verbs           []string
+	reason          string
+}
+
+// apiNeed is an API group version the controller needs and why.
+type apiNeed struct {
+	groupVersion string
+	reason       string
+}
+
+// preflight verifies on startup what the configured features need from the
+// cluster: the namespace, the API groups they use and the RBAC permissions
+// on them. Problems are reported together, with what to do about each,
+// instead of surfacing later as opaque errors from deep inside a reconcile.
+type preflight struct {
+	clientset kubernetes.Interface
+	namespace string
+	perms     []permission
+	apis      []apiNeed
+
+	mu     sync.Mutex
+	report *PreflightReport
+}
+
+func newPreflight(clientset kubernetes.Interface, namespace string) *preflight {
+	p := &preflight{clientset: clientset, namespace: namespace}
+	p.Permit("", "pods", "list,watch", "the pod informer renders the grid")
+	p.Permit("", "pods", "delete", "chaos deletes cell pods")
+	p.Permit("", "events", "create,patch", "alerts are recorded as events")
+	return p
+}
+
+// Permit adds a permission a feature needs in the controller's namespace.
+// verbs is comma-separated.
+func (p *preflight) Permit(group, resource, verbs, reason string) {
+	p.perms = append(p.perms, permission{group: group, resource: resource, verbs: strings.Split(verbs, ","), reason: reason})
+}
+
+// API adds an API group version a feature needs, e.g. metrics.k8s.io/v1beta1.
+func (p *preflight) API(groupVersion, reason string) {
+	p.apis = append(p.apis, apiNeed{groupVersion: groupVersion, reason: reason})
+}
+
+// Run performs the checks, logs an aggregated diagnostic and keeps the
+// report for /readyz.
+func (p *preflight) Run(ctx context.Context) *PreflightReport {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	report := &PreflightReport{Checked: time.Now()}
+	add := func(c PreflightCheck) {
+		if c.Result == checkFailed {
+			report.Failed++
+		}
+		report.Checks = append(report.Checks, c)
+	}
+	add(p.checkNamespace(ctx))
+	for _, need := range p.apis {
+		add(p.checkAPI(need))
+	}
+	for _, perm := range p.perms {
+		for _, verb := range perm.verbs {
+			add(p.checkPermission(ctx, perm, verb))
+		}
+	}
+
+	p.mu.Lock()
+	p.report = report
+	p.mu.Unlock()
+
+	if report.Failed == 0 {
+		log.Printf("Preflight: %d checks passed", len(report.Checks))
+		return report
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Preflight: %d of %d checks failed:", report.Failed, len(report.Checks))
+	for _, c := range report.Checks {
+		if c.Result == checkFailed {
+			fmt.Fprintf(&b, "\n  - %s: %s\n    fix: %s", c.Name, c.Detail, c.Fix)
+		}
+	}
+	log.Print(b.String())
+	return report
+}
+
+// Report returns the last report, or nil before the checks ran.
+func (p *preflight) Report() *PreflightReport {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report
+}
+
+func (p *preflight) checkNamespace(ctx context.Context) PreflightCheck {
+	c := PreflightCheck{Name: "namespace " + p.namespace}
+	_, err := p.clientset.CoreV1().Namespaces().Get(ctx, p.namespace, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		c.Result = checkPassed
+	case apierrors.IsForbidden(err):
+		// Namespaced RBAC need not include reading the namespace itself.
+		c.Result, c.Detail = checkSkipped, "not allowed to read namespaces"
+	case apierrors.IsNotFound(err):
+		c.Result, c.Detail = checkFailed, "the namespace does not exist"
+		c.Fix = "create it (k8s/namespace.yaml) or set NAMESPACE to the controller's namespace"
+	default:
+		c.Result, c.Detail = checkFailed, err.Error()
+		c.Fix = "check that the API server is reachable with the controller's kubeconfig or in-cluster credentials"
+	}
+	return c
+}
+
+func (p *preflight) checkAPI(need apiNeed) PreflightCheck {
+	c := PreflightCheck{Name: "API " + need.groupVersion}
+	_, err := p.clientset.Discovery().ServerResourcesForGroupVersion(need.groupVersion)
+	switch {
+	case err == nil:
+		c.Result = checkPassed
+	case apierrors.IsNotFound(err):
+		c.Result, c.Detail = checkFailed, "the cluster does not serve it, but "+need.reason
+		c.Fix = "install what serves " + need.groupVersion + " or turn the feature off"
+	default:
+		c.Result, c.Detail = checkFailed, err.Error()
+		c.Fix = "check that the API server is reachable"
+	}
+	return c
+}
+
+func (p *preflight) checkPermission(ctx context.Context, perm permission, verb string) PreflightCheck {
+	resource := perm.resource
+	if perm.group != "" {
+		resource += "." + perm.group
+	}
+	c := PreflightCheck{Name: "RBAC " + verb + " " + resource}
+	review, err := p.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: p.namespace,
+			Verb:      verb,
+			Group:     perm.group,
+			Resource:  perm.resource,
+		}},
+	}, metav1.CreateOptions{})
+	switch {
+	case err != nil:
+		c.Result, c.Detail = checkFailed, "access review failed: "+err.Error()
+		c.Fix = "check that the API server is reachable"
+	case review.Status.Allowed:
+		c.Result = checkPassed
+	default:
+		c.Result, c.Detail = checkFailed, "not allowed, but "+perm.reason
+		if review.Status.Reason != "" {
+			c.Detail += " (" + review.Status.Reason + ")"
+		}
+		c.Fix = fmt.Sprintf("grant %q on %s in namespace %s to the controller's ServiceAccount, see the grid-controller Role in k8s/infra.yaml", verb, resource, p.namespace)
+	}
+	return c
+}
+
+// Readiness is what /readyz reports.
+type Readiness struct {
+	Ready     bool             `json:"ready"`
+	Synced    bool             `json:"synced"`
+	Preflight *PreflightReport `json:"preflight,omitempty"`
+}
+
+// handleReadyz serves GET /readyz: 200 once the pod cache has synced and no
+// preflight check failed, 503 otherwise, with the details either way.
+func handleReadyz(w http.ResponseWriter, r *http.Request, p *preflight, synced cache.InformerSynced) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := Readiness{Synced: synced(), Preflight: p.Report()}
+	resp.Ready = resp.Synced && (resp.Preflight == nil || resp.Preflight.Failed == 0)
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
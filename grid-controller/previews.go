@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// A preview grid is a small, short-lived grid for one branch, so CI can show
+// a pull request's rule-engine changes running live. Every branch gets its
+// own namespace; with --stream-grids its cells are streamed under the grid ID
+// preview-<branch>-<hash>, which the dashboard subscribes to with ?grid=.
+// Previews expire like workshop sessions, but nothing is kept of them.
+const (
+	previewBranchAnnotation = "cellular-automaton/preview-branch"
+	previewLabel            = "cellular-automaton/preview"
+
+	defaultPreviewTTL = 2 * time.Hour
+	maxPreviewTTL     = 24 * time.Hour
+	maxPreviewCells   = 256
+)
+
+// PreviewRequest is the body of POST /api/previews.
+type PreviewRequest struct {
+	Branch string `json:"branch"`
+	// Image is the cell image built for the branch; it must start with
+	// --preview-image-prefix. Empty runs the controller's --cell-image.
+	Image          string          `json:"image,omitempty"`
+	Width          int             `json:"width,omitempty"`
+	Height         int             `json:"height,omitempty"`
+	TickIntervalMs int             `json:"tickIntervalMs,omitempty"`
+	TTL            metav1.Duration `json:"ttl,omitempty"`
+}
+
+func (p *PreviewRequest) validate(imagePrefix string) error {
+	if p.Branch == "" || len(p.Branch) > 255 {
+		return fmt.Errorf("branch is required")
+	}
+	if p.Image != "" && (imagePrefix == "" || !strings.HasPrefix(p.Image, imagePrefix)) {
+		return fmt.Errorf("image must start with %q", imagePrefix)
+	}
+	if p.Width == 0 && p.Height == 0 {
+		p.Width, p.Height = 16, 16
+	}
+	if p.Width*p.Height > maxPreviewCells {
+		return fmt.Errorf("previews have at most %d cells", maxPreviewCells)
+	}
+	if p.TTL.Duration == 0 {
+		p.TTL.Duration = defaultPreviewTTL
+	}
+	if p.TTL.Duration < 0 || p.TTL.Duration > maxPreviewTTL {
+		return fmt.Errorf("ttl must be positive and at most %s", maxPreviewTTL)
+	}
+	return nil
+}
+
+var previewSlugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// previewName derives the grid name of a branch. The hash keeps branches
+// apart whose names only differ in characters a DNS label cannot hold.
+func previewName(branch string) string {
+	slug := previewSlugInvalid.ReplaceAllString(strings.ToLower(branch), "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > 24 {
+		slug = strings.TrimRight(slug[:24], "-")
+	}
+	sum := sha256.Sum256([]byte(branch))
+	if slug == "" {
+		return "preview-" + hex.EncodeToString(sum[:3])
+	}
+	return "preview-" + slug + "-" + hex.EncodeToString(sum[:3])
+}
+
+// Preview starts the preview grid of a branch, or, when it is already
+// running, extends its expiry and rolls its cells onto the new image. created
+// reports which of the two happened.
+func (b *gridBootstrapper) Preview(ctx context.Context, req PreviewRequest, tenant string) (info *GridInfo, created bool, err error) {
+	spec := GridSpec{Name: previewName(req.Branch), Width: req.Width, Height: req.Height, TickIntervalMs: req.TickIntervalMs}
+	if err := spec.validate(); err != nil {
+		return nil, false, err
+	}
+	expires := time.Now().Add(req.TTL.Duration).UTC().Truncate(time.Second)
+	image := req.Image
+	if image == "" {
+		image = b.image
+	}
+
+	existing, err := b.Get(ctx, spec.Name, tenant)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, false, err
+	}
+	if existing != nil {
+		if existing.Preview == "" {
+			return nil, false, apierrors.NewAlreadyExists(v1.Resource("namespaces"), existing.Namespace)
+		}
+		if err := b.refreshPreview(ctx, existing.Namespace, image, expires); err != nil {
+			return nil, false, err
+		}
+		log.Printf("Grids: preview %s of %s refreshed until %s", spec.Name, req.Branch, expires.Format(time.RFC3339))
+		existing.Expires = &expires
+		return existing, false, nil
+	}
+
+	preview := *b
+	preview.image = image
+	info, err = preview.Create(ctx, spec, tenant, func(ns *v1.Namespace) {
+		ns.Labels[previewLabel] = "true"
+		ns.Annotations[previewBranchAnnotation] = req.Branch
+		ns.Annotations[sessionExpiresAnnotation] = expires.Format(time.RFC3339)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	log.Printf("Grids: preview %s of %s expires at %s", spec.Name, req.Branch, expires.Format(time.RFC3339))
+	return info, true, nil
+}
+
+// refreshPreview moves a preview's expiry and points its cells at image.
+func (b *gridBootstrapper) refreshPreview(ctx context.Context, ns, image string, expires time.Time) error {
+	patch, _ := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{
+		sessionExpiresAnnotation: expires.Format(time.RFC3339),
+	}}})
+	if _, err := b.clientset.CoreV1().Namespaces().Patch(ctx, ns, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	patch, _ = json.Marshal(map[string]any{"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+		"containers": []map[string]string{{"name": "worker", "image": image}},
+	}}}})
+	_, err := b.clientset.AppsV1().StatefulSets(ns).Patch(ctx, "cell", types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// handlePreviews serves POST /api/previews, which starts or refreshes the
+// preview of a branch, GET /api/previews and DELETE
+// /api/previews?branch=, for CI to clean up when the pull request closes.
+func handlePreviews(w http.ResponseWriter, r *http.Request, b *gridBootstrapper, imagePrefix string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	tenant, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+	var owner string
+	if tenant != nil {
+		owner = tenant.Name
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case "GET":
+		grids, err := b.List(ctx, owner)
+		if err != nil {
+			http.Error(w, "Failed to list grids: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		previews := []GridInfo{}
+		for _, g := range grids {
+			if g.Preview != "" {
+				previews = append(previews, g)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(previews)
+
+	case "POST":
+		var req PreviewRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid preview: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(imagePrefix); err != nil {
+			http.Error(w, "Invalid preview: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !quotas.Allow(w, r, actionPreviews) {
+			return
+		}
+		// Only a new preview counts against the tenant's grids.
+		if _, err := b.Get(ctx, previewName(req.Branch), owner); apierrors.IsNotFound(err) {
+			if !b.admit(w, ctx, tenant, GridSpec{Width: req.Width, Height: req.Height}) {
+				return
+			}
+		}
+		info, created, err := b.Preview(ctx, req, owner)
+		if apierrors.IsAlreadyExists(err) {
+			http.Error(w, "A grid that is not a preview has this name", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to start preview: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(info)
+
+	case "DELETE":
+		branch := r.URL.Query().Get("branch")
+		if branch == "" {
+			http.Error(w, "branch is required", http.StatusBadRequest)
+			return
+		}
+		info, err := b.Get(ctx, previewName(branch), owner)
+		if err == nil && info.Preview == "" {
+			err = apierrors.NewNotFound(v1.Resource("namespaces"), info.Namespace)
+		}
+		if err == nil {
+			err = b.Delete(ctx, info.Name, owner)
+		}
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Preview not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete preview: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
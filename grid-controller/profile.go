@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resource profiles (--profile).
+const (
+	profileDefault = "default"
+	// profileEdge suits Raspberry Pi-class k3s nodes, where the controller
+	// shares a few cores and a gigabyte or two with the k3s server itself.
+	profileEdge = "edge"
+)
+
+// edgeSmallMemory is the memory below which the edge profile keeps its
+// shortest histories and queues.
+const edgeSmallMemory = 512 << 20
+
+// applyProfile tunes the controller for the resources it detects. Flags set
+// on the command line are left alone, so any tuned value can still be
+// overridden.
+func applyProfile(name string) error {
+	switch name {
+	case profileDefault:
+		return nil
+	case profileEdge:
+	default:
+		return fmt.Errorf("unknown profile %q (want %s or %s)", name, profileDefault, profileEdge)
+	}
+
+	cpus, memory := runtime.GOMAXPROCS(0), detectMemory()
+	small := memory > 0 && memory < edgeSmallMemory
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	tuned := map[string]string{
+		// Leave a core to the k3s server sharing the node.
+		"rule-workers": strconv.Itoa(max(1, cpus-1)),
+		"client-queue": "128",
+		// k3s commonly stores its state in SQLite on an SD card; fewer,
+		// slower writes and reconciles keep it responsive.
+		"pod-write-qps":      "2",
+		"pod-write-burst":    "5",
+		"reconcile-interval": "1m",
+		// Paging through the namespace and polling metrics-server cost the
+		// API server more than they are worth on a single node.
+		"cache-audit-interval":  "0",
+		"cell-metrics-interval": "0",
+	}
+	if cgroupMemoryLimit() == 0 && memory > 0 {
+		// Without a container limit the default budget is off; the node's
+		// memory is shared with k3s and the cells.
+		tuned["memory-budget"] = strconv.FormatUint(memory/4, 10)
+	}
+	hub, stats, digests := 256, 2000, 100
+	if small {
+		tuned["client-queue"] = "64"
+		hub, stats, digests = 128, statsHistoryUnderPressure, digestHistoryUnderPressure
+	}
+
+	var applied []string
+	for name, value := range tuned {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("--%s=%s: %w", name, value, err)
+		}
+		applied = append(applied, "--"+name+"="+value)
+	}
+	sort.Strings(applied)
+	hubHistory, statsHistoryLimit, digestHistoryLimit = hub, stats, digests
+
+	detected := fmt.Sprintf("%d CPUs", cpus)
+	if memory > 0 {
+		detected += fmt.Sprintf(" and %d MiB", memory>>20)
+	}
+	log.Printf("Profile: %s for %s: %s; keeping %d stream messages, %d stats samples and %d digests",
+		name, detected, strings.Join(applied, " "), hub, stats, digests)
+	return nil
+}
+
+// detectMemory returns the container's memory limit or, without one, the
+// node's memory; 0 when neither is known.
+func detectMemory() uint64 {
+	if limit := cgroupMemoryLimit(); limit > 0 {
+		return limit
+	}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:        3884140 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+	return 0
+}
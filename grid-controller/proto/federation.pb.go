@@ -0,0 +1,721 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v3.21.12
+// source: proto/federation.proto
+
+package cell
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Edge selects the first (top) or last (bottom) row of a band.
+type Edge int32
+
+const (
+	Edge_EDGE_TOP    Edge = 0
+	Edge_EDGE_BOTTOM Edge = 1
+)
+
+// Enum value maps for Edge.
+var (
+	Edge_name = map[int32]string{
+		0: "EDGE_TOP",
+		1: "EDGE_BOTTOM",
+	}
+	Edge_value = map[string]int32{
+		"EDGE_TOP":    0,
+		"EDGE_BOTTOM": 1,
+	}
+)
+
+func (x Edge) Enum() *Edge {
+	p := new(Edge)
+	*p = x
+	return p
+}
+
+func (x Edge) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Edge) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_federation_proto_enumTypes[0].Descriptor()
+}
+
+func (Edge) Type() protoreflect.EnumType {
+	return &file_proto_federation_proto_enumTypes[0]
+}
+
+func (x Edge) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Edge.Descriptor instead.
+func (Edge) EnumDescriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{0}
+}
+
+type BoundaryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Generation    int64                  `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	Edge          Edge                   `protobuf:"varint,2,opt,name=edge,proto3,enum=cell.Edge" json:"edge,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BoundaryRequest) Reset() {
+	*x = BoundaryRequest{}
+	mi := &file_proto_federation_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BoundaryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BoundaryRequest) ProtoMessage() {}
+
+func (x *BoundaryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BoundaryRequest.ProtoReflect.Descriptor instead.
+func (*BoundaryRequest) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *BoundaryRequest) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *BoundaryRequest) GetEdge() Edge {
+	if x != nil {
+		return x.Edge
+	}
+	return Edge_EDGE_TOP
+}
+
+// BoundaryRow is the live state of one edge row, one entry per column.
+type BoundaryRow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Generation    int64                  `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	Alive         []bool                 `protobuf:"varint,2,rep,packed,name=alive,proto3" json:"alive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BoundaryRow) Reset() {
+	*x = BoundaryRow{}
+	mi := &file_proto_federation_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BoundaryRow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BoundaryRow) ProtoMessage() {}
+
+func (x *BoundaryRow) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BoundaryRow.ProtoReflect.Descriptor instead.
+func (*BoundaryRow) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *BoundaryRow) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *BoundaryRow) GetAlive() []bool {
+	if x != nil {
+		return x.Alive
+	}
+	return nil
+}
+
+// BandUpdate reports births and deaths within a band as band-local indices.
+type BandUpdate struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Generation int64                  `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	// row_offset is the global row of the band's first row.
+	RowOffset int32   `protobuf:"varint,2,opt,name=row_offset,json=rowOffset,proto3" json:"row_offset,omitempty"`
+	Width     int32   `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height    int32   `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	Births    []int32 `protobuf:"varint,5,rep,packed,name=births,proto3" json:"births,omitempty"`
+	Deaths    []int32 `protobuf:"varint,6,rep,packed,name=deaths,proto3" json:"deaths,omitempty"`
+	// revision numbers the member's updates; it increases by one per update.
+	Revision      uint64 `protobuf:"varint,7,opt,name=revision,proto3" json:"revision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BandUpdate) Reset() {
+	*x = BandUpdate{}
+	mi := &file_proto_federation_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BandUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BandUpdate) ProtoMessage() {}
+
+func (x *BandUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BandUpdate.ProtoReflect.Descriptor instead.
+func (*BandUpdate) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BandUpdate) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *BandUpdate) GetRowOffset() int32 {
+	if x != nil {
+		return x.RowOffset
+	}
+	return 0
+}
+
+func (x *BandUpdate) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *BandUpdate) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *BandUpdate) GetBirths() []int32 {
+	if x != nil {
+		return x.Births
+	}
+	return nil
+}
+
+func (x *BandUpdate) GetDeaths() []int32 {
+	if x != nil {
+		return x.Deaths
+	}
+	return nil
+}
+
+func (x *BandUpdate) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+type StateHashRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Generation    int64                  `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StateHashRequest) Reset() {
+	*x = StateHashRequest{}
+	mi := &file_proto_federation_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StateHashRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateHashRequest) ProtoMessage() {}
+
+func (x *StateHashRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateHashRequest.ProtoReflect.Descriptor instead.
+func (*StateHashRequest) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StateHashRequest) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+// StateHash is the canonical hash of a band's live cells, see stateHash.
+type StateHash struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Generation    int64                  `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	Hash          string                 `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StateHash) Reset() {
+	*x = StateHash{}
+	mi := &file_proto_federation_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StateHash) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateHash) ProtoMessage() {}
+
+func (x *StateHash) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateHash.ProtoReflect.Descriptor instead.
+func (*StateHash) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StateHash) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *StateHash) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+type BackfillRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// page_token continues a backfill; empty starts one at the member's latest
+	// generation.
+	PageToken string `protobuf:"bytes,1,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// page_size is the maximum number of live cells per page.
+	PageSize int32 `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// history is how many generations of changes up to the backfilled one to
+	// return with the first page.
+	History       int32 `protobuf:"varint,3,opt,name=history,proto3" json:"history,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackfillRequest) Reset() {
+	*x = BackfillRequest{}
+	mi := &file_proto_federation_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackfillRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackfillRequest) ProtoMessage() {}
+
+func (x *BackfillRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackfillRequest.ProtoReflect.Descriptor instead.
+func (*BackfillRequest) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BackfillRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *BackfillRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *BackfillRequest) GetHistory() int32 {
+	if x != nil {
+		return x.History
+	}
+	return 0
+}
+
+// BackfillPage is one page of a band's live cells at a generation. The state
+// includes every update up to revision; it may already include some later
+// ones, which are safe to apply again.
+type BackfillPage struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Generation int64                  `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	Revision   uint64                 `protobuf:"varint,8,opt,name=revision,proto3" json:"revision,omitempty"`
+	RowOffset  int32                  `protobuf:"varint,2,opt,name=row_offset,json=rowOffset,proto3" json:"row_offset,omitempty"`
+	Width      int32                  `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height     int32                  `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	// alive are band-local indices, ascending across pages.
+	Alive []int32 `protobuf:"varint,5,rep,packed,name=alive,proto3" json:"alive,omitempty"`
+	// history holds the updates up to revision of the last requested
+	// generations, oldest first; only set on the first page.
+	History []*BandUpdate `protobuf:"bytes,6,rep,name=history,proto3" json:"history,omitempty"`
+	// next_page_token is empty on the last page.
+	NextPageToken string `protobuf:"bytes,7,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackfillPage) Reset() {
+	*x = BackfillPage{}
+	mi := &file_proto_federation_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackfillPage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackfillPage) ProtoMessage() {}
+
+func (x *BackfillPage) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackfillPage.ProtoReflect.Descriptor instead.
+func (*BackfillPage) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BackfillPage) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *BackfillPage) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+func (x *BackfillPage) GetRowOffset() int32 {
+	if x != nil {
+		return x.RowOffset
+	}
+	return 0
+}
+
+func (x *BackfillPage) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *BackfillPage) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *BackfillPage) GetAlive() []int32 {
+	if x != nil {
+		return x.Alive
+	}
+	return nil
+}
+
+func (x *BackfillPage) GetHistory() []*BandUpdate {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+func (x *BackfillPage) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type WatchBandRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// from_revision is the last update the watcher already has.
+	FromRevision  uint64 `protobuf:"varint,1,opt,name=from_revision,json=fromRevision,proto3" json:"from_revision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchBandRequest) Reset() {
+	*x = WatchBandRequest{}
+	mi := &file_proto_federation_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchBandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchBandRequest) ProtoMessage() {}
+
+func (x *WatchBandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_federation_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchBandRequest.ProtoReflect.Descriptor instead.
+func (*WatchBandRequest) Descriptor() ([]byte, []int) {
+	return file_proto_federation_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchBandRequest) GetFromRevision() uint64 {
+	if x != nil {
+		return x.FromRevision
+	}
+	return 0
+}
+
+var File_proto_federation_proto protoreflect.FileDescriptor
+
+const file_proto_federation_proto_rawDesc = "" +
+	"\n" +
+	"\x16proto/federation.proto\x12\x04cell\x1a\x10proto/cell.proto\"Q\n" +
+	"\x0fBoundaryRequest\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\x12\x1e\n" +
+	"\x04edge\x18\x02 \x01(\x0e2\n" +
+	".cell.EdgeR\x04edge\"C\n" +
+	"\vBoundaryRow\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\x12\x14\n" +
+	"\x05alive\x18\x02 \x03(\bR\x05alive\"\xc5\x01\n" +
+	"\n" +
+	"BandUpdate\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\x12\x1d\n" +
+	"\n" +
+	"row_offset\x18\x02 \x01(\x05R\trowOffset\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\x12\x16\n" +
+	"\x06births\x18\x05 \x03(\x05R\x06births\x12\x16\n" +
+	"\x06deaths\x18\x06 \x03(\x05R\x06deaths\x12\x1a\n" +
+	"\brevision\x18\a \x01(\x04R\brevision\"2\n" +
+	"\x10StateHashRequest\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\"?\n" +
+	"\tStateHash\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\x12\x12\n" +
+	"\x04hash\x18\x02 \x01(\tR\x04hash\"g\n" +
+	"\x0fBackfillRequest\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x01 \x01(\tR\tpageToken\x12\x1b\n" +
+	"\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x18\n" +
+	"\ahistory\x18\x03 \x01(\x05R\ahistory\"\x81\x02\n" +
+	"\fBackfillPage\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\x12\x1a\n" +
+	"\brevision\x18\b \x01(\x04R\brevision\x12\x1d\n" +
+	"\n" +
+	"row_offset\x18\x02 \x01(\x05R\trowOffset\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\x12\x14\n" +
+	"\x05alive\x18\x05 \x03(\x05R\x05alive\x12*\n" +
+	"\ahistory\x18\x06 \x03(\v2\x10.cell.BandUpdateR\ahistory\x12&\n" +
+	"\x0fnext_page_token\x18\a \x01(\tR\rnextPageToken\"7\n" +
+	"\x10WatchBandRequest\x12#\n" +
+	"\rfrom_revision\x18\x01 \x01(\x04R\ffromRevision*%\n" +
+	"\x04Edge\x12\f\n" +
+	"\bEDGE_TOP\x10\x00\x12\x0f\n" +
+	"\vEDGE_BOTTOM\x10\x012\xa7\x02\n" +
+	"\x11FederationService\x127\n" +
+	"\vGetBoundary\x12\x15.cell.BoundaryRequest\x1a\x11.cell.BoundaryRow\x12,\n" +
+	"\tWatchBand\x12\v.cell.Empty\x1a\x10.cell.BandUpdate0\x01\x127\n" +
+	"\fGetStateHash\x12\x16.cell.StateHashRequest\x1a\x0f.cell.StateHash\x125\n" +
+	"\bBackfill\x12\x15.cell.BackfillRequest\x1a\x12.cell.BackfillPage\x12;\n" +
+	"\rWatchBandFrom\x12\x16.cell.WatchBandRequest\x1a\x10.cell.BandUpdate0\x01BGZEgithub.com/nordiwnd/k3s-cellular-automaton/grid-controller/proto/cellb\x06proto3"
+
+var (
+	file_proto_federation_proto_rawDescOnce sync.Once
+	file_proto_federation_proto_rawDescData []byte
+)
+
+func file_proto_federation_proto_rawDescGZIP() []byte {
+	file_proto_federation_proto_rawDescOnce.Do(func() {
+		file_proto_federation_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_federation_proto_rawDesc), len(file_proto_federation_proto_rawDesc)))
+	})
+	return file_proto_federation_proto_rawDescData
+}
+
+var file_proto_federation_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_federation_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_proto_federation_proto_goTypes = []any{
+	(Edge)(0),                // 0: cell.Edge
+	(*BoundaryRequest)(nil),  // 1: cell.BoundaryRequest
+	(*BoundaryRow)(nil),      // 2: cell.BoundaryRow
+	(*BandUpdate)(nil),       // 3: cell.BandUpdate
+	(*StateHashRequest)(nil), // 4: cell.StateHashRequest
+	(*StateHash)(nil),        // 5: cell.StateHash
+	(*BackfillRequest)(nil),  // 6: cell.BackfillRequest
+	(*BackfillPage)(nil),     // 7: cell.BackfillPage
+	(*WatchBandRequest)(nil), // 8: cell.WatchBandRequest
+	(*Empty)(nil),            // 9: cell.Empty
+}
+var file_proto_federation_proto_depIdxs = []int32{
+	0, // 0: cell.BoundaryRequest.edge:type_name -> cell.Edge
+	3, // 1: cell.BackfillPage.history:type_name -> cell.BandUpdate
+	1, // 2: cell.FederationService.GetBoundary:input_type -> cell.BoundaryRequest
+	9, // 3: cell.FederationService.WatchBand:input_type -> cell.Empty
+	4, // 4: cell.FederationService.GetStateHash:input_type -> cell.StateHashRequest
+	6, // 5: cell.FederationService.Backfill:input_type -> cell.BackfillRequest
+	8, // 6: cell.FederationService.WatchBandFrom:input_type -> cell.WatchBandRequest
+	2, // 7: cell.FederationService.GetBoundary:output_type -> cell.BoundaryRow
+	3, // 8: cell.FederationService.WatchBand:output_type -> cell.BandUpdate
+	5, // 9: cell.FederationService.GetStateHash:output_type -> cell.StateHash
+	7, // 10: cell.FederationService.Backfill:output_type -> cell.BackfillPage
+	3, // 11: cell.FederationService.WatchBandFrom:output_type -> cell.BandUpdate
+	7, // [7:12] is the sub-list for method output_type
+	2, // [2:7] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_proto_federation_proto_init() }
+func file_proto_federation_proto_init() {
+	if File_proto_federation_proto != nil {
+		return
+	}
+	file_proto_cell_proto_init()
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_federation_proto_rawDesc), len(file_proto_federation_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_federation_proto_goTypes,
+		DependencyIndexes: file_proto_federation_proto_depIdxs,
+		EnumInfos:         file_proto_federation_proto_enumTypes,
+		MessageInfos:      file_proto_federation_proto_msgTypes,
+	}.Build()
+	File_proto_federation_proto = out.File
+	file_proto_federation_proto_goTypes = nil
+	file_proto_federation_proto_depIdxs = nil
+}
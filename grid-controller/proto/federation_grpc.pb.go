@@ -0,0 +1,306 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.1
+// - protoc             v3.21.12
+// source: proto/federation.proto
+
+package cell
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	FederationService_GetBoundary_FullMethodName   = "/cell.FederationService/GetBoundary"
+	FederationService_WatchBand_FullMethodName     = "/cell.FederationService/WatchBand"
+	FederationService_GetStateHash_FullMethodName  = "/cell.FederationService/GetStateHash"
+	FederationService_Backfill_FullMethodName      = "/cell.FederationService/Backfill"
+	FederationService_WatchBandFrom_FullMethodName = "/cell.FederationService/WatchBandFrom"
+)
+
+// FederationServiceClient is the client API for FederationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// The FederationService links grid controllers that each own a horizontal
+// band of one automaton spread across several clusters.
+type FederationServiceClient interface {
+	// GetBoundary returns one edge row of the member's band at a generation.
+	GetBoundary(ctx context.Context, in *BoundaryRequest, opts ...grpc.CallOption) (*BoundaryRow, error)
+	// WatchBand streams the member's cell changes, starting with its full state.
+	WatchBand(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BandUpdate], error)
+	// GetStateHash returns the hash of the member's band at a generation, so
+	// an aggregator can verify the state it rebuilt from WatchBand.
+	GetStateHash(ctx context.Context, in *StateHashRequest, opts ...grpc.CallOption) (*StateHash, error)
+	// Backfill pages through the member's band at one recent generation and
+	// the changes leading up to it, so a peer or aggregator that joins can
+	// learn the band without one huge message. Pass next_page_token back to
+	// continue, also after reconnecting.
+	Backfill(ctx context.Context, in *BackfillRequest, opts ...grpc.CallOption) (*BackfillPage, error)
+	// WatchBandFrom streams the member's cell changes after a revision, e.g.
+	// the one a backfill returned, instead of starting with the full state.
+	WatchBandFrom(ctx context.Context, in *WatchBandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BandUpdate], error)
+}
+
+type federationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFederationServiceClient(cc grpc.ClientConnInterface) FederationServiceClient {
+	return &federationServiceClient{cc}
+}
+
+func (c *federationServiceClient) GetBoundary(ctx context.Context, in *BoundaryRequest, opts ...grpc.CallOption) (*BoundaryRow, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BoundaryRow)
+	err := c.cc.Invoke(ctx, FederationService_GetBoundary_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *federationServiceClient) WatchBand(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BandUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FederationService_ServiceDesc.Streams[0], FederationService_WatchBand_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Empty, BandUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FederationService_WatchBandClient = grpc.ServerStreamingClient[BandUpdate]
+
+func (c *federationServiceClient) GetStateHash(ctx context.Context, in *StateHashRequest, opts ...grpc.CallOption) (*StateHash, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StateHash)
+	err := c.cc.Invoke(ctx, FederationService_GetStateHash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *federationServiceClient) Backfill(ctx context.Context, in *BackfillRequest, opts ...grpc.CallOption) (*BackfillPage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BackfillPage)
+	err := c.cc.Invoke(ctx, FederationService_Backfill_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *federationServiceClient) WatchBandFrom(ctx context.Context, in *WatchBandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BandUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FederationService_ServiceDesc.Streams[1], FederationService_WatchBandFrom_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchBandRequest, BandUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FederationService_WatchBandFromClient = grpc.ServerStreamingClient[BandUpdate]
+
+// FederationServiceServer is the server API for FederationService service.
+// All implementations must embed UnimplementedFederationServiceServer
+// for forward compatibility.
+//
+// The FederationService links grid controllers that each own a horizontal
+// band of one automaton spread across several clusters.
+type FederationServiceServer interface {
+	// GetBoundary returns one edge row of the member's band at a generation.
+	GetBoundary(context.Context, *BoundaryRequest) (*BoundaryRow, error)
+	// WatchBand streams the member's cell changes, starting with its full state.
+	WatchBand(*Empty, grpc.ServerStreamingServer[BandUpdate]) error
+	// GetStateHash returns the hash of the member's band at a generation, so
+	// an aggregator can verify the state it rebuilt from WatchBand.
+	GetStateHash(context.Context, *StateHashRequest) (*StateHash, error)
+	// Backfill pages through the member's band at one recent generation and
+	// the changes leading up to it, so a peer or aggregator that joins can
+	// learn the band without one huge message. Pass next_page_token back to
+	// continue, also after reconnecting.
+	Backfill(context.Context, *BackfillRequest) (*BackfillPage, error)
+	// WatchBandFrom streams the member's cell changes after a revision, e.g.
+	// the one a backfill returned, instead of starting with the full state.
+	WatchBandFrom(*WatchBandRequest, grpc.ServerStreamingServer[BandUpdate]) error
+	mustEmbedUnimplementedFederationServiceServer()
+}
+
+// UnimplementedFederationServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFederationServiceServer struct{}
+
+func (UnimplementedFederationServiceServer) GetBoundary(context.Context, *BoundaryRequest) (*BoundaryRow, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetBoundary not implemented")
+}
+func (UnimplementedFederationServiceServer) WatchBand(*Empty, grpc.ServerStreamingServer[BandUpdate]) error {
+	return status.Error(codes.Unimplemented, "method WatchBand not implemented")
+}
+func (UnimplementedFederationServiceServer) GetStateHash(context.Context, *StateHashRequest) (*StateHash, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStateHash not implemented")
+}
+func (UnimplementedFederationServiceServer) Backfill(context.Context, *BackfillRequest) (*BackfillPage, error) {
+	return nil, status.Error(codes.Unimplemented, "method Backfill not implemented")
+}
+func (UnimplementedFederationServiceServer) WatchBandFrom(*WatchBandRequest, grpc.ServerStreamingServer[BandUpdate]) error {
+	return status.Error(codes.Unimplemented, "method WatchBandFrom not implemented")
+}
+func (UnimplementedFederationServiceServer) mustEmbedUnimplementedFederationServiceServer() {}
+func (UnimplementedFederationServiceServer) testEmbeddedByValue()                           {}
+
+// UnsafeFederationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FederationServiceServer will
+// result in compilation errors.
+type UnsafeFederationServiceServer interface {
+	mustEmbedUnimplementedFederationServiceServer()
+}
+
+func RegisterFederationServiceServer(s grpc.ServiceRegistrar, srv FederationServiceServer) {
+	// If the following call panics, it indicates UnimplementedFederationServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&FederationService_ServiceDesc, srv)
+}
+
+func _FederationService_GetBoundary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BoundaryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FederationServiceServer).GetBoundary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FederationService_GetBoundary_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FederationServiceServer).GetBoundary(ctx, req.(*BoundaryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FederationService_WatchBand_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FederationServiceServer).WatchBand(m, &grpc.GenericServerStream[Empty, BandUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FederationService_WatchBandServer = grpc.ServerStreamingServer[BandUpdate]
+
+func _FederationService_GetStateHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StateHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FederationServiceServer).GetStateHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FederationService_GetStateHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FederationServiceServer).GetStateHash(ctx, req.(*StateHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FederationService_Backfill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackfillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FederationServiceServer).Backfill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FederationService_Backfill_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FederationServiceServer).Backfill(ctx, req.(*BackfillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FederationService_WatchBandFrom_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchBandRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FederationServiceServer).WatchBandFrom(m, &grpc.GenericServerStream[WatchBandRequest, BandUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FederationService_WatchBandFromServer = grpc.ServerStreamingServer[BandUpdate]
+
+// FederationService_ServiceDesc is the grpc.ServiceDesc for FederationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FederationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cell.FederationService",
+	HandlerType: (*FederationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBoundary",
+			Handler:    _FederationService_GetBoundary_Handler,
+		},
+		{
+			MethodName: "GetStateHash",
+			Handler:    _FederationService_GetStateHash_Handler,
+		},
+		{
+			MethodName: "Backfill",
+			Handler:    _FederationService_Backfill_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchBand",
+			Handler:       _FederationService_WatchBand_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchBandFrom",
+			Handler:       _FederationService_WatchBandFrom_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/federation.proto",
+}
@@ -0,0 +1,262 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v3.21.12
+// source: proto/rule.proto
+
+package cell
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RuleInfo struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// rule names the automaton, e.g. B36/S23; archives record it.
+	Rule          string `protobuf:"bytes,1,opt,name=rule,proto3" json:"rule,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleInfo) Reset() {
+	*x = RuleInfo{}
+	mi := &file_proto_rule_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleInfo) ProtoMessage() {}
+
+func (x *RuleInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rule_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleInfo.ProtoReflect.Descriptor instead.
+func (*RuleInfo) Descriptor() ([]byte, []int) {
+	return file_proto_rule_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RuleInfo) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+// NeighborhoodBatch carries one neighborhood per cell in index order
+// (row-major). Each is a 9-bit mask of the cell's 3x3 Moore neighborhood,
+// also row-major: bit 0 is the north-west neighbor, bit 4 the cell itself
+// and bit 8 the south-east neighbor. Cells outside the grid are dead.
+type NeighborhoodBatch struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// generation is the generation the neighborhoods belong to.
+	Generation    int64    `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	Width         int32    `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32    `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	Neighborhoods []uint32 `protobuf:"varint,4,rep,packed,name=neighborhoods,proto3" json:"neighborhoods,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NeighborhoodBatch) Reset() {
+	*x = NeighborhoodBatch{}
+	mi := &file_proto_rule_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NeighborhoodBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NeighborhoodBatch) ProtoMessage() {}
+
+func (x *NeighborhoodBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rule_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NeighborhoodBatch.ProtoReflect.Descriptor instead.
+func (*NeighborhoodBatch) Descriptor() ([]byte, []int) {
+	return file_proto_rule_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *NeighborhoodBatch) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *NeighborhoodBatch) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *NeighborhoodBatch) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *NeighborhoodBatch) GetNeighborhoods() []uint32 {
+	if x != nil {
+		return x.Neighborhoods
+	}
+	return nil
+}
+
+// NextStates holds the next state of each cell, in the order of the batch.
+type NextStates struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Alive         []bool                 `protobuf:"varint,1,rep,packed,name=alive,proto3" json:"alive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NextStates) Reset() {
+	*x = NextStates{}
+	mi := &file_proto_rule_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NextStates) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NextStates) ProtoMessage() {}
+
+func (x *NextStates) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rule_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NextStates.ProtoReflect.Descriptor instead.
+func (*NextStates) Descriptor() ([]byte, []int) {
+	return file_proto_rule_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *NextStates) GetAlive() []bool {
+	if x != nil {
+		return x.Alive
+	}
+	return nil
+}
+
+var File_proto_rule_proto protoreflect.FileDescriptor
+
+const file_proto_rule_proto_rawDesc = "" +
+	"\n" +
+	"\x10proto/rule.proto\x12\x04cell\x1a\x10proto/cell.proto\"\x1e\n" +
+	"\bRuleInfo\x12\x12\n" +
+	"\x04rule\x18\x01 \x01(\tR\x04rule\"\x87\x01\n" +
+	"\x11NeighborhoodBatch\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x03R\n" +
+	"generation\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x05R\x06height\x12$\n" +
+	"\rneighborhoods\x18\x04 \x03(\rR\rneighborhoods\"\"\n" +
+	"\n" +
+	"NextStates\x12\x14\n" +
+	"\x05alive\x18\x01 \x03(\bR\x05alive2i\n" +
+	"\vRuleService\x12'\n" +
+	"\bDescribe\x12\v.cell.Empty\x1a\x0e.cell.RuleInfo\x121\n" +
+	"\x04Next\x12\x17.cell.NeighborhoodBatch\x1a\x10.cell.NextStatesBGZEgithub.com/nordiwnd/k3s-cellular-automaton/grid-controller/proto/cellb\x06proto3"
+
+var (
+	file_proto_rule_proto_rawDescOnce sync.Once
+	file_proto_rule_proto_rawDescData []byte
+)
+
+func file_proto_rule_proto_rawDescGZIP() []byte {
+	file_proto_rule_proto_rawDescOnce.Do(func() {
+		file_proto_rule_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_rule_proto_rawDesc), len(file_proto_rule_proto_rawDesc)))
+	})
+	return file_proto_rule_proto_rawDescData
+}
+
+var file_proto_rule_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_proto_rule_proto_goTypes = []any{
+	(*RuleInfo)(nil),          // 0: cell.RuleInfo
+	(*NeighborhoodBatch)(nil), // 1: cell.NeighborhoodBatch
+	(*NextStates)(nil),        // 2: cell.NextStates
+	(*Empty)(nil),             // 3: cell.Empty
+}
+var file_proto_rule_proto_depIdxs = []int32{
+	3, // 0: cell.RuleService.Describe:input_type -> cell.Empty
+	1, // 1: cell.RuleService.Next:input_type -> cell.NeighborhoodBatch
+	0, // 2: cell.RuleService.Describe:output_type -> cell.RuleInfo
+	2, // 3: cell.RuleService.Next:output_type -> cell.NextStates
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_proto_rule_proto_init() }
+func file_proto_rule_proto_init() {
+	if File_proto_rule_proto != nil {
+		return
+	}
+	file_proto_cell_proto_init()
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_rule_proto_rawDesc), len(file_proto_rule_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_rule_proto_goTypes,
+		DependencyIndexes: file_proto_rule_proto_depIdxs,
+		MessageInfos:      file_proto_rule_proto_msgTypes,
+	}.Build()
+	File_proto_rule_proto = out.File
+	file_proto_rule_proto_goTypes = nil
+	file_proto_rule_proto_depIdxs = nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Wire protocol versions served side by side, so kiosks can be moved to a new
+// schema one by one instead of in lockstep with the controller.
+//
+//	v1: bare JSON objects; cell updates are CellUpdate, every other message
+//	    carries a "type" field.
+//	v2: every message is an Envelope with type, sequence number and timestamp.
+//
+// A connection opens with the current banner, if any, then a snapshot of the
+// cells, after which sequence numbers strictly increase. A client that
+// reconnects with ?since=<seq> (or Last-Event-ID on /api/events) instead
+// receives the messages it missed, or a snapshot when the hub no longer has
+// them all. The conformance package checks a controller against this.
+const (
+	protocolV1     = 1
+	protocolV2     = 2
+	latestProtocol = protocolV2
+)
+
+// Message types.
+const (
+	msgCell          = "cell"
+	msgViewers       = "viewers"
+	msgBanner        = "banner"
+	msgAlert         = "alert"
+	msgCellMetrics   = "cell_metrics"
+	msgSonification  = "sonification"
+	msgChaosDecision = "chaos_decision"
+	msgTheme         = "theme"
+	msgCellError     = "cell_error"
+	msgSnapshot      = "snapshot"
+	msgGeneration    = "generation"
+	msgTournament    = "tournament"
+	msgMatch         = "match"
+	msgSelfTest      = "selftest"
+	msgFrame         = "frame"
+	msgScenario      = "scenario"
+)
+
+// Envelope is the v2 framing of every message.
+type Envelope struct {
+	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
+	Time time.Time       `json:"ts"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Snapshot is the latest state of every cell of a grid, sent when a client
+// connects. In v2 its sequence number is that of the last message it
+// reflects; the client's stream continues with the next one.
+type Snapshot struct {
+	Cells []CellUpdate `json:"cells"`
+}
+
+// Message is one hub event. It is encoded at most once per protocol version,
+// and only for versions that a connected client actually speaks.
+type Message struct {
+	Type string
+	Data any
+	Seq  uint64
+	Time time.Time
+	// Origin is when the event the message reports happened, e.g. when the
+	// informer delivered a pod update; it defaults to Time.
+	Origin time.Time
+	// Scope limits the message to clients subscribed to that grid; empty
+	// means every client.
+	Scope string
+
+	once    [latestProtocol + 1]sync.Once
+	encoded [latestProtocol + 1][]byte
+}
+
+func (m *Message) Encode(version int) []byte {
+	m.once[version].Do(func() {
+		data, _ := json.Marshal(m.Data)
+		switch version {
+		case protocolV1:
+			if m.Type != msgCell {
+				data = withType(data, m.Type)
+			}
+			m.encoded[version] = data
+		default:
+			m.encoded[version], _ = json.Marshal(Envelope{Type: m.Type, Seq: m.Seq, Time: m.Time, Data: data})
+		}
+	})
+	return m.encoded[version]
+}
+
+// withType adds the "type" field v1 clients use to tell messages apart.
+func withType(data []byte, typ string) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return data
+	}
+	fields["type"], _ = json.Marshal(typ)
+	out, _ := json.Marshal(fields)
+	return out
+}
+
+// publish hands a message to the hub for every connected client.
+func publish(typ string, data any) {
+	broadcast <- &Message{Type: typ, Data: data, Origin: time.Now()}
+}
+
+// publishScoped hands a message to the hub for the clients subscribed to grid.
+func publishScoped(grid, typ string, data any) {
+	broadcast <- &Message{Type: typ, Data: data, Scope: grid, Origin: time.Now()}
+}
+
+var viewersByProtocol = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grid_viewers_by_protocol",
+	Help: "Connected WebSocket viewers by negotiated protocol version.",
+}, []string{"version"})
+
+// negotiateProtocol picks the client's version from the grid.vN WebSocket
+// subprotocol or the ?v= query parameter, defaulting to v1 for clients that
+// predate versioning.
+func negotiateProtocol(r *http.Request, ws *websocket.Conn) int {
+	switch ws.Subprotocol() {
+	case "grid.v1":
+		return protocolV1
+	case "grid.v2":
+		return protocolV2
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("v")); err == nil && v >= protocolV1 && v <= latestProtocol {
+		return v
+	}
+	return protocolV1
+}
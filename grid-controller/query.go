@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Indexes of the pod informer used by GET /api/cells.
+const (
+	indexStatus = "status"
+	indexNode   = "node"
+	indexRow    = "row"
+	indexTile   = "tile"
+)
+
+// cellTile is the side of the square tiles the region index groups cells
+// into.
+const cellTile = 16
+
+// Page sizes of GET /api/cells.
+const (
+	defaultCellPage = 100
+	maxCellPage     = 1000
+)
+
+// podStatus is a cell pod's status as streamed to viewers: its game-status
+// label, initializing before it has one, terminating once it is deleted.
+func podStatus(pod *v1.Pod) string {
+	status, ok := pod.Labels["game-status"]
+	if !ok {
+		status = "initializing"
+	}
+	if pod.DeletionTimestamp != nil {
+		status = "terminating"
+	}
+	return status
+}
+
+func tileKey(x, y int) string {
+	return strconv.Itoa(x/cellTile) + "," + strconv.Itoa(y/cellTile)
+}
+
+// cellIndexers indexes cell pods by status, node, row and tile of the grid.
+func cellIndexers(grid GridGeometry) cache.Indexers {
+	cellPod := func(obj interface{}) (*v1.Pod, int, bool) {
+		pod, ok := obj.(*v1.Pod)
+		if !ok || pod.Labels["app"] != "cell" {
+			return nil, 0, false
+		}
+		i, ok := cellIndex(pod.Name)
+		return pod, i, ok && i < grid.Size()
+	}
+	return cache.Indexers{
+		indexStatus: func(obj interface{}) ([]string, error) {
+			if pod, _, ok := cellPod(obj); ok {
+				return []string{podStatus(pod)}, nil
+			}
+			return nil, nil
+		},
+		indexNode: func(obj interface{}) ([]string, error) {
+			if pod, _, ok := cellPod(obj); ok && pod.Spec.NodeName != "" {
+				return []string{pod.Spec.NodeName}, nil
+			}
+			return nil, nil
+		},
+		indexRow: func(obj interface{}) ([]string, error) {
+			if _, i, ok := cellPod(obj); ok {
+				_, y := grid.Coords(i)
+				return []string{strconv.Itoa(y)}, nil
+			}
+			return nil, nil
+		},
+		indexTile: func(obj interface{}) ([]string, error) {
+			if _, i, ok := cellPod(obj); ok {
+				return []string{tileKey(grid.Coords(i))}, nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+// CellInfo is one cell in a GET /api/cells result.
+type CellInfo struct {
+	Index      int     `json:"index"`
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Condition  string  `json:"condition,omitempty"`
+	Node       string  `json:"node,omitempty"`
+	AgeSeconds float64 `json:"ageSeconds"`
+	Genome     string  `json:"genome,omitempty"`
+}
+
+// CellPage is the body of GET /api/cells. Continue, when set, is passed
+// back as ?continue= for the next page.
+type CellPage struct {
+	Cells    []CellInfo `json:"cells"`
+	Continue string     `json:"continue,omitempty"`
+}
+
+// cellQuery is a parsed GET /api/cells query.
+type cellQuery struct {
+	statuses map[string]bool
+	node     string
+	minAge   time.Duration
+	// region is x0, y0, x1, y1 with x1 and y1 exclusive.
+	region []int
+	// near, when not -1, limits the result to the neighbors of that cell.
+	near  int
+	limit int
+	from  int
+}
+
+func parseCellQuery(q map[string][]string, grid GridGeometry) (cellQuery, error) {
+	get := func(key string) string {
+		if v := q[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	query := cellQuery{node: get("node"), near: -1, limit: defaultCellPage}
+	if v := get("status"); v != "" {
+		query.statuses = map[string]bool{}
+		for _, s := range strings.Split(v, ",") {
+			query.statuses[s] = true
+		}
+	}
+	if v := get("minAge"); v != "" {
+		// Bare numbers are seconds.
+		if _, err := strconv.Atoi(v); err == nil {
+			v += "s"
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return query, fmt.Errorf("invalid minAge %q", v)
+		}
+		query.minAge = d
+	}
+	if v := get("region"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return query, fmt.Errorf("region must be x0,y0,x1,y1")
+		}
+		for _, p := range parts {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("region must be x0,y0,x1,y1")
+			}
+			query.region = append(query.region, n)
+		}
+		query.region[2] = min(query.region[2], grid.Width)
+		query.region[3] = min(query.region[3], grid.Height)
+	}
+	if v := get("near"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n >= grid.Size() {
+			return query, fmt.Errorf("near must be a cell index below %d", grid.Size())
+		}
+		query.near = n
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxCellPage {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxCellPage)
+		}
+		query.limit = n
+	}
+	if v := get("continue"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return query, fmt.Errorf("invalid continue token")
+		}
+		query.from = n
+	}
+	return query, nil
+}
+
+// candidates narrows the pods to look at with the most selective index the
+// query allows.
+func (q cellQuery) candidates(indexer cache.Indexer, grid GridGeometry) ([]interface{}, error) {
+	switch {
+	case q.near >= 0:
+		return neighborPods(indexer, grid, q.near)
+	case q.node != "":
+		return indexer.ByIndex(indexNode, q.node)
+	case q.region != nil:
+		var objs []interface{}
+		for ty := q.region[1] / cellTile; ty*cellTile < q.region[3]; ty++ {
+			for tx := q.region[0] / cellTile; tx*cellTile < q.region[2]; tx++ {
+				tile, err := indexer.ByIndex(indexTile, tileKey(tx*cellTile, ty*cellTile))
+				if err != nil {
+					return nil, err
+				}
+				objs = append(objs, tile...)
+			}
+		}
+		return objs, nil
+	case q.statuses != nil:
+		var objs []interface{}
+		for status := range q.statuses {
+			matched, err := indexer.ByIndex(indexStatus, status)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, matched...)
+		}
+		return objs, nil
+	}
+	return indexer.List(), nil
+}
+
+// neighborPods returns the pods of the cells around a cell from the rows
+// index, without scanning the whole grid.
+func neighborPods(indexer cache.Indexer, grid GridGeometry, index int) ([]interface{}, error) {
+	x, y := grid.Coords(index)
+	var pods []interface{}
+	for ny := max(y-1, 0); ny <= min(y+1, grid.Height-1); ny++ {
+		row, err := indexer.ByIndex(indexRow, strconv.Itoa(ny))
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range row {
+			if pod, ok := obj.(*v1.Pod); ok {
+				if i, ok := cellIndex(pod.Name); ok {
+					if nx, _ := grid.Coords(i); nx >= x-1 && nx <= x+1 && i != index {
+						pods = append(pods, obj)
+					}
+				}
+			}
+		}
+	}
+	return pods, nil
+}
+
+// isNeighbor reports whether (x, y) is one of the eight cells around index.
+func isNeighbor(grid GridGeometry, index, x, y int) bool {
+	cx, cy := grid.Coords(index)
+	return (x != cx || y != cy) && x >= cx-1 && x <= cx+1 && y >= cy-1 && y <= cy+1
+}
+
+// match reports whether a cell pod satisfies every filter of the query.
+func (q cellQuery) match(pod *v1.Pod, grid GridGeometry, x, y int, now time.Time) bool {
+	switch {
+	case q.statuses != nil && !q.statuses[podStatus(pod)]:
+	case q.node != "" && pod.Spec.NodeName != q.node:
+	case q.minAge > 0 && now.Sub(pod.CreationTimestamp.Time) < q.minAge:
+	case q.region != nil && (x < q.region[0] || y < q.region[1] || x >= q.region[2] || y >= q.region[3]):
+	case q.near >= 0 && !isNeighbor(grid, q.near, x, y):
+	default:
+		return true
+	}
+	return false
+}
+
+// queryCells answers a query from the pod informer's cache, a page of cells
+// in index order.
+func queryCells(indexer cache.Indexer, grid GridGeometry, q cellQuery) (CellPage, error) {
+	objs, err := q.candidates(indexer, grid)
+	if err != nil {
+		return CellPage{}, err
+	}
+	now := time.Now()
+	page := CellPage{Cells: []CellInfo{}}
+	for _, obj := range objs {
+		pod, ok := obj.(*v1.Pod)
+		if !ok || pod.Labels["app"] != "cell" {
+			continue
+		}
+		i, ok := cellIndex(pod.Name)
+		if !ok || i < q.from || i >= grid.Size() {
+			continue
+		}
+		x, y := grid.Coords(i)
+		if !q.match(pod, grid, x, y, now) {
+			continue
+		}
+		page.Cells = append(page.Cells, CellInfo{
+			Index:      i,
+			X:          x,
+			Y:          y,
+			Name:       pod.Name,
+			Status:     podStatus(pod),
+			Condition:  podCondition(pod, zombieRestarts),
+			Node:       pod.Spec.NodeName,
+			AgeSeconds: now.Sub(pod.CreationTimestamp.Time).Seconds(),
+			Genome:     pod.Annotations[genomeAnnotation],
+		})
+	}
+	sort.Slice(page.Cells, func(a, b int) bool { return page.Cells[a].Index < page.Cells[b].Index })
+	if len(page.Cells) > q.limit {
+		page.Continue = strconv.Itoa(page.Cells[q.limit].Index)
+		page.Cells = page.Cells[:q.limit]
+	}
+	return page, nil
+}
+
+// handleCells serves GET /api/cells, e.g.
+// ?status=alive&minAge=10&region=0,0,20,20&node=worker-2&limit=100.
+// Filters combine; region is x0,y0,x1,y1 with x1 and y1 exclusive, minAge
+// is a duration or seconds, and near=N keeps the neighbors of cell N.
+func handleCells(w http.ResponseWriter, r *http.Request, indexer cache.Indexer, grid GridGeometry) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseCellQuery(r.URL.Query(), grid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := queryCells(indexer, grid, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
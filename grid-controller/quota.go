@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Rate-limited actions.
+const (
+	actionChaos    = "chaos"
+	actionSpawn    = "spawn"
+	actionPatterns = "patterns"
+	actionGrids    = "grids"
+	actionSessions = "sessions"
+	actionPreviews = "previews"
+	actionRules    = "rules"
+	// actionChat is charged for every chat or slash command, on top of the
+	// action the command performs.
+	actionChat = "chat"
+)
+
+var quotaActions = []string{actionChaos, actionSpawn, actionPatterns, actionGrids, actionSessions, actionPreviews, actionRules, actionChat}
+
+// QuotaRule limits how often each caller may perform an action, e.g.
+//
+//	quotas:
+//	- action: chaos
+//	  limit: 20
+//	  per: 1h
+//
+// Callers are told apart by requestIdentity; anonymous callers by address.
+// Administrators are exempt.
+type QuotaRule struct {
+	Action string          `json:"action"`
+	Limit  int             `json:"limit"`
+	Per    metav1.Duration `json:"per"`
+}
+
+func (q QuotaRule) validate() error {
+	known := false
+	for _, a := range quotaActions {
+		known = known || a == q.Action
+	}
+	if !known {
+		return fmt.Errorf("unknown action %q (want one of %v)", q.Action, quotaActions)
+	}
+	if q.Limit <= 0 || q.Per.Duration <= 0 {
+		return errors.New("limit and per must be positive")
+	}
+	return nil
+}
+
+// QuotaUsage is one action's usage in the current window.
+type QuotaUsage struct {
+	Action string    `json:"action"`
+	Limit  int       `json:"limit"`
+	Used   int       `json:"used"`
+	Resets time.Time `json:"resets"`
+}
+
+type quotaWindow struct {
+	start time.Time
+	used  int
+}
+
+// quotaTracker counts actions per caller in fixed windows.
+type quotaTracker struct {
+	mu      sync.Mutex
+	rules   map[string]QuotaRule
+	windows map[string]map[string]*quotaWindow // action -> caller -> window
+}
+
+var quotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_quota_rejections_total",
+	Help: "Requests rejected because the caller's quota was used up, by action.",
+}, []string{"action"})
+
+// quotas is set from the configuration file at startup.
+var quotas = newQuotaTracker(nil)
+
+func newQuotaTracker(rules []QuotaRule) *quotaTracker {
+	t := &quotaTracker{rules: map[string]QuotaRule{}, windows: map[string]map[string]*quotaWindow{}}
+	for _, rule := range rules {
+		t.rules[rule.Action] = rule
+		t.windows[rule.Action] = map[string]*quotaWindow{}
+	}
+	return t
+}
+
+// quotaCaller identifies the caller a quota is charged to.
+func quotaCaller(r *http.Request) string {
+	id := requestIdentity(r)
+	if id != "anonymous" {
+		return id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "anonymous:" + host
+}
+
+// window returns the caller's current window for a rule; t.mu must be held.
+func (t *quotaTracker) window(rule QuotaRule, caller string, now time.Time) *quotaWindow {
+	w := t.windows[rule.Action][caller]
+	if w == nil || now.Sub(w.start) >= rule.Per.Duration {
+		w = &quotaWindow{start: now}
+		t.windows[rule.Action][caller] = w
+	}
+	return w
+}
+
+// Allow charges one action to the caller, or writes a 429 response when the
+// quota is used up.
+func (t *quotaTracker) Allow(w http.ResponseWriter, r *http.Request, action string) bool {
+	rule, ok := t.rules[action]
+	if !ok || isAdmin(r) {
+		return true
+	}
+	caller := quotaCaller(r)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	win := t.window(rule, caller, now)
+	resets := win.start.Add(rule.Per.Duration)
+	w.Header().Set("X-Quota-Limit", strconv.Itoa(rule.Limit))
+	if win.used >= rule.Limit {
+		w.Header().Set("X-Quota-Remaining", "0")
+		w.Header().Set("Retry-After", strconv.Itoa(int(resets.Sub(now).Seconds())+1))
+		quotaRejections.WithLabelValues(action).Inc()
+		http.Error(w, fmt.Sprintf("Quota exceeded: %d %s per %s", rule.Limit, action, rule.Per.Duration), http.StatusTooManyRequests)
+		return false
+	}
+	win.used++
+	w.Header().Set("X-Quota-Remaining", strconv.Itoa(rule.Limit-win.used))
+	return true
+}
+
+// Usage reports the caller's usage of every limited action.
+func (t *quotaTracker) Usage(r *http.Request) []QuotaUsage {
+	caller := quotaCaller(r)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := []QuotaUsage{}
+	for _, action := range quotaActions {
+		rule, ok := t.rules[action]
+		if !ok {
+			continue
+		}
+		win := t.window(rule, caller, now)
+		usage = append(usage, QuotaUsage{Action: action, Limit: rule.Limit, Used: win.used, Resets: win.start.Add(rule.Per.Duration)})
+	}
+	return usage
+}
+
+// Prune forgets windows that have ended.
+func (t *quotaTracker) Prune() {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for action, callers := range t.windows {
+		for caller, win := range callers {
+			if now.Sub(win.start) >= t.rules[action].Per.Duration {
+				delete(callers, caller)
+			}
+		}
+	}
+}
+
+func (t *quotaTracker) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Prune()
+		}
+	}
+}
+
+// handleQuota serves GET /api/quota: the caller's usage.
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := struct {
+		Identity string       `json:"identity"`
+		Quotas   []QuotaUsage `json:"quotas"`
+	}{quotaCaller(r), quotas.Usage(r)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ansiRenderer draws frames on a terminal, e.g. a console on /dev/tty1 of a
+// headless node, or the controller's standard output with target "-". Two
+// rows of cells share one line of half-block characters, so a 64x64 grid
+// fits a 64x32 terminal.
+type ansiRenderer struct {
+	out   io.Writer
+	close func() error
+	grid  GridGeometry
+}
+
+func newANSIRenderer(cfg RendererConfig, grid GridGeometry) (Renderer, error) {
+	if err := checkRendererOptions(cfg.Options); err != nil {
+		return nil, err
+	}
+	r := &ansiRenderer{grid: grid}
+	switch cfg.Target {
+	case "", "-":
+		r.out, r.close = os.Stdout, func() error { return nil }
+	default:
+		f, err := os.OpenFile(cfg.Target, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, err
+		}
+		r.out, r.close = f, f.Close
+	}
+	return r, nil
+}
+
+func (r *ansiRenderer) Render(ctx context.Context, f *Frame) error {
+	var b strings.Builder
+	// Home the cursor and draw over the previous frame.
+	b.WriteString("\x1b[H")
+	fmt.Fprintf(&b, "generation %d  population %d\x1b[K\n", f.Generation, f.Population())
+	for y := 0; y < r.grid.Height; y += 2 {
+		top := f.Row(y)
+		bottom := make([]bool, r.grid.Width)
+		if y+1 < r.grid.Height {
+			bottom = f.Row(y + 1)
+		}
+		for x := range top {
+			switch {
+			case top[x] && bottom[x]:
+				b.WriteString("█")
+			case top[x]:
+				b.WriteString("▀")
+			case bottom[x]:
+				b.WriteString("▄")
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString("\x1b[K\n")
+	}
+	b.WriteString("\x1b[J")
+	_, err := io.WriteString(r.out, b.String())
+	return err
+}
+
+func (r *ansiRenderer) Close() error {
+	return r.close()
+}
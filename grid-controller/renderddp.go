@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"net"
+)
+
+// DDP (Distributed Display Protocol) packet layout, as spoken by WLED and
+// most LED controllers.
+const (
+	ddpPort      = "4048"
+	ddpHeaderLen = 10
+	// ddpMaxData is the pixel data of one packet: 480 RGB pixels, which
+	// keeps packets within a 1500-byte MTU.
+	ddpMaxData = 1440
+	ddpVersion = 0x40
+	ddpPush    = 0x01
+	// ddpTypeRGB24 is RGB with 8 bits per channel.
+	ddpTypeRGB24 = 0x0b
+	// ddpDisplay is the default output device.
+	ddpDisplay = 0x01
+)
+
+// ddpRenderer drives an LED matrix with one pixel per cell over DDP on UDP.
+// The target is the controller's host, or host:port (4048 by default).
+//
+// Options: on and off (colors of live and dead cells), born (color of cells
+// born this generation, on by default) and serpentine ("true" for matrices
+// wired in a zig-zag, every other row running right to left).
+type ddpRenderer struct {
+	conn       net.Conn
+	grid       GridGeometry
+	on, off    color.RGBA
+	born       color.RGBA
+	serpentine bool
+
+	seq byte
+	buf []byte
+}
+
+func newDDPRenderer(cfg RendererConfig, grid GridGeometry) (Renderer, error) {
+	if err := checkRendererOptions(cfg.Options, "on", "off", "born", "serpentine"); err != nil {
+		return nil, err
+	}
+	if cfg.Target == "" {
+		return nil, errors.New("target must be the LED controller's host or host:port")
+	}
+	addr := cfg.Target
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, ddpPort)
+	}
+	r := &ddpRenderer{grid: grid, buf: make([]byte, 3*grid.Size())}
+	var err error
+	if r.on, err = rendererColor(cfg.Options, "on", color.RGBA{0xff, 0xff, 0xff, 0xff}); err != nil {
+		return nil, err
+	}
+	if r.off, err = rendererColor(cfg.Options, "off", color.RGBA{}); err != nil {
+		return nil, err
+	}
+	if r.born, err = rendererColor(cfg.Options, "born", r.on); err != nil {
+		return nil, err
+	}
+	switch cfg.Options["serpentine"] {
+	case "", "false":
+	case "true":
+		r.serpentine = true
+	default:
+		return nil, fmt.Errorf("option serpentine: %q is not true or false", cfg.Options["serpentine"])
+	}
+	if r.conn, err = net.Dial("udp", addr); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// pixel is where a cell's pixel is in the strip.
+func (r *ddpRenderer) pixel(index int) int {
+	x, y := r.grid.Coords(index)
+	if r.serpentine && y%2 == 1 {
+		x = r.grid.Width - 1 - x
+	}
+	return y*r.grid.Width + x
+}
+
+func (r *ddpRenderer) set(index int, c color.RGBA) {
+	p := 3 * r.pixel(index)
+	r.buf[p], r.buf[p+1], r.buf[p+2] = c.R, c.G, c.B
+}
+
+func (r *ddpRenderer) Render(ctx context.Context, f *Frame) error {
+	for i := 0; i < r.grid.Size(); i++ {
+		r.set(i, r.off)
+	}
+	for _, i := range f.view.cells {
+		r.set(i, r.on)
+	}
+	for _, i := range f.Births {
+		r.set(i, r.born)
+	}
+
+	// Only the last packet pushes, so the matrix shows whole frames.
+	r.seq = r.seq%15 + 1
+	packet := make([]byte, ddpHeaderLen+ddpMaxData)
+	for offset := 0; offset < len(r.buf); offset += ddpMaxData {
+		data := r.buf[offset:min(offset+ddpMaxData, len(r.buf))]
+		flags := byte(ddpVersion)
+		if offset+len(data) == len(r.buf) {
+			flags |= ddpPush
+		}
+		packet[0], packet[1], packet[2], packet[3] = flags, r.seq, ddpTypeRGB24, ddpDisplay
+		binary.BigEndian.PutUint32(packet[4:], uint32(offset))
+		binary.BigEndian.PutUint16(packet[8:], uint16(len(data)))
+		n := copy(packet[ddpHeaderLen:], data)
+		if _, err := r.conn.Write(packet[:ddpHeaderLen+n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ddpRenderer) Close() error {
+	return r.conn.Close()
+}
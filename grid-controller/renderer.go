@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Frame is one generation of the standalone engine as renderers see it. It
+// shares the engine's immutable snapshot, so renderers may keep it.
+type Frame struct {
+	Grid       GridGeometry
+	Generation int64
+	// Births and deaths are the cells that changed to reach this generation.
+	Births, Deaths []int
+
+	view *gridSnapshot
+}
+
+func newFrame(view *gridSnapshot, births, deaths []int) *Frame {
+	return &Frame{Grid: view.grid, Generation: view.generation, Births: births, Deaths: deaths, view: view}
+}
+
+// Alive reports whether a cell is alive.
+func (f *Frame) Alive(index int) bool {
+	return f.view.alive.Has(index)
+}
+
+// Row returns the live state of one row.
+func (f *Frame) Row(y int) []bool {
+	return f.view.Row(y)
+}
+
+// Population is the number of live cells.
+func (f *Frame) Population() int {
+	return len(f.view.cells)
+}
+
+// Renderer is a display backend: it turns frames into output for one sink,
+// e.g. an LED matrix or a file. Render is called from one goroutine per
+// renderer and may block; frames arriving meanwhile replace each other, so a
+// slow sink shows the latest generation instead of falling behind.
+type Renderer interface {
+	Render(ctx context.Context, f *Frame) error
+	Close() error
+}
+
+// RendererConfig adds a display backend, e.g.
+//
+//	renderers:
+//	  - kind: ddp
+//	    target: 192.168.1.50:4048
+//	    options: {on: "#ff8000", serpentine: "true"}
+//	  - kind: gif
+//	    target: /data/grid.gif
+//	    every: 2
+//	    options: {frames: "100", scale: "4"}
+//	  - kind: ansi
+//	    target: /dev/tty1
+//
+// target is where the output goes, as the kind understands it; every renders
+// only every nth generation (1 by default). options are kind-specific.
+type RendererConfig struct {
+	Kind    string            `json:"kind"`
+	Target  string            `json:"target,omitempty"`
+	Every   int               `json:"every,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+func (c *RendererConfig) validate() error {
+	if _, ok := rendererKinds[c.Kind]; !ok {
+		return fmt.Errorf("unknown kind %q (want one of %s)", c.Kind, strings.Join(rendererKindNames(), ", "))
+	}
+	if c.Every < 0 {
+		return errors.New("every must not be negative")
+	}
+	if c.Every == 0 {
+		c.Every = 1
+	}
+	return nil
+}
+
+// rendererFactory opens a renderer for a grid.
+type rendererFactory func(cfg RendererConfig, grid GridGeometry) (Renderer, error)
+
+// rendererKinds are the display backends. A new backend is a Renderer in a
+// file of its own and its factory here.
+var rendererKinds = map[string]rendererFactory{
+	"websocket": newFrameRenderer,
+	"gif":       newGIFRenderer,
+	"ddp":       newDDPRenderer,
+	"ansi":      newANSIRenderer,
+}
+
+func rendererKindNames() []string {
+	names := make([]string, 0, len(rendererKinds))
+	for name := range rendererKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var rendererFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_renderer_frames_total",
+	Help: "Frames handed to renderers, by renderer and result: rendered, failed or dropped (replaced by a newer frame before the renderer got to it).",
+}, []string{"renderer", "result"})
+
+// rendererOutput runs one configured renderer.
+type rendererOutput struct {
+	name     string
+	every    int
+	renderer Renderer
+	frames   chan *Frame
+}
+
+// rendererSet feeds every generation to the configured renderers. Its methods
+// are safe to call on a nil set.
+type rendererSet struct {
+	outputs []*rendererOutput
+}
+
+func newRendererSet(configs []RendererConfig, grid GridGeometry) (*rendererSet, error) {
+	set := &rendererSet{}
+	for i, cfg := range configs {
+		r, err := rendererKinds[cfg.Kind](cfg, grid)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("renderer %d (%s): %w", i, cfg.Kind, err)
+		}
+		name := cfg.Kind
+		if cfg.Target != "" {
+			name += " " + cfg.Target
+		}
+		set.outputs = append(set.outputs, &rendererOutput{name: name, every: cfg.Every, renderer: r, frames: make(chan *Frame, 1)})
+	}
+	return set, nil
+}
+
+// Run renders frames until ctx is done, then closes the renderers.
+func (s *rendererSet) Run(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	for _, o := range s.outputs {
+		log.Printf("Renderers: %s every %d generation(s)", o.name, o.every)
+		go o.run(ctx)
+	}
+	<-ctx.Done()
+	s.close()
+}
+
+func (s *rendererSet) close() {
+	for _, o := range s.outputs {
+		if err := o.renderer.Close(); err != nil {
+			log.Printf("Renderers: close %s: %v", o.name, err)
+		}
+	}
+}
+
+// Render hands a frame to every renderer due for it without waiting for any.
+func (s *rendererSet) Render(f *Frame) {
+	if s == nil {
+		return
+	}
+	for _, o := range s.outputs {
+		if f.Generation%int64(o.every) != 0 {
+			continue
+		}
+		select {
+		case o.frames <- f:
+			continue
+		default:
+		}
+		// The renderer is still busy with an older frame than the one
+		// waiting; replace that one.
+		select {
+		case <-o.frames:
+			rendererFrames.WithLabelValues(o.name, "dropped").Inc()
+		default:
+		}
+		select {
+		case o.frames <- f:
+		default:
+			rendererFrames.WithLabelValues(o.name, "dropped").Inc()
+		}
+	}
+}
+
+func (o *rendererOutput) run(ctx context.Context) {
+	failing := false
+	for {
+		var f *Frame
+		select {
+		case <-ctx.Done():
+			return
+		case f = <-o.frames:
+		}
+		renderCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := o.renderer.Render(renderCtx, f)
+		cancel()
+		if err != nil {
+			rendererFrames.WithLabelValues(o.name, "failed").Inc()
+			if !failing {
+				log.Printf("Renderers: %s: generation %d: %v", o.name, f.Generation, err)
+			}
+			failing = true
+			continue
+		}
+		rendererFrames.WithLabelValues(o.name, "rendered").Inc()
+		if failing {
+			log.Printf("Renderers: %s is rendering again", o.name)
+		}
+		failing = false
+	}
+}
+
+// FrameMessage is the whole grid as a bitmap, streamed by the websocket
+// renderer: row-major, one bit per cell, least significant bit first.
+type FrameMessage struct {
+	Generation int64  `json:"generation"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Population int    `json:"population"`
+	Bits       string `json:"bits"`
+}
+
+// frameRenderer streams frames to WebSocket and event stream clients as frame
+// messages, for displays that redraw whole generations instead of applying
+// cell updates.
+type frameRenderer struct{}
+
+func newFrameRenderer(cfg RendererConfig, grid GridGeometry) (Renderer, error) {
+	if cfg.Target != "" || len(cfg.Options) > 0 {
+		return nil, errors.New("takes no target or options")
+	}
+	return frameRenderer{}, nil
+}
+
+func (frameRenderer) Render(ctx context.Context, f *Frame) error {
+	bits := make([]byte, (f.Grid.Size()+7)/8)
+	for _, i := range f.view.cells {
+		bits[i/8] |= 1 << (i % 8)
+	}
+	publish(msgFrame, FrameMessage{
+		Generation: f.Generation,
+		Width:      f.Grid.Width,
+		Height:     f.Grid.Height,
+		Population: f.Population(),
+		Bits:       base64.StdEncoding.EncodeToString(bits),
+	})
+	return nil
+}
+
+func (frameRenderer) Close() error {
+	return nil
+}
+
+// rendererColor reads a #rgb or #rrggbb option, or returns def when it is
+// not set.
+func rendererColor(options map[string]string, name string, def color.RGBA) (color.RGBA, error) {
+	v, ok := options[name]
+	if !ok {
+		return def, nil
+	}
+	hex := strings.TrimPrefix(v, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return def, fmt.Errorf("option %s: %q is not a #rrggbb color", name, v)
+	}
+	return color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xff}, nil
+}
+
+// rendererInt reads a positive integer option, or returns def when it is not
+// set.
+func rendererInt(options map[string]string, name string, def int) (int, error) {
+	v, ok := options[name]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return def, fmt.Errorf("option %s: %q is not a positive integer", name, v)
+	}
+	return n, nil
+}
+
+// checkRendererOptions rejects options a renderer does not know.
+func checkRendererOptions(options map[string]string, known ...string) error {
+	for name := range options {
+		found := false
+		for _, k := range known {
+			found = found || name == k
+		}
+		if !found {
+			return fmt.Errorf("unknown option %q (want %s)", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
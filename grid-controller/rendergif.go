@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+)
+
+// gifRenderer records frames as an animated GIF clip. Once it has frames
+// frames it writes the clip to its target, replacing the previous one, and
+// starts the next, so the file always holds the latest complete clip.
+//
+// Options: frames (per clip, 100 by default), scale (pixels per cell, 4),
+// delay (between frames in hundredths of a second, 10), on and off (colors
+// of live and dead cells).
+type gifRenderer struct {
+	path   string
+	grid   GridGeometry
+	frames int
+	scale  int
+	delay  int
+
+	palette color.Palette
+	clip    gif.GIF
+}
+
+func newGIFRenderer(cfg RendererConfig, grid GridGeometry) (Renderer, error) {
+	if err := checkRendererOptions(cfg.Options, "frames", "scale", "delay", "on", "off"); err != nil {
+		return nil, err
+	}
+	if cfg.Target == "" {
+		return nil, errors.New("target must be the path of the GIF file")
+	}
+	r := &gifRenderer{path: cfg.Target, grid: grid}
+	var err error
+	if r.frames, err = rendererInt(cfg.Options, "frames", 100); err != nil {
+		return nil, err
+	}
+	if r.scale, err = rendererInt(cfg.Options, "scale", 4); err != nil {
+		return nil, err
+	}
+	if r.delay, err = rendererInt(cfg.Options, "delay", 10); err != nil {
+		return nil, err
+	}
+	off, err := rendererColor(cfg.Options, "off", color.RGBA{0x11, 0x18, 0x27, 0xff})
+	if err != nil {
+		return nil, err
+	}
+	on, err := rendererColor(cfg.Options, "on", color.RGBA{0x22, 0xc5, 0x5e, 0xff})
+	if err != nil {
+		return nil, err
+	}
+	r.palette = color.Palette{off, on}
+	return r, nil
+}
+
+func (r *gifRenderer) Render(ctx context.Context, f *Frame) error {
+	img := image.NewPaletted(image.Rect(0, 0, r.grid.Width*r.scale, r.grid.Height*r.scale), r.palette)
+	for _, i := range f.view.cells {
+		x, y := r.grid.Coords(i)
+		for dy := 0; dy < r.scale; dy++ {
+			row := img.Pix[(y*r.scale+dy)*img.Stride:]
+			for dx := 0; dx < r.scale; dx++ {
+				row[x*r.scale+dx] = 1
+			}
+		}
+	}
+	r.clip.Image = append(r.clip.Image, img)
+	r.clip.Delay = append(r.clip.Delay, r.delay)
+	if len(r.clip.Image) < r.frames {
+		return nil
+	}
+	clip := r.clip
+	r.clip = gif.GIF{}
+	return r.write(&clip)
+}
+
+// write replaces the file with a clip, through a temporary file so readers
+// never see a partial one.
+func (r *gifRenderer) write(clip *gif.GIF) error {
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".grid-*.gif")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gif.EncodeAll(tmp, clip); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+func (r *gifRenderer) Close() error {
+	return nil
+}
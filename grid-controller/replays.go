@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Exit codes of `grid-controller verify-replay`.
+const (
+	verifyOK       = 0
+	verifyMismatch = 1
+	verifyUsage    = 2
+)
+
+var sideNames = map[int8]string{ownerHome: "home", ownerAway: "away"}
+
+// ReplayMove is a bot's move: the cells it placed before the engine computed
+// the generation after Generation.
+type ReplayMove struct {
+	Generation int64  `json:"generation"`
+	Side       string `json:"side"`
+	Cells      []int  `json:"cells"`
+}
+
+// MatchReplay is everything needed to play a tournament match again: the
+// grid, its seed (the live cells before any move, none for the fresh grids
+// matches start on), the rule, the moves in the order they were applied, and
+// the outcome. FinalHash covers the last generation and who owned each cell.
+type MatchReplay struct {
+	Tournament  string       `json:"tournament"`
+	Match       int          `json:"match"`
+	Home        string       `json:"home"`
+	Away        string       `json:"away"`
+	Rule        string       `json:"rule"`
+	Width       int          `json:"width"`
+	Height      int          `json:"height"`
+	Generations int          `json:"generations"`
+	Seed        []int        `json:"seed"`
+	Moves       []ReplayMove `json:"moves"`
+	HomeScore   int          `json:"homeScore"`
+	AwayScore   int          `json:"awayScore"`
+	Winner      string       `json:"winner,omitempty"`
+	FinalHash   string       `json:"finalHash"`
+}
+
+// MatchAttestation is a replay signed by the controller. Digest is the
+// SHA-256 of the replay's JSON encoding, Signature an Ed25519 signature of
+// the digest by PublicKey, both hex.
+type MatchAttestation struct {
+	Replay    MatchReplay `json:"replay"`
+	Digest    string      `json:"digest"`
+	PublicKey string      `json:"publicKey"`
+	Signature string      `json:"signature"`
+}
+
+// attestationKey signs match replays. It is read from the ATTESTATION_KEY
+// environment variable, a hex Ed25519 seed; without it a random key is drawn
+// at startup, and results are only verifiable against the key served by that
+// controller at GET /api/attestation-key.
+var attestationKey = loadAttestationKey()
+
+func loadAttestationKey() ed25519.PrivateKey {
+	if s := os.Getenv("ATTESTATION_KEY"); s != "" {
+		seed, err := hex.DecodeString(s)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			log.Fatalf("ATTESTATION_KEY must be a hex %d-byte Ed25519 seed", ed25519.SeedSize)
+		}
+		return ed25519.NewKeyFromSeed(seed)
+	}
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	return key
+}
+
+// hash digests the generation and the owner of every cell.
+func (g *arenaGrid) hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:", g.engine.Generation())
+	for _, o := range g.owners {
+		h.Write([]byte{byte(o)})
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// attestMatch signs the replay of a finished match; the arena's mu must be
+// held.
+func attestMatch(t *Tournament, m *Match) *MatchAttestation {
+	replay := MatchReplay{
+		Tournament:  t.Name,
+		Match:       m.ID,
+		Home:        m.Home,
+		Away:        m.Away,
+		Rule:        t.Rule,
+		Width:       t.Width,
+		Height:      t.Height,
+		Generations: t.Generations,
+		Seed:        []int{},
+		Moves:       append([]ReplayMove{}, m.moves...),
+		HomeScore:   m.HomeScore,
+		AwayScore:   m.AwayScore,
+		Winner:      m.Winner,
+		FinalHash:   m.grid.hash(),
+	}
+	digest := replayDigest(replay)
+	return &MatchAttestation{
+		Replay:    replay,
+		Digest:    hex.EncodeToString(digest),
+		PublicKey: hex.EncodeToString(attestationKey.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(attestationKey, digest)),
+	}
+}
+
+func replayDigest(r MatchReplay) []byte {
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// verifyAttestation checks an attestation's signature, against key unless it
+// is nil, and plays its replay again with the standalone engine, which must
+// reach the same final state and scores.
+func verifyAttestation(ctx context.Context, a MatchAttestation, key ed25519.PublicKey) error {
+	digest := replayDigest(a.Replay)
+	if hex.EncodeToString(digest) != a.Digest {
+		return errors.New("digest does not match the replay")
+	}
+	signed, err := hex.DecodeString(a.PublicKey)
+	if err != nil || len(signed) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if key != nil && !key.Equal(ed25519.PublicKey(signed)) {
+		return errors.New("signed by a different key")
+	}
+	sig, err := hex.DecodeString(a.Signature)
+	if err != nil || !ed25519.Verify(signed, digest, sig) {
+		return errors.New("invalid signature")
+	}
+
+	g, err := replayMatch(ctx, a.Replay)
+	if err != nil {
+		return err
+	}
+	if hash := g.hash(); hash != a.Replay.FinalHash {
+		return fmt.Errorf("replay ends in %s, attested %s", hash, a.Replay.FinalHash)
+	}
+	home, away := len(g.cells(ownerHome)), len(g.cells(ownerAway))
+	if home != a.Replay.HomeScore || away != a.Replay.AwayScore {
+		return fmt.Errorf("replay scores %d-%d, attested %d-%d", home, away, a.Replay.HomeScore, a.Replay.AwayScore)
+	}
+	return nil
+}
+
+// replayMatch plays a replay's moves and generations on a fresh grid.
+func replayMatch(ctx context.Context, r MatchReplay) (*arenaGrid, error) {
+	rule, err := newLifeLikeEngine(r.Rule)
+	if err != nil {
+		return nil, err
+	}
+	grid := GridGeometry{Width: r.Width, Height: r.Height}
+	if r.Width <= 0 || r.Height <= 0 || grid.Size() > maxTournamentCells || r.Generations > maxTournamentGens {
+		return nil, errors.New("grid or generations out of range")
+	}
+	owners := map[string]int8{"home": ownerHome, "away": ownerAway}
+	g := newArenaGrid(grid, rule)
+	for _, i := range r.Seed {
+		if i < 0 || i >= grid.Size() {
+			return nil, fmt.Errorf("seed cell %d outside the grid", i)
+		}
+		g.place(g.side(i), []int{i})
+	}
+	moves := r.Moves
+	for gen := int64(0); ; gen++ {
+		for len(moves) > 0 && moves[0].Generation == gen {
+			owner, ok := owners[moves[0].Side]
+			if !ok {
+				return nil, fmt.Errorf("unknown side %q", moves[0].Side)
+			}
+			for _, i := range moves[0].Cells {
+				if i < 0 || i >= grid.Size() || g.side(i) != owner {
+					return nil, fmt.Errorf("move at generation %d places cell %d outside its half", gen, i)
+				}
+			}
+			g.place(owner, moves[0].Cells)
+			moves = moves[1:]
+		}
+		if gen == int64(r.Generations) {
+			break
+		}
+		if err := g.step(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(moves) > 0 {
+		return nil, fmt.Errorf("move at generation %d is out of order", moves[0].Generation)
+	}
+	return g, nil
+}
+
+// handleAttestationKey serves GET /api/attestation-key, the hex Ed25519
+// public key match replays are signed with.
+func handleAttestationKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, hex.EncodeToString(attestationKey.Public().(ed25519.PublicKey)))
+}
+
+// runVerifyReplay implements `grid-controller verify-replay`: it checks a
+// match attestation, as served by GET
+// /api/tournaments/{name}/matches/{id}/replay, without a cluster. It returns
+// the exit code: 0 when the result holds, 1 when it does not and 2 on
+// invalid flags or input.
+func runVerifyReplay(args []string) int {
+	fs := flag.NewFlagSet("verify-replay", flag.ContinueOnError)
+	file := fs.String("file", "", "attestation JSON to verify, - for stdin (required)")
+	keyHex := fs.String("key", "", "hex Ed25519 public key the attestation must be signed with; any key when empty")
+	if err := fs.Parse(args); err != nil {
+		return verifyUsage
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "verify-replay: -file is required")
+		return verifyUsage
+	}
+	var key ed25519.PublicKey
+	if *keyHex != "" {
+		b, err := hex.DecodeString(*keyHex)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			fmt.Fprintln(os.Stderr, "verify-replay: -key must be a hex Ed25519 public key")
+			return verifyUsage
+		}
+		key = b
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify-replay: %v\n", err)
+			return verifyUsage
+		}
+		defer f.Close()
+		in = f
+	}
+	var a MatchAttestation
+	if err := json.NewDecoder(in).Decode(&a); err != nil {
+		fmt.Fprintf(os.Stderr, "verify-replay: invalid attestation: %v\n", err)
+		return verifyUsage
+	}
+
+	if err := verifyAttestation(context.Background(), a, key); err != nil {
+		fmt.Printf("FAIL %s match %d: %v\n", a.Replay.Tournament, a.Replay.Match, err)
+		return verifyMismatch
+	}
+	fmt.Printf("OK %s match %d: %s %d - %d %s, %s\n", a.Replay.Tournament, a.Replay.Match,
+		a.Replay.Home, a.Replay.HomeScore, a.Replay.AwayScore, a.Replay.Away, a.Replay.FinalHash)
+	return verifyOK
+}
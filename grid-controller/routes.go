@@ -0,0 +1,27 @@
+package main
+
+import "net/http"
+
+// routes keeps the read-only public surface apart from control and admin
+// endpoints. With --control-listen set the two are served on different
+// listeners, so the public port cannot reach control endpoints whatever the
+// ingress path rules say; otherwise everything is served together.
+type routes struct {
+	public  *http.ServeMux
+	control *http.ServeMux
+}
+
+func newRoutes() *routes {
+	return &routes{public: http.NewServeMux(), control: http.NewServeMux()}
+}
+
+// Public registers a read-only endpoint, reachable from both listeners.
+func (rt *routes) Public(pattern string, handler http.HandlerFunc) {
+	rt.public.HandleFunc(pattern, handler)
+	rt.control.HandleFunc(pattern, handler)
+}
+
+// Control registers an endpoint that mutates state or exposes internals.
+func (rt *routes) Control(pattern string, handler http.HandlerFunc) {
+	rt.control.HandleFunc(pattern, handler)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"plugin"
+	"strings"
+	"time"
+
+	pb "github.com/nordiwnd/k3s-cellular-automaton/grid-controller/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Neighborhood is a cell's 3x3 Moore neighborhood as a 9-bit mask in
+// row-major order: bit 0 is the north-west neighbor, bit 4 the cell itself
+// and bit 8 the south-east neighbor.
+type Neighborhood uint16
+
+const neighborhoodCenter Neighborhood = 1 << 4
+
+// Alive reports whether the cell itself is alive.
+func (n Neighborhood) Alive() bool {
+	return n&neighborhoodCenter != 0
+}
+
+// Neighbors counts the live neighbors, not including the cell itself.
+func (n Neighborhood) Neighbors() int {
+	return bits.OnesCount16(uint16(n &^ neighborhoodCenter))
+}
+
+// RuleEngine is the transition function of the standalone engine. Custom
+// automata implement it in a Go plugin or behind a gRPC rule server; see
+// openRuleEngine.
+type RuleEngine interface {
+	// Rule names the automaton, e.g. B3/S23. Archives record it, and only
+	// import into an engine running the same rule.
+	Rule() string
+	// Next returns the next state of every cell of a generation given its
+	// neighborhood, in index order.
+	Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error)
+}
+
+var ruleErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_rule_errors_total",
+	Help: "Generations skipped because the rule engine failed to compute them.",
+})
+
+// lifeEngine is Conway's Life, the built-in rule.
+type lifeEngine struct{}
+
+func (lifeEngine) Rule() string {
+	return lifeRule
+}
+
+func (lifeEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	next := make([]bool, len(hoods))
+	partition(len(hoods), 1, func(lo, hi int) {
+		for i, n := range hoods[lo:hi] {
+			count := n.Neighbors()
+			next[lo+i] = count == 3 || (n.Alive() && count == 2)
+		}
+	})
+	return next, nil
+}
+
+// lifeLikeEngine is an outer-totalistic rule in B/S notation, such as
+// HighLife (B36/S23) or Seeds (B2/S).
+type lifeLikeEngine struct {
+	rule            string
+	birth, survival [9]bool
+}
+
+func newLifeLikeEngine(spec string) (*lifeLikeEngine, error) {
+	rule, err := parseRule(spec)
+	if err != nil {
+		return nil, err
+	}
+	e := &lifeLikeEngine{rule: rule}
+	birth, survival, _ := strings.Cut(rule, "/")
+	for _, c := range birth[1:] {
+		e.birth[c-'0'] = true
+	}
+	for _, c := range survival[1:] {
+		e.survival[c-'0'] = true
+	}
+	return e, nil
+}
+
+func (e *lifeLikeEngine) Rule() string {
+	return e.rule
+}
+
+func (e *lifeLikeEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	next := make([]bool, len(hoods))
+	partition(len(hoods), 1, func(lo, hi int) {
+		for i, n := range hoods[lo:hi] {
+			if n.Alive() {
+				next[lo+i] = e.survival[n.Neighbors()]
+			} else {
+				next[lo+i] = e.birth[n.Neighbors()]
+			}
+		}
+	})
+	return next, nil
+}
+
+// pluginEngine runs a rule compiled as a Go plugin
+// (go build -buildmode=plugin). The plugin exports
+//
+//	func Next(neighborhood uint16) bool
+//
+// and optionally a Rule string naming it; its path is used otherwise. Plugins
+// need a cgo build of the controller; the container image is built without.
+type pluginEngine struct {
+	rule string
+	next func(uint16) bool
+}
+
+func openPluginEngine(path string) (*pluginEngine, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Next")
+	if err != nil {
+		return nil, err
+	}
+	next, ok := sym.(func(uint16) bool)
+	if !ok {
+		return nil, fmt.Errorf("%s: Next is %T, want func(uint16) bool", path, sym)
+	}
+	e := &pluginEngine{rule: "plugin:" + path, next: next}
+	if sym, err := p.Lookup("Rule"); err == nil {
+		if rule, ok := sym.(*string); ok && *rule != "" {
+			e.rule = *rule
+		}
+	}
+	return e, nil
+}
+
+func (e *pluginEngine) Rule() string {
+	return e.rule
+}
+
+func (e *pluginEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	next := make([]bool, len(hoods))
+	for i, n := range hoods {
+		next[i] = e.next(uint16(n))
+	}
+	return next, nil
+}
+
+// remoteEngine asks a gRPC rule server (proto/rule.proto) for each
+// generation.
+type remoteEngine struct {
+	client  pb.RuleServiceClient
+	grid    GridGeometry
+	rule    string
+	timeout time.Duration
+}
+
+func dialRuleServer(ctx context.Context, addr string, grid GridGeometry, timeout time.Duration) (*remoteEngine, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	client := pb.NewRuleServiceClient(conn)
+	info, err := client.Describe(ctx, &pb.Empty{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if info.Rule == "" {
+		conn.Close()
+		return nil, fmt.Errorf("%s did not name its rule", addr)
+	}
+	return &remoteEngine{client: client, grid: grid, rule: info.Rule, timeout: timeout}, nil
+}
+
+func (e *remoteEngine) Rule() string {
+	return e.rule
+}
+
+func (e *remoteEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	batch := &pb.NeighborhoodBatch{
+		Generation:    generation,
+		Width:         int32(e.grid.Width),
+		Height:        int32(e.grid.Height),
+		Neighborhoods: make([]uint32, len(hoods)),
+	}
+	for i, n := range hoods {
+		batch.Neighborhoods[i] = uint32(n)
+	}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	resp, err := e.client.Next(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Alive, nil
+}
+
+// openRuleEngine resolves --rule-engine: life for the built-in rule, a
+// Life-like rule such as B36/S23, plugin:/path/to/rule.so for a Go plugin,
+// grpc://host:port for a rule server, or an http(s) URL for a webhook.
+// timeout bounds each call to a rule server or webhook.
+func openRuleEngine(ctx context.Context, spec string, grid GridGeometry, timeout time.Duration) (RuleEngine, error) {
+	switch {
+	case strings.Contains(spec, "/") && !strings.Contains(spec, ":"):
+		return newLifeLikeEngine(spec)
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return newWebhookEngine(spec, grid, timeout)
+	case spec == "" || spec == "life":
+		return lifeEngine{}, nil
+	case strings.HasPrefix(spec, "plugin:"):
+		return openPluginEngine(strings.TrimPrefix(spec, "plugin:"))
+	case strings.HasPrefix(spec, "grpc://"):
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return dialRuleServer(ctx, strings.TrimPrefix(spec, "grpc://"), grid, timeout)
+	}
+	return nil, fmt.Errorf("unknown rule engine %q (want life, a B/S rule, plugin:/path, grpc://host:port or an http(s) URL)", spec)
+}
+
+// sameRule compares rule names, treating Life-like notations of the same
+// rule (B3/S23, 23/3) as equal.
+func sameRule(a, b string) bool {
+	ra, errA := parseRule(a)
+	rb, errB := parseRule(b)
+	if errA == nil && errB == nil {
+		return ra == rb
+	}
+	return a == b
+}
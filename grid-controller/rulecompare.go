@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Rule comparison modes.
+const (
+	compareSplit     = "split"
+	compareAlternate = "alternate"
+)
+
+const causeMirror = "mirror"
+
+// RulesConfig rotates or compares Life-like rules, e.g.
+//
+//	rules:
+//	  rotation: [B3/S23, B36/S23, B3678/S34678]
+//	  every: 24h
+//
+// or
+//
+//	rules:
+//	  compare:
+//	    a: B3/S23
+//	    b: B36/S23
+//	    mirrorSeed: true
+//
+// The rotation picks the rule of the current period, counted from the Unix
+// epoch, so every controller agrees on today's rule. Both need the built-in
+// --rule-engine.
+type RulesConfig struct {
+	Rotation []string        `json:"rotation,omitempty"`
+	Every    metav1.Duration `json:"every,omitempty"`
+	Compare  *RuleComparison `json:"compare,omitempty"`
+}
+
+func (c *RulesConfig) validate() error {
+	if len(c.Rotation) > 0 && c.Compare != nil {
+		return errors.New("rotation and compare are mutually exclusive")
+	}
+	for _, rule := range c.Rotation {
+		if _, err := parseRule(rule); err != nil {
+			return fmt.Errorf("rotation: %w", err)
+		}
+	}
+	if c.Every.Duration == 0 {
+		c.Every.Duration = 24 * time.Hour
+	}
+	if c.Every.Duration < time.Second {
+		return errors.New("every must be at least 1s")
+	}
+	if c.Compare != nil {
+		if err := c.Compare.validate(); err != nil {
+			return fmt.Errorf("compare: %w", err)
+		}
+	}
+	return nil
+}
+
+// RuleComparison runs two Life-like rules side by side: on the left and
+// right halves of the grid (split), or on even and odd generations
+// (alternate). Split halves are walled off from each other by a dead seam so
+// each evolves on its own.
+type RuleComparison struct {
+	A    string `json:"a"`
+	B    string `json:"b"`
+	Mode string `json:"mode,omitempty"`
+	// MirrorSeed copies the left half onto the right when a split comparison
+	// starts, so both rules evolve from identical seeds.
+	MirrorSeed bool `json:"mirrorSeed,omitempty"`
+}
+
+func (c *RuleComparison) validate() error {
+	if c.Mode == "" {
+		c.Mode = compareSplit
+	}
+	if c.Mode != compareSplit && c.Mode != compareAlternate {
+		return fmt.Errorf("unknown mode %q (want %s or %s)", c.Mode, compareSplit, compareAlternate)
+	}
+	if c.MirrorSeed && c.Mode != compareSplit {
+		return errors.New("mirrorSeed needs the split mode")
+	}
+	if _, err := parseRule(c.A); err != nil {
+		return fmt.Errorf("a: %w", err)
+	}
+	if _, err := parseRule(c.B); err != nil {
+		return fmt.Errorf("b: %w", err)
+	}
+	return nil
+}
+
+// ComparisonSide is what one rule of a comparison did. In split mode each
+// side is its half of the grid; in alternate mode Population is the whole
+// grid after the rule's last generation.
+type ComparisonSide struct {
+	Rule        string `json:"rule"`
+	Population  int    `json:"population"`
+	Births      int    `json:"births"`
+	Deaths      int    `json:"deaths"`
+	TotalBirths int64  `json:"totalBirths"`
+	TotalDeaths int64  `json:"totalDeaths"`
+	Generations int64  `json:"generations"`
+}
+
+// ComparisonSample is the population of both sides at one generation.
+type ComparisonSample struct {
+	Generation int64 `json:"generation"`
+	A          int   `json:"a"`
+	B          int   `json:"b"`
+}
+
+// ComparisonStats compares the two rules.
+type ComparisonStats struct {
+	Mode   string             `json:"mode"`
+	A      ComparisonSide     `json:"a"`
+	B      ComparisonSide     `json:"b"`
+	Recent []ComparisonSample `json:"recent"`
+}
+
+// comparisonHistoryLimit is how many samples ComparisonStats.Recent keeps.
+const comparisonHistoryLimit = 500
+
+var comparePopulation = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grid_rule_compare_population",
+	Help: "Population of each side of a rule comparison.",
+}, []string{"side", "rule"})
+
+// compareEngine is the rule engine of a comparison.
+type compareEngine struct {
+	mode   string
+	mirror bool
+	grid   GridGeometry
+	sides  [2]RuleEngine
+
+	mu     sync.Mutex
+	stats  [2]ComparisonSide
+	recent []ComparisonSample
+}
+
+func newCompareEngine(c RuleComparison, grid GridGeometry) (*compareEngine, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	a, err := newLifeLikeEngine(c.A)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newLifeLikeEngine(c.B)
+	if err != nil {
+		return nil, err
+	}
+	e := &compareEngine{mode: c.Mode, mirror: c.MirrorSeed, grid: grid, sides: [2]RuleEngine{a, b}}
+	e.stats[0].Rule, e.stats[1].Rule = a.Rule(), b.Rule()
+	return e, nil
+}
+
+func (e *compareEngine) Rule() string {
+	return "compare:" + e.mode + ":" + e.sides[0].Rule() + "|" + e.sides[1].Rule()
+}
+
+// side is 0 for the left half, 1 for the right half and -1 for the seam, the
+// middle column of an odd-width grid.
+func (e *compareEngine) side(x int) int {
+	half := e.grid.Width / 2
+	switch {
+	case x < half:
+		return 0
+	case x >= e.grid.Width-half:
+		return 1
+	}
+	return -1
+}
+
+func (e *compareEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	if e.mode == compareAlternate {
+		side := int(generation % 2)
+		next, err := e.sides[side].Next(ctx, generation, hoods)
+		if err != nil {
+			return nil, err
+		}
+		var delta [2]ComparisonSide
+		delta[side] = countChanges(hoods, next, func(int) bool { return true })
+		e.record(generation, delta, side)
+		return next, nil
+	}
+
+	// Split: hide the other half from each cell and let each side's rule
+	// compute its own cells.
+	next := make([]bool, len(hoods))
+	var delta [2]ComparisonSide
+	for side := 0; side < 2; side++ {
+		var idx []int
+		var masked []Neighborhood
+		for i, n := range hoods {
+			x, _ := e.grid.Coords(i)
+			if e.side(x) != side {
+				continue
+			}
+			for dx := -1; dx <= 1; dx++ {
+				if e.side(x+dx) != side {
+					n &^= (1 << (dx + 1)) | (1 << (dx + 4)) | (1 << (dx + 7))
+				}
+			}
+			idx = append(idx, i)
+			masked = append(masked, n)
+		}
+		states, err := e.sides[side].Next(ctx, generation, masked)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range idx {
+			next[i] = states[j]
+		}
+		delta[side] = countChanges(hoods, next, func(i int) bool {
+			x, _ := e.grid.Coords(i)
+			return e.side(x) == side
+		})
+	}
+	e.record(generation, delta, -1)
+	return next, nil
+}
+
+// countChanges counts the population, births and deaths among the cells in.
+func countChanges(hoods []Neighborhood, next []bool, in func(int) bool) ComparisonSide {
+	var c ComparisonSide
+	for i, n := range hoods {
+		if !in(i) {
+			continue
+		}
+		if next[i] {
+			c.Population++
+		}
+		switch {
+		case next[i] && !n.Alive():
+			c.Births++
+		case !next[i] && n.Alive():
+			c.Deaths++
+		}
+	}
+	return c
+}
+
+// record adds a generation's changes to the statistics; only is the side
+// that computed it in alternate mode, -1 for both.
+func (e *compareEngine) record(generation int64, delta [2]ComparisonSide, only int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for side := range e.stats {
+		if only >= 0 && side != only {
+			continue
+		}
+		s := &e.stats[side]
+		s.Population, s.Births, s.Deaths = delta[side].Population, delta[side].Births, delta[side].Deaths
+		s.TotalBirths += int64(delta[side].Births)
+		s.TotalDeaths += int64(delta[side].Deaths)
+		s.Generations++
+		comparePopulation.WithLabelValues([]string{"a", "b"}[side], s.Rule).Set(float64(s.Population))
+	}
+	e.recent = append(e.recent, ComparisonSample{Generation: generation + 1, A: e.stats[0].Population, B: e.stats[1].Population})
+	if len(e.recent) > comparisonHistoryLimit {
+		e.recent = append([]ComparisonSample(nil), e.recent[len(e.recent)-comparisonHistoryLimit:]...)
+	}
+}
+
+func (e *compareEngine) Stats() *ComparisonStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &ComparisonStats{
+		Mode:   e.mode,
+		A:      e.stats[0],
+		B:      e.stats[1],
+		Recent: append([]ComparisonSample{}, e.recent...),
+	}
+}
+
+// mirrored copies the live cells of the left half onto the right half,
+// clearing the right half and the seam.
+func (e *compareEngine) mirrored(alive []int) []int {
+	half := e.grid.Width / 2
+	shift := e.grid.Width - half
+	var out []int
+	for _, i := range alive {
+		x, y := e.grid.Coords(i)
+		if e.side(x) == 0 {
+			out = append(out, i, e.grid.Index(x+shift, y))
+		}
+	}
+	return out
+}
+
+// mirrorComparison gives both halves of a split comparison identical seeds,
+// if the running comparison asks for it.
+func (s *simulation) mirrorComparison() {
+	c, ok := s.engine.RuleEngine().(*compareEngine)
+	if !ok || !c.mirror {
+		return
+	}
+	gen, alive := s.engine.Snapshot()
+	births, deaths := s.engine.Restore(gen, c.mirrored(alive))
+	for _, i := range births {
+		s.digests.Birth(i, causeMirror)
+	}
+	for _, i := range deaths {
+		s.digests.Death(i, causeMirror)
+	}
+	if s.federation != nil {
+		s.federation.Publish(births, deaths)
+	}
+	s.cells.Resync()
+	log.Printf("Engine: mirrored the left half onto the right for %s", c.Rule())
+}
+
+// ruleRotation picks the rule of the day.
+type ruleRotation struct {
+	rules []RuleEngine
+	every time.Duration
+}
+
+func newRuleRotation(specs []string, every time.Duration) (*ruleRotation, error) {
+	r := &ruleRotation{every: every}
+	for _, spec := range specs {
+		rule, err := newLifeLikeEngine(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+func (r *ruleRotation) current(now time.Time) RuleEngine {
+	period := now.Unix() / int64(r.every/time.Second)
+	return r.rules[int(period%int64(len(r.rules)))]
+}
+
+// rotate switches to the rule of the current period. A rule an operator put
+// in place, such as an upload or a comparison, is left alone; the rotation
+// resumes once it is removed.
+func (s *simulation) rotate() {
+	if s.rotation == nil {
+		return
+	}
+	want := s.rotation.current(time.Now())
+	s.mu.Lock()
+	base := s.baseRule
+	s.baseRule = want
+	s.mu.Unlock()
+	if want != base && s.engine.ReplaceRule(base, want) {
+		log.Printf("Engine: rule of the day is %s", want.Rule())
+		s.digests.Annotate("rule", want.Rule())
+	}
+}
+
+// BaseRule is the rule restored when an operator's rule is removed.
+func (s *simulation) BaseRule() RuleEngine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseRule
+}
+
+// handleRuleComparison serves POST /api/rules/compare, which starts
+// comparing the two rules of the RuleComparison in the body, and DELETE
+// /api/rules/compare, which stops. Both need the operator role; GET
+// /api/rules reports the comparison's statistics.
+func handleRuleComparison(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireRole(w, r, roleOperator) {
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Rule comparison requires --engine=standalone", http.StatusConflict)
+		return
+	}
+	if s.federation != nil {
+		http.Error(w, "Rule comparison is not supported in a federated grid", http.StatusConflict)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if _, ok := s.engine.RuleEngine().(*compareEngine); !ok {
+			http.Error(w, "No comparison running", http.StatusNotFound)
+			return
+		}
+		s.SetRule(s.BaseRule())
+		log.Printf("Engine: %s stopped the rule comparison", requestIdentity(r))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var c RuleComparison
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid comparison: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	compare, err := newCompareEngine(c, s.engine.grid)
+	if err != nil {
+		http.Error(w, "Invalid comparison: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.SetRule(compare)
+	s.mirrorComparison()
+	log.Printf("Engine: %s started comparing %s", requestIdentity(r), compare.Rule())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(compare.Stats())
+}
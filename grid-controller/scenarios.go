@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scenario is a scripted lesson: steps with an instruction each, advancing
+// once the grid shows what the step expects. Lessons come built in and from
+// the configuration file, e.g.
+//
+//	scenarios:
+//	  - name: still-life
+//	    title: Blocks never change
+//	    steps:
+//	      - instruction: Stamp a block of four cells with POST /api/cells/{index}.
+//	        expect: {pattern: block, converged: true}
+//	      - instruction: Watch it stay put for five generations.
+//	        expect: {pattern: block, generations: 5}
+type Scenario struct {
+	Name  string         `json:"name"`
+	Title string         `json:"title"`
+	Steps []ScenarioStep `json:"steps"`
+}
+
+type ScenarioStep struct {
+	Instruction string        `json:"instruction"`
+	Expect      ScenarioCheck `json:"expect"`
+}
+
+// ScenarioCheck is what a step waits for; every condition set must hold at
+// once.
+type ScenarioCheck struct {
+	// Pattern is a built-in pattern, or block, that must stand on its own
+	// somewhere on the grid, in any rotation or reflection.
+	Pattern       string `json:"pattern,omitempty"`
+	MinPopulation *int   `json:"minPopulation,omitempty"`
+	MaxPopulation *int   `json:"maxPopulation,omitempty"`
+	// Events are causes of births and deaths (spawn, kill, pattern,
+	// transform or chaos), any one of which must have happened since the
+	// step began.
+	Events []string `json:"events,omitempty"`
+	// Generations must have passed since the step began.
+	Generations int `json:"generations,omitempty"`
+	// Converged waits for the cell pods to match the grid: a running pod
+	// for every live cell and none for the others.
+	Converged bool `json:"converged,omitempty"`
+}
+
+// scenarioEvents are the causes a step may wait for.
+var scenarioEvents = []string{causeSpawn, causeKill, causePattern, causeTransform, causeChaos}
+
+// scenarioPatterns are the patterns steps may look for.
+var scenarioPatterns = func() map[string]Pattern {
+	patterns := map[string]Pattern{"block": newPattern("block", "OO", "OO")}
+	for name, p := range builtinPatterns {
+		patterns[name] = p
+	}
+	return patterns
+}()
+
+func (c *ScenarioCheck) validate() error {
+	if c.Pattern != "" {
+		if _, ok := scenarioPatterns[c.Pattern]; !ok {
+			return fmt.Errorf("unknown pattern %q", c.Pattern)
+		}
+	}
+	for _, e := range c.Events {
+		found := false
+		for _, known := range scenarioEvents {
+			found = found || e == known
+		}
+		if !found {
+			return fmt.Errorf("unknown event %q (want %s)", e, strings.Join(scenarioEvents, ", "))
+		}
+	}
+	if c.Generations < 0 {
+		return errors.New("generations must not be negative")
+	}
+	if c.Pattern == "" && c.MinPopulation == nil && c.MaxPopulation == nil && len(c.Events) == 0 && c.Generations == 0 && !c.Converged {
+		return errors.New("expects nothing")
+	}
+	return nil
+}
+
+func (s *Scenario) validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(s.Steps) == 0 {
+		return errors.New("at least one step is required")
+	}
+	for i := range s.Steps {
+		if s.Steps[i].Instruction == "" {
+			return fmt.Errorf("step %d: instruction is required", i+1)
+		}
+		if err := s.Steps[i].Expect.validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+// builtinScenarios introduce the grid as a Kubernetes teaching tool.
+var builtinScenarios = []Scenario{
+	{
+		Name:  "pods-are-cells",
+		Title: "Every live cell is a pod",
+		Steps: []ScenarioStep{
+			{
+				Instruction: "Stamp a blinker with POST /api/patterns/blinker. Its three live cells become three pods: watch them with kubectl get pods -l app=cell -w.",
+				Expect:      ScenarioCheck{Pattern: "blinker", Converged: true},
+			},
+			{
+				Instruction: "Watch it oscillate for four generations. Each generation the controller deletes two pods and creates two others.",
+				Expect:      ScenarioCheck{Pattern: "blinker", Generations: 4},
+			},
+			{
+				Instruction: "Kill one of the blinker's cells with DELETE /api/cells/{index}, or delete its pod with kubectl delete pod.",
+				Expect:      ScenarioCheck{Events: []string{causeKill, causeChaos}},
+			},
+			{
+				Instruction: "Observe recovery: the rule plays on with what is left, and the controller reconciles until the pods match the grid again.",
+				Expect:      ScenarioCheck{Generations: 2, Converged: true},
+			},
+		},
+	},
+	{
+		Name:  "gliders",
+		Title: "Moving structures across nodes",
+		Steps: []ScenarioStep{
+			{
+				Instruction: "Stamp a glider with POST /api/patterns/glider?x=2&y=2.",
+				Expect:      ScenarioCheck{Pattern: "glider"},
+			},
+			{
+				Instruction: "Let it fly for eight generations: its pods are created ahead of it and deleted behind it, walking diagonally across the grid.",
+				Expect:      ScenarioCheck{Pattern: "glider", Generations: 8},
+			},
+			{
+				Instruction: "Aim it elsewhere: rotate its region with POST /api/region/transform, e.g. {\"region\": [x0, y0, x1, y1], \"rotate\": 90}.",
+				Expect:      ScenarioCheck{Pattern: "glider", Events: []string{causeTransform}},
+			},
+			{
+				Instruction: "Clear the grid by killing the glider's cells or erasing them with POST /api/patterns/glider?op=difference, until no cell pod is left.",
+				Expect:      ScenarioCheck{MaxPopulation: intPtr(0), Converged: true},
+			},
+		},
+	},
+}
+
+// ScenarioStepResult is a step completed in the running lesson.
+type ScenarioStepResult struct {
+	Step        int       `json:"step"`
+	Instruction string    `json:"instruction"`
+	Completed   time.Time `json:"completed"`
+	Seconds     float64   `json:"seconds"`
+}
+
+// ScenarioProgress is the running lesson, as served by
+// GET /api/scenarios/active and streamed as scenario messages.
+type ScenarioProgress struct {
+	Scenario string    `json:"scenario"`
+	Title    string    `json:"title"`
+	Started  time.Time `json:"started"`
+	// Step is the current step, from 1; it is past the last step once the
+	// lesson is finished.
+	Step        int    `json:"step"`
+	Steps       int    `json:"steps"`
+	Instruction string `json:"instruction,omitempty"`
+	// Waiting lists what the current step still waits for.
+	Waiting   []string             `json:"waiting,omitempty"`
+	Completed []ScenarioStepResult `json:"completed"`
+	Finished  bool                 `json:"finished"`
+	Stopped   bool                 `json:"stopped,omitempty"`
+}
+
+// scenarioCheckInterval is how often the running step's expectations are
+// checked.
+const scenarioCheckInterval = time.Second
+
+// scenarioRunner steps through one lesson at a time; the grid is shared, so
+// everyone follows the same one.
+type scenarioRunner struct {
+	sim       *simulation
+	scenarios map[string]Scenario
+
+	mu       sync.Mutex
+	progress *ScenarioProgress
+	scenario Scenario
+	// stepStarted, stepGeneration and events describe the current step.
+	stepStarted    time.Time
+	stepGeneration int64
+	events         map[string]bool
+}
+
+func newScenarioRunner(sim *simulation, extra []Scenario) *scenarioRunner {
+	r := &scenarioRunner{sim: sim, scenarios: map[string]Scenario{}}
+	for _, s := range builtinScenarios {
+		r.scenarios[s.Name] = s
+	}
+	for _, s := range extra {
+		r.scenarios[s.Name] = s
+	}
+	return r
+}
+
+// List returns the lessons by name.
+func (r *scenarioRunner) List() []Scenario {
+	list := make([]Scenario, 0, len(r.scenarios))
+	for _, s := range r.scenarios {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Start begins a lesson, replacing any running one.
+func (r *scenarioRunner) Start(name string) (ScenarioProgress, bool) {
+	s, ok := r.scenarios[name]
+	if !ok {
+		return ScenarioProgress{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scenario = s
+	r.progress = &ScenarioProgress{Scenario: s.Name, Title: s.Title, Started: time.Now(), Steps: len(s.Steps), Completed: []ScenarioStepResult{}}
+	r.beginStep(0)
+	return r.publish(), true
+}
+
+// Stop ends the running lesson; it reports false when none was running.
+func (r *scenarioRunner) Stop() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		return false
+	}
+	r.progress.Stopped = true
+	r.publish()
+	r.progress = nil
+	return true
+}
+
+// Progress returns the running lesson, or nil.
+func (r *scenarioRunner) Progress() *ScenarioProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		return nil
+	}
+	p := *r.progress
+	return &p
+}
+
+// Observe records a birth or death cause for the running step.
+func (r *scenarioRunner) Observe(cause string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.progress != nil {
+		r.events[cause] = true
+	}
+	r.mu.Unlock()
+}
+
+// beginStep makes step i, from 0, the current one. r.mu must be held.
+func (r *scenarioRunner) beginStep(i int) {
+	r.progress.Step = i + 1
+	r.progress.Instruction, r.progress.Waiting = "", nil
+	if i < len(r.scenario.Steps) {
+		r.progress.Instruction = r.scenario.Steps[i].Instruction
+	}
+	r.stepStarted, r.stepGeneration, r.events = time.Now(), r.sim.engine.Generation(), map[string]bool{}
+}
+
+// publish streams the progress to every client. r.mu must be held.
+func (r *scenarioRunner) publish() ScenarioProgress {
+	p := *r.progress
+	p.Completed = append([]ScenarioStepResult(nil), p.Completed...)
+	go publish(msgScenario, p)
+	return p
+}
+
+// Run checks the running step every scenarioCheckInterval until ctx is done.
+func (r *scenarioRunner) Run(ctx context.Context) {
+	ticker := time.NewTicker(scenarioCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.check()
+	}
+}
+
+func (r *scenarioRunner) check() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil || r.progress.Finished {
+		return
+	}
+	i := r.progress.Step - 1
+	step := r.scenario.Steps[i]
+	waiting := r.waiting(step.Expect)
+	if len(waiting) > 0 {
+		r.progress.Waiting = waiting
+		return
+	}
+	now := time.Now()
+	r.progress.Completed = append(r.progress.Completed, ScenarioStepResult{
+		Step:        i + 1,
+		Instruction: step.Instruction,
+		Completed:   now,
+		Seconds:     now.Sub(r.stepStarted).Seconds(),
+	})
+	r.beginStep(i + 1)
+	if i+1 == len(r.scenario.Steps) {
+		r.progress.Finished = true
+		log.Printf("Scenarios: %s finished in %s", r.scenario.Name, now.Sub(r.progress.Started).Round(time.Second))
+	} else {
+		log.Printf("Scenarios: %s step %d of %d done", r.scenario.Name, i+1, len(r.scenario.Steps))
+	}
+	r.publish()
+}
+
+// waiting describes the conditions of a check that do not hold yet.
+// r.mu must be held.
+func (r *scenarioRunner) waiting(c ScenarioCheck) []string {
+	var waiting []string
+	population := r.sim.engine.Population()
+	if c.Pattern != "" && !containsPattern(r.sim.engine.View(), scenarioPatterns[c.Pattern]) {
+		waiting = append(waiting, "a "+c.Pattern+" on the grid")
+	}
+	if c.MinPopulation != nil && population < *c.MinPopulation {
+		waiting = append(waiting, fmt.Sprintf("a population of at least %d", *c.MinPopulation))
+	}
+	if c.MaxPopulation != nil && population > *c.MaxPopulation {
+		waiting = append(waiting, fmt.Sprintf("a population of at most %d", *c.MaxPopulation))
+	}
+	if len(c.Events) > 0 {
+		seen := false
+		for _, e := range c.Events {
+			seen = seen || r.events[e]
+		}
+		if !seen {
+			waiting = append(waiting, "an edit or event: "+strings.Join(c.Events, " or "))
+		}
+	}
+	if passed := r.sim.engine.Generation() - r.stepGeneration; passed < int64(c.Generations) {
+		waiting = append(waiting, fmt.Sprintf("%d more generations", int64(c.Generations)-passed))
+	}
+	if c.Converged && !r.sim.cells.Converged() {
+		waiting = append(waiting, "the cell pods to match the grid")
+	}
+	return waiting
+}
+
+// containsPattern reports whether the pattern, in any rotation or
+// reflection, stands on its own somewhere on the grid: its cells are alive
+// and every other cell of its box and the ring around it is dead, so a
+// blinker is not found in a glider's bottom row.
+func containsPattern(view *gridSnapshot, p Pattern) bool {
+	if len(p.Cells) == 0 {
+		return true
+	}
+	grid := view.grid
+	for _, flip := range []string{"", "h"} {
+		for _, rotate := range []int{0, 90, 180, 270} {
+			o := Transform{Rotate: rotate, Flip: flip}.Pattern(p)
+			cells := make(map[[2]int]bool, len(o.Cells))
+			for _, c := range o.Cells {
+				cells[c] = true
+			}
+			anchor := o.Cells[0]
+			for _, i := range view.cells {
+				x, y := grid.Coords(i)
+				if isolatedAt(view, o, cells, x-anchor[0], y-anchor[1]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isolatedAt reports whether the pattern stands on its own with its top-left
+// corner at (x0, y0).
+func isolatedAt(view *gridSnapshot, p Pattern, cells map[[2]int]bool, x0, y0 int) bool {
+	grid := view.grid
+	for dy := -1; dy <= p.Height; dy++ {
+		for dx := -1; dx <= p.Width; dx++ {
+			x, y := x0+dx, y0+dy
+			alive := grid.Contains(x, y) && view.alive.Has(grid.Index(x, y))
+			if alive != cells[[2]int{dx, dy}] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// handleScenarios serves GET /api/scenarios, the lessons, and
+// GET /api/scenarios/active, the running one's progress (404 when none runs).
+// Operators start a lesson with POST /api/scenarios/{name}/start, replacing
+// any running one, and stop it with POST /api/scenarios/stop.
+func handleScenarios(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Idempotency-Key")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Scenarios require --engine=standalone", http.StatusConflict)
+		return
+	}
+	runner := s.scenarios
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/scenarios"), "/")
+	var resp any
+	switch {
+	case rest == "" && r.Method == "GET":
+		resp = runner.List()
+	case rest == "active" && r.Method == "GET":
+		p := runner.Progress()
+		if p == nil {
+			http.Error(w, "No scenario is running", http.StatusNotFound)
+			return
+		}
+		resp = p
+	case rest == "stop" && r.Method == "POST":
+		if !requireRole(w, r, roleOperator) {
+			return
+		}
+		if !runner.Stop() {
+			http.Error(w, "No scenario is running", http.StatusNotFound)
+			return
+		}
+		log.Printf("Scenarios: %s stopped the lesson", requestIdentity(r))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case strings.HasSuffix(rest, "/start") && r.Method == "POST":
+		if !requireRole(w, r, roleOperator) {
+			return
+		}
+		name := strings.TrimSuffix(rest, "/start")
+		p, ok := runner.Start(name)
+		if !ok {
+			http.Error(w, "Unknown scenario", http.StatusNotFound)
+			return
+		}
+		log.Printf("Scenarios: %s started %s", requestIdentity(r), name)
+		resp = p
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// selfTestLabel marks the temporary pods of self-tests with the test's ID.
+// They are not labelled app=cell, so neither the grid, the cell manager nor
+// chaos ever sees them.
+const selfTestLabel = "cellular-automaton/selftest"
+
+const (
+	defaultSelfTestTimeout = 10 * time.Second
+	maxSelfTestTimeout     = time.Minute
+)
+
+// Self-test stages, in order.
+const (
+	stageCreate          = "create"
+	stageInformerAdd     = "informer_add"
+	stageBroadcastAdd    = "broadcast_add"
+	stageDelete          = "delete"
+	stageInformerDelete  = "informer_delete"
+	stageBroadcastDelete = "broadcast_delete"
+)
+
+// SelfTestEvent is streamed, only to the self-test's own loopback client,
+// when the informer reports the test pod.
+type SelfTestEvent struct {
+	ID    string `json:"id"`
+	Pod   string `json:"pod"`
+	Event string `json:"event"`
+}
+
+// SelfTestStage is how long one step of the pipeline took, measured from the
+// end of the previous one.
+type SelfTestStage struct {
+	Name  string  `json:"name"`
+	OK    bool    `json:"ok"`
+	Ms    float64 `json:"ms"`
+	Error string  `json:"error,omitempty"`
+}
+
+// SelfTestReport is the outcome of POST /api/selftest. Stages after the
+// first failure are missing.
+type SelfTestReport struct {
+	ID      string          `json:"id"`
+	Pod     string          `json:"pod"`
+	OK      bool            `json:"ok"`
+	TotalMs float64         `json:"totalMs"`
+	Stages  []SelfTestStage `json:"stages"`
+}
+
+// selfTester runs one self-test at a time: it creates a temporary pod,
+// waits for the informer to report it and for the hub to deliver that to a
+// loopback client, then does the same for its deletion, timing each stage.
+// It is the path every cell update takes, so a slow or stuck stage shows
+// where a grid that looks frozen is stuck.
+type selfTester struct {
+	clientset kubernetes.Interface
+	namespace string
+	image     string
+
+	running sync.Mutex
+
+	mu sync.Mutex
+	// seen receives the informer's events for the running test's pod.
+	seen chan string
+	id   string
+}
+
+func newSelfTester(clientset kubernetes.Interface, namespace, image string) *selfTester {
+	return &selfTester{clientset: clientset, namespace: namespace, image: image}
+}
+
+// Observe is called with every pod the informer adds ("added") or deletes
+// ("deleted"). It reports the running test's pod to the test and through the
+// hub.
+func (t *selfTester) Observe(obj interface{}, event string) {
+	pod, ok := podFromTombstone(obj)
+	if !ok || pod.Labels[selfTestLabel] == "" {
+		return
+	}
+	id := pod.Labels[selfTestLabel]
+	t.mu.Lock()
+	if id == t.id {
+		select {
+		case t.seen <- event:
+		default:
+		}
+	}
+	t.mu.Unlock()
+	go publishScoped(selfTestScope(id), msgSelfTest, SelfTestEvent{ID: id, Pod: pod.Name, Event: event})
+}
+
+// selfTestScope keeps a test's events to its own loopback client.
+func selfTestScope(id string) string {
+	return "selftest:" + id
+}
+
+// Run performs a self-test, giving each stage up to timeout.
+func (t *selfTester) Run(ctx context.Context, timeout time.Duration) *SelfTestReport {
+	var b [4]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	report := &SelfTestReport{ID: id, Pod: "selftest-" + id}
+	seen := make(chan string, 4)
+	t.mu.Lock()
+	t.id, t.seen = id, seen
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.id, t.seen = "", nil
+		t.mu.Unlock()
+	}()
+
+	// The loopback client subscribes like any viewer, to the test's scope.
+	c := newClient(nil, protocolV2, selfTestScope(id))
+	register(c, 0, false)
+	defer c.evict(closeGone)
+
+	begin := time.Now()
+	last := begin
+	stage := func(name string, err error) bool {
+		now := time.Now()
+		s := SelfTestStage{Name: name, OK: err == nil, Ms: float64(now.Sub(last).Microseconds()) / 1000}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		report.Stages = append(report.Stages, s)
+		last = now
+		return err == nil
+	}
+	finish := func() *SelfTestReport {
+		report.TotalMs = float64(time.Since(begin).Microseconds()) / 1000
+		report.OK = len(report.Stages) == 6 && report.Stages[5].OK
+		return report
+	}
+	// waitInformer waits for the informer to report event for the pod.
+	waitInformer := func(event string) error {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case e := <-seen:
+				if e == event {
+					return nil
+				}
+			case <-timer.C:
+				return fmt.Errorf("the pod informer reported no %s event within %s; its watch may be stuck", event, timeout)
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	// The loopback client is drained throughout, so the other messages it
+	// receives never fill its queue.
+	delivered := make(chan string, 4)
+	go func() {
+		for {
+			select {
+			case out := <-c.send:
+				var env Envelope
+				var e SelfTestEvent
+				if json.Unmarshal(out.data, &env) == nil && env.Type == msgSelfTest && json.Unmarshal(env.Data, &e) == nil {
+					select {
+					case delivered <- e.Event:
+					default:
+					}
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	// waitBroadcast waits for the loopback client to receive event.
+	waitBroadcast := func(event string) error {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case e := <-delivered:
+				if e == event {
+					return nil
+				}
+			case <-c.done:
+				return errors.New("the hub dropped the loopback client")
+			case <-timer.C:
+				return fmt.Errorf("the hub delivered no %s event within %s; the broadcaster may be stuck", event, timeout)
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	pods := t.clientset.CoreV1().Pods(t.namespace)
+	createCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := podWrites.Do(createCtx, verbCreate, func(ctx context.Context) error {
+		_, err := pods.Create(ctx, t.podFor(report.Pod, id), metav1.CreateOptions{})
+		return err
+	})
+	cancel()
+	if !stage(stageCreate, err) {
+		return finish()
+	}
+	// Whatever happens from here, the pod goes.
+	deleted := false
+	defer func() {
+		if !deleted {
+			err := pods.Delete(context.Background(), report.Pod, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Printf("Self-test: delete %s: %v", report.Pod, err)
+			}
+		}
+	}()
+
+	if !stage(stageInformerAdd, waitInformer("added")) || !stage(stageBroadcastAdd, waitBroadcast("added")) {
+		return finish()
+	}
+
+	deleteCtx, cancel := context.WithTimeout(ctx, timeout)
+	grace := int64(0)
+	err = podWrites.Do(deleteCtx, verbDelete, func(ctx context.Context) error {
+		return pods.Delete(ctx, report.Pod, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+	})
+	cancel()
+	deleted = err == nil
+	if !stage(stageDelete, err) {
+		return finish()
+	}
+	if stage(stageInformerDelete, waitInformer("deleted")) {
+		stage(stageBroadcastDelete, waitBroadcast("deleted"))
+	}
+	return finish()
+}
+
+// podFor is the test pod. It only needs to exist, so it is never restarted
+// and is deleted without a grace period.
+func (t *selfTester) podFor(name, id string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{selfTestLabel: id},
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers: []v1.Container{{
+				Name:  "selftest",
+				Image: t.image,
+			}},
+		},
+	}
+}
+
+// handleSelfTest serves POST /api/selftest[?timeout=10s], which runs a
+// self-test and reports each stage's latency. It answers 200 with the report
+// whether the test passed or not, and 409 while another test is running.
+func handleSelfTest(w http.ResponseWriter, r *http.Request, t *selfTester) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireRole(w, r, roleOperator) {
+		return
+	}
+
+	timeout := defaultSelfTestTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxSelfTestTimeout {
+			http.Error(w, fmt.Sprintf("Invalid timeout: want a duration up to %s", maxSelfTestTimeout), http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+
+	if !t.running.TryLock() {
+		http.Error(w, "A self-test is already running", http.StatusConflict)
+		return
+	}
+	defer t.running.Unlock()
+
+	// Stages have their own timeouts; the request deadline would cut a slow
+	// test short.
+	report := t.Run(context.WithoutCancel(r.Context()), timeout)
+	var failed []string
+	for _, s := range report.Stages {
+		if !s.OK {
+			failed = append(failed, s.Name+": "+s.Error)
+		}
+	}
+	if report.OK {
+		log.Printf("Self-test: %s passed in %.0fms", requestIdentity(r), report.TotalMs)
+	} else {
+		log.Printf("Self-test: %s failed: %s", requestIdentity(r), strings.Join(failed, "; "))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
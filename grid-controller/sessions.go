@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// A workshop session is a grid with an expiry and a join code. Participants
+// use the code to find and watch the grid; when the session expires its state
+// is saved to a ConfigMap in the controller's namespace and the grid is
+// deleted.
+const (
+	sessionExpiresAnnotation = "cellular-automaton/expires"
+	// sessionCodeLabel holds a prefix of the join code's SHA-256, so the
+	// grid can be looked up by code without storing the code itself.
+	sessionCodeLabel = "cellular-automaton/join-code"
+
+	maxSessionTTL = 7 * 24 * time.Hour
+)
+
+// SessionRequest is the body of POST /api/sessions.
+type SessionRequest struct {
+	GridSpec
+	TTL metav1.Duration `json:"ttl"`
+}
+
+// Session is returned once, on creation; the join code cannot be recovered.
+type Session struct {
+	Grid     *GridInfo `json:"grid"`
+	JoinCode string    `json:"joinCode"`
+}
+
+// SessionSnapshot is what remains of a session after it expired.
+type SessionSnapshot struct {
+	Grid  GridInfo  `json:"grid"`
+	Taken time.Time `json:"taken"`
+	Alive []int     `json:"alive"`
+}
+
+func newJoinCode() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return base32.StdEncoding.EncodeToString(b)
+}
+
+func joinCodeDigest(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:16])
+}
+
+func sessionExpiry(ns *v1.Namespace) *time.Time {
+	t, err := time.Parse(time.RFC3339, ns.Annotations[sessionExpiresAnnotation])
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// CreateSession provisions a grid that expires after ttl.
+func (b *gridBootstrapper) CreateSession(ctx context.Context, spec GridSpec, ttl time.Duration, tenant string) (*Session, error) {
+	code := newJoinCode()
+	expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	info, err := b.Create(ctx, spec, tenant, func(ns *v1.Namespace) {
+		ns.Labels[sessionCodeLabel] = joinCodeDigest(code)
+		ns.Annotations[sessionExpiresAnnotation] = expires.Format(time.RFC3339)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Sessions: %s expires at %s", spec.Name, expires.Format(time.RFC3339))
+	return &Session{Grid: info, JoinCode: code}, nil
+}
+
+// Join returns the live session grid for a join code.
+func (b *gridBootstrapper) Join(ctx context.Context, code string) (*GridInfo, error) {
+	list, err := b.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{
+			gridManagedByLabel: "grid-controller",
+			sessionCodeLabel:   joinCodeDigest(code),
+		}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		info := namespaceGridInfo(&list.Items[i])
+		if info.Expires != nil && time.Now().Before(*info.Expires) {
+			return info, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(v1.Resource("namespaces"), "session")
+}
+
+// ExpireSessions snapshots and deletes every session grid past its expiry.
+// Expired previews are deleted without a snapshot.
+func (b *gridBootstrapper) ExpireSessions(ctx context.Context) {
+	grids, err := b.List(ctx, "")
+	if err != nil {
+		log.Printf("Sessions: list: %v", err)
+		return
+	}
+	for _, g := range grids {
+		if g.Expires == nil || time.Now().Before(*g.Expires) || g.Phase == string(v1.NamespaceTerminating) {
+			continue
+		}
+		if g.Preview == "" {
+			if err := b.snapshot(ctx, g); err != nil {
+				log.Printf("Sessions: snapshot of %s: %v; deleting anyway", g.Name, err)
+			}
+		}
+		if err := b.Delete(ctx, g.Name, ""); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Sessions: delete %s: %v", g.Name, err)
+			continue
+		}
+		log.Printf("Sessions: %s expired", g.Name)
+	}
+}
+
+// snapshot saves the grid's live cells to the ConfigMap session-<name>.
+func (b *gridBootstrapper) snapshot(ctx context.Context, g GridInfo) error {
+	pods, err := b.clientset.CoreV1().Pods(g.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=cell,game-status=alive",
+	})
+	if err != nil {
+		return err
+	}
+	snap := SessionSnapshot{Grid: g, Taken: time.Now().UTC(), Alive: []int{}}
+	for _, pod := range pods.Items {
+		if i, ok := cellIndex(pod.Name); ok {
+			snap.Alive = append(snap.Alive, i)
+		}
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "session-" + g.Name,
+			Namespace: b.controllerNamespace,
+			Labels:    map[string]string{gridNameLabel: g.Name},
+		},
+		Data: map[string]string{"snapshot.json": string(data)},
+	}
+	_, err = b.clientset.CoreV1().ConfigMaps(b.controllerNamespace).Create(ctx, cm, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = b.clientset.CoreV1().ConfigMaps(b.controllerNamespace).Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// RunSessionReaper expires sessions every interval until ctx is done.
+func (b *gridBootstrapper) RunSessionReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reapCtx, cancel := context.WithTimeout(ctx, interval)
+			b.ExpireSessions(reapCtx)
+			cancel()
+		}
+	}
+}
+
+// handleSessions serves POST /api/sessions for administrators and tenants,
+// and GET /api/sessions/join?code= for participants.
+func handleSessions(w http.ResponseWriter, r *http.Request, b *gridBootstrapper) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/sessions/join":
+		info, err := b.Join(ctx, r.URL.Query().Get("code"))
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Unknown or expired join code", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to look up session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "POST" && r.URL.Path == "/api/sessions":
+		tenant, ok := requireTenant(w, r)
+		if !ok {
+			return
+		}
+		var req SessionRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid session: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, "Invalid session: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.TTL.Duration <= 0 || req.TTL.Duration > maxSessionTTL {
+			http.Error(w, "Invalid session: ttl must be positive and at most "+maxSessionTTL.String(), http.StatusBadRequest)
+			return
+		}
+		if !b.admit(w, ctx, tenant, req.GridSpec) || !quotas.Allow(w, r, actionSessions) {
+			return
+		}
+		var owner string
+		if tenant != nil {
+			owner = tenant.Name
+		}
+		session, err := b.CreateSession(ctx, req.GridSpec, req.TTL.Duration, owner)
+		if apierrors.IsAlreadyExists(err) {
+			http.Error(w, "Grid already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(session)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// shareSecret signs share links. It is read from the SHARE_SECRET
+// environment variable; without it a random secret is drawn at startup and
+// links stop working when the controller restarts.
+var shareSecret = loadShareSecret()
+
+func loadShareSecret() []byte {
+	if s := os.Getenv("SHARE_SECRET"); s != "" {
+		return []byte(s)
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// shareClaims is the signed payload of a share token.
+type shareClaims struct {
+	Grid    string `json:"g"`
+	Expires int64  `json:"e"`
+}
+
+// signShareToken returns a token granting a read-only view of grid until
+// expires.
+func signShareToken(grid string, expires time.Time) string {
+	payload, _ := json.Marshal(shareClaims{Grid: grid, Expires: expires.Unix()})
+	mac := hmac.New(sha256.New, shareSecret)
+	mac.Write(payload)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+var errInvalidShare = errors.New("invalid or expired share link")
+
+// verifyShareToken returns the grid a token grants a view of.
+func verifyShareToken(token string) (string, error) {
+	enc := base64.RawURLEncoding
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidShare
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return "", errInvalidShare
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil {
+		return "", errInvalidShare
+	}
+	mac := hmac.New(sha256.New, shareSecret)
+	mac.Write(payload)
+	if subtle.ConstantTimeCompare(sig, mac.Sum(nil)) != 1 {
+		return "", errInvalidShare
+	}
+	var claims shareClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Grid == "" || time.Now().Unix() >= claims.Expires {
+		return "", errInvalidShare
+	}
+	return claims.Grid, nil
+}
+
+// ShareRequest is the body of POST /api/share.
+type ShareRequest struct {
+	Grid string          `json:"grid"`
+	TTL  metav1.Duration `json:"ttl,omitempty"`
+}
+
+// ShareLink is a read-only link to one grid.
+type ShareLink struct {
+	Grid    string    `json:"grid"`
+	URL     string    `json:"url"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleShare serves POST /api/share, which signs a link to a read-only live
+// view of one of the caller's grids. The link works without credentials
+// until it expires and grants nothing but the grid's stream.
+func handleShare(w http.ResponseWriter, r *http.Request, b *gridBootstrapper) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenant, ok := requireTenant(w, r)
+	if !ok {
+		return
+	}
+	var owner string
+	if tenant != nil {
+		owner = tenant.Name
+	}
+
+	var req ShareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid share: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TTL.Duration == 0 {
+		req.TTL.Duration = defaultShareTTL
+	}
+	if req.TTL.Duration < 0 || req.TTL.Duration > maxShareTTL {
+		http.Error(w, "Invalid share: ttl must be positive and at most "+maxShareTTL.String(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Grid == "" {
+		http.Error(w, "Invalid share: grid is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if _, err := b.Get(ctx, req.Grid, owner); err != nil {
+		http.Error(w, "Grid not found", http.StatusNotFound)
+		return
+	}
+
+	expires := time.Now().Add(req.TTL.Duration).Truncate(time.Second)
+	token := signShareToken(req.Grid, expires)
+	log.Printf("Share: %s shared grid %s until %s", requestIdentity(r), req.Grid, expires.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShareLink{Grid: req.Grid, URL: "/view/" + token, Token: token, Expires: expires})
+}
+
+// handleView serves GET /view/<token>: it sends the browser to the
+// dashboard, which opens the grid's stream with the token.
+func handleView(w http.ResponseWriter, r *http.Request, b *gridBootstrapper) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/view/")
+	grid, err := verifyShareToken(token)
+	if err == nil {
+		_, err = b.Get(r.Context(), grid, "")
+	}
+	if err != nil {
+		http.Error(w, "This share link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+	query := url.Values{"grid": {grid}, "share": {token}}
+	http.Redirect(w, r, "/?"+query.Encode(), http.StatusFound)
+}
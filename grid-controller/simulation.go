@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// simulation drives the standalone engine: one Step per tick, after which the
+// pod manager catches the cluster up with the new generation.
+type simulation struct {
+	engine *Engine
+	cells  *cellPodManager
+	// supercells, when set, materializes cells as Deployments instead, and
+	// fireworks births as Jobs.
+	supercells *superCellManager
+	fireworks  *fireworkLauncher
+	federation *federationMember
+	interval   time.Duration
+
+	// viewerBirths is the expected number of spontaneous births per viewer
+	// and tick.
+	viewerBirths float64
+
+	alerts     *alertEngine
+	extinction ExtinctionPolicy
+	deadline   DeadlinePolicy
+	stats      statsHistory
+	history    *statsWriter
+	digests    digestLog
+	snapshots  *snapshotter
+	edits      editLedger
+
+	// baseRule is the rule from --rule-engine or the rotation's rule of the
+	// day, restored when an uploaded rule or comparison is removed.
+	baseRule   RuleEngine
+	rotation   *ruleRotation
+	wasmLimits WasmLimits
+	hooks      *hookRunner
+	energy     *energyLedger
+	sonifier   *sonifier
+	osc        *oscOutput
+	renderers  *rendererSet
+	scenarios  *scenarioRunner
+	summaries  *summaryStream
+	structures *structureDetector
+	breaker    *churnBreaker
+	// hours is set when the grid keeps operating hours.
+	hours *OperatingHours
+
+	// previous is the generation before the current one, kept while a
+	// rollback deadline policy may revert to it.
+	previous  *engineState
+	rollbacks chan int64
+	// external disables the ticker; generations then only advance through
+	// ticks, fed by POST /api/simulation/tick.
+	external bool
+	ticks    chan tickRequest
+
+	mu     sync.Mutex
+	paused bool
+	// maintenance freezes the simulation while nodes are cordoned,
+	// independently of paused.
+	maintenance bool
+	// offHours is set outside operating hours; lastTick paces the
+	// screensaver then.
+	offHours bool
+	lastTick time.Time
+	// dormant freezes the simulation while the grid is scaled to zero.
+	dormant  bool
+	extinct  time.Time
+	reseeded bool
+}
+
+// Extinction policies.
+const (
+	extinctionNotify = "notify"
+	extinctionReseed = "reseed"
+	extinctionPause  = "pause"
+)
+
+// ExtinctionPolicy decides what happens once every cell has died, e.g.
+//
+//	extinction:
+//	  policy: reseed
+//	  delay: 10s
+//	  pattern: random
+//	  density: 0.3
+type ExtinctionPolicy struct {
+	// Policy is notify (the default), reseed or pause.
+	Policy string          `json:"policy,omitempty"`
+	Delay  metav1.Duration `json:"delay,omitempty"`
+	// Pattern is a built-in pattern stamped in the middle of the grid, or
+	// "random" for a soup of the given density.
+	Pattern string  `json:"pattern,omitempty"`
+	Density float64 `json:"density,omitempty"`
+}
+
+func (p ExtinctionPolicy) validate() error {
+	switch p.Policy {
+	case "", extinctionNotify, extinctionPause:
+	case extinctionReseed:
+		if _, ok := builtinPatterns[p.Pattern]; !ok && p.Pattern != "random" {
+			return fmt.Errorf("unknown pattern %q (want random or one of %v)", p.Pattern, builtinPatternNames())
+		}
+		if p.Pattern == "random" && (p.Density <= 0 || p.Density > 1) {
+			return fmt.Errorf("density must be in (0, 1]")
+		}
+	default:
+		return fmt.Errorf("unknown policy %q", p.Policy)
+	}
+	return nil
+}
+
+// Tick sources.
+const (
+	tickInternal = "internal"
+	tickExternal = "external"
+)
+
+// maxTicksPerRequest bounds the generations one POST /api/simulation/tick
+// may advance.
+const maxTicksPerRequest = 100
+
+// tickRequest asks the engine's goroutine for generations from outside.
+type tickRequest struct {
+	generations int
+	done        chan TickResult
+}
+
+// TickResult answers POST /api/simulation/tick.
+type TickResult struct {
+	Generation int64 `json:"generation"`
+	Population int   `json:"population"`
+	// Advanced is less than requested when the simulation was paused on the
+	// way, e.g. by the extinction policy.
+	Advanced int `json:"advanced"`
+}
+
+func (s *simulation) Run(ctx context.Context) {
+	if s.federation != nil {
+		s.federation.Record()
+	}
+	// With an external tick source only requests advance the engine.
+	var ticks <-chan time.Time
+	if !s.external {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			if s.screensaverRests() {
+				continue
+			}
+			s.tick(ctx)
+		case req := <-s.ticks:
+			var res TickResult
+			for ; res.Advanced < req.generations && !s.Frozen(); res.Advanced++ {
+				s.tick(ctx)
+			}
+			res.Generation, res.Population = s.engine.Generation(), s.engine.Population()
+			req.done <- res
+		case gen := <-s.rollbacks:
+			s.rollBack(gen)
+		}
+	}
+}
+
+func (s *simulation) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+func (s *simulation) SetPaused(paused bool) {
+	s.mu.Lock()
+	s.paused = paused
+	s.mu.Unlock()
+	log.Printf("Engine: paused=%v", paused)
+}
+
+// SetMaintenance freezes or thaws the simulation for cluster maintenance.
+// It is safe to call on a nil simulation.
+func (s *simulation) SetMaintenance(frozen bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.maintenance = frozen
+	s.mu.Unlock()
+	log.Printf("Engine: maintenance=%v", frozen)
+}
+
+func (s *simulation) inMaintenance() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maintenance
+}
+
+// SetOffHours enters or leaves the time outside operating hours.
+func (s *simulation) SetOffHours(off bool) {
+	s.mu.Lock()
+	s.offHours = off
+	s.mu.Unlock()
+	log.Printf("Engine: offHours=%v", off)
+}
+
+// OffHours reports whether the grid is outside its operating hours.
+func (s *simulation) OffHours() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offHours
+}
+
+// SetDormant freezes or thaws the simulation as the grid is scaled to zero
+// and back.
+func (s *simulation) SetDormant(dormant bool) {
+	s.mu.Lock()
+	s.dormant = dormant
+	s.mu.Unlock()
+}
+
+// screensaverRests reports whether the ticker's tick is skipped because the
+// screensaver computed a generation less than its interval ago.
+func (s *simulation) screensaverRests() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.offHours || s.hours == nil || s.hours.Outside != offHoursScreensaver {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(s.lastTick) < s.hours.ScreensaverInterval.Duration {
+		return true
+	}
+	s.lastTick = now
+	return false
+}
+
+// Frozen reports whether the simulation is paused, frozen for maintenance,
+// paused outside operating hours or dormant.
+func (s *simulation) Frozen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused || s.maintenance || s.dormant || (s.offHours && s.hours != nil && s.hours.Outside == offHoursPause)
+}
+
+func (s *simulation) tick(ctx context.Context) {
+	if s.Frozen() {
+		return
+	}
+	s.rotate()
+
+	started := time.Now()
+	var above, below []bool
+	if s.federation != nil {
+		above, below = s.federation.Ghosts(ctx, s.engine.Generation(), s.interval/2)
+	}
+	read := time.Since(started)
+
+	if s.deadline.Timeout.Duration > 0 && s.deadline.Policy == deadlineRollback {
+		gen, alive := s.engine.Snapshot()
+		s.previous = &engineState{generation: gen, alive: alive}
+	}
+
+	stepCtx, cancel := context.WithTimeout(ctx, s.interval/2)
+	s.edits.mu.Lock()
+	births, deaths, err := s.engine.Step(stepCtx, above, below)
+	s.edits.mu.Unlock()
+	cancel()
+	if err != nil {
+		ruleErrors.Inc()
+		log.Printf("Engine: generation %d not computed by rule %s: %s", s.engine.Generation(), s.engine.Rule(), err.Error())
+		return
+	}
+	events := cellEvents(births, causeRule)
+	if s.viewerBirths > 0 {
+		spontaneous := spontaneousBirths(s.engine, viewerCount(), s.viewerBirths)
+		births = append(births, spontaneous...)
+		events = append(events, cellEvents(spontaneous, causeViewer)...)
+	}
+	reseeded := s.checkExtinction()
+	births = append(births, reseeded...)
+	events = append(events, cellEvents(reseeded, causeReseed)...)
+	deathEvents := cellEvents(deaths, causeRule)
+	if s.energy != nil {
+		starved := s.energy.Spend(s.engine.LiveCells())
+		for _, i := range starved {
+			s.engine.Set(i, false)
+		}
+		deaths = append(deaths, starved...)
+		deathEvents = append(deathEvents, cellEvents(starved, causeStarved)...)
+	}
+
+	compute := time.Since(started) - read
+	tickPhaseSeconds.WithLabelValues(phaseReadState).Observe(read.Seconds())
+	tickPhaseSeconds.WithLabelValues(phaseCompute).Observe(compute.Seconds())
+
+	gen, population := s.engine.Generation(), s.engine.Population()
+	s.digests.Begin(&GenerationDigest{
+		Generation: gen,
+		Started:    started,
+		Population: population,
+		Births:     events,
+		Deaths:     deathEvents,
+		PhasesMs: map[string]float64{
+			phaseReadState: float64(read) / float64(time.Millisecond),
+			phaseCompute:   float64(compute) / float64(time.Millisecond),
+		},
+	})
+
+	spawned, killed := s.hooks.Run(s, gen, population, events, deathEvents)
+	if len(spawned) > 0 || len(killed) > 0 {
+		births, deaths = append(births, spawned...), append(deaths, killed...)
+		population = s.engine.Population()
+		s.digests.update(func(d *GenerationDigest) { d.Population = population })
+	}
+
+	broadcast := time.Now()
+	if s.federation != nil {
+		s.federation.Record()
+		s.federation.Publish(births, deaths)
+	}
+	s.digests.Phase(phaseBroadcast, time.Since(broadcast))
+	if s.deadline.Timeout.Duration > 0 {
+		s.cells.SetDeadline(gen, started.Add(s.deadline.Timeout.Duration), s.deadline.Policy)
+	}
+	if s.engine.cap.Over(population) {
+		s.SetPaused(true)
+		s.alerts.Emit(Alert{Name: "population-cap", Message: fmt.Sprintf("population above the cap of %d; simulation paused", s.engine.cap.cfg.Max), Generation: gen, Population: population, Time: time.Now()}, false, "")
+	}
+	if tripped, reason := s.breaker.Record(time.Now(), len(births), len(deaths)); tripped {
+		// This generation is still materialized; the next one waits for an
+		// operator.
+		s.SetPaused(true)
+		s.alerts.Emit(Alert{Name: "churn-breaker", Message: reason + "; simulation paused", Generation: gen, Population: population, Time: time.Now()}, s.breaker.cfg.Event, s.breaker.cfg.Webhook)
+	}
+	s.cells.Resync()
+	s.supercells.Resync()
+	s.fireworks.Launch(gen, births)
+	s.digests.update(func(d *GenerationDigest) {
+		d.DurationMs = float64(time.Since(started)) / float64(time.Millisecond)
+	})
+
+	sample := StatsSample{Generation: gen, Population: population, Births: len(births), Deaths: len(deaths), Time: time.Now()}
+	s.stats.Record(sample)
+	s.history.Record(sample)
+	// Bots and other clients step along with the engine. Published from the
+	// tick loop itself, so generations reach the hub in order and a stalled
+	// hub holds up the ticks rather than piling up goroutines.
+	publish(msgGeneration, sample)
+	s.alerts.Observe(gen, population)
+	s.osc.Generation(gen, population, births, deaths)
+	s.renderers.Render(newFrame(s.engine.View(), births, deaths))
+	s.sonifier.Record(gen, population, births, deaths)
+	s.structures.Record(s.engine.View().alive)
+	s.summaries.Record(sample, s.engine.View())
+	if s.engine.genetics != nil {
+		geneticsLineages.Set(float64(len(lineages(s.engine.Genomes()))))
+	}
+	s.snapshots.Tick(ctx, s)
+}
+
+// checkExtinction applies the extinction policy once the grid has been empty
+// for the configured delay, and returns any births it caused.
+func (s *simulation) checkExtinction() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.engine.Population() > 0 {
+		s.extinct, s.reseeded = time.Time{}, false
+		return nil
+	}
+	if s.extinct.IsZero() {
+		s.extinct = time.Now()
+		gen := s.engine.Generation()
+		log.Printf("Engine: extinction at generation %d", gen)
+		s.alerts.Emit(Alert{Name: "extinction", Message: "every cell has died", Generation: gen, Time: s.extinct}, true, "")
+	}
+	if s.reseeded || time.Since(s.extinct) < s.extinction.Delay.Duration {
+		return nil
+	}
+	s.reseeded = true
+
+	switch s.extinction.Policy {
+	case extinctionPause:
+		s.paused = true
+		log.Printf("Engine: paused after extinction")
+	case extinctionReseed:
+		p := builtinPatterns[s.extinction.Pattern]
+		if s.extinction.Pattern == "random" {
+			p = randomSoup(s.engine.grid, s.extinction.Density)
+		}
+		log.Printf("Engine: reseeding with %s", p.Name)
+		return s.engine.StampCentered(p)
+	}
+	return nil
+}
+
+// handleSimulation serves the operator controls POST /api/simulation/pause,
+// POST /api/simulation/resume and, with --tick-source=external, POST
+// /api/simulation/tick?generations=, which advances the engine and answers
+// once the generations are computed.
+func handleSimulation(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireRole(w, r, roleOperator) {
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Simulation control requires --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	switch path.Base(r.URL.Path) {
+	case "pause":
+		s.SetPaused(true)
+	case "resume":
+		s.SetPaused(false)
+	case "tick":
+		s.handleTick(w, r)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *simulation) handleTick(w http.ResponseWriter, r *http.Request) {
+	if !s.external {
+		http.Error(w, "Ticks require --tick-source=external", http.StatusConflict)
+		return
+	}
+	generations := 1
+	if v := r.URL.Query().Get("generations"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxTicksPerRequest {
+			http.Error(w, fmt.Sprintf("generations must be between 1 and %d", maxTicksPerRequest), http.StatusBadRequest)
+			return
+		}
+		generations = n
+	}
+	if s.Frozen() {
+		http.Error(w, "Simulation is paused", http.StatusConflict)
+		return
+	}
+
+	req := tickRequest{generations: generations, done: make(chan TickResult, 1)}
+	select {
+	case s.ticks <- req:
+	case <-r.Context().Done():
+		return
+	}
+	// The generations are computed even if the caller gives up waiting.
+	select {
+	case res := <-req.done:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case <-r.Context().Done():
+	}
+}
+
+// observeCells samples the population of worker-driven cells. Workers tick on
+// their own, so there is no controller-side generation; samples are numbered
+// instead.
+func observeCells(ctx context.Context, pods corelisters.PodLister, namespace string, interval time.Duration, observe func(gen int64, population int)) {
+	alive := labels.SelectorFromSet(labels.Set{"app": "cell", "game-status": "alive"})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var sample int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			list, err := pods.Pods(namespace).List(alive)
+			if err != nil {
+				continue
+			}
+			sample++
+			observe(sample, len(list))
+		}
+	}
+}
+
+// PodDeleted keeps the engine in sync with pods deleted behind its back: a
+// chaos kill of a live cell is a death in the automaton.
+func (s *simulation) PodDeleted(name string) {
+	if s.cells.Retired(name) || s.cells.Replaced(name) {
+		s.cells.Forget(name)
+		return
+	}
+	i, ok := cellIndex(name)
+	if !ok || !s.engine.Alive(i) {
+		return
+	}
+	if s.inMaintenance() {
+		// Evicted by a drain: the cell lives on and its pod is recreated.
+		log.Printf("Engine: %s deleted during maintenance, cell kept alive", name)
+		return
+	}
+	log.Printf("Engine: %s killed externally", name)
+	s.engine.Set(i, false)
+	s.digests.Death(i, causeChaos)
+	s.digests.Chaos(name)
+	s.scenarios.Observe(causeChaos)
+	s.hooks.Chaos(name, i)
+	if s.federation != nil {
+		s.federation.Publish(nil, []int{i})
+	}
+}
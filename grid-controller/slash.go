@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SlashConfig serves a /life slash command to Slack and Discord, e.g.
+//
+//	slash:
+//	  slackSigningSecretFile: /var/run/secrets/slack/signing-secret
+//	  discordPublicKey: 3f4e...   # the application's public key, hex
+//
+// Point the Slack command's request URL at /api/slash/slack and the Discord
+// application's interactions endpoint at /api/slash/discord. Requests must be
+// signed by the platform. The command takes the chat bridge's commands
+// without the "!" (/life kill 3 7, /life pattern glider 10 10, /life stats)
+// and /life snapshot, which answers with the grid rendered as text. Each
+// Slack or Discord user is charged their own quotas, as with chat commands.
+// On Discord, register /life with one string option holding the rest of the
+// command, or with subcommands whose options are given in order.
+type SlashConfig struct {
+	SlackSigningSecretFile string `json:"slackSigningSecretFile,omitempty"`
+	DiscordPublicKey       string `json:"discordPublicKey,omitempty"`
+}
+
+func (c *SlashConfig) validate() error {
+	if c.SlackSigningSecretFile == "" && c.DiscordPublicKey == "" {
+		return errors.New("slackSigningSecretFile or discordPublicKey is required")
+	}
+	if c.DiscordPublicKey != "" {
+		if key, err := hex.DecodeString(c.DiscordPublicKey); err != nil || len(key) != ed25519.PublicKeySize {
+			return errors.New("discordPublicKey must be a hex Ed25519 public key")
+		}
+	}
+	return nil
+}
+
+// slashMaxSkew bounds the age of a signed request, against replays.
+const slashMaxSkew = 5 * time.Minute
+
+// Rendered snapshots are downsampled to fit a chat message.
+const (
+	snapshotMaxWidth  = 64
+	snapshotMaxHeight = 32
+)
+
+// slashCommands answers slash commands through the chat bridge.
+type slashCommands struct {
+	bridge       *chatBridge
+	slackSecret  []byte
+	discordKey   ed25519.PublicKey
+	maxBodyBytes int64
+}
+
+func newSlashCommands(cfg *SlashConfig, bridge *chatBridge) (*slashCommands, error) {
+	s := &slashCommands{bridge: bridge, maxBodyBytes: 64 << 10}
+	if cfg == nil {
+		return s, nil
+	}
+	if cfg.SlackSigningSecretFile != "" {
+		data, err := os.ReadFile(cfg.SlackSigningSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		s.slackSecret = bytes.TrimSpace(data)
+	}
+	if cfg.DiscordPublicKey != "" {
+		s.discordKey, _ = hex.DecodeString(cfg.DiscordPublicKey)
+	}
+	return s, nil
+}
+
+// run answers "/life <text>" for a platform user.
+func (s *slashCommands) run(r *http.Request, platform, user, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || fields[0] == "help" {
+		return "usage: /life kill x y | spawn x y | pattern name [x y] | stats | snapshot"
+	}
+	if strings.ToLower(fields[0]) == "snapshot" {
+		return s.snapshot()
+	}
+	reply, ok := s.bridge.Command(r.Context(), platform, user, "!"+text)
+	if !ok {
+		return fmt.Sprintf("unknown command %q; try /life help", fields[0])
+	}
+	return reply
+}
+
+// snapshot renders the grid as text, one character per block of cells.
+func (s *slashCommands) snapshot() string {
+	sim := s.bridge.sim
+	if sim == nil {
+		return "snapshots require --engine=standalone"
+	}
+	grid := sim.engine.grid
+	gen, alive := sim.engine.Snapshot()
+	live := make(map[int]bool, len(alive))
+	for _, i := range alive {
+		live[i] = true
+	}
+	scale := max(1, (grid.Width+snapshotMaxWidth-1)/snapshotMaxWidth, (grid.Height+snapshotMaxHeight-1)/snapshotMaxHeight)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "generation %d, population %d", gen, len(alive))
+	if scale > 1 {
+		fmt.Fprintf(&b, " (1 character = %dx%d cells)", scale, scale)
+	}
+	b.WriteString("\n```\n")
+	for y := 0; y < grid.Height; y += scale {
+		for x := 0; x < grid.Width; x += scale {
+			c := '.'
+		block:
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					if grid.Contains(x+dx, y+dy) && live[grid.Index(x+dx, y+dy)] {
+						c = '#'
+						break block
+					}
+				}
+			}
+			b.WriteRune(c)
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// readSigned reads the body of a signed request, or writes the error response.
+func (s *slashCommands) readSigned(w http.ResponseWriter, r *http.Request, verify func(timestamp string, body []byte) bool, timestampHeader string) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	timestamp := r.Header.Get(timestampHeader)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > slashMaxSkew || !verify(timestamp, body) {
+		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
+
+// handleSlack serves POST /api/slash/slack, a Slack slash command.
+func (s *slashCommands) handleSlack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(s.slackSecret) == 0 {
+		http.Error(w, "Slack commands disabled", http.StatusForbidden)
+		return
+	}
+
+	body, ok := s.readSigned(w, r, func(timestamp string, body []byte) bool {
+		mac := hmac.New(sha256.New, s.slackSecret)
+		fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+		want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(want), []byte(r.Header.Get("X-Slack-Signature")))
+	}, "X-Slack-Request-Timestamp")
+	if !ok {
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil || form.Get("user_id") == "" {
+		http.Error(w, "Invalid slash command", http.StatusBadRequest)
+		return
+	}
+
+	reply := s.run(r, "slack", form.Get("team_id")+"."+form.Get("user_id"), form.Get("text"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"response_type": "in_channel", "text": reply})
+}
+
+// discordInteraction is the part of a Discord interaction the command reads.
+type discordInteraction struct {
+	Type int `json:"type"`
+	Data struct {
+		Options []discordOption `json:"options"`
+	} `json:"data"`
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+}
+
+type discordUser struct {
+	ID string `json:"id"`
+}
+
+type discordOption struct {
+	Name    string          `json:"name"`
+	Value   json.RawMessage `json:"value"`
+	Options []discordOption `json:"options"`
+}
+
+// Discord interaction and response types.
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+	discordPong               = 1
+	discordChannelMessage     = 4
+)
+
+// text flattens subcommands and option values into a command line.
+func (o discordOption) text() string {
+	if o.Value == nil {
+		parts := []string{o.Name}
+		for _, sub := range o.Options {
+			parts = append(parts, sub.text())
+		}
+		return strings.Join(parts, " ")
+	}
+	var s string
+	if json.Unmarshal(o.Value, &s) == nil {
+		return s
+	}
+	return string(o.Value)
+}
+
+// handleDiscord serves POST /api/slash/discord, a Discord interactions
+// endpoint.
+func (s *slashCommands) handleDiscord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.discordKey == nil {
+		http.Error(w, "Discord commands disabled", http.StatusForbidden)
+		return
+	}
+
+	body, ok := s.readSigned(w, r, func(timestamp string, body []byte) bool {
+		sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+		return err == nil && ed25519.Verify(s.discordKey, append([]byte(timestamp), body...), sig)
+	}, "X-Signature-Timestamp")
+	if !ok {
+		return
+	}
+	var in discordInteraction
+	if err := json.Unmarshal(body, &in); err != nil {
+		http.Error(w, "Invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch in.Type {
+	case discordPing:
+		json.NewEncoder(w).Encode(map[string]int{"type": discordPong})
+		return
+	case discordApplicationCommand:
+	default:
+		http.Error(w, "Unsupported interaction", http.StatusBadRequest)
+		return
+	}
+
+	user := in.User
+	if in.Member != nil {
+		user = &in.Member.User
+	}
+	if user == nil || user.ID == "" {
+		http.Error(w, "Interaction without a user", http.StatusBadRequest)
+		return
+	}
+	var parts []string
+	for _, o := range in.Data.Options {
+		parts = append(parts, o.text())
+	}
+
+	reply := s.run(r, "discord", user.ID, strings.Join(parts, " "))
+	json.NewEncoder(w).Encode(map[string]any{
+		"type": discordChannelMessage,
+		"data": map[string]string{"content": reply},
+	})
+}
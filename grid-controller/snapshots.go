@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SnapshotPolicy takes automatic snapshots of the standalone engine, e.g.
+//
+//	snapshots:
+//	  everyGenerations: 500
+//	  every: 10m
+//	  keep: 12
+//	  directory: /var/lib/grid-controller/snapshots
+//
+// A snapshot is taken when either interval has passed since the last one.
+// Snapshots are stored as ConfigMaps in the controller's namespace, or as
+// files when a directory (e.g. a mounted PVC) is set.
+type SnapshotPolicy struct {
+	EveryGenerations int64           `json:"everyGenerations,omitempty"`
+	Every            metav1.Duration `json:"every,omitempty"`
+	// Keep is how many snapshots are retained; defaults to 10.
+	Keep      int    `json:"keep,omitempty"`
+	Directory string `json:"directory,omitempty"`
+}
+
+func (p *SnapshotPolicy) enabled() bool {
+	return p.EveryGenerations > 0 || p.Every.Duration > 0
+}
+
+func (p *SnapshotPolicy) validate() error {
+	if p.EveryGenerations < 0 || p.Every.Duration < 0 || p.Keep < 0 {
+		return errors.New("everyGenerations, every and keep must not be negative")
+	}
+	if p.Keep == 0 {
+		p.Keep = 10
+	}
+	return nil
+}
+
+// SnapshotInfo describes a stored snapshot.
+type SnapshotInfo struct {
+	Name       string    `json:"name"`
+	Generation int64     `json:"generation"`
+	Population int       `json:"population"`
+	Taken      time.Time `json:"taken"`
+}
+
+// snapshotStore persists archives under a name.
+type snapshotStore interface {
+	Save(ctx context.Context, info SnapshotInfo, a *Archive) error
+	// List returns the stored snapshots, newest first.
+	List(ctx context.Context) ([]SnapshotInfo, error)
+	Load(ctx context.Context, name string) (*Archive, error)
+	Delete(ctx context.Context, name string) error
+}
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+// configMapSnapshots keeps each snapshot in a ConfigMap. ConfigMaps are
+// limited to 1 MiB, so only the most recent statistics are kept.
+type configMapSnapshots struct {
+	clientset kubernetes.Interface
+	namespace string
+}
+
+const (
+	snapshotLabel            = "cellular-automaton/snapshot"
+	snapshotConfigMapStats   = 1000
+	snapshotGenerationAnnot  = "cellular-automaton/generation"
+	snapshotPopulationAnnot  = "cellular-automaton/population"
+	snapshotTakenAnnotation  = "cellular-automaton/taken"
+	snapshotArchiveConfigKey = "archive.json"
+)
+
+func (s *configMapSnapshots) Save(ctx context.Context, info SnapshotInfo, a *Archive) error {
+	trimmed := *a
+	if len(trimmed.Stats) > snapshotConfigMapStats {
+		trimmed.Stats = trimmed.Stats[len(trimmed.Stats)-snapshotConfigMapStats:]
+	}
+	data, err := json.Marshal(&trimmed)
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Create(ctx, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      info.Name,
+			Namespace: s.namespace,
+			Labels:    map[string]string{snapshotLabel: "true"},
+			Annotations: map[string]string{
+				snapshotGenerationAnnot: strconv.FormatInt(info.Generation, 10),
+				snapshotPopulationAnnot: strconv.Itoa(info.Population),
+				snapshotTakenAnnotation: info.Taken.Format(time.RFC3339),
+			},
+		},
+		Data: map[string]string{snapshotArchiveConfigKey: string(data)},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func (s *configMapSnapshots) List(ctx context.Context) ([]SnapshotInfo, error) {
+	list, err := s.clientset.CoreV1().ConfigMaps(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: snapshotLabel + "=true"})
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []SnapshotInfo{}
+	for _, cm := range list.Items {
+		info := SnapshotInfo{Name: cm.Name}
+		info.Generation, _ = strconv.ParseInt(cm.Annotations[snapshotGenerationAnnot], 10, 64)
+		info.Population, _ = strconv.Atoi(cm.Annotations[snapshotPopulationAnnot])
+		info.Taken, _ = time.Parse(time.RFC3339, cm.Annotations[snapshotTakenAnnotation])
+		snapshots = append(snapshots, info)
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (s *configMapSnapshots) Load(ctx context.Context, name string) (*Archive, error) {
+	cm, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && cm.Labels[snapshotLabel] != "true") {
+		return nil, errSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a Archive
+	if err := json.Unmarshal([]byte(cm.Data[snapshotArchiveConfigKey]), &a); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return &a, nil
+}
+
+func (s *configMapSnapshots) Delete(ctx context.Context, name string) error {
+	err := s.clientset.CoreV1().ConfigMaps(s.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// dirSnapshots keeps each snapshot as a JSON file in a directory.
+type dirSnapshots struct {
+	dir string
+}
+
+func (s *dirSnapshots) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+func (s *dirSnapshots) Save(_ context.Context, info SnapshotInfo, a *Archive) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	// Write and rename, so a crash never leaves a torn snapshot behind.
+	tmp := s.path(info.Name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(info.Name))
+}
+
+func (s *dirSnapshots) List(ctx context.Context) ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []SnapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []SnapshotInfo{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !strings.HasPrefix(name, "grid-snapshot-") {
+			continue
+		}
+		a, err := s.Load(ctx, name)
+		if err != nil {
+			log.Printf("Snapshots: %v", err)
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{Name: name, Generation: a.Generation, Population: len(a.Alive), Taken: a.Exported})
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (s *dirSnapshots) Load(_ context.Context, name string) (*Archive, error) {
+	if filepath.Base(name) != name {
+		return nil, errSnapshotNotFound
+	}
+	data, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, errSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a Archive
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return &a, nil
+}
+
+func (s *dirSnapshots) Delete(_ context.Context, name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func sortSnapshots(snapshots []SnapshotInfo) {
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Taken.After(snapshots[j].Taken) })
+}
+
+// snapshotter takes snapshots on the policy's schedule, one at a time and off
+// the tick path.
+type snapshotter struct {
+	policy SnapshotPolicy
+	store  snapshotStore
+
+	mu      sync.Mutex
+	busy    bool
+	lastGen int64
+	last    time.Time
+}
+
+func newSnapshotter(policy SnapshotPolicy, store snapshotStore) *snapshotter {
+	return &snapshotter{policy: policy, store: store, last: time.Now()}
+}
+
+// Tick is called after every generation and starts a snapshot when one is due.
+func (sn *snapshotter) Tick(ctx context.Context, s *simulation) {
+	if sn == nil || !sn.policy.enabled() {
+		return
+	}
+	gen := s.engine.Generation()
+	sn.mu.Lock()
+	due := (sn.policy.EveryGenerations > 0 && gen-sn.lastGen >= sn.policy.EveryGenerations) ||
+		(sn.policy.Every.Duration > 0 && time.Since(sn.last) >= sn.policy.Every.Duration)
+	if !due || sn.busy {
+		sn.mu.Unlock()
+		return
+	}
+	sn.busy, sn.lastGen, sn.last = true, gen, time.Now()
+	sn.mu.Unlock()
+
+	a := s.Export()
+	go func() {
+		defer func() {
+			sn.mu.Lock()
+			sn.busy = false
+			sn.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if _, err := sn.Take(ctx, a); err != nil {
+			log.Printf("Snapshots: %v", err)
+		}
+	}()
+}
+
+// Restart counts the next snapshot's interval from generation gen, after the
+// engine state was replaced.
+func (sn *snapshotter) Restart(gen int64) {
+	if sn == nil {
+		return
+	}
+	sn.mu.Lock()
+	sn.lastGen, sn.last = gen, time.Now()
+	sn.mu.Unlock()
+}
+
+// Take stores an archive as a new snapshot and prunes old ones.
+func (sn *snapshotter) Take(ctx context.Context, a *Archive) (SnapshotInfo, error) {
+	info := SnapshotInfo{
+		Name:       fmt.Sprintf("grid-snapshot-%d", a.Exported.UnixMilli()),
+		Generation: a.Generation,
+		Population: len(a.Alive),
+		Taken:      a.Exported,
+	}
+	if err := sn.store.Save(ctx, info, a); err != nil {
+		return info, fmt.Errorf("save %s: %w", info.Name, err)
+	}
+	log.Printf("Snapshots: saved %s at generation %d", info.Name, info.Generation)
+
+	all, err := sn.store.List(ctx)
+	if err != nil {
+		return info, fmt.Errorf("list: %w", err)
+	}
+	for _, old := range all[min(len(all), max(sn.policy.Keep, 1)):] {
+		if err := sn.store.Delete(ctx, old.Name); err != nil {
+			log.Printf("Snapshots: prune %s: %v", old.Name, err)
+		}
+	}
+	return info, nil
+}
+
+// handleSnapshots serves GET /api/snapshots, GET /api/snapshots/{name} (the
+// archive), and for administrators POST /api/snapshots, which takes one now,
+// and POST /api/snapshots/{name}/restore.
+func handleSnapshots(w http.ResponseWriter, r *http.Request, s *simulation, sn *snapshotter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if s == nil || sn == nil {
+		http.Error(w, "Snapshots require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/snapshots"), "/")
+	name, restore := strings.CutSuffix(name, "/restore")
+
+	switch {
+	case r.Method == "GET" && name == "":
+		list, err := sn.store.List(ctx)
+		if err != nil {
+			http.Error(w, "Failed to list snapshots: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case r.Method == "GET" && !restore:
+		a, err := sn.store.Load(ctx, name)
+		if errors.Is(err, errSnapshotNotFound) {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to load snapshot: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+
+	case r.Method == "POST" && name == "":
+		if !requireAdmin(w, r) {
+			return
+		}
+		info, err := sn.Take(ctx, s.Export())
+		if err != nil {
+			http.Error(w, "Failed to take snapshot: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "POST" && restore:
+		if !requireAdmin(w, r) {
+			return
+		}
+		a, err := sn.store.Load(ctx, name)
+		if errors.Is(err, errSnapshotNotFound) {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to load snapshot: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := a.validate(s.engine.grid, s.engine.Rule()); err != nil {
+			http.Error(w, "Invalid snapshot: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		s.Import(a)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// SonificationConfig turns the births and deaths of the standalone engine
+// into a low-rate note feed for audio installations, e.g.
+//
+//	sonification:
+//	  every: 4
+//	  scale: pentatonic
+//	  baseNote: 48
+//	  maxNotes: 8
+//	  osc: 192.168.1.20:9000
+//
+// Every `every` generations the columns where cells were born or died are
+// folded onto two octaves of the scale above baseNote (births) or an octave
+// lower (deaths), and the busiest maxNotes are sent with a velocity scaled by
+// how many cells they stand for. The summary is streamed as a sonification
+// WebSocket message and, when osc is set, sent as OSC over UDP:
+//
+//	/grid/summary     generation population births deaths  (int32s)
+//	/grid/birth       note velocity                        (int32s)
+//	/grid/death       note velocity                        (int32s)
+type SonificationConfig struct {
+	Every    int    `json:"every,omitempty"`
+	Scale    string `json:"scale,omitempty"`
+	BaseNote int    `json:"baseNote,omitempty"`
+	MaxNotes int    `json:"maxNotes,omitempty"`
+	OSC      string `json:"osc,omitempty"`
+}
+
+// scales are semitone offsets within one octave.
+var scales = map[string][]int{
+	"pentatonic": {0, 2, 4, 7, 9},
+	"major":      {0, 2, 4, 5, 7, 9, 11},
+	"minor":      {0, 2, 3, 5, 7, 8, 10},
+	"chromatic":  {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+}
+
+func (c *SonificationConfig) validate() error {
+	if c.Every < 0 || c.MaxNotes < 0 {
+		return errors.New("every and maxNotes must not be negative")
+	}
+	if c.Every == 0 {
+		c.Every = 1
+	}
+	if c.MaxNotes == 0 {
+		c.MaxNotes = 8
+	}
+	if c.Scale == "" {
+		c.Scale = "pentatonic"
+	}
+	if _, ok := scales[c.Scale]; !ok {
+		return fmt.Errorf("unknown scale %q", c.Scale)
+	}
+	if c.BaseNote == 0 {
+		c.BaseNote = 48
+	}
+	// Deaths sound an octave below births, which span two octaves.
+	if c.BaseNote < 12 || c.BaseNote > 127-24 {
+		return fmt.Errorf("baseNote must be between 12 and %d", 127-24)
+	}
+	if c.OSC != "" {
+		if _, _, err := net.SplitHostPort(c.OSC); err != nil {
+			return fmt.Errorf("osc: %w", err)
+		}
+	}
+	return nil
+}
+
+// Note kinds, also the last element of their OSC address.
+const (
+	noteBirth = "birth"
+	noteDeath = "death"
+)
+
+// Note is a MIDI note and velocity.
+type Note struct {
+	Note     int    `json:"note"`
+	Velocity int    `json:"velocity"`
+	Kind     string `json:"kind"`
+}
+
+// SonificationMessage summarizes the generations since the previous one.
+type SonificationMessage struct {
+	Generation int64  `json:"generation"`
+	Population int    `json:"population"`
+	Births     int    `json:"births"`
+	Deaths     int    `json:"deaths"`
+	Notes      []Note `json:"notes"`
+}
+
+// sonifier accumulates births and deaths on the engine's goroutine. Its
+// methods are safe to call on a nil sonifier.
+type sonifier struct {
+	cfg  SonificationConfig
+	grid GridGeometry
+	osc  *oscOutput
+
+	generations    int
+	births, deaths int
+	counts         map[Note]int
+}
+
+func newSonifier(cfg *SonificationConfig, grid GridGeometry) (*sonifier, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	s := &sonifier{cfg: *cfg, grid: grid, counts: map[Note]int{}}
+	if cfg.OSC != "" {
+		osc, err := newOSCOutput("/grid", cfg.OSC)
+		if err != nil {
+			return nil, err
+		}
+		s.osc = osc
+	}
+	return s, nil
+}
+
+// note maps a column onto the scale.
+func (s *sonifier) note(x int, kind string) Note {
+	steps := scales[s.cfg.Scale]
+	degree := x * 2 * len(steps) / max(s.grid.Width, 1)
+	n := s.cfg.BaseNote + 12*(degree/len(steps)) + steps[degree%len(steps)]
+	if kind == noteDeath {
+		n -= 12
+	}
+	return Note{Note: n, Kind: kind}
+}
+
+// Record adds a generation's births and deaths and, every cfg.Every
+// generations, emits the summary.
+func (s *sonifier) Record(generation int64, population int, births, deaths []int) {
+	if s == nil {
+		return
+	}
+	for _, i := range births {
+		x, _ := s.grid.Coords(i)
+		s.counts[s.note(x, noteBirth)]++
+	}
+	for _, i := range deaths {
+		x, _ := s.grid.Coords(i)
+		s.counts[s.note(x, noteDeath)]++
+	}
+	s.births += len(births)
+	s.deaths += len(deaths)
+	if s.generations++; s.generations < s.cfg.Every {
+		return
+	}
+
+	msg := SonificationMessage{Generation: generation, Population: population, Births: s.births, Deaths: s.deaths, Notes: s.notes()}
+	s.generations, s.births, s.deaths = 0, 0, 0
+	clear(s.counts)
+
+	go publish(msgSonification, msg)
+	if s.osc != nil {
+		s.send(msg)
+	}
+}
+
+// notes picks the busiest notes, louder the more cells they stand for.
+func (s *sonifier) notes() []Note {
+	notes := make([]Note, 0, len(s.counts))
+	busiest := 0
+	for n, c := range s.counts {
+		notes = append(notes, n)
+		busiest = max(busiest, c)
+	}
+	sort.Slice(notes, func(a, b int) bool {
+		if ca, cb := s.counts[notes[a]], s.counts[notes[b]]; ca != cb {
+			return ca > cb
+		}
+		return notes[a].Note < notes[b].Note
+	})
+	if len(notes) > s.cfg.MaxNotes {
+		notes = notes[:s.cfg.MaxNotes]
+	}
+	for i := range notes {
+		notes[i].Velocity = 40 + 87*s.counts[notes[i]]/busiest
+	}
+	return notes
+}
+
+func (s *sonifier) send(msg SonificationMessage) {
+	s.osc.mu.Lock()
+	defer s.osc.mu.Unlock()
+	s.osc.send("/summary", int32(msg.Generation), int32(msg.Population), int32(msg.Births), int32(msg.Deaths))
+	for _, n := range msg.Notes {
+		s.osc.send("/"+n.Kind, int32(n.Note), int32(n.Velocity))
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatsSample is the grid's population after one generation.
+type StatsSample struct {
+	Generation int64     `json:"generation"`
+	Population int       `json:"population"`
+	Births     int       `json:"births"`
+	Deaths     int       `json:"deaths"`
+	Time       time.Time `json:"time"`
+}
+
+// statsHistoryLimit bounds the in-memory history: about a day at one
+// generation per ten seconds, or a few hours at the default tick.
+var statsHistoryLimit = 10000
+
+// statsHistory keeps the most recent samples in memory.
+type statsHistory struct {
+	mu      sync.Mutex
+	samples []StatsSample
+}
+
+func (h *statsHistory) Record(s StatsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, s)
+	limit := statsHistoryLimit
+	if memoryPressure.Load() {
+		limit = statsHistoryUnderPressure
+	}
+	if len(h.samples) > limit {
+		h.samples = append([]StatsSample(nil), h.samples[len(h.samples)-limit:]...)
+	}
+}
+
+// Samples returns a copy of the history, oldest first.
+func (h *statsHistory) Samples() []StatsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]StatsSample{}, h.samples...)
+}
+
+// Replace swaps in a history, e.g. from an imported archive.
+func (h *statsHistory) Replace(samples []StatsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(samples) > statsHistoryLimit {
+		samples = samples[len(samples)-statsHistoryLimit:]
+	}
+	h.samples = append([]StatsSample(nil), samples...)
+}
+
+// Shrink keeps only the latest n samples.
+func (h *statsHistory) Shrink(n int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dropped := max(len(h.samples)-n, 0)
+	if dropped > 0 {
+		h.samples = append([]StatsSample(nil), h.samples[dropped:]...)
+	}
+	return dropped
+}
+
+// statsSource is where the full statistics history lives: the stats database
+// when one is configured, otherwise memory.
+func (s *simulation) statsSource() statsStore {
+	if s.history != nil {
+		return s.history.store
+	}
+	return &s.stats
+}
+
+// handleStatsExport serves GET /api/stats/export?format=csv|json&from=&to=,
+// streaming the statistics of generations from through to (both optional).
+func handleStatsExport(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Statistics require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	q := r.URL.Query()
+	from, to := int64(0), int64(-1)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = strconv.ParseInt(v, 10, 64); err != nil || from < 0 {
+			http.Error(w, "from must be a generation", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = strconv.ParseInt(v, 10, 64); err != nil || to < from {
+			http.Error(w, "to must be a generation no earlier than from", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	filename := "grid-stats." + format
+
+	var write func(StatsSample) error
+	var finish func() error
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		cw.Write([]string{"generation", "population", "births", "deaths", "time"})
+		write = func(sample StatsSample) error {
+			return cw.Write([]string{
+				strconv.FormatInt(sample.Generation, 10),
+				strconv.Itoa(sample.Population),
+				strconv.Itoa(sample.Births),
+				strconv.Itoa(sample.Deaths),
+				sample.Time.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "json":
+		// Written element by element, so a long history is never held in
+		// memory at once.
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		enc := json.NewEncoder(w)
+		sep := "["
+		write = func(sample StatsSample) error {
+			if _, err := fmt.Fprint(w, sep); err != nil {
+				return err
+			}
+			sep = ","
+			return enc.Encode(sample)
+		}
+		finish = func() error {
+			if sep == "[" {
+				_, err := fmt.Fprint(w, "[]")
+				return err
+			}
+			_, err := fmt.Fprintln(w, "]")
+			return err
+		}
+	default:
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	started := false
+	err = s.statsSource().Query(r.Context(), from, to, func(sample StatsSample) error {
+		started = true
+		return write(sample)
+	})
+	if err != nil && !started {
+		http.Error(w, "Failed to read statistics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		// The response has started; all that's left is to cut it short.
+		log.Printf("Stats: export: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+// Exit codes of `grid-controller step`.
+const (
+	stepOK      = 0
+	stepFailed  = 1
+	stepUsage   = 2
+	stepExtinct = 3
+)
+
+// maxStepGenerations bounds one run of `grid-controller step`.
+const maxStepGenerations = 1000
+
+// restConfig uses the in-cluster config if available, otherwise the
+// kubeconfig.
+func restConfig(kubeconfig string) (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return config, nil
+}
+
+// runStep implements `grid-controller step`: it reads a grid's live cells from
+// its pods, advances the standalone engine's rule a number of generations,
+// materializes the result as pods and exits, so a CronJob can drive a
+// slow-motion automaton without a controller running in between. The
+// generation count is kept in the ConfigMap step-<grid>. Run no controller
+// with --engine=standalone on the same namespace. It returns the exit code:
+// 0 when the grid advanced, 1 on failure, 2 on invalid flags and 3 when the
+// grid is extinct afterwards.
+func runStep(args []string) int {
+	fs := flag.NewFlagSet("step", flag.ContinueOnError)
+	var defaultKubeconfig string
+	if home := homedir.HomeDir(); home != "" {
+		defaultKubeconfig = filepath.Join(home, ".kube", "config")
+	}
+	kubeconfig := fs.String("kubeconfig", defaultKubeconfig, "path to the kubeconfig file when running outside the cluster")
+	name := fs.String("grid", "", "name of the slow-motion grid, which keys its generation count (required)")
+	namespace := fs.String("namespace", os.Getenv("NAMESPACE"), "namespace of the grid's cell pods; defaults to $NAMESPACE, then cellular-automaton")
+	generations := fs.Int("generations", 1, fmt.Sprintf("generations to advance, at most %d", maxStepGenerations))
+	ruleSpec := fs.String("rule-engine", "life", "rule to advance by, as for the controller's --rule-engine")
+	image := fs.String("cell-image", "ghcr.io/nordiwnd/k3s-cellular-automaton/cells-worker:latest", "cell worker image of created cell pods")
+	timeout := fs.Duration("timeout", 2*time.Minute, "give up after this long")
+	if err := fs.Parse(args); err != nil {
+		return stepUsage
+	}
+	if *name == "" || *generations < 1 || *generations > maxStepGenerations {
+		fmt.Fprintf(os.Stderr, "step: --grid is required and --generations must be between 1 and %d\n", maxStepGenerations)
+		return stepUsage
+	}
+	if *namespace == "" {
+		*namespace = "cellular-automaton"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	config, err := restConfig(*kubeconfig)
+	if err != nil {
+		log.Printf("Step: kubeconfig: %v", err)
+		return stepFailed
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Printf("Step: clientset: %v", err)
+		return stepFailed
+	}
+
+	grid, err := stepGeometry(ctx, clientset, *namespace)
+	if err != nil {
+		log.Printf("Step: read cell-config: %v", err)
+		return stepFailed
+	}
+	rule, err := openRuleEngine(ctx, *ruleSpec, grid, 0)
+	if err != nil {
+		log.Printf("Step: invalid --rule-engine: %v", err)
+		return stepUsage
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(*namespace))
+	pods := factory.Core().V1().Pods()
+	informer := pods.Informer()
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		log.Printf("Step: pod cache did not sync")
+		return stepFailed
+	}
+
+	state, gen, err := loadStepState(ctx, clientset, *namespace, *name)
+	if err != nil {
+		log.Printf("Step: read generation: %v", err)
+		return stepFailed
+	}
+	alive, err := materializedCells(pods.Lister(), *namespace)
+	if err != nil {
+		log.Printf("Step: list cells: %v", err)
+		return stepFailed
+	}
+	engine := NewEngine(grid, rule)
+	if state == nil && len(alive) == 0 {
+		log.Printf("Step: seeding grid %s", *name)
+		engine.Seed()
+	} else {
+		engine.Restore(gen, alive)
+	}
+
+	for i := 0; i < *generations; i++ {
+		if _, _, err := engine.Step(ctx, nil, nil); err != nil {
+			log.Printf("Step: generation %d not computed by rule %s: %v", engine.Generation()+1, engine.Rule(), err)
+			return stepFailed
+		}
+	}
+
+	cells := newCellPodManager(clientset, *namespace, grid, *image, pods.Lister())
+	cells.desired = engine.Alive
+	cells.reconcile(ctx)
+	if drift, err := stepDrift(ctx, clientset, *namespace, engine); err != nil || drift > 0 {
+		log.Printf("Step: %d cells not materialized (%v)", drift, err)
+		return stepFailed
+	}
+	if err := saveStepState(ctx, clientset, *namespace, *name, state, engine.Generation()); err != nil {
+		log.Printf("Step: save generation: %v", err)
+		return stepFailed
+	}
+
+	log.Printf("Step: grid %s at generation %d, population %d", *name, engine.Generation(), engine.Population())
+	if engine.Population() == 0 {
+		return stepExtinct
+	}
+	return stepOK
+}
+
+// stepGeometry reads the grid size from the namespace's cell-config, falling
+// back to GRID_WIDTH and GRID_HEIGHT.
+func stepGeometry(ctx context.Context, clientset kubernetes.Interface, namespace string) (GridGeometry, error) {
+	width := envInt("GRID_WIDTH", 10)
+	grid := GridGeometry{Width: width, Height: envInt("GRID_HEIGHT", width)}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "cell-config", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return grid, nil
+	}
+	if err != nil {
+		return grid, err
+	}
+	if w, err := strconv.Atoi(cm.Data["GRID_WIDTH"]); err == nil {
+		grid.Width, grid.Height = w, w
+	}
+	if h, err := strconv.Atoi(cm.Data["GRID_HEIGHT"]); err == nil {
+		grid.Height = h
+	}
+	return grid, nil
+}
+
+func stepStateName(grid string) string {
+	return "step-" + grid
+}
+
+// loadStepState returns the grid's generation ConfigMap, nil before the first
+// step.
+func loadStepState(ctx context.Context, clientset kubernetes.Interface, namespace, grid string) (*v1.ConfigMap, int64, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, stepStateName(grid), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	gen, err := strconv.ParseInt(cm.Data["generation"], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("configmap %s: invalid generation %q", cm.Name, cm.Data["generation"])
+	}
+	return cm, gen, nil
+}
+
+func saveStepState(ctx context.Context, clientset kubernetes.Interface, namespace, grid string, cm *v1.ConfigMap, gen int64) error {
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	if cm == nil {
+		_, err := configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      stepStateName(grid),
+				Namespace: namespace,
+				Labels:    map[string]string{gridNameLabel: grid},
+			},
+			Data: map[string]string{"generation": strconv.FormatInt(gen, 10)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	cm.Data = map[string]string{"generation": strconv.FormatInt(gen, 10)}
+	_, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// stepDrift counts the cells whose pod disagrees with the engine, read from
+// the API server rather than the cache. Pods being deleted count as gone.
+func stepDrift(ctx context.Context, clientset kubernetes.Interface, namespace string, engine *Engine) (int, error) {
+	list, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=cell"})
+	if err != nil {
+		return 0, err
+	}
+	exists := make(map[int]bool, len(list.Items))
+	for _, pod := range list.Items {
+		if i, ok := cellIndex(pod.Name); ok && pod.DeletionTimestamp == nil {
+			exists[i] = true
+		}
+	}
+	drift := 0
+	for i := 0; i < engine.grid.Size(); i++ {
+		if exists[i] != engine.Alive(i) {
+			drift++
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"sync"
+)
+
+// Structure kinds.
+const (
+	structureStillLife  = "still-life"
+	structureOscillator = "oscillator"
+)
+
+// defaultMaxPeriod is the longest oscillator period looked for unless
+// configured otherwise; it covers the common oscillators up to the
+// pentadecathlon.
+const defaultMaxPeriod = 15
+
+// Structure is a still life or oscillator found on the grid.
+type Structure struct {
+	// ID is derived from the cells, so it is stable while the structure
+	// lives.
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Period int    `json:"period"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Cells are the cells alive in any phase.
+	Cells []int `json:"cells"`
+}
+
+// structureDetector keeps the standalone engine's recent generations and
+// finds the structures that repeat in place. Spaceships and guns change
+// their extent, so they are not reported.
+type structureDetector struct {
+	grid      GridGeometry
+	maxPeriod int
+
+	mu      sync.Mutex
+	history []*cellBits // oldest first
+}
+
+func newStructureDetector(grid GridGeometry, maxPeriod int) *structureDetector {
+	return &structureDetector{grid: grid, maxPeriod: maxPeriod}
+}
+
+// Record adds a generation, whose bitset must not change afterwards, e.g.
+// one of the engine's snapshots. Its methods are safe to call on a nil
+// detector.
+func (d *structureDetector) Record(alive *cellBits) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Period p is confirmed over two repeats, so keep 2*maxPeriod+1.
+	if keep := 2*d.maxPeriod + 1; len(d.history) >= keep {
+		d.history = append(d.history[:0], d.history[len(d.history)-keep+1:]...)
+	}
+	d.history = append(d.history, alive)
+}
+
+// Detect returns the structures of the newest generation, largest first.
+func (d *structureDetector) Detect() []Structure {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	history := d.history
+	d.mu.Unlock()
+	if len(history) < 3 {
+		return nil
+	}
+
+	var out []Structure
+	for _, component := range d.components(history[len(history)-1]) {
+		if s, ok := d.classify(history, component); ok {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if len(out[a].Cells) != len(out[b].Cells) {
+			return len(out[a].Cells) > len(out[b].Cells)
+		}
+		return out[a].ID < out[b].ID
+	})
+	return out
+}
+
+// components groups live cells close enough to interact: within two cells of
+// each other they share a neighbor.
+func (d *structureDetector) components(cur *cellBits) [][]int {
+	seen := make(map[int]bool)
+	var out [][]int
+	for i := 0; i < d.grid.Size(); i++ {
+		if !cur.Has(i) || seen[i] {
+			continue
+		}
+		component := []int{i}
+		seen[i] = true
+		for k := 0; k < len(component); k++ {
+			x, y := d.grid.Coords(component[k])
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					if !d.grid.Contains(x+dx, y+dy) {
+						continue
+					}
+					n := d.grid.Index(x+dx, y+dy)
+					if cur.Has(n) && !seen[n] {
+						seen[n] = true
+						component = append(component, n)
+					}
+				}
+			}
+		}
+		out = append(out, component)
+	}
+	return out
+}
+
+// classify finds the smallest period with which the component repeats in
+// place: every phase of the last two periods matches the phase a period
+// later, within a box that holds all of them and an empty ring around it.
+func (d *structureDetector) classify(history []*cellBits, component []int) (Structure, bool) {
+	b := d.bounds(component)
+	t := len(history) - 1
+	for p := 1; p <= d.maxPeriod && 2*p <= t; p++ {
+		// Quick check on the newest phase before collecting the others.
+		if !d.repeats(history[t-p], history[t], b.grow(1)) {
+			continue
+		}
+		// Other phases may reach a little further than the newest one.
+		var cells []int
+		search := b.grow(2)
+		for y := search.y0; y <= search.y1; y++ {
+			for x := search.x0; x <= search.x1; x++ {
+				if !d.grid.Contains(x, y) {
+					continue
+				}
+				i := d.grid.Index(x, y)
+				for k := t - p + 1; k <= t; k++ {
+					if history[k].Has(i) {
+						cells = append(cells, i)
+						break
+					}
+				}
+			}
+		}
+		outer := d.bounds(cells).grow(1)
+		ok := true
+		for k := t - 2*p; k <= t-p && ok; k++ {
+			ok = d.repeats(history[k], history[k+p], outer) && d.ringEmpty(history[k+p], outer)
+		}
+		if !ok {
+			continue
+		}
+		inner := outer.grow(-1)
+		s := Structure{Kind: structureOscillator, Period: p, X: inner.x0, Y: inner.y0, Width: inner.x1 - inner.x0 + 1, Height: inner.y1 - inner.y0 + 1, Cells: cells}
+		if p == 1 {
+			s.Kind = structureStillLife
+		}
+		s.ID = structureID(cells)
+		return s, true
+	}
+	return Structure{}, false
+}
+
+// box is an inclusive rectangle of cells.
+type box struct{ x0, y0, x1, y1 int }
+
+func (d *structureDetector) bounds(cells []int) box {
+	x, y := d.grid.Coords(cells[0])
+	b := box{x, y, x, y}
+	for _, i := range cells[1:] {
+		x, y := d.grid.Coords(i)
+		b = box{min(b.x0, x), min(b.y0, y), max(b.x1, x), max(b.y1, y)}
+	}
+	return b
+}
+
+// grow widens the box by n cells on every side; the result may extend past
+// the grid, which reads as dead.
+func (b box) grow(n int) box {
+	return box{b.x0 - n, b.y0 - n, b.x1 + n, b.y1 + n}
+}
+
+func (d *structureDetector) alive(bits *cellBits, x, y int) bool {
+	return d.grid.Contains(x, y) && bits.Has(d.grid.Index(x, y))
+}
+
+// repeats reports whether two generations agree within the box.
+func (d *structureDetector) repeats(a, b *cellBits, r box) bool {
+	for y := r.y0; y <= r.y1; y++ {
+		for x := r.x0; x <= r.x1; x++ {
+			if d.alive(a, x, y) != d.alive(b, x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ringEmpty reports whether the outermost cells of the box are dead.
+func (d *structureDetector) ringEmpty(bits *cellBits, r box) bool {
+	for y := r.y0; y <= r.y1; y++ {
+		for x := r.x0; x <= r.x1; x++ {
+			edge := x == r.x0 || x == r.x1 || y == r.y0 || y == r.y1
+			if edge && d.alive(bits, x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func structureID(cells []int) string {
+	h := sha256.New()
+	for _, i := range cells {
+		binary.Write(h, binary.BigEndian, uint32(i))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var summariesDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_summaries_dropped_total",
+	Help: "Generation summaries not sent to a listener that was still busy with earlier ones.",
+})
+
+// summaryQueue is how many summaries a listener may fall behind by before it
+// misses some.
+const summaryQueue = 16
+
+// summaryStream turns generations into one-line descriptions, e.g.
+//
+//	generation 1042: 3 births, 5 deaths, population 87, a glider is moving northeast
+//
+// for screen readers and text dashboards. Summaries are only written while
+// someone listens; its methods are safe to call on a nil stream.
+type summaryStream struct {
+	grid       GridGeometry
+	structures *structureDetector
+
+	generations chan summaryInput
+	listening   atomic.Int32
+
+	mu        sync.Mutex
+	listeners map[chan summary]bool
+}
+
+// summary is one generation's description.
+type summary struct {
+	generation int64
+	text       string
+}
+
+type summaryInput struct {
+	sample StatsSample
+	view   *gridSnapshot
+}
+
+func newSummaryStream(grid GridGeometry, structures *structureDetector) *summaryStream {
+	return &summaryStream{
+		grid:        grid,
+		structures:  structures,
+		generations: make(chan summaryInput, 1),
+		listeners:   map[chan summary]bool{},
+	}
+}
+
+// Record hands a generation to the stream without waiting; when the stream
+// is still describing an older one, the newest wins.
+func (s *summaryStream) Record(sample StatsSample, view *gridSnapshot) {
+	if s == nil || s.listening.Load() == 0 {
+		return
+	}
+	in := summaryInput{sample, view}
+	select {
+	case s.generations <- in:
+		return
+	default:
+	}
+	select {
+	case <-s.generations:
+	default:
+	}
+	select {
+	case s.generations <- in:
+	default:
+	}
+}
+
+// Listeners returns how many clients follow the stream.
+func (s *summaryStream) Listeners() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.listening.Load())
+}
+
+// Run describes generations and sends them to the listeners until ctx is
+// done.
+func (s *summaryStream) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case in := <-s.generations:
+			sum := summary{in.sample.Generation, s.summarize(in.sample, in.view)}
+			s.mu.Lock()
+			for ch := range s.listeners {
+				select {
+				case ch <- sum:
+				default:
+					summariesDropped.Inc()
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *summaryStream) subscribe() chan summary {
+	ch := make(chan summary, summaryQueue)
+	s.mu.Lock()
+	s.listeners[ch] = true
+	s.mu.Unlock()
+	s.listening.Add(1)
+	return ch
+}
+
+func (s *summaryStream) unsubscribe(ch chan summary) {
+	s.mu.Lock()
+	delete(s.listeners, ch)
+	s.mu.Unlock()
+	s.listening.Add(-1)
+}
+
+// summarize describes a generation: what changed, then the structures and
+// gliders on the grid.
+func (s *summaryStream) summarize(sample StatsSample, view *gridSnapshot) string {
+	parts := []string{
+		plural(sample.Births, "birth", "births"),
+		plural(sample.Deaths, "death", "deaths"),
+		fmt.Sprintf("population %d", sample.Population),
+	}
+	if sample.Population == 0 {
+		parts[2] = "the grid is empty"
+	}
+
+	stillLifes, oscillators := 0, map[int]int{}
+	for _, st := range s.structures.Detect() {
+		if st.Kind == structureStillLife {
+			stillLifes++
+		} else {
+			oscillators[st.Period]++
+		}
+	}
+	if stillLifes > 0 {
+		parts = append(parts, plural(stillLifes, "still life", "still lifes"))
+	}
+	periods := make([]int, 0, len(oscillators))
+	for p := range oscillators {
+		periods = append(periods, p)
+	}
+	sort.Ints(periods)
+	for _, p := range periods {
+		name := fmt.Sprintf("period-%d oscillator", p)
+		parts = append(parts, plural(oscillators[p], name, name+"s"))
+	}
+
+	headings := s.gliders(view)
+	for _, heading := range compassHeadings {
+		switch n := headings[heading]; n {
+		case 0:
+		case 1:
+			parts = append(parts, "a glider is moving "+heading)
+		default:
+			parts = append(parts, fmt.Sprintf("%d gliders are moving %s", n, heading))
+		}
+	}
+	return fmt.Sprintf("generation %d: %s", sample.Generation, strings.Join(parts, ", "))
+}
+
+// compassHeadings name the diagonals gliders move along; north is the top
+// row of the grid.
+var compassHeadings = []string{"northeast", "southeast", "southwest", "northwest"}
+
+// gliders counts the isolated gliders on the grid by heading.
+func (s *summaryStream) gliders(view *gridSnapshot) map[string]int {
+	live := make(map[int]int, len(view.cells))
+	for _, i := range view.cells {
+		live[i] = i
+	}
+	headings := map[string]int{}
+	for y := 0; y+3 <= s.grid.Height; y++ {
+		for x := 0; x+3 <= s.grid.Width; x++ {
+			if _, h, ok := gliderAt(s.grid, live, x, y); ok {
+				ns, ew := "south", "east"
+				if h[1] < 0 {
+					ns = "north"
+				}
+				if h[0] < 0 {
+					ew = "west"
+				}
+				headings[ns+ew]++
+			}
+		}
+	}
+	return headings
+}
+
+func plural(n int, one, many string) string {
+	switch n {
+	case 0:
+		return "no " + many
+	case 1:
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// handleSummaries serves GET /api/summaries, a low-bandwidth alternative to
+// the event stream: one line of text per generation. It is plain text, one
+// summary per line, or server-sent events when the client accepts
+// text/event-stream. ?every=N sends every Nth generation only, e.g. for a
+// screen reader that should not speak every tick.
+func handleSummaries(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Summaries require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	every := int64(1)
+	if v := r.URL.Query().Get("every"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid every", http.StatusBadRequest)
+			return
+		}
+		every = n
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if !admitClient(w) {
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := s.summaries.subscribe()
+	defer s.summaries.unsubscribe(ch)
+
+	// Comments keep proxies from timing the event stream out.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case sum := <-ch:
+			if sum.generation%every != 0 {
+				continue
+			}
+			if sse {
+				_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", sum.generation, sum.text)
+			} else {
+				_, err = fmt.Fprintln(w, sum.text)
+			}
+		case <-keepalive.C:
+			if !sse {
+				continue
+			}
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kinds of object that materialize the standalone engine's cells
+// (--cell-objects).
+const (
+	cellObjectPods        = "pods"
+	cellObjectDeployments = "deployments"
+	cellObjectJobs        = "jobs"
+)
+
+// maxIntensity is the replica count of a super-cell at full intensity.
+const maxIntensity = 3
+
+// superCellManager materializes the standalone engine's grid as one
+// Deployment per cell, a super-cell, whose replica count encodes the cell's
+// intensity: 0 is dead, 1 to maxIntensity are shades of alive. Every cell
+// keeps its Deployment, so a generation only scales existing objects.
+type superCellManager struct {
+	clientset   kubernetes.Interface
+	namespace   string
+	grid        GridGeometry
+	image       string
+	deployments appslisters.DeploymentLister
+	// intensity returns the replicas a cell should run, 0 when it is dead.
+	intensity func(index int) int32
+
+	trigger chan struct{}
+}
+
+func newSuperCellManager(clientset kubernetes.Interface, namespace string, grid GridGeometry, image string, deployments appslisters.DeploymentLister) *superCellManager {
+	return &superCellManager{
+		clientset:   clientset,
+		namespace:   namespace,
+		grid:        grid,
+		image:       image,
+		deployments: deployments,
+		trigger:     make(chan struct{}, 1),
+	}
+}
+
+// engineIntensity grades live cells by their energy when the energy economy
+// runs, and otherwise by how many live neighbors they have.
+func engineIntensity(e *Engine, energy *energyLedger) func(index int) int32 {
+	return func(index int) int32 {
+		if !e.Alive(index) {
+			return 0
+		}
+		if f, ok := energy.Fraction(index); ok {
+			return min(maxIntensity, 1+int32(f*maxIntensity))
+		}
+		return min(maxIntensity, 1+int32(len(e.LiveNeighbors(index)))/2)
+	}
+}
+
+// deploymentFor builds the super-cell of a grid index. Its pods run the
+// worker in passive mode under the cell's name, and are labeled app=super-cell
+// so that they are not taken for cell pods.
+func (m *superCellManager) deploymentFor(index int, replicas int32) *appsv1.Deployment {
+	name := cellName(index)
+	selector := map[string]string{"app": "super-cell", "cell": strconv.Itoa(index)}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.namespace,
+			Labels:    map[string]string{"app": "super-cell", "cell": strconv.Itoa(index), "managed-by": "grid-controller"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: selector},
+				Spec: v1.PodSpec{
+					ServiceAccountName: "cell",
+					Containers: []v1.Container{{
+						Name:            "worker",
+						Image:           m.image,
+						ImagePullPolicy: v1.PullAlways,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceMemory: resource.MustParse("5Mi"),
+								v1.ResourceCPU:    resource.MustParse("10m"),
+							},
+							Limits: v1.ResourceList{
+								v1.ResourceMemory: resource.MustParse("10Mi"),
+								v1.ResourceCPU:    resource.MustParse("50m"),
+							},
+						},
+						EnvFrom: []v1.EnvFromSource{{
+							ConfigMapRef: &v1.ConfigMapEnvSource{
+								LocalObjectReference: v1.LocalObjectReference{Name: "cell-config"},
+							},
+						}},
+						Env: []v1.EnvVar{
+							{Name: "RUST_LOG", Value: "info"},
+							{Name: "HOSTNAME", Value: name},
+							{Name: "CELL_MODE", Value: "passive"},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// Resync asks the manager to reconcile as soon as possible.
+func (m *superCellManager) Resync() {
+	if m == nil {
+		return
+	}
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *superCellManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.reconcile(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.trigger:
+		}
+	}
+}
+
+// reconcile creates missing super-cells, scales those whose replicas differ
+// from their cell's intensity and deletes those outside the grid.
+func (m *superCellManager) reconcile(ctx context.Context) {
+	api := m.clientset.AppsV1().Deployments(m.namespace)
+	drift := map[string]int{}
+	for i := 0; i < m.grid.Size(); i++ {
+		want := m.intensity(i)
+		d, err := m.deployments.Deployments(m.namespace).Get(cellName(i))
+		switch {
+		case apierrors.IsNotFound(err):
+			drift[driftMissing]++
+			_, err = api.Create(ctx, m.deploymentFor(i, want), metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				err = nil
+			}
+		case err != nil:
+		case d.Spec.Replicas == nil || *d.Spec.Replicas != want:
+			patch := fmt.Appendf(nil, `{"spec":{"replicas":%d}}`, want)
+			_, err = api.Patch(ctx, d.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		}
+		if err != nil {
+			log.Printf("Super-cells: %s: %v", cellName(i), err)
+		}
+	}
+
+	list, err := m.deployments.Deployments(m.namespace).List(labels.SelectorFromSet(labels.Set{"app": "super-cell", "managed-by": "grid-controller"}))
+	if err != nil {
+		return
+	}
+	for _, d := range list {
+		if i, ok := cellIndex(d.Name); ok && cellName(i) == d.Name && i < m.grid.Size() {
+			continue
+		}
+		drift[driftDuplicated]++
+		if err := api.Delete(ctx, d.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Super-cells: delete %s: %v", d.Name, err)
+		}
+	}
+	if len(drift) > 0 {
+		log.Printf("Super-cells: repaired drift %v", drift)
+	}
+}
+
+// handleSuperCellUpdate streams a super-cell's state and intensity.
+func handleSuperCellUpdate(obj interface{}) {
+	d, ok := obj.(*appsv1.Deployment)
+	if !ok || d.Labels["app"] != "super-cell" {
+		return
+	}
+	if _, ok := cellIndex(d.Name); !ok {
+		return
+	}
+	update := CellUpdate{
+		Name:      federatedName(d.Name),
+		Status:    "dead",
+		Namespace: d.Namespace,
+		Grid:      gridID,
+	}
+	switch {
+	case d.DeletionTimestamp != nil:
+		update.Status = "deleted"
+	case d.Spec.Replicas != nil && *d.Spec.Replicas > 0:
+		update.Status, update.Intensity = "initializing", int(*d.Spec.Replicas)
+		if d.Status.ReadyReplicas > 0 {
+			update.Status = "alive"
+		}
+	}
+	publish(msgCell, update)
+}
+
+func handleSuperCellDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	d, ok := obj.(*appsv1.Deployment)
+	if !ok || d.Labels["app"] != "super-cell" {
+		return
+	}
+	publish(msgCell, CellUpdate{Name: federatedName(d.Name), Status: "deleted", Namespace: d.Namespace, Grid: gridID})
+}
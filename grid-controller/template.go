@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// templateAnnotation records the hash of the cell template a pod was built
+// from, so a re-materialize can tell which pods are stale.
+const templateAnnotation = "cellular-automaton/template"
+
+// CellTemplate customizes the cell pods the controller creates, e.g.
+//
+//	cellTemplate:
+//	  image: ghcr.io/example/cell-worker:v2
+//	  resources:
+//	    requests: {cpu: 20m, memory: 8Mi}
+//	    limits: {cpu: 100m, memory: 16Mi}
+//	  labels:
+//	    team: automata
+//	  tolerations:
+//	  - key: dedicated
+//	    operator: Equal
+//	    value: cells
+//	    effect: NoSchedule
+//
+// Unset fields keep the defaults: the --cell-image image and the requests
+// and limits of k8s/cells.yaml. The template can be replaced at runtime with
+// PUT /api/cells/template; it applies to pods created from then on, and
+// POST /api/cells/rematerialize rolls it out to the existing ones.
+type CellTemplate struct {
+	Image       string                   `json:"image,omitempty"`
+	Resources   *v1.ResourceRequirements `json:"resources,omitempty"`
+	Labels      map[string]string        `json:"labels,omitempty"`
+	Tolerations []v1.Toleration          `json:"tolerations,omitempty"`
+}
+
+// reservedCellLabels are set by the controller and select cells elsewhere.
+var reservedCellLabels = []string{"app", "managed-by", "game-status", "statefulset.kubernetes.io/pod-name"}
+
+func (t *CellTemplate) validate() error {
+	if t.Image != "" && strings.ContainsAny(t.Image, " \t\n") {
+		return fmt.Errorf("image %q is not a valid image reference", t.Image)
+	}
+	if t.Resources != nil {
+		for name, limit := range t.Resources.Limits {
+			if name != v1.ResourceCPU && name != v1.ResourceMemory {
+				return fmt.Errorf("resources: unsupported resource %q", name)
+			}
+			if request, ok := t.Resources.Requests[name]; ok && request.Cmp(limit) > 0 {
+				return fmt.Errorf("resources: %s request %s exceeds its limit %s", name, request.String(), limit.String())
+			}
+		}
+		for name, request := range t.Resources.Requests {
+			if name != v1.ResourceCPU && name != v1.ResourceMemory {
+				return fmt.Errorf("resources: unsupported resource %q", name)
+			}
+			if request.Sign() <= 0 {
+				return fmt.Errorf("resources: %s request must be positive", name)
+			}
+		}
+	}
+	for key, value := range t.Labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("label %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("label %q: %s", key, strings.Join(errs, "; "))
+		}
+		for _, reserved := range reservedCellLabels {
+			if key == reserved {
+				return fmt.Errorf("label %q is set by the controller", key)
+			}
+		}
+	}
+	for i, tol := range t.Tolerations {
+		switch tol.Operator {
+		case "", v1.TolerationOpEqual:
+		case v1.TolerationOpExists:
+			if tol.Value != "" {
+				return fmt.Errorf("toleration %d: operator Exists takes no value", i)
+			}
+		default:
+			return fmt.Errorf("toleration %d: unknown operator %q", i, tol.Operator)
+		}
+		switch tol.Effect {
+		case "", v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("toleration %d: unknown effect %q", i, tol.Effect)
+		}
+		if tol.Key == "" && tol.Operator != v1.TolerationOpExists {
+			return fmt.Errorf("toleration %d: an empty key requires operator Exists", i)
+		}
+		if tol.TolerationSeconds != nil && tol.Effect != v1.TaintEffectNoExecute {
+			return fmt.Errorf("toleration %d: tolerationSeconds requires effect NoExecute", i)
+		}
+	}
+	return nil
+}
+
+// apply stamps the template onto a pod built by podFor.
+func (t *CellTemplate) apply(pod *v1.Pod) {
+	c := &pod.Spec.Containers[0]
+	if t.Image != "" {
+		c.Image = t.Image
+	}
+	if t.Resources != nil {
+		for name, q := range t.Resources.Requests {
+			c.Resources.Requests[name] = q
+		}
+		for name, q := range t.Resources.Limits {
+			c.Resources.Limits[name] = q
+		}
+	}
+	for key, value := range t.Labels {
+		pod.Labels[key] = value
+	}
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, t.Tolerations...)
+}
+
+// hash identifies the pods built from the template with the given default
+// image.
+func (t *CellTemplate) hash(image string) string {
+	data, _ := json.Marshal(struct {
+		Default  string        `json:"default"`
+		Template *CellTemplate `json:"template"`
+	}{image, t})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Template returns the current cell template.
+func (m *cellPodManager) Template() CellTemplate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.template
+}
+
+// SetTemplate replaces the cell template for the pods created from now on.
+func (m *cellPodManager) SetTemplate(t CellTemplate) {
+	m.mu.Lock()
+	m.template = t
+	m.templateVersion++
+	m.mu.Unlock()
+	log.Printf("Cells: template %s", t.hash(m.image))
+}
+
+// templateFor returns the template of a cell's pod: the cell template, with
+// the canary image in the cells of a canary rollout.
+func (m *cellPodManager) templateFor(index int) CellTemplate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.template
+	if m.canaryImage != "" && m.canaryCells(index) {
+		t.Image = m.canaryImage
+	}
+	return t
+}
+
+// SetCanary builds the pods of the cells in with the canary image from now
+// on; an empty image ends the canary.
+func (m *cellPodManager) SetCanary(image string, in func(index int) bool) {
+	m.mu.Lock()
+	m.canaryImage, m.canaryCells = image, in
+	m.templateVersion++
+	m.mu.Unlock()
+}
+
+var rematerializedPods = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_rematerialized_pods_total",
+	Help: "Cell pods replaced to roll out a new cell template.",
+})
+
+// Rollout reports the progress of a re-materialize.
+type Rollout struct {
+	Template string     `json:"template"`
+	Batch    int        `json:"batch"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Replaced int        `json:"replaced"`
+	// Remaining counts the pods still built from an older template.
+	Remaining int    `json:"remaining"`
+	Error     string `json:"error,omitempty"`
+}
+
+// rematerializeTimeout bounds how long a batch may take to come back up.
+const rematerializeTimeout = 2 * time.Minute
+
+// rollout replaces cell pods built from an older template, a batch at a
+// time. Replaced pods are marked as such, so the engine keeps their cells
+// alive and the pattern survives.
+type rollout struct {
+	mu     sync.Mutex
+	status *Rollout
+}
+
+// Status returns the progress of the current or last re-materialize, or nil.
+func (r *rollout) Status() *Rollout {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status == nil {
+		return nil
+	}
+	s := *r.status
+	return &s
+}
+
+func (r *rollout) update(f func(s *Rollout)) {
+	r.mu.Lock()
+	f(r.status)
+	r.mu.Unlock()
+}
+
+// stalePods lists the cell pods not built from their current template, in
+// cell order.
+func (m *cellPodManager) stalePods() ([]string, error) {
+	list, err := m.pods.Pods(m.namespace).List(labels.SelectorFromSet(labels.Set{"app": "cell", "managed-by": "grid-controller"}))
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, pod := range list {
+		i, ok := cellIndex(pod.Name)
+		if !ok || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if template := m.templateFor(i); pod.Annotations[templateAnnotation] != template.hash(m.image) {
+			stale = append(stale, pod.Name)
+		}
+	}
+	sort.Slice(stale, func(a, b int) bool {
+		i, _ := cellIndex(stale[a])
+		j, _ := cellIndex(stale[b])
+		return i < j
+	})
+	return stale, nil
+}
+
+// Rematerialize starts replacing the cell pods built from an older template,
+// batch pods at a time; it fails if a re-materialize is already running.
+func (m *cellPodManager) Rematerialize(ctx context.Context, batch int) (*Rollout, error) {
+	m.mu.Lock()
+	template, version := m.template, m.templateVersion
+	m.mu.Unlock()
+	hash := template.hash(m.image)
+	m.rollout.mu.Lock()
+	if s := m.rollout.status; s != nil && s.Finished == nil {
+		m.rollout.mu.Unlock()
+		return nil, errors.New("a re-materialize is already running")
+	}
+	m.rollout.status = &Rollout{Template: hash, Batch: batch, Started: time.Now()}
+	m.rollout.mu.Unlock()
+
+	log.Printf("Cells: re-materializing onto template %s, %d pods at a time", hash, batch)
+	go func() {
+		err := m.rematerialize(ctx, version, batch)
+		now := time.Now()
+		m.rollout.update(func(s *Rollout) {
+			s.Finished = &now
+			if err != nil {
+				s.Error = err.Error()
+			}
+		})
+		if err != nil {
+			log.Printf("Cells: re-materialize: %v", err)
+			return
+		}
+		log.Printf("Cells: re-materialized onto template %s", hash)
+	}()
+	return m.rollout.Status(), nil
+}
+
+func (m *cellPodManager) rematerialize(ctx context.Context, version, batch int) error {
+	for {
+		stale, err := m.stalePods()
+		if err != nil {
+			return err
+		}
+		m.rollout.update(func(s *Rollout) { s.Remaining = len(stale) })
+		if len(stale) == 0 {
+			return nil
+		}
+		m.mu.Lock()
+		changed := m.templateVersion != version
+		m.mu.Unlock()
+		if changed {
+			return errors.New("the template changed during the re-materialize")
+		}
+
+		stale = stale[:min(batch, len(stale))]
+		for _, name := range stale {
+			m.mu.Lock()
+			m.replacing[name] = true
+			m.mu.Unlock()
+			err := podWrites.Do(ctx, verbDelete, func(ctx context.Context) error {
+				return m.clientset.CoreV1().Pods(m.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			})
+			if err != nil && !apierrors.IsNotFound(err) {
+				m.Forget(name)
+				return fmt.Errorf("delete %s: %w", name, err)
+			}
+			rematerializedPods.Inc()
+		}
+		m.Resync()
+		if err := m.awaitReplaced(ctx, stale); err != nil {
+			return err
+		}
+		m.rollout.update(func(s *Rollout) { s.Replaced += len(stale) })
+	}
+}
+
+// awaitReplaced waits until each named pod is running from its template, or
+// its cell no longer wants a pod.
+func (m *cellPodManager) awaitReplaced(ctx context.Context, names []string) error {
+	ctx, cancel := context.WithTimeout(ctx, rematerializeTimeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		waiting := 0
+		for _, name := range names {
+			i, _ := cellIndex(name)
+			template := m.templateFor(i)
+			pod, err := m.pods.Pods(m.namespace).Get(name)
+			switch {
+			case apierrors.IsNotFound(err) && m.desired != nil && !m.desired(i):
+			case err != nil || pod.Annotations[templateAnnotation] != template.hash(m.image) || pod.Status.Phase != v1.PodRunning:
+				waiting++
+			}
+		}
+		if waiting == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d of %d replaced pods did not come up: %w", waiting, len(names), ctx.Err())
+		case <-ticker.C:
+			m.Resync()
+		}
+	}
+}
+
+// handleCellTemplate serves GET /api/cells/template, the template of new
+// cell pods, and PUT /api/cells/template, which replaces it.
+func handleCellTemplate(w http.ResponseWriter, r *http.Request, m *cellPodManager) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if m == nil {
+		http.Error(w, "Cell templates require --engine=standalone or --placement", http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		if !requireAdmin(w, r) {
+			return
+		}
+		var t CellTemplate
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16384))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&t); err != nil {
+			http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := t.validate(); err != nil {
+			http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.SetTemplate(t)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Template())
+}
+
+// handleRematerialize serves GET /api/cells/rematerialize, the progress of
+// the last re-materialize, and POST /api/cells/rematerialize?batch=, which
+// replaces the cell pods built from an older template, batch at a time
+// (default 5).
+func handleRematerialize(w http.ResponseWriter, r *http.Request, m *cellPodManager, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	// Only the standalone engine keeps cell state outside the pods; workers
+	// would lose theirs.
+	if s == nil || m == nil {
+		http.Error(w, "Re-materializing requires --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		status := m.rollout.Status()
+		if status == nil {
+			http.Error(w, "No re-materialize has run", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	case "POST":
+		if !requireRole(w, r, roleOperator) {
+			return
+		}
+		batch := 5
+		if v := r.URL.Query().Get("batch"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid batch", http.StatusBadRequest)
+				return
+			}
+			batch = n
+		}
+		// The rollout outlives the request.
+		status, err := m.Rematerialize(context.WithoutCancel(r.Context()), batch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
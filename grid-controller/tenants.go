@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// Tenant is a user allowed to create their own grids through /api/grids, e.g.
+//
+//	tenants:
+//	- name: alice
+//	  tokenSHA256: 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824
+//	  maxGrids: 2
+//	  maxCells: 400
+//
+// Only the SHA-256 of the bearer token is configured, so the config file can
+// live in a ConfigMap.
+type Tenant struct {
+	Name        string `json:"name"`
+	TokenSHA256 string `json:"tokenSHA256"`
+	// MaxGrids is how many grids the tenant may own at once.
+	MaxGrids int `json:"maxGrids"`
+	// MaxCells bounds the width times height of each of the tenant's grids.
+	MaxCells int `json:"maxCells"`
+}
+
+func (t Tenant) validate() error {
+	if !gridNamePattern.MatchString(t.Name) {
+		return errors.New("name must be a DNS label")
+	}
+	if b, err := hex.DecodeString(t.TokenSHA256); err != nil || len(b) != sha256.Size {
+		return errors.New("tokenSHA256 must be a hex SHA-256 digest")
+	}
+	if t.MaxGrids <= 0 || t.MaxCells <= 0 {
+		return errors.New("maxGrids and maxCells must be positive")
+	}
+	return nil
+}
+
+// tenants is set from the configuration file at startup.
+var tenants []Tenant
+
+// tenantFor returns the tenant owning the request's bearer token, or nil.
+func tenantFor(r *http.Request) *Tenant {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+	for i := range tenants {
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(tenants[i].TokenSHA256)) == 1 {
+			return &tenants[i]
+		}
+	}
+	return nil
+}
+
+// requireTenant admits administrators, for whom it returns a nil tenant, and
+// tenants. Anyone else gets the error response.
+func requireTenant(w http.ResponseWriter, r *http.Request) (*Tenant, bool) {
+	if isAdmin(r) {
+		return nil, true
+	}
+	if t := tenantFor(r); t != nil {
+		return t, true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return nil, false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// themeConfigMap holds the dashboard theme in the controller's namespace.
+const themeConfigMap = "grid-theme"
+
+// Cell shapes.
+const (
+	shapeSquare  = "square"
+	shapeRounded = "rounded"
+	shapeCircle  = "circle"
+)
+
+// Theme restyles the dashboard without rebuilding it, e.g.
+//
+//	{
+//	  "states": {"alive": "#22c55e", "dead": "#1f2937"},
+//	  "species": {"hardy": "#eab308", "fertile": "#ec4899"},
+//	  "cellShape": "circle",
+//	  "background": "#111827"
+//	}
+//
+// States colors cells by pod status, or by condition (dying, sick, zombie)
+// where the pod has one, and Species by genetics trait; states
+// and species left out keep the dashboard's built-in colors. Colors are
+// hex, so a theme cannot inject CSS.
+type Theme struct {
+	States     map[string]string `json:"states,omitempty"`
+	Species    map[string]string `json:"species,omitempty"`
+	CellShape  string            `json:"cellShape,omitempty"`
+	Background string            `json:"background,omitempty"`
+	// Updated is set by the controller when the theme is stored.
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+var (
+	themeColor   = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+	themeSpecies = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+	themeStates  = map[string]bool{"alive": true, "dead": true, "initializing": true, "terminating": true, "deleted": true, "unknown": true, conditionDying: true, conditionSick: true, conditionZombie: true, statusSpark: true, statusFizzled: true, statusFailed: true}
+)
+
+// maxThemeSpecies caps the species colors of a theme.
+const maxThemeSpecies = 32
+
+func (t *Theme) validate() error {
+	for state, color := range t.States {
+		if !themeStates[state] {
+			return fmt.Errorf("unknown state %q", state)
+		}
+		if !themeColor.MatchString(color) {
+			return fmt.Errorf("state %s: %q is not a hex color", state, color)
+		}
+	}
+	if len(t.Species) > maxThemeSpecies {
+		return fmt.Errorf("at most %d species", maxThemeSpecies)
+	}
+	for species, color := range t.Species {
+		if !themeSpecies.MatchString(species) {
+			return fmt.Errorf("invalid species name %q", species)
+		}
+		if !themeColor.MatchString(color) {
+			return fmt.Errorf("species %s: %q is not a hex color", species, color)
+		}
+	}
+	switch t.CellShape {
+	case "", shapeSquare, shapeRounded, shapeCircle:
+	default:
+		return fmt.Errorf("cellShape must be %s, %s or %s", shapeSquare, shapeRounded, shapeCircle)
+	}
+	if t.Background != "" && !themeColor.MatchString(t.Background) {
+		return fmt.Errorf("background: %q is not a hex color", t.Background)
+	}
+	return nil
+}
+
+// themeStore keeps the theme in a ConfigMap so it survives restarts.
+type themeStore struct {
+	clientset kubernetes.Interface
+	namespace string
+
+	mu    sync.Mutex
+	theme Theme
+}
+
+// newThemeStore loads the stored theme; without one the dashboard's
+// built-in look applies.
+func newThemeStore(ctx context.Context, clientset kubernetes.Interface, namespace string) *themeStore {
+	s := &themeStore{clientset: clientset, namespace: namespace}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, themeConfigMap, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		log.Printf("Theme: load: %v", err)
+	default:
+		var t Theme
+		if err := json.Unmarshal([]byte(cm.Data["theme.json"]), &t); err != nil || t.validate() != nil {
+			log.Printf("Theme: ignoring invalid %s ConfigMap", themeConfigMap)
+			break
+		}
+		s.theme = t
+	}
+	return s
+}
+
+// Theme returns the current theme.
+func (s *themeStore) Theme() Theme {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.theme
+}
+
+// Set stores a theme and sends it to every client; the zero theme restores
+// the built-in look.
+func (s *themeStore) Set(ctx context.Context, t Theme) error {
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	if t.States == nil && t.Species == nil && t.CellShape == "" && t.Background == "" {
+		t.Updated = nil
+		if err := configMaps.Delete(ctx, themeConfigMap, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	} else {
+		now := time.Now().UTC()
+		t.Updated = &now
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: themeConfigMap, Namespace: s.namespace},
+			Data:       map[string]string{"theme.json": string(data)},
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.theme = t
+	s.mu.Unlock()
+	publish(msgTheme, t)
+	return nil
+}
+
+// handleTheme serves GET /api/theme, the dashboard theme, PUT /api/theme,
+// which replaces it, and DELETE /api/theme, which restores the built-in
+// look. Changes are pushed to every dashboard as a theme message.
+func handleTheme(w http.ResponseWriter, r *http.Request, s *themeStore) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT", "DELETE":
+		if !requireAdmin(w, r) {
+			return
+		}
+		var t Theme
+		if r.Method == "PUT" {
+			dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16384))
+			dec.DisallowUnknownFields()
+			err := dec.Decode(&t)
+			if err == nil {
+				err = t.validate()
+			}
+			if err != nil {
+				http.Error(w, "Invalid theme: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if err := s.Set(ctx, t); err != nil {
+			http.Error(w, "Failed to store theme: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Theme: %s updated the dashboard theme", requestIdentity(r))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Theme())
+}
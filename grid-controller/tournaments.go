@@ -0,0 +1,658 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// A tournament pits bots against each other in head-to-head matches, every
+// bot playing every other once. Each match runs on a fresh grid of its own,
+// computed in the controller: the home bot owns the left half, the away bot
+// the right half. During a short setup and then while the match runs, each
+// bot places live cells of its colour in its own half, up to the move
+// budget. A cell born takes the colour most of its live neighbours had (on a
+// tie, that of the half it is born in), so patterns can invade and convert
+// the other side. After the last generation the bot with more live cells
+// wins; a win is worth 3 points, a draw 1.
+const (
+	tournamentPending  = "pending"
+	tournamentRunning  = "running"
+	tournamentFinished = "finished"
+
+	matchScheduled = "scheduled"
+	matchSetup     = "setup"
+	matchRunning   = "running"
+	matchFinished  = "finished"
+
+	// tournamentsKept bounds how many finished tournaments are remembered.
+	tournamentsKept    = 16
+	maxTournamentBots  = 16
+	maxTournamentCells = 4096
+	maxTournamentGens  = 2000
+)
+
+// Cell owners on a match grid.
+const (
+	ownerNone int8 = iota
+	ownerHome
+	ownerAway
+)
+
+// TournamentRequest is the body of POST /api/tournaments. Bots name
+// configured bots scoped to tournament.
+type TournamentRequest struct {
+	Name           string   `json:"name"`
+	Bots           []string `json:"bots"`
+	Rule           string   `json:"rule,omitempty"`
+	Width          int      `json:"width,omitempty"`
+	Height         int      `json:"height,omitempty"`
+	Generations    int      `json:"generations,omitempty"`
+	MoveBudget     int      `json:"moveBudget,omitempty"`
+	TickIntervalMs int      `json:"tickIntervalMs,omitempty"`
+	SetupSeconds   int      `json:"setupSeconds,omitempty"`
+}
+
+func (t *TournamentRequest) validate() error {
+	if !gridNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("name must be a DNS label")
+	}
+	if len(t.Bots) < 2 || len(t.Bots) > maxTournamentBots {
+		return fmt.Errorf("a tournament has 2 to %d bots", maxTournamentBots)
+	}
+	seen := map[string]bool{}
+	for _, name := range t.Bots {
+		b := botNamed(name)
+		if b == nil {
+			return fmt.Errorf("unknown bot %q", name)
+		}
+		if !b.scopes[botScopeTournament] {
+			return fmt.Errorf("bot %q is not scoped to %s", name, botScopeTournament)
+		}
+		if seen[name] {
+			return fmt.Errorf("bot %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	if t.Rule == "" {
+		t.Rule = lifeRule
+	}
+	if _, err := parseRule(t.Rule); err != nil {
+		return fmt.Errorf("rule: %w", err)
+	}
+	if t.Width == 0 && t.Height == 0 {
+		t.Width, t.Height = 32, 16
+	}
+	if t.Width < 4 || t.Height < 2 || t.Width*t.Height > maxTournamentCells {
+		return fmt.Errorf("grids are at least 4x2 and have at most %d cells", maxTournamentCells)
+	}
+	if t.Generations == 0 {
+		t.Generations = 200
+	}
+	if t.MoveBudget == 0 {
+		t.MoveBudget = 50
+	}
+	if t.TickIntervalMs == 0 {
+		t.TickIntervalMs = 200
+	}
+	if t.SetupSeconds == 0 {
+		t.SetupSeconds = 10
+	}
+	if t.Generations < 1 || t.Generations > maxTournamentGens || t.MoveBudget < 1 || t.TickIntervalMs < 10 || t.SetupSeconds < 0 {
+		return fmt.Errorf("generations must be 1 to %d, moveBudget positive and tickIntervalMs at least 10", maxTournamentGens)
+	}
+	return nil
+}
+
+// Tournament is a tournament's schedule, results and standings.
+type Tournament struct {
+	TournamentRequest
+	Status    string     `json:"status"`
+	Created   time.Time  `json:"created"`
+	Matches   []*Match   `json:"matches"`
+	Standings []Standing `json:"standings"`
+}
+
+// Match is one head-to-head game. Moves are the cells each bot may still
+// place; scores are its live cells.
+type Match struct {
+	ID         int        `json:"id"`
+	Home       string     `json:"home"`
+	Away       string     `json:"away"`
+	Status     string     `json:"status"`
+	Generation int64      `json:"generation"`
+	HomeMoves  int        `json:"homeMoves"`
+	AwayMoves  int        `json:"awayMoves"`
+	HomeScore  int        `json:"homeScore"`
+	AwayScore  int        `json:"awayScore"`
+	Winner     string     `json:"winner,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+
+	grid  *arenaGrid
+	moves []ReplayMove
+	// attestation is signed when the match ends.
+	attestation *MatchAttestation
+}
+
+// Standing is one bot's record in a tournament.
+type Standing struct {
+	Bot    string `json:"bot"`
+	Played int    `json:"played"`
+	Won    int    `json:"won"`
+	Drawn  int    `json:"drawn"`
+	Lost   int    `json:"lost"`
+	Points int    `json:"points"`
+}
+
+// MatchBoard is a match with the live cells of each side, as served and
+// streamed while it is played.
+type MatchBoard struct {
+	Tournament string `json:"tournament"`
+	Match
+	Width  int   `json:"width"`
+	Height int   `json:"height"`
+	Home   []int `json:"homeCells"`
+	Away   []int `json:"awayCells"`
+}
+
+// MoveRequest is the body of POST /api/tournaments/{name}/matches/{id}/moves.
+type MoveRequest struct {
+	Cells []int `json:"cells"`
+}
+
+var tournamentMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "grid_tournament_matches_total",
+	Help: "Tournament matches played, by outcome: win or draw.",
+}, []string{"outcome"})
+
+// arena runs tournaments, one match at a time in the order they were
+// scheduled.
+type arena struct {
+	mu          sync.Mutex
+	tournaments []*Tournament
+	wake        chan struct{}
+}
+
+func newArena() *arena {
+	return &arena{wake: make(chan struct{}, 1)}
+}
+
+// Create schedules a tournament's matches.
+func (a *arena) Create(req TournamentRequest) (*Tournament, error) {
+	t := &Tournament{TournamentRequest: req, Status: tournamentPending, Created: time.Now()}
+	for i := range req.Bots {
+		t.Standings = append(t.Standings, Standing{Bot: req.Bots[i]})
+		for j := i + 1; j < len(req.Bots); j++ {
+			home, away := req.Bots[i], req.Bots[j]
+			// Alternate sides so no bot always starts on the left.
+			if len(t.Matches)%2 == 1 {
+				home, away = away, home
+			}
+			t.Matches = append(t.Matches, &Match{ID: len(t.Matches) + 1, Home: home, Away: away, Status: matchScheduled})
+		}
+	}
+
+	a.mu.Lock()
+	for _, other := range a.tournaments {
+		if other.Name == t.Name {
+			a.mu.Unlock()
+			return nil, fmt.Errorf("tournament %q already exists", t.Name)
+		}
+	}
+	a.tournaments = append(a.tournaments, t)
+	a.prune()
+	data := t.view()
+	a.mu.Unlock()
+
+	publish(msgTournament, data)
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	return t, nil
+}
+
+// prune forgets the oldest finished tournaments; a.mu must be held.
+func (a *arena) prune() {
+	finished := 0
+	for _, t := range a.tournaments {
+		if t.Status == tournamentFinished {
+			finished++
+		}
+	}
+	kept := a.tournaments[:0]
+	for _, t := range a.tournaments {
+		if t.Status == tournamentFinished && finished > tournamentsKept {
+			finished--
+			continue
+		}
+		kept = append(kept, t)
+	}
+	a.tournaments = kept
+}
+
+// view copies the tournament for encoding outside a.mu; a.mu must be held.
+func (t *Tournament) view() Tournament {
+	v := *t
+	v.Matches = make([]*Match, len(t.Matches))
+	for i, m := range t.Matches {
+		c := *m
+		v.Matches[i] = &c
+	}
+	v.Standings = append([]Standing(nil), t.Standings...)
+	return v
+}
+
+// board returns the match and its live cells; a.mu must be held.
+func (m *Match) board(tournament string) MatchBoard {
+	b := MatchBoard{Tournament: tournament, Match: *m, Home: []int{}, Away: []int{}}
+	if m.grid != nil {
+		b.Width, b.Height = m.grid.grid.Width, m.grid.grid.Height
+		b.Home, b.Away = m.grid.cells(ownerHome), m.grid.cells(ownerAway)
+	}
+	return b
+}
+
+// Run plays scheduled matches until ctx is done.
+func (a *arena) Run(ctx context.Context) {
+	for {
+		if t, m := a.next(); m != nil {
+			a.play(ctx, t, m)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.wake:
+		}
+	}
+}
+
+// next returns the first scheduled match.
+func (a *arena) next() (*Tournament, *Match) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range a.tournaments {
+		if m := t.scheduled(); m != nil {
+			return t, m
+		}
+	}
+	return nil, nil
+}
+
+// scheduled returns the tournament's first match still to play; the
+// arena's mu must be held.
+func (t *Tournament) scheduled() *Match {
+	for _, m := range t.Matches {
+		if m.Status == matchScheduled {
+			return m
+		}
+	}
+	return nil
+}
+
+// play sets a match up on a fresh grid, runs it and scores it.
+func (a *arena) play(ctx context.Context, t *Tournament, m *Match) {
+	rule, _ := newLifeLikeEngine(t.Rule)
+	now := time.Now()
+
+	a.mu.Lock()
+	m.grid = newArenaGrid(GridGeometry{Width: t.Width, Height: t.Height}, rule)
+	m.Status, m.Started = matchSetup, &now
+	m.HomeMoves, m.AwayMoves = t.MoveBudget, t.MoveBudget
+	t.Status = tournamentRunning
+	summary, board := t.view(), m.board(t.Name)
+	a.mu.Unlock()
+	publish(msgTournament, summary)
+	publish(msgMatch, board)
+	log.Printf("Tournament: %s match %d, %s vs %s", t.Name, m.ID, m.Home, m.Away)
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(t.SetupSeconds) * time.Second):
+	}
+
+	a.mu.Lock()
+	m.Status = matchRunning
+	a.mu.Unlock()
+	ticker := time.NewTicker(time.Duration(t.TickIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for gen := 0; gen < t.Generations; gen++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		m.grid.step(ctx)
+		m.score()
+		board := m.board(t.Name)
+		a.mu.Unlock()
+		publish(msgMatch, board)
+	}
+
+	a.mu.Lock()
+	// A move may have landed after the last generation.
+	m.score()
+	m.finish(t)
+	m.attestation = attestMatch(t, m)
+	if t.scheduled() == nil {
+		t.Status = tournamentFinished
+	}
+	summary = t.view()
+	a.mu.Unlock()
+	publish(msgTournament, summary)
+	log.Printf("Tournament: %s match %d ended %d-%d", t.Name, m.ID, m.HomeScore, m.AwayScore)
+}
+
+// arenaGrid is the board of a match: the engine's cells and which bot owns
+// each. Replays are verified by running the same code.
+type arenaGrid struct {
+	grid   GridGeometry
+	engine *Engine
+	owners []int8
+}
+
+func newArenaGrid(grid GridGeometry, rule RuleEngine) *arenaGrid {
+	return &arenaGrid{grid: grid, engine: NewEngine(grid, rule), owners: make([]int8, grid.Size())}
+}
+
+// side returns the owner of the half a cell is in.
+func (g *arenaGrid) side(i int) int8 {
+	if x, _ := g.grid.Coords(i); x < g.grid.Width/2 {
+		return ownerHome
+	}
+	return ownerAway
+}
+
+// place brings cells to life for an owner; cells already alive keep theirs.
+func (g *arenaGrid) place(owner int8, cells []int) {
+	for _, i := range cells {
+		if g.owners[i] == ownerNone {
+			g.engine.Set(i, true)
+			g.owners[i] = owner
+		}
+	}
+}
+
+// step advances one generation and colours the cells born by the previous
+// generation's owners.
+func (g *arenaGrid) step(ctx context.Context) error {
+	births, deaths, err := g.engine.Step(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	next := append([]int8(nil), g.owners...)
+	for _, i := range deaths {
+		next[i] = ownerNone
+	}
+	for _, i := range births {
+		var home, away int
+		for _, n := range g.grid.Neighbors(i) {
+			switch g.owners[n] {
+			case ownerHome:
+				home++
+			case ownerAway:
+				away++
+			}
+		}
+		switch {
+		case home > away:
+			next[i] = ownerHome
+		case away > home:
+			next[i] = ownerAway
+		default:
+			next[i] = g.side(i)
+		}
+	}
+	g.owners = next
+	return nil
+}
+
+// cells returns an owner's live cells.
+func (g *arenaGrid) cells(owner int8) []int {
+	cells := []int{}
+	for i, o := range g.owners {
+		if o == owner {
+			cells = append(cells, i)
+		}
+	}
+	return cells
+}
+
+// score updates the match from its grid; the arena's mu must be held.
+func (m *Match) score() {
+	m.Generation = m.grid.engine.Generation()
+	m.HomeScore, m.AwayScore = len(m.grid.cells(ownerHome)), len(m.grid.cells(ownerAway))
+}
+
+// finish records the match's result in the standings; a.mu must be held.
+func (m *Match) finish(t *Tournament) {
+	now := time.Now()
+	m.Status, m.Finished = matchFinished, &now
+	winner, loser := m.Home, m.Away
+	switch {
+	case m.AwayScore > m.HomeScore:
+		winner, loser = m.Away, m.Home
+	case m.AwayScore == m.HomeScore:
+		winner, loser = "", ""
+	}
+	m.Winner = winner
+	for i := range t.Standings {
+		s := &t.Standings[i]
+		if s.Bot != m.Home && s.Bot != m.Away {
+			continue
+		}
+		s.Played++
+		switch s.Bot {
+		case winner:
+			s.Won++
+			s.Points += 3
+		case loser:
+			s.Lost++
+		default:
+			s.Drawn++
+			s.Points++
+		}
+	}
+	if winner == "" {
+		tournamentMatches.WithLabelValues("draw").Inc()
+	} else {
+		tournamentMatches.WithLabelValues("win").Inc()
+	}
+}
+
+// Move places a bot's cells on its half of a match in progress.
+func (a *arena) Move(tournament string, id int, bot string, cells []int) (MatchBoard, int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := a.match(tournament, id)
+	if m == nil {
+		return MatchBoard{}, http.StatusNotFound, fmt.Errorf("unknown match")
+	}
+	if m.Status != matchSetup && m.Status != matchRunning {
+		return MatchBoard{}, http.StatusConflict, fmt.Errorf("match is %s", m.Status)
+	}
+	var owner int8
+	left := &m.HomeMoves
+	switch bot {
+	case m.Home:
+		owner = ownerHome
+	case m.Away:
+		owner, left = ownerAway, &m.AwayMoves
+	default:
+		return MatchBoard{}, http.StatusForbidden, fmt.Errorf("bot %s does not play in this match", bot)
+	}
+	if len(cells) > *left {
+		return MatchBoard{}, http.StatusConflict, fmt.Errorf("%d moves left", *left)
+	}
+	for _, i := range cells {
+		if i < 0 || i >= m.grid.grid.Size() || m.grid.side(i) != owner {
+			return MatchBoard{}, http.StatusForbidden, fmt.Errorf("cell %d is not on your half", i)
+		}
+	}
+	m.grid.place(owner, cells)
+	m.moves = append(m.moves, ReplayMove{Generation: m.grid.engine.Generation(), Side: sideNames[owner], Cells: cells})
+	*left -= len(cells)
+	m.score()
+	return m.board(tournament), http.StatusOK, nil
+}
+
+// match finds a match; a.mu must be held.
+func (a *arena) match(tournament string, id int) *Match {
+	for _, t := range a.tournaments {
+		if t.Name == tournament && id >= 1 && id <= len(t.Matches) {
+			return t.Matches[id-1]
+		}
+	}
+	return nil
+}
+
+// handleTournaments serves GET /api/tournaments, every tournament, and
+// POST /api/tournaments for administrators, which schedules one from a
+// TournamentRequest. GET /api/tournaments/{name} returns one with its
+// matches and standings, GET .../matches/{id} a match with its board, and
+// bots play with POST .../matches/{id}/moves.
+func handleTournaments(w http.ResponseWriter, r *http.Request, a *arena) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tournaments"), "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+	var id int
+	if len(parts) >= 3 {
+		var err error
+		if parts[1] != "matches" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if id, err = strconv.Atoi(parts[2]); err != nil {
+			http.Error(w, "Invalid match", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var resp any
+	status := http.StatusOK
+	switch {
+	case r.Method == "GET" && len(parts) == 0:
+		a.mu.Lock()
+		list := make([]Tournament, 0, len(a.tournaments))
+		for _, t := range a.tournaments {
+			list = append(list, t.view())
+		}
+		a.mu.Unlock()
+		resp = list
+
+	case r.Method == "POST" && len(parts) == 0:
+		if !requireAdmin(w, r) {
+			return
+		}
+		var req TournamentRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid tournament: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, "Invalid tournament: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := a.Create(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Tournament: %s scheduled %s, %d matches", requestIdentity(r), t.Name, len(t.Matches))
+		a.mu.Lock()
+		resp, status = t.view(), http.StatusCreated
+		a.mu.Unlock()
+
+	case r.Method == "GET" && len(parts) == 1:
+		a.mu.Lock()
+		for _, t := range a.tournaments {
+			if t.Name == parts[0] {
+				resp = t.view()
+			}
+		}
+		a.mu.Unlock()
+		if resp == nil {
+			http.Error(w, "Tournament not found", http.StatusNotFound)
+			return
+		}
+
+	case r.Method == "GET" && len(parts) == 3:
+		a.mu.Lock()
+		if m := a.match(parts[0], id); m != nil {
+			resp = m.board(parts[0])
+		}
+		a.mu.Unlock()
+		if resp == nil {
+			http.Error(w, "Match not found", http.StatusNotFound)
+			return
+		}
+
+	case r.Method == "GET" && len(parts) == 4 && parts[3] == "replay":
+		a.mu.Lock()
+		m := a.match(parts[0], id)
+		if m != nil && m.attestation != nil {
+			resp = m.attestation
+		}
+		a.mu.Unlock()
+		if m == nil {
+			http.Error(w, "Match not found", http.StatusNotFound)
+			return
+		}
+		if resp == nil {
+			http.Error(w, "Match has not finished", http.StatusConflict)
+			return
+		}
+
+	case r.Method == "POST" && len(parts) == 4 && parts[3] == "moves":
+		b := botFor(r)
+		if b == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized: moves need a bot token", http.StatusUnauthorized)
+			return
+		}
+		if !allowBot(w, r, botScopeTournament, GridGeometry{}, nil) {
+			return
+		}
+		var move MoveRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 65536)).Decode(&move); err != nil {
+			http.Error(w, "Invalid move: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		board, code, err := a.Move(parts[0], id, b.Name, move.Cells)
+		if err != nil {
+			http.Error(w, "Move refused: "+err.Error(), code)
+			return
+		}
+		resp = board
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Transform is a geometric transformation: an optional flip (h mirrors left
+// to right, v top to bottom), then a clockwise rotation by Rotate degrees,
+// then a translation by (DX, DY). Flips and rotations keep the top-left
+// corner of the box they apply to.
+type Transform struct {
+	Rotate int    `json:"rotate,omitempty"`
+	Flip   string `json:"flip,omitempty"`
+	DX     int    `json:"dx,omitempty"`
+	DY     int    `json:"dy,omitempty"`
+}
+
+func (t Transform) validate() error {
+	switch t.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("rotate must be 0, 90, 180 or 270, not %d", t.Rotate)
+	}
+	switch t.Flip {
+	case "", "h", "v":
+	default:
+		return fmt.Errorf("flip must be h or v, not %q", t.Flip)
+	}
+	return nil
+}
+
+// point maps (x, y) within a width by height box, returning its new position
+// before translation.
+func (t Transform) point(x, y, width, height int) (int, int) {
+	switch t.Flip {
+	case "h":
+		x = width - 1 - x
+	case "v":
+		y = height - 1 - y
+	}
+	switch t.Rotate {
+	case 90:
+		return height - 1 - y, x
+	case 180:
+		return width - 1 - x, height - 1 - y
+	case 270:
+		return y, width - 1 - x
+	}
+	return x, y
+}
+
+// Pattern flips and rotates a pattern; where it is stamped translates it.
+func (t Transform) Pattern(p Pattern) Pattern {
+	if t.Rotate == 0 && t.Flip == "" {
+		return p
+	}
+	out := Pattern{Name: p.Name, Width: p.Width, Height: p.Height, Cells: make([][2]int, len(p.Cells))}
+	if t.Rotate == 90 || t.Rotate == 270 {
+		out.Width, out.Height = p.Height, p.Width
+	}
+	for i, c := range p.Cells {
+		x, y := t.point(c[0], c[1], p.Width, p.Height)
+		out.Cells[i] = [2]int{x, y}
+	}
+	return out
+}
+
+// patternTransform reads ?rotate= and ?flip= of a pattern stamp.
+func patternTransform(r *http.Request) (Transform, error) {
+	q := r.URL.Query()
+	t := Transform{Flip: q.Get("flip")}
+	if v := q.Get("rotate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return t, fmt.Errorf("rotate must be 0, 90, 180 or 270, not %q", v)
+		}
+		t.Rotate = n
+	}
+	return t, t.validate()
+}
+
+// RegionTransform moves the live cells of a region, x0, y0, x1, y1 with x1
+// and y1 exclusive: they are flipped and rotated within the region's box and
+// then translated.
+type RegionTransform struct {
+	Region []int `json:"region"`
+	Transform
+}
+
+// TransformResult acknowledges a region transform. Clipped counts the cells
+// that landed outside the grid and were lost.
+type TransformResult struct {
+	EditResult
+	Moved   int `json:"moved"`
+	Clipped int `json:"clipped"`
+}
+
+func (rt RegionTransform) validate(grid GridGeometry) error {
+	if len(rt.Region) != 4 {
+		return errors.New("region must be [x0, y0, x1, y1]")
+	}
+	if rt.Region[0] < 0 || rt.Region[1] < 0 || rt.Region[2] > grid.Width || rt.Region[3] > grid.Height || rt.Region[2] <= rt.Region[0] || rt.Region[3] <= rt.Region[1] {
+		return fmt.Errorf("region must be a non-empty box within the %dx%d grid", grid.Width, grid.Height)
+	}
+	return rt.Transform.validate()
+}
+
+// cells returns the cells the transform brings to life and kills given the
+// live cells, and how many of the region's cells it moved and lost off the
+// grid's edges.
+func (rt RegionTransform) cells(grid GridGeometry, live []int) (births, deaths []int, moved, clipped int) {
+	x0, y0, width, height := rt.Region[0], rt.Region[1], rt.Region[2]-rt.Region[0], rt.Region[3]-rt.Region[1]
+	target := map[int]bool{}
+	var region []int
+	for _, i := range live {
+		x, y := grid.Coords(i)
+		if x < x0 || y < y0 || x >= rt.Region[2] || y >= rt.Region[3] {
+			continue
+		}
+		region = append(region, i)
+		nx, ny := rt.point(x-x0, y-y0, width, height)
+		nx, ny = x0+nx+rt.DX, y0+ny+rt.DY
+		if !grid.Contains(nx, ny) {
+			clipped++
+			continue
+		}
+		j := grid.Index(nx, ny)
+		if !target[j] {
+			target[j] = true
+			births = append(births, j)
+		}
+	}
+	for _, i := range region {
+		if !target[i] {
+			deaths = append(deaths, i)
+		}
+	}
+	return births, deaths, len(region), clipped
+}
+
+// handleRegionTransform serves POST /api/region/transform, which moves the
+// live cells of a region in one edit, e.g. to aim a glider or shift a
+// structure:
+//
+//	{"region": [10, 10, 20, 20], "rotate": 90, "flip": "h", "dx": 5, "dy": -3}
+//
+// Cells already alive where the region's cells land are kept.
+func handleRegionTransform(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Transforming regions requires --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	var req RegionTransform
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	grid := s.engine.grid
+	if err := req.validate(grid); err != nil {
+		http.Error(w, "Invalid transform: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A bot's region must hold every cell the transform may change.
+	births, deaths, _, _ := req.cells(grid, s.engine.LiveCells())
+	if !allowBot(w, r, botScopePatterns, grid, append(births, deaths...)) {
+		return
+	}
+
+	if !quotas.Allow(w, r, actionPatterns) {
+		return
+	}
+	var result TransformResult
+	result.EditResult = s.edit(r, func() ([]int, []int) {
+		births, deaths, result.Moved, result.Clipped = req.cells(grid, s.engine.LiveCells())
+		return births, deaths
+	})
+	s.Edited(result.EditResult, causeTransform)
+	log.Printf("Edit: %s transformed region %v, %d cells moved, %d clipped (edit %d)", requestIdentity(r), req.Region, result.Moved, result.Clipped, result.Seq)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
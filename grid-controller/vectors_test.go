@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nordiwnd/k3s-cellular-automaton/grid-controller/conformance"
+)
+
+// TestVectors runs the golden vectors three ways: through the engine,
+// through cell pods reconciled into a fake cluster generation by
+// generation, and through a plain reference implementation of the rule, so
+// that a wrong golden value cannot go unnoticed either.
+func TestVectors(t *testing.T) {
+	vectors, err := conformance.Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			if got := len(v.Cells()); got == 0 {
+				t.Fatal("empty seed")
+			}
+			t.Run("engine", func(t *testing.T) {
+				e := vectorEngine(t, v)
+				for g := int64(0); g < v.Generations; g++ {
+					e.Step(context.Background(), nil, nil)
+				}
+				gen, live := e.Snapshot()
+				checkVector(t, v, gen, live)
+			})
+			t.Run("materialized", func(t *testing.T) {
+				e := vectorEngine(t, v)
+				cells := newVectorCells(e, v)
+				cells.reconcile(t)
+				for g := int64(0); g < v.Generations; g++ {
+					e.Step(context.Background(), nil, nil)
+					cells.reconcile(t)
+				}
+				live, err := materializedCells(cells.lister, cells.m.namespace)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkVector(t, v, e.Generation(), live)
+			})
+			t.Run("reference", func(t *testing.T) {
+				checkVector(t, v, v.Generations, referenceRun(t, v))
+			})
+		})
+	}
+}
+
+// TestStateHashMatchesConformance keeps the vectors' hash in step with the
+// one served by /api/state/hash.
+func TestStateHashMatchesConformance(t *testing.T) {
+	for _, live := range [][]int{nil, {0}, {7, 3, 42, 5}} {
+		if got, want := conformance.StateHash(12, live), stateHash(12, append([]int(nil), live...)); got != want {
+			t.Errorf("StateHash(12, %v) = %s, want %s", live, got, want)
+		}
+	}
+}
+
+func checkVector(t *testing.T, v conformance.Vector, gen int64, live []int) {
+	t.Helper()
+	if gen != v.Generations {
+		t.Fatalf("generation %d, want %d", gen, v.Generations)
+	}
+	if len(live) != v.Population {
+		t.Errorf("population %d, want %d", len(live), v.Population)
+	}
+	if got := conformance.StateHash(gen, live); got != v.Hash {
+		t.Errorf("hash %s, want %s", got, v.Hash)
+	}
+}
+
+func vectorEngine(t *testing.T, v conformance.Vector) *Engine {
+	t.Helper()
+	grid := GridGeometry{Width: v.Width, Height: v.Height}
+	rule, err := openRuleEngine(context.Background(), v.Rule, grid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(grid, rule)
+	for _, i := range v.Cells() {
+		e.Set(i, true)
+	}
+	return e
+}
+
+// vectorCells materializes an engine's live cells as pods in a fake cluster,
+// with the informer cache replaced by a lister refilled after every pass.
+type vectorCells struct {
+	m       *cellPodManager
+	indexer cache.Indexer
+	lister  corelisters.PodLister
+}
+
+func newVectorCells(e *Engine, v conformance.Vector) *vectorCells {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	lister := corelisters.NewPodLister(indexer)
+	m := newCellPodManager(fake.NewSimpleClientset(), "default", GridGeometry{Width: v.Width, Height: v.Height}, "cell:test", lister)
+	m.desired = e.Alive
+	return &vectorCells{m: m, indexer: indexer, lister: lister}
+}
+
+func (c *vectorCells) reconcile(t *testing.T) {
+	t.Helper()
+	c.m.reconcile(context.Background())
+	list, err := c.m.clientset.CoreV1().Pods(c.m.namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pods := make([]interface{}, 0, len(list.Items))
+	for i := range list.Items {
+		pod := list.Items[i]
+		pod.Status.Phase = v1.PodRunning
+		pods = append(pods, &pod)
+	}
+	if err := c.indexer.Replace(pods, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// referenceRun applies the vector's rule the obvious way, one cell and one
+// neighbor at a time.
+func referenceRun(t *testing.T, v conformance.Vector) []int {
+	t.Helper()
+	birth, survival, ok := strings.Cut(v.Rule, "/")
+	if !ok || !strings.HasPrefix(birth, "B") || !strings.HasPrefix(survival, "S") {
+		t.Fatalf("rule %q is not in B/S notation", v.Rule)
+	}
+	alive := make([]bool, v.Width*v.Height)
+	for _, i := range v.Cells() {
+		alive[i] = true
+	}
+	for g := int64(0); g < v.Generations; g++ {
+		next := make([]bool, len(alive))
+		for y := 0; y < v.Height; y++ {
+			for x := 0; x < v.Width; x++ {
+				n := 0
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := x+dx, y+dy
+						if (dx != 0 || dy != 0) && nx >= 0 && ny >= 0 && nx < v.Width && ny < v.Height && alive[ny*v.Width+nx] {
+							n++
+						}
+					}
+				}
+				counts := birth[1:]
+				if alive[y*v.Width+x] {
+					counts = survival[1:]
+				}
+				next[y*v.Width+x] = strings.ContainsRune(counts, rune('0'+n))
+			}
+		}
+		alive = next
+	}
+	var live []int
+	for i, a := range alive {
+		if a {
+			live = append(live, i)
+		}
+	}
+	return live
+}
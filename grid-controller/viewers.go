@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var viewersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "grid_viewers",
+	Help: "Number of connected WebSocket viewers.",
+})
+
+// ViewersMessage announces the current audience size to every client.
+type ViewersMessage struct {
+	Viewers int `json:"viewers"`
+}
+
+func viewerCount() int {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return len(clients)
+}
+
+// viewersChanged updates the gauge and tells every client. It is called with
+// clientsMu held, so the broadcast is sent asynchronously.
+func viewersChanged() {
+	n := len(clients)
+	viewersGauge.Set(float64(n))
+	go publish(msgViewers, ViewersMessage{Viewers: n})
+}
+
+// spontaneousBirths picks dead cells to bring to life this tick. The expected
+// number of births is rate per viewer, so a bigger audience livens the grid.
+func spontaneousBirths(engine *Engine, viewers int, rate float64) []int {
+	expected := rate * float64(viewers)
+	n := int(expected)
+	if rand.Float64() < expected-float64(n) {
+		n++
+	}
+
+	var births []int
+	size := engine.grid.Size()
+	for attempts := 0; len(births) < n && attempts < 4*n; attempts++ {
+		i := rand.Intn(size)
+		if !engine.Alive(i) {
+			engine.Set(i, true)
+			births = append(births, i)
+		}
+	}
+	return births
+}
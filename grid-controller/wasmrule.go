@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WasmLimits bounds rules uploaded as WebAssembly, e.g.
+//
+//	wasmRules:
+//	  maxModuleSize: 262144
+//	  maxMemoryPages: 16
+//	  budget: 50ms
+//
+// Modules run in an interpreter without any host functions, so they cannot
+// reach the filesystem, network or clock. Memory is capped in 64 KiB pages.
+// wazero has no instruction metering, so the fuel is a wall-clock budget per
+// generation: a module that overruns it is stopped and the generation skipped.
+type WasmLimits struct {
+	// MaxModuleSize caps uploads in bytes; defaults to 256 KiB.
+	MaxModuleSize int64 `json:"maxModuleSize,omitempty"`
+	// MaxMemoryPages caps linear memory; defaults to 16 (1 MiB).
+	MaxMemoryPages uint32 `json:"maxMemoryPages,omitempty"`
+	// Budget is the time a module may spend on one generation; defaults to
+	// 100ms.
+	Budget metav1.Duration `json:"budget,omitempty"`
+}
+
+func (l *WasmLimits) validate() error {
+	if l.MaxModuleSize < 0 || l.Budget.Duration < 0 {
+		return errors.New("maxModuleSize and budget must not be negative")
+	}
+	if l.MaxMemoryPages > 65536 {
+		return errors.New("maxMemoryPages must be at most 65536")
+	}
+	if l.MaxModuleSize == 0 {
+		l.MaxModuleSize = 256 << 10
+	}
+	if l.MaxMemoryPages == 0 {
+		l.MaxMemoryPages = 16
+	}
+	if l.Budget.Duration == 0 {
+		l.Budget.Duration = 100 * time.Millisecond
+	}
+	return nil
+}
+
+// wasmEngine runs a rule uploaded as a WebAssembly module. The module
+// exports
+//
+//	(func (export "next") (param $neighborhood i32) (param $generation i64) (result i32))
+//
+// returning non-zero for a live cell; neighborhood is a Neighborhood mask.
+// It may keep state in its memory between calls, and imports nothing.
+type wasmEngine struct {
+	rule   string
+	budget time.Duration
+
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mu     sync.Mutex
+	module api.Module
+	next   api.Function
+}
+
+var wasmRuleName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// compileWasmRule validates and compiles a module and runs it once over
+// every possible neighborhood, so a module that traps or overruns its budget
+// is refused before it reaches the grid.
+func compileWasmRule(ctx context.Context, name string, code []byte, limits WasmLimits) (*wasmEngine, error) {
+	config := wazero.NewRuntimeConfigInterpreter().
+		WithMemoryLimitPages(limits.MaxMemoryPages).
+		WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("invalid module: %w", err)
+	}
+	if len(compiled.ImportedFunctions()) > 0 || len(compiled.ImportedMemories()) > 0 {
+		runtime.Close(ctx)
+		return nil, errors.New("modules must not import anything")
+	}
+	fn, ok := compiled.ExportedFunctions()["next"]
+	if !ok || !sameValueTypes(fn.ParamTypes(), api.ValueTypeI32, api.ValueTypeI64) || !sameValueTypes(fn.ResultTypes(), api.ValueTypeI32) {
+		runtime.Close(ctx)
+		return nil, errors.New("module must export next(i32, i64) i32")
+	}
+
+	sum := sha256.Sum256(code)
+	e := &wasmEngine{
+		rule:     "wasm:" + name + "@" + hex.EncodeToString(sum[:4]),
+		budget:   limits.Budget.Duration,
+		runtime:  runtime,
+		compiled: compiled,
+	}
+	probe := make([]Neighborhood, 1<<9)
+	for i := range probe {
+		probe[i] = Neighborhood(i)
+	}
+	if _, err := e.Next(ctx, 0, probe); err != nil {
+		e.Close()
+		return nil, err
+	}
+	// Start the grid from a fresh instance rather than the probe's.
+	e.reset()
+	return e, nil
+}
+
+func sameValueTypes(have []api.ValueType, want ...api.ValueType) bool {
+	if len(have) != len(want) {
+		return false
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *wasmEngine) Rule() string {
+	return e.rule
+}
+
+func (e *wasmEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, e.budget)
+	defer cancel()
+	if e.module == nil {
+		module, err := e.runtime.InstantiateModule(ctx, e.compiled, wazero.NewModuleConfig().WithName(""))
+		if err != nil {
+			return nil, fmt.Errorf("instantiate: %w", err)
+		}
+		e.module, e.next = module, module.ExportedFunction("next")
+	}
+
+	next := make([]bool, len(hoods))
+	for i, n := range hoods {
+		res, err := e.next.Call(ctx, uint64(n), uint64(generation))
+		if err != nil {
+			// A trap or an overrun leaves the instance unusable; the
+			// next generation starts a new one.
+			e.module.Close(context.Background())
+			e.module = nil
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("%s exceeded its %s budget", e.rule, e.budget)
+			}
+			return nil, err
+		}
+		next[i] = uint32(res[0]) != 0
+	}
+	return next, nil
+}
+
+func (e *wasmEngine) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.module != nil {
+		e.module.Close(context.Background())
+		e.module = nil
+	}
+}
+
+func (e *wasmEngine) Close() error {
+	return e.runtime.Close(context.Background())
+}
+
+// RuleInfo describes the rule the standalone engine runs.
+type RuleInfo struct {
+	Rule     string `json:"rule"`
+	Uploaded bool   `json:"uploaded"`
+	// Comparison is set while two rules are compared.
+	Comparison *ComparisonStats `json:"comparison,omitempty"`
+}
+
+// handleRules serves GET /api/rules, the current rule; POST
+// /api/rules?name=, which replaces it with the WebAssembly module in the
+// body; and DELETE /api/rules, which restores the --rule-engine rule.
+// Uploads need the WasmRules feature gate and the operator role.
+func handleRules(w http.ResponseWriter, r *http.Request, s *simulation) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if s == nil {
+		http.Error(w, "Rules require --engine=standalone", http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST", "DELETE":
+		if !requireFeature(w, featureWasmRules) || !requireRole(w, r, roleOperator) {
+			return
+		}
+		if s.federation != nil {
+			http.Error(w, "Uploaded rules are not supported in a federated grid", http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		name := r.URL.Query().Get("name")
+		if !wasmRuleName.MatchString(name) {
+			http.Error(w, "Invalid rule name", http.StatusBadRequest)
+			return
+		}
+		if !quotas.Allow(w, r, actionRules) {
+			return
+		}
+		code, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.wasmLimits.MaxModuleSize))
+		if err != nil {
+			http.Error(w, "Module too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		rule, err := compileWasmRule(r.Context(), name, code, s.wasmLimits)
+		if err != nil {
+			http.Error(w, "Invalid rule: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		s.SetRule(rule)
+		log.Printf("Engine: %s switched the rule to %s", requestIdentity(r), rule.Rule())
+	case "DELETE":
+		base := s.BaseRule()
+		s.SetRule(base)
+		log.Printf("Engine: %s restored the rule %s", requestIdentity(r), base.Rule())
+	}
+
+	rule := s.engine.RuleEngine()
+	_, uploaded := rule.(*wasmEngine)
+	info := RuleInfo{Rule: rule.Rule(), Uploaded: uploaded}
+	if c, ok := rule.(*compareEngine); ok {
+		info.Comparison = c.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// SetRule swaps the engine's rule, releasing an uploaded one it replaces.
+func (s *simulation) SetRule(rule RuleEngine) {
+	if old, ok := s.engine.SetRule(rule).(*wasmEngine); ok && old != rule {
+		old.Close()
+	}
+}
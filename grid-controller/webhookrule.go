@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxWebhookResponse caps the next state a webhook may return.
+const maxWebhookResponse = 16 << 20
+
+var webhookFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "grid_rule_webhook_fallbacks_total",
+	Help: "Generations computed by the built-in rule because the rule webhook failed.",
+})
+
+// WebhookRequest is POSTed to a rule webhook each generation.
+type WebhookRequest struct {
+	Generation int64 `json:"generation"`
+	Width      int   `json:"width"`
+	Height     int   `json:"height"`
+	// Alive lists the live cells by index (row-major).
+	Alive []int `json:"alive"`
+}
+
+// WebhookResponse is the webhook's answer: the live cells of the next
+// generation.
+type WebhookResponse struct {
+	Alive []int `json:"alive"`
+}
+
+// webhookEngine hands each generation to an external service, e.g. a model
+// served over HTTP, with --rule-engine=https://host/path. When the service
+// fails, times out or answers nonsense, the generation is computed with the
+// built-in rule instead so the grid keeps moving.
+type webhookEngine struct {
+	url      string
+	grid     GridGeometry
+	client   *http.Client
+	fallback RuleEngine
+	failing  bool
+}
+
+func newWebhookEngine(rawURL string, grid GridGeometry, timeout time.Duration) (*webhookEngine, error) {
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return nil, err
+	}
+	if offline {
+		return nil, errOffline
+	}
+	return &webhookEngine{
+		url:      rawURL,
+		grid:     grid,
+		client:   egressClient("webhook-rule", timeout),
+		fallback: lifeEngine{},
+	}, nil
+}
+
+// Rule names the webhook by host only; its URL may carry credentials.
+func (e *webhookEngine) Rule() string {
+	u, _ := url.Parse(e.url)
+	return "webhook:" + u.Host
+}
+
+func (e *webhookEngine) Next(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	next, err := e.call(ctx, generation, hoods)
+	if err != nil {
+		webhookFallbacks.Inc()
+		if !e.failing {
+			log.Printf("Rule: webhook failed, falling back to %s: %s", e.fallback.Rule(), err.Error())
+		}
+		e.failing = true
+		return e.fallback.Next(ctx, generation, hoods)
+	}
+	if e.failing {
+		log.Printf("Rule: webhook recovered at generation %d", generation)
+	}
+	e.failing = false
+	return next, nil
+}
+
+func (e *webhookEngine) call(ctx context.Context, generation int64, hoods []Neighborhood) ([]bool, error) {
+	payload := WebhookRequest{Generation: generation, Width: e.grid.Width, Height: e.grid.Height, Alive: []int{}}
+	for i, n := range hoods {
+		if n.Alive() {
+			payload.Alive = append(payload.Alive, i)
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	var answer WebhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWebhookResponse)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	next := make([]bool, len(hoods))
+	for _, i := range answer.Alive {
+		if i < 0 || i >= len(next) {
+			return nil, fmt.Errorf("cell %d outside the grid", i)
+		}
+		next[i] = true
+	}
+	return next, nil
+}